@@ -0,0 +1,506 @@
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"song_library/blobstore"
+	"song_library/config"
+	"song_library/db"
+	"song_library/models"
+	"song_library/worker"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	ErrSongNotFound    = errors.New("song not found")
+	ErrNotFound        = errors.New("attachment not found")
+	ErrInvalid         = errors.New("invalid attachment")
+	ErrTooLarge        = errors.New("attachment is too large")
+	ErrUnsupportedType = errors.New("unsupported attachment type")
+	ErrNotScalable     = errors.New("no thumbnails can be made of the attachment")
+	ErrBadSignature    = errors.New("download URL is invalid or expired")
+)
+
+// sweepInterval is how often blobs no attachment refers to any more are deleted
+const sweepInterval = time.Hour
+
+// Store keeps the attachments of songs: their records in the database and their content, once per
+// SHA-256, in a blob store
+type Store struct {
+	blobs        blobstore.BlobStore
+	maxBytes     int64
+	allowedTypes []string
+	secret       []byte
+	urlTTL       time.Duration
+	publicURL    string
+}
+
+// New builds the attachment store over a blob store
+func New(cfg *config.Config, blobs blobstore.BlobStore) (*Store, error) {
+	secret := []byte(cfg.AttachmentsURLSecret)
+	if len(secret) == 0 {
+		log.Warn().Msg("ATTACHMENTS_URL_SECRET is not set, download URLs are signed with a random key and only work on this instance until it restarts")
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			return nil, err
+		}
+	}
+	return &Store{
+		blobs:        blobs,
+		maxBytes:     cfg.AttachmentsMaxBytes,
+		allowedTypes: cfg.AttachmentsAllowedTypes,
+		secret:       secret,
+		urlTTL:       cfg.AttachmentsURLTTL,
+		publicURL:    strings.TrimSuffix(cfg.AttachmentsPublicURL, "/"),
+	}, nil
+}
+
+// MaxBytes is the largest attachment accepted
+func (s *Store) MaxBytes() int64 {
+	return s.maxBytes
+}
+
+func blobKey(sum string) string {
+	return "blobs/" + sum[:2] + "/" + sum
+}
+
+func thumbnailKey(sum, size string) string {
+	return "thumbnails/" + sum[:2] + "/" + sum + "/" + size + ".jpg"
+}
+
+// signature authenticates a download URL of an attachment, or of one of its thumbnails, until expires
+func (s *Store) signature(attachmentID int, size string, expires int64) string {
+	mac := hmac.New(sha256.New, s.secret)
+	fmt.Fprintf(mac, "%d:%s:%d", attachmentID, size, expires)
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (s *Store) downloadURL(attachmentID int, size string, expires int64) string {
+	query := url.Values{}
+	if size != "" {
+		query.Set("size", size)
+	}
+	query.Set("expires", strconv.FormatInt(expires, 10))
+	query.Set("signature", s.signature(attachmentID, size, expires))
+	return s.publicURL + "/files/" + strconv.Itoa(attachmentID) + "?" + query.Encode()
+}
+
+// sign sets the signed download URLs of an attachment
+func (s *Store) sign(a *models.Attachment) {
+	a.ExpiresAt = time.Now().Add(s.urlTTL).Truncate(time.Second)
+	expires := a.ExpiresAt.Unix()
+	a.URL = s.downloadURL(a.ID, "", expires)
+	if canScale(a.ContentType, a.Width, a.Height) {
+		a.Thumbnails = make(map[string]string, len(ThumbnailSizes))
+		for size := range ThumbnailSizes {
+			a.Thumbnails[size] = s.downloadURL(a.ID, size, expires)
+		}
+	}
+}
+
+const attachmentColumns = `a.attachment_id, a.song_id, a.kind, a.filename, b.content_type, b.size, b.sha256,
+	b.width, b.height, COALESCE(a.uploaded_by, ''), a.created_at`
+
+func scanAttachment(scan func(dest ...any) error) (models.Attachment, error) {
+	var a models.Attachment
+	err := scan(&a.ID, &a.SongID, &a.Kind, &a.Filename, &a.ContentType, &a.Size, &a.SHA256,
+		&a.Width, &a.Height, &a.UploadedBy, &a.CreatedAt)
+	return a, err
+}
+
+func (s *Store) get(ctx context.Context, condition string, args ...any) (models.Attachment, error) {
+	a, err := scanAttachment(db.Reader().QueryRowContext(ctx, "SELECT "+attachmentColumns+`
+		FROM song_attachments a JOIN blobs b USING (sha256) WHERE `+condition, args...).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Attachment{}, ErrNotFound
+	} else if err != nil {
+		return models.Attachment{}, err
+	}
+	s.sign(&a)
+	return a, nil
+}
+
+// Get returns an attachment of a song with signed download URLs
+func (s *Store) Get(ctx context.Context, songID, attachmentID int) (models.Attachment, error) {
+	return s.get(ctx, "a.song_id = $1 AND a.attachment_id = $2", songID, attachmentID)
+}
+
+// Cover returns the cover of a song with signed download URLs
+func (s *Store) Cover(ctx context.Context, songID int) (models.Attachment, error) {
+	return s.get(ctx, "a.song_id = $1 AND a.kind = $2", songID, models.AttachmentCover)
+}
+
+// List returns the attachments of a song, cover first, with signed download URLs
+func (s *Store) List(ctx context.Context, songID int) ([]models.Attachment, error) {
+	var exists bool
+	if err := db.Reader().QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM songs WHERE song_id = $1)", songID).Scan(&exists); err != nil {
+		return nil, err
+	} else if !exists {
+		return nil, ErrSongNotFound
+	}
+
+	rows, err := db.Reader().QueryContext(ctx, "SELECT "+attachmentColumns+`
+		FROM song_attachments a JOIN blobs b USING (sha256)
+		WHERE a.song_id = $1 ORDER BY a.kind = $2 DESC, a.attachment_id
+	`, songID, models.AttachmentCover)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []models.Attachment{}
+	for rows.Next() {
+		a, err := scanAttachment(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		s.sign(&a)
+		list = append(list, a)
+	}
+	return list, rows.Err()
+}
+
+// cleanFilename keeps the base name of an uploaded file without control characters
+func cleanFilename(name string) string {
+	name = filepath.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" || name == "" {
+		return "file"
+	}
+	if runes := []rune(name); len(runes) > 255 {
+		name = string(runes[len(runes)-255:])
+	}
+	return name
+}
+
+// Upload stores a file as an attachment of a song. The content type is sniffed from the content
+// rather than trusted from the client. Content already stored is not stored again, and a song
+// keeps one attachment per content: uploading it again returns the existing attachment, with
+// created false. A new cover replaces the previous one.
+func (s *Store) Upload(ctx context.Context, songID int, kind, filename string, r io.Reader, uploadedBy string) (models.Attachment, bool, error) {
+	if kind == "" {
+		kind = models.AttachmentOther
+	}
+	if !slices.Contains(models.AttachmentKinds, kind) {
+		return models.Attachment{}, false, fmt.Errorf("%w: kind must be one of %v", ErrInvalid, models.AttachmentKinds)
+	}
+	filename = cleanFilename(filename)
+
+	// Spool the upload to learn its hash and size before storing it
+	tmp, err := os.CreateTemp("", "attachment-*")
+	if err != nil {
+		return models.Attachment{}, false, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(r, s.maxBytes+1))
+	if err != nil {
+		return models.Attachment{}, false, err
+	}
+	if size > s.maxBytes {
+		return models.Attachment{}, false, fmt.Errorf("%w: the limit is %d bytes", ErrTooLarge, s.maxBytes)
+	}
+	if size == 0 {
+		return models.Attachment{}, false, fmt.Errorf("%w: file is empty", ErrInvalid)
+	}
+	sum := hex.EncodeToString(hash.Sum(nil))
+
+	head := make([]byte, 512)
+	n, err := tmp.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return models.Attachment{}, false, err
+	}
+	contentType := http.DetectContentType(head[:n])
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	if !slices.Contains(s.allowedTypes, mediaType) {
+		return models.Attachment{}, false, fmt.Errorf("%w: %s, allowed are %s", ErrUnsupportedType, mediaType, strings.Join(s.allowedTypes, ", "))
+	}
+	if kind == models.AttachmentCover && !strings.HasPrefix(mediaType, "image/") {
+		return models.Attachment{}, false, fmt.Errorf("%w: a cover must be an image, got %s", ErrInvalid, mediaType)
+	}
+	var width, height *int
+	if slices.Contains(scalable, mediaType) {
+		dims, _, err := image.DecodeConfig(io.NewSectionReader(tmp, 0, size))
+		if err != nil {
+			return models.Attachment{}, false, fmt.Errorf("%w: corrupt %s image: %v", ErrInvalid, mediaType, err)
+		}
+		width, height = &dims.Width, &dims.Height
+	}
+
+	var attachmentID int
+	var created, stored bool
+	var replaced string
+	err = db.InTx(ctx, func(tx *sql.Tx) error {
+		if err := tx.QueryRowContext(ctx, "SELECT song_id FROM songs WHERE song_id = $1 FOR SHARE", songID).Scan(&songID); errors.Is(err, sql.ErrNoRows) {
+			return ErrSongNotFound
+		} else if err != nil {
+			return err
+		}
+
+		// Upserting locks the blob row, so that sweep cannot delete the content until the attachment refers to it
+		var inserted bool
+		err := tx.QueryRowContext(ctx, `
+			INSERT INTO blobs (sha256, size, content_type, width, height) VALUES ($1, $2, $3, $4, $5)
+			ON CONFLICT (sha256) DO UPDATE SET sha256 = EXCLUDED.sha256
+			RETURNING xmax = 0
+		`, sum, size, contentType, width, height).Scan(&inserted)
+		if err != nil {
+			return err
+		}
+		if inserted {
+			if err := s.blobs.Put(ctx, blobKey(sum), io.NewSectionReader(tmp, 0, size), size, contentType); err != nil {
+				return fmt.Errorf("storing blob: %w", err)
+			}
+			stored = true
+		}
+
+		if kind == models.AttachmentCover {
+			err := tx.QueryRowContext(ctx, `
+				DELETE FROM song_attachments WHERE song_id = $1 AND kind = $2 AND sha256 <> $3 RETURNING sha256
+			`, songID, models.AttachmentCover, sum).Scan(&replaced)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return err
+			}
+		}
+		// Uploading the cover again as another kind keeps it the cover
+		return tx.QueryRowContext(ctx, `
+			INSERT INTO song_attachments (song_id, kind, filename, sha256, uploaded_by) VALUES ($1, $2, $3, $4, NULLIF($5, ''))
+			ON CONFLICT (song_id, sha256) DO UPDATE
+			SET kind = CASE WHEN EXCLUDED.kind = 'cover' THEN EXCLUDED.kind ELSE song_attachments.kind END
+			RETURNING attachment_id, xmax = 0
+		`, songID, kind, filename, sum, uploadedBy).Scan(&attachmentID, &created)
+	})
+	if err != nil {
+		// The blob row rolled back, so nothing refers to the object and sweeping would not find it
+		if stored {
+			if err := s.discard(ctx, sum, size, contentType); err != nil {
+				log.Error().Err(err).Str("sha256", sum).Msg("Error deleting the content of a failed upload")
+			}
+		}
+		return models.Attachment{}, false, err
+	}
+
+	if replaced != "" {
+		if err := s.sweep(ctx, replaced); err != nil {
+			log.Error().Err(err).Str("sha256", replaced).Msg("Error deleting replaced cover")
+		}
+	}
+	a, err := s.Get(ctx, songID, attachmentID)
+	return a, created, err
+}
+
+// discard deletes the object of an upload whose transaction rolled back. Inserting the blob row
+// again, in a transaction that is rolled back too, locks the content while the object is deleted:
+// an upload of the same content meanwhile either stored it under its own row, which is then kept,
+// or waits and stores it again.
+func (s *Store) discard(ctx context.Context, sum string, size int64, contentType string) error {
+	// The upload may have failed because the client went away
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), 30*time.Second)
+	defer cancel()
+	tx, err := db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var free bool
+	err = tx.QueryRowContext(ctx, `
+		INSERT INTO blobs (sha256, size, content_type) VALUES ($1, $2, $3) ON CONFLICT (sha256) DO NOTHING RETURNING TRUE
+	`, sum, size, contentType).Scan(&free)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return s.blobs.Delete(ctx, blobKey(sum))
+}
+
+// Delete removes an attachment of a song, and its content unless other attachments share it
+func (s *Store) Delete(ctx context.Context, songID, attachmentID int) error {
+	var sum string
+	err := db.Db.QueryRowContext(ctx, `
+		DELETE FROM song_attachments WHERE song_id = $1 AND attachment_id = $2 RETURNING sha256
+	`, songID, attachmentID).Scan(&sum)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	} else if err != nil {
+		return err
+	}
+	if err := s.sweep(ctx, sum); err != nil {
+		log.Error().Err(err).Str("sha256", sum).Msg("Error deleting attachment content")
+	}
+	return nil
+}
+
+// sweep deletes a blob and its thumbnails once no attachment refers to it. The blob row stays
+// locked while its objects are deleted, so an upload of the same content waits and stores it again.
+func (s *Store) sweep(ctx context.Context, sum string) error {
+	return db.InTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, `
+			DELETE FROM blobs WHERE sha256 = $1 AND NOT EXISTS (SELECT 1 FROM song_attachments WHERE sha256 = $1)
+			RETURNING sha256
+		`, sum).Scan(&sum)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		keys := []string{blobKey(sum)}
+		for size := range ThumbnailSizes {
+			keys = append(keys, thumbnailKey(sum, size))
+		}
+		for _, key := range keys {
+			if err := s.blobs.Delete(ctx, key); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Start deletes the content of attachments removed along with their songs, every sweepInterval
+func (s *Store) Start(workers *worker.Group) {
+	workers.Go("attachment-sweeper", func(ctx context.Context) {
+		ticker := time.NewTicker(sweepInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.sweepOrphans(ctx); err != nil && ctx.Err() == nil {
+					log.Error().Err(err).Msg("Error sweeping attachment blobs")
+				}
+			}
+		}
+	})
+}
+
+func (s *Store) sweepOrphans(ctx context.Context) error {
+	rows, err := db.Db.QueryContext(ctx, `
+		SELECT sha256 FROM blobs b WHERE NOT EXISTS (SELECT 1 FROM song_attachments a WHERE a.sha256 = b.sha256)
+	`)
+	if err != nil {
+		return err
+	}
+	var orphans []string
+	for rows.Next() {
+		var sum string
+		if err := rows.Scan(&sum); err != nil {
+			rows.Close()
+			return err
+		}
+		orphans = append(orphans, sum)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, sum := range orphans {
+		if err := s.sweep(ctx, sum); err != nil {
+			return err
+		}
+	}
+	if len(orphans) > 0 {
+		log.Info().Int("count", len(orphans)).Msg("Deleted unused attachment blobs")
+	}
+	return nil
+}
+
+// Download is the content of an attachment or of one of its thumbnails
+type Download struct {
+	Body        io.ReadCloser
+	ContentType string
+	// Size is -1 when unknown
+	Size     int64
+	Filename string
+	ETag     string
+}
+
+// Open checks a signed download URL and opens the attachment, or its thumbnail of a size, making
+// and storing the thumbnail on first use
+func (s *Store) Open(ctx context.Context, attachmentID int, size string, expires int64, signature string) (Download, error) {
+	if time.Now().Unix() > expires || !hmac.Equal([]byte(signature), []byte(s.signature(attachmentID, size, expires))) {
+		return Download{}, ErrBadSignature
+	}
+	var a models.Attachment
+	err := db.Reader().QueryRowContext(ctx, `
+		SELECT a.filename, b.sha256, b.size, b.content_type, b.width, b.height
+		FROM song_attachments a JOIN blobs b USING (sha256) WHERE a.attachment_id = $1
+	`, attachmentID).Scan(&a.Filename, &a.SHA256, &a.Size, &a.ContentType, &a.Width, &a.Height)
+	if errors.Is(err, sql.ErrNoRows) {
+		return Download{}, ErrNotFound
+	} else if err != nil {
+		return Download{}, err
+	}
+
+	if size == "" {
+		body, err := s.blobs.Get(ctx, blobKey(a.SHA256))
+		if err != nil {
+			return Download{}, err
+		}
+		return Download{Body: body, ContentType: a.ContentType, Size: a.Size, Filename: a.Filename, ETag: `"` + a.SHA256 + `"`}, nil
+	}
+
+	pixels, ok := ThumbnailSizes[size]
+	if !ok || !canScale(a.ContentType, a.Width, a.Height) {
+		return Download{}, ErrNotScalable
+	}
+	download := Download{
+		ContentType: "image/jpeg",
+		Size:        -1,
+		Filename:    strings.TrimSuffix(a.Filename, filepath.Ext(a.Filename)) + "-" + size + ".jpg",
+		ETag:        `"` + a.SHA256 + "-" + size + `"`,
+	}
+	body, err := s.blobs.Get(ctx, thumbnailKey(a.SHA256, size))
+	if err == nil {
+		download.Body = body
+		return download, nil
+	} else if !errors.Is(err, blobstore.ErrNotFound) {
+		return Download{}, err
+	}
+
+	original, err := s.blobs.Get(ctx, blobKey(a.SHA256))
+	if err != nil {
+		return Download{}, err
+	}
+	defer original.Close()
+	thumb, err := thumbnail(original, pixels)
+	if err != nil {
+		return Download{}, fmt.Errorf("%w: %v", ErrNotScalable, err)
+	}
+	if err := s.blobs.Put(ctx, thumbnailKey(a.SHA256, size), bytes.NewReader(thumb), int64(len(thumb)), "image/jpeg"); err != nil {
+		// The thumbnail is served anyway and made again next time
+		log.Error().Err(err).Str("sha256", a.SHA256).Msg("Error storing thumbnail")
+	}
+	download.Body, download.Size = io.NopCloser(bytes.NewReader(thumb)), int64(len(thumb))
+	return download, nil
+}
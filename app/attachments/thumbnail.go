@@ -0,0 +1,75 @@
+package attachments
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	_ "image/gif"
+	"image/jpeg"
+	_ "image/png"
+	"io"
+	"slices"
+)
+
+// ThumbnailSizes are the longest sides, in pixels, of the thumbnail sizes
+var ThumbnailSizes = map[string]int{"small": 128, "medium": 512, "large": 1024}
+
+// scalable lists the content types thumbnails can be made of
+var scalable = []string{"image/jpeg", "image/png", "image/gif"}
+
+// maxPixels bounds the images decoded for thumbnails, against decompression bombs
+const maxPixels = 50_000_000
+
+// canScale reports whether thumbnails can be made of an image of a type and dimensions
+func canScale(contentType string, width, height *int) bool {
+	return slices.Contains(scalable, contentType) && width != nil && height != nil && *width**height <= maxPixels
+}
+
+// thumbnail scales an image down so that its longest side is at most size and encodes it as JPEG,
+// flattening transparency onto white. Smaller images keep their dimensions.
+func thumbnail(r io.Reader, size int) ([]byte, error) {
+	src, _, err := image.Decode(r)
+	if err != nil {
+		return nil, err
+	}
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	flat := image.NewRGBA(image.Rect(0, 0, sw, sh))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.White), image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), src, bounds.Min, draw.Over)
+
+	dw, dh := sw, sh
+	if longest := max(sw, sh); longest > size {
+		dw, dh = max(1, sw*size/longest), max(1, sh*size/longest)
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, boxScale(flat, dw, dh), &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// boxScale shrinks src to dw by dh pixels, averaging the source pixels each target pixel covers
+func boxScale(src *image.RGBA, dw, dh int) *image.RGBA {
+	sw, sh := src.Bounds().Dx(), src.Bounds().Dy()
+	dst := image.NewRGBA(image.Rect(0, 0, dw, dh))
+	for y := range dh {
+		y0, y1 := y*sh/dh, max((y+1)*sh/dh, y*sh/dh+1)
+		for x := range dw {
+			x0, x1 := x*sw/dw, max((x+1)*sw/dw, x*sw/dw+1)
+			var r, g, b, n int
+			for sy := y0; sy < y1; sy++ {
+				row := src.Pix[sy*src.Stride:]
+				for sx := x0; sx < x1; sx++ {
+					p := row[sx*4:]
+					r, g, b = r+int(p[0]), g+int(p[1]), b+int(p[2])
+					n++
+				}
+			}
+			d := dst.Pix[y*dst.Stride+x*4:]
+			d[0], d[1], d[2], d[3] = uint8(r/n), uint8(g/n), uint8(b/n), 0xff
+		}
+	}
+	return dst
+}
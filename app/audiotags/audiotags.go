@@ -0,0 +1,197 @@
+// Package audiotags reads the tags, duration and content hashes of MP3 and FLAC files
+package audiotags
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnsupported = errors.New("not an MP3 or FLAC file")
+	ErrInvalid     = errors.New("invalid audio file")
+)
+
+// Formats of audio files
+const (
+	FormatMP3  = "mp3"
+	FormatFLAC = "flac"
+)
+
+// Tags are the values of the ID3 or Vorbis comment tags of a file; missing values are empty
+type Tags struct {
+	Title  string
+	Artist string
+	Album  string
+	// Date is YYYY, YYYY-MM or YYYY-MM-DD
+	Date   string
+	ISRC   string
+	BPM    string
+	Key    string
+	Lyrics string
+	// LyricsLanguage is the language of the lyrics or, failing that, of the recording as written in
+	// the tags: an ISO 639-2 code in ID3, anything in Vorbis comments
+	LyricsLanguage string
+}
+
+// File describes an audio file
+type File struct {
+	Format     string
+	Tags       Tags
+	Duration   time.Duration
+	SampleRate int
+	// Bitrate is the average bitrate of the audio in kbit/s
+	Bitrate int
+	// SHA256 is the hash of the whole file
+	SHA256 string
+	// AudioSHA256 is the hash of the audio alone, without tags, so that it stays the same when a
+	// file is retagged
+	AudioSHA256 string
+
+	// audioBytes is the size of the audio, which ends where the trailing tags start
+	audioBytes int64
+}
+
+// Read reads an MP3 or FLAC file of a size
+func Read(r io.ReaderAt, size int64) (File, error) {
+	var f File
+	var id3 Tags
+	start := int64(0)
+	head := make([]byte, 10)
+	if _, err := r.ReadAt(head, 0); err != nil {
+		return File{}, ErrUnsupported
+	}
+	if string(head[:3]) == "ID3" {
+		tagSize, err := readID3v2(r, head, size, &id3)
+		if err != nil {
+			return File{}, err
+		}
+		start = tagSize
+	}
+
+	magic := make([]byte, 4)
+	if _, err := r.ReadAt(magic, start); err == nil && string(magic) == "fLaC" {
+		f.Format = FormatFLAC
+		end, err := readFLAC(r, start, size, &f)
+		if err != nil {
+			return File{}, err
+		}
+		f.Tags = merge(f.Tags, id3)
+		if err := f.hash(r, size, end); err != nil {
+			return File{}, err
+		}
+		return f, nil
+	}
+
+	f.Format = FormatMP3
+	f.Tags = id3
+	end := trailerStart(r, size, &f.Tags)
+	if err := readMPEG(r, start, end, &f); err != nil {
+		return File{}, err
+	}
+	if err := f.hash(r, size, end); err != nil {
+		return File{}, err
+	}
+	return f, nil
+}
+
+// hash sets the hashes of the file and of its audio, which ends at end
+func (f *File) hash(r io.ReaderAt, size, end int64) error {
+	sum := sha256.New()
+	if _, err := io.Copy(sum, io.NewSectionReader(r, 0, size)); err != nil {
+		return err
+	}
+	f.SHA256 = hex.EncodeToString(sum.Sum(nil))
+
+	audio := sha256.New()
+	start := end - f.audioBytes
+	if _, err := io.Copy(audio, io.NewSectionReader(r, start, f.audioBytes)); err != nil {
+		return err
+	}
+	f.AudioSHA256 = hex.EncodeToString(audio.Sum(nil))
+	if f.Duration > 0 && f.Bitrate == 0 {
+		f.Bitrate = int(float64(f.audioBytes*8) / f.Duration.Seconds() / 1000)
+	}
+	return nil
+}
+
+// merge fills the empty values of tags with those of fallback
+func merge(tags, fallback Tags) Tags {
+	for _, field := range []struct{ value, fallback *string }{
+		{&tags.Title, &fallback.Title}, {&tags.Artist, &fallback.Artist}, {&tags.Album, &fallback.Album},
+		{&tags.Date, &fallback.Date}, {&tags.ISRC, &fallback.ISRC}, {&tags.BPM, &fallback.BPM},
+		{&tags.Key, &fallback.Key}, {&tags.Lyrics, &fallback.Lyrics}, {&tags.LyricsLanguage, &fallback.LyricsLanguage},
+	} {
+		if *field.value == "" {
+			*field.value = *field.fallback
+		}
+	}
+	return tags
+}
+
+// trailerStart returns where the ID3v1 and APEv2 tags at the end of a file start, reading the ID3v1
+// tag into the empty values of tags
+func trailerStart(r io.ReaderAt, size int64, tags *Tags) int64 {
+	end := size
+	v1 := make([]byte, 128)
+	if size >= 128 {
+		if _, err := r.ReadAt(v1, size-128); err == nil && string(v1[:3]) == "TAG" {
+			*tags = merge(*tags, Tags{
+				Title:  latin1Field(v1[3:33]),
+				Artist: latin1Field(v1[33:63]),
+				Album:  latin1Field(v1[63:93]),
+				Date:   latin1Field(v1[93:97]),
+			})
+			end -= 128
+		}
+	}
+
+	footer := make([]byte, 32)
+	if end >= 32 {
+		if _, err := r.ReadAt(footer, end-32); err == nil && string(footer[:8]) == "APETAGEX" {
+			apeSize := int64(le32(footer[12:16]))
+			if le32(footer[20:24])&(1<<31) != 0 {
+				apeSize += 32
+			}
+			if apeSize <= end {
+				end -= apeSize
+			}
+		}
+	}
+	return end
+}
+
+func latin1Field(b []byte) string {
+	if i := strings.IndexByte(string(b), 0); i >= 0 {
+		b = b[:i]
+	}
+	return strings.TrimSpace(decodeLatin1(b))
+}
+
+func le32(b []byte) uint32 {
+	return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 | uint32(b[3])<<24
+}
+
+func be32(b []byte) uint32 {
+	return uint32(b[0])<<24 | uint32(b[1])<<16 | uint32(b[2])<<8 | uint32(b[3])
+}
+
+// sampleDuration is the playing time of a number of samples at a sample rate. It is computed in
+// seconds, since the count of a long stream times time.Second overflows, and false is returned when
+// the time does not fit in a time.Duration.
+func sampleDuration(samples int64, sampleRate int) (time.Duration, bool) {
+	seconds := float64(samples) / float64(sampleRate)
+	if seconds >= float64(math.MaxInt64/time.Second) {
+		return 0, false
+	}
+	return time.Duration(seconds * float64(time.Second)), true
+}
+
+func invalid(format string, args ...any) error {
+	return fmt.Errorf("%w: "+format, append([]any{ErrInvalid}, args...)...)
+}
@@ -0,0 +1,250 @@
+package audiotags
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"testing"
+	"time"
+)
+
+func syncsafeBytes(n int) []byte {
+	return []byte{byte(n >> 21 & 0x7f), byte(n >> 14 & 0x7f), byte(n >> 7 & 0x7f), byte(n & 0x7f)}
+}
+
+// id3Tag builds an ID3v2 tag of a version around a body of frames
+func id3Tag(major, flags byte, body []byte) []byte {
+	tag := append([]byte{'I', 'D', '3', major, 0, flags}, syncsafeBytes(len(body))...)
+	return append(tag, body...)
+}
+
+// id3Frame builds a frame of a tag version
+func id3Frame(major byte, id string, flags byte, data []byte) []byte {
+	switch major {
+	case 2:
+		return append([]byte{id[0], id[1], id[2], byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}, data...)
+	case 3:
+		frame := binary.BigEndian.AppendUint32([]byte(id), uint32(len(data)))
+		return append(append(frame, 0, flags), data...)
+	default:
+		frame := append([]byte(id), syncsafeBytes(len(data))...)
+		return append(append(frame, 0, flags), data...)
+	}
+}
+
+func latin1(s string) []byte { return append([]byte{0}, s...) }
+
+func utf8Text(s string) []byte { return append([]byte{3}, s...) }
+
+// utf16Text encodes ASCII text as UTF-16 with a little-endian byte order mark
+func utf16Text(s string) []byte {
+	b := []byte{1, 0xff, 0xfe}
+	for _, r := range s {
+		b = append(b, byte(r), 0)
+	}
+	return b
+}
+
+// mpegFrames returns two 128 kbit/s 44.1 kHz MPEG 1 Layer III frames of silence
+func mpegFrames() []byte {
+	frame := make([]byte, 417)
+	copy(frame, []byte{0xff, 0xfb, 0x90, 0x00})
+	return append(frame, frame...)
+}
+
+func concat(parts ...[]byte) []byte {
+	return bytes.Join(parts, nil)
+}
+
+// flacFile builds a FLAC stream of 10 seconds at 44.1 kHz with a Vorbis comment block
+func flacFile(comments ...string) []byte {
+	info := make([]byte, 34)
+	info[10], info[11], info[12], info[13] = 0x0a, 0xc4, 0x42, 0xf0
+	binary.BigEndian.PutUint32(info[14:], 441000)
+
+	vorbis := binary.LittleEndian.AppendUint32(nil, 6)
+	vorbis = append(vorbis, "vendor"...)
+	vorbis = binary.LittleEndian.AppendUint32(vorbis, uint32(len(comments)))
+	for _, c := range comments {
+		vorbis = binary.LittleEndian.AppendUint32(vorbis, uint32(len(c)))
+		vorbis = append(vorbis, c...)
+	}
+
+	block := func(last bool, blockType byte, data []byte) []byte {
+		if last {
+			blockType |= 0x80
+		}
+		return append([]byte{blockType, byte(len(data) >> 16), byte(len(data) >> 8), byte(len(data))}, data...)
+	}
+	return concat([]byte("fLaC"), block(false, flacStreamInfo, info), block(true, flacVorbisComment, vorbis), make([]byte, 64))
+}
+
+// withStreamInfo sets the sample rate and the number of samples of a FLAC stream built by flacFile
+func withStreamInfo(file []byte, sampleRate int, samples int64) []byte {
+	info := file[8:]
+	info[10], info[11], info[12] = byte(sampleRate>>12), byte(sampleRate>>4), byte(sampleRate<<4)|info[12]&0x0f
+	info[13] = info[13]&0xf0 | byte(samples>>32)&0x0f
+	binary.BigEndian.PutUint32(info[14:], uint32(samples))
+	return file
+}
+
+func TestRead(t *testing.T) {
+	tests := []struct {
+		name     string
+		file     []byte
+		format   string
+		tags     Tags
+		duration time.Duration
+	}{
+		{
+			name: "ID3v2.2",
+			file: concat(id3Tag(2, 0, concat(
+				id3Frame(2, "TT2", 0, latin1("Title")),
+				id3Frame(2, "TP1", 0, latin1("Artist")),
+				id3Frame(2, "TYE", 0, latin1("2006")),
+				id3Frame(2, "TDA", 0, latin1("1607")),
+			)), mpegFrames()),
+			format: FormatMP3,
+			tags:   Tags{Title: "Title", Artist: "Artist", Date: "2006-07-16"},
+		},
+		{
+			name: "ID3v2.3",
+			file: concat(id3Tag(3, 0, concat(
+				id3Frame(3, "TIT2", 0, utf16Text("Title")),
+				id3Frame(3, "TPE1", 0, latin1("One\x00Two")),
+				id3Frame(3, "TSRC", 0, latin1("USRC17607839")),
+				id3Frame(3, "TBPM", 0, latin1("120")),
+				id3Frame(3, "TKEY", 0, latin1("Am")),
+				id3Frame(3, "USLT", 0, concat([]byte{0}, []byte("eng"), []byte("\x00Some lyrics"))),
+			)), mpegFrames()),
+			format: FormatMP3,
+			tags: Tags{Title: "Title", Artist: "One, Two", ISRC: "USRC17607839", BPM: "120", Key: "Am",
+				Lyrics: "Some lyrics", LyricsLanguage: "eng"},
+		},
+		{
+			name: "ID3v2.4",
+			file: concat(id3Tag(4, 0, concat(
+				id3Frame(4, "TIT2", 0, utf8Text("Тайтл")),
+				id3Frame(4, "TDRC", 0, utf8Text("2020-05-01")),
+				id3Frame(4, "TLAN", 0, latin1("rus")),
+				// Frame unsynchronisation
+				id3Frame(4, "TALB", 0x02, latin1("a\xff\x00b")),
+			)), mpegFrames()),
+			format: FormatMP3,
+			tags:   Tags{Title: "Тайтл", Date: "2020-05-01", LyricsLanguage: "rus", Album: "aÿb"},
+		},
+		{
+			name: "ID3v2.3 unsynchronisation",
+			file: concat(id3Tag(3, 0x80, bytes.ReplaceAll(concat(
+				id3Frame(3, "TIT2", 0, latin1("a\xffb")),
+				id3Frame(3, "TPE1", 0, latin1("Artist")),
+			), []byte{0xff}, []byte{0xff, 0x00})), mpegFrames()),
+			format: FormatMP3,
+			tags:   Tags{Title: "aÿb", Artist: "Artist"},
+		},
+		{
+			name: "ID3v2.3 extended header",
+			file: concat(id3Tag(3, 0x40, concat(
+				[]byte{0, 0, 0, 6, 0, 0, 0, 0, 0, 0},
+				id3Frame(3, "TIT2", 0, latin1("Title")),
+			)), mpegFrames()),
+			format: FormatMP3,
+			tags:   Tags{Title: "Title"},
+		},
+		{
+			name: "ID3v2.4 extended header",
+			file: concat(id3Tag(4, 0x40, concat(
+				[]byte{0, 0, 0, 6, 1, 0},
+				id3Frame(4, "TIT2", 0, latin1("Title")),
+			)), mpegFrames()),
+			format: FormatMP3,
+			tags:   Tags{Title: "Title"},
+		},
+		{
+			name:     "Vorbis comments",
+			file:     flacFile("TITLE=Title", "artist=One", "ARTIST=Two", "DATE=1999", "INITIALKEY=8A", "LYRICS=Words", "ignored"),
+			format:   FormatFLAC,
+			tags:     Tags{Title: "Title", Artist: "One, Two", Date: "1999", Key: "8A", Lyrics: "Words"},
+			duration: 10 * time.Second,
+		},
+		{
+			name:     "Vorbis comments after ID3v2",
+			file:     concat(id3Tag(3, 0, id3Frame(3, "TALB", 0, latin1("Album"))), flacFile("TITLE=Title")),
+			format:   FormatFLAC,
+			tags:     Tags{Title: "Title", Album: "Album"},
+			duration: 10 * time.Second,
+		},
+		{
+			// The number of samples times a second overflows an int64
+			name:     "long FLAC",
+			file:     withStreamInfo(flacFile("TITLE=Title"), 44100, 44100*400000),
+			format:   FormatFLAC,
+			tags:     Tags{Title: "Title"},
+			duration: 400000 * time.Second,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f, err := Read(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if err != nil {
+				t.Fatalf("Read: %v", err)
+			}
+			if f.Format != tt.format {
+				t.Errorf("format = %q, want %q", f.Format, tt.format)
+			}
+			if f.Tags != tt.tags {
+				t.Errorf("tags = %+v, want %+v", f.Tags, tt.tags)
+			}
+			if tt.duration != 0 && f.Duration != tt.duration {
+				t.Errorf("duration = %v, want %v", f.Duration, tt.duration)
+			}
+			if f.SHA256 == "" || f.AudioSHA256 == "" {
+				t.Error("hashes are missing")
+			}
+		})
+	}
+}
+
+func TestReadAudioHashIgnoresTags(t *testing.T) {
+	audio := mpegFrames()
+	a := concat(id3Tag(3, 0, id3Frame(3, "TIT2", 0, latin1("One"))), audio)
+	b := concat(id3Tag(4, 0, id3Frame(4, "TIT2", 0, latin1("Another"))), audio)
+	fa, err := Read(bytes.NewReader(a), int64(len(a)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fb, err := Read(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fa.AudioSHA256 != fb.AudioSHA256 || fa.SHA256 == fb.SHA256 {
+		t.Errorf("retagging changed the audio hash or kept the file hash")
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	flac := flacFile("TITLE=Title")
+	tests := []struct {
+		name string
+		file []byte
+		want error
+	}{
+		{"ID3v2 size past the end", []byte{'I', 'D', '3', 3, 0, 0, 0x7f, 0x7f, 0x7f, 0x7f}, ErrInvalid},
+		{"ID3v2 footer past the end", concat(id3Tag(4, 0x10, nil), []byte{0, 0, 0}), ErrInvalid},
+		{"ID3v2 truncated body", id3Tag(3, 0, id3Frame(3, "TIT2", 0, latin1("Title")))[:15], ErrInvalid},
+		{"ID3v2 extended header too large", concat(id3Tag(3, 0x40, []byte{0, 0, 1, 0, 0, 0}), mpegFrames()), ErrInvalid},
+		{"FLAC truncated metadata", flac[:30], ErrInvalid},
+		{"FLAC without stream info", []byte("fLaC\x84\x00\x00\x00\x00\x00"), ErrInvalid},
+		{"FLAC longer than a duration", withStreamInfo(flacFile(), 1, 1<<36-1), ErrInvalid},
+		{"not audio", []byte("plain text, not an audio file at all"), ErrUnsupported},
+		{"too short", []byte("ID"), ErrUnsupported},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Read(bytes.NewReader(tt.file), int64(len(tt.file)))
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Read error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,125 @@
+package audiotags
+
+import (
+	"io"
+	"strings"
+)
+
+// FLAC metadata block types
+const (
+	flacStreamInfo    = 0
+	flacVorbisComment = 4
+)
+
+// readFLAC reads the metadata blocks of a FLAC stream at start and returns where the audio ends
+func readFLAC(r io.ReaderAt, start, size int64, f *File) (int64, error) {
+	offset := start + 4
+	header := make([]byte, 4)
+	for last := false; !last; {
+		if _, err := r.ReadAt(header, offset); err != nil {
+			return 0, invalid("truncated FLAC metadata")
+		}
+		last = header[0]&0x80 != 0
+		blockType := header[0] & 0x7f
+		length := int64(header[1])<<16 | int64(header[2])<<8 | int64(header[3])
+		offset += 4
+		if offset+length > size {
+			return 0, invalid("truncated FLAC metadata")
+		}
+
+		switch blockType {
+		case flacStreamInfo:
+			if length < 18 {
+				return 0, invalid("invalid FLAC stream info")
+			}
+			info := make([]byte, 18)
+			if _, err := r.ReadAt(info, offset); err != nil {
+				return 0, err
+			}
+			// Bits 80-99 hold the sample rate and bits 108-143 the number of samples
+			f.SampleRate = int(info[10])<<12 | int(info[11])<<4 | int(info[12])>>4
+			samples := int64(info[13]&0x0f)<<32 | int64(be32(info[14:18]))
+			if f.SampleRate > 0 {
+				var ok bool
+				if f.Duration, ok = sampleDuration(samples, f.SampleRate); !ok {
+					return 0, invalid("FLAC stream is too long")
+				}
+			}
+		case flacVorbisComment:
+			block := make([]byte, length)
+			if _, err := r.ReadAt(block, offset); err != nil {
+				return 0, err
+			}
+			f.Tags = vorbisComments(block)
+		}
+		offset += length
+	}
+	if f.SampleRate == 0 {
+		return 0, invalid("FLAC stream info is missing")
+	}
+
+	var ignored Tags
+	end := trailerStart(r, size, &ignored)
+	if end < offset {
+		end = offset
+	}
+	f.audioBytes = end - offset
+	return end, nil
+}
+
+// vorbisComments reads the fields of a Vorbis comment block, whose names are case-insensitive and
+// may repeat
+func vorbisComments(block []byte) Tags {
+	fields := map[string][]string{}
+	next := func() (string, bool) {
+		if len(block) < 4 {
+			return "", false
+		}
+		n := int(le32(block))
+		if n < 0 || 4+n > len(block) {
+			return "", false
+		}
+		value := string(block[4 : 4+n])
+		block = block[4+n:]
+		return value, true
+	}
+	if _, ok := next(); !ok { // vendor string
+		return Tags{}
+	}
+	if len(block) < 4 {
+		return Tags{}
+	}
+	count := le32(block)
+	block = block[4:]
+	for range count {
+		comment, ok := next()
+		if !ok {
+			break
+		}
+		name, value, found := strings.Cut(comment, "=")
+		if value = strings.TrimSpace(strings.ToValidUTF8(value, "�")); found && value != "" {
+			name = strings.ToUpper(name)
+			fields[name] = append(fields[name], value)
+		}
+	}
+
+	first := func(names ...string) string {
+		for _, name := range names {
+			if values := fields[name]; len(values) > 0 {
+				return values[0]
+			}
+		}
+		return ""
+	}
+	return Tags{
+		Title:          first("TITLE"),
+		Artist:         strings.Join(fields["ARTIST"], ", "),
+		Album:          first("ALBUM"),
+		Date:           first("DATE", "YEAR"),
+		ISRC:           first("ISRC"),
+		BPM:            first("BPM"),
+		Key:            first("INITIALKEY", "KEY"),
+		Lyrics:         first("LYRICS", "UNSYNCEDLYRICS"),
+		LyricsLanguage: first("LANGUAGE"),
+	}
+}
@@ -0,0 +1,220 @@
+package audiotags
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"unicode/utf16"
+)
+
+// id3v22Frames maps the three-letter frame IDs of ID3v2.2 to their later names
+var id3v22Frames = map[string]string{
+	"TT2": "TIT2", "TP1": "TPE1", "TAL": "TALB", "TYE": "TYER", "TDA": "TDAT",
+	"TRC": "TSRC", "TBP": "TBPM", "TKE": "TKEY", "TLA": "TLAN", "ULT": "USLT",
+}
+
+func syncsafe(b []byte) int64 {
+	return int64(b[0]&0x7f)<<21 | int64(b[1]&0x7f)<<14 | int64(b[2]&0x7f)<<7 | int64(b[3]&0x7f)
+}
+
+// unsynchronise undoes ID3 unsynchronisation, which inserts a zero byte after every 0xFF
+func unsynchronise(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xff, 0x00}, []byte{0xff})
+}
+
+// readID3v2 reads the ID3v2 tag at the start of a file of fileSize bytes, whose first ten bytes
+// are head, and returns its size
+func readID3v2(r io.ReaderAt, head []byte, fileSize int64, tags *Tags) (int64, error) {
+	major, flags := head[3], head[5]
+	size := syncsafe(head[6:10])
+	total := 10 + size
+	if flags&0x10 != 0 {
+		total += 10
+	}
+	// The size comes from the upload, so it is checked before anything is allocated for it
+	if total > fileSize {
+		return 0, invalid("truncated ID3v2 tag")
+	}
+	if major < 2 || major > 4 {
+		// An unknown version is skipped as a whole
+		return total, nil
+	}
+	body := make([]byte, size)
+	if _, err := r.ReadAt(body, 10); err != nil {
+		return 0, invalid("truncated ID3v2 tag")
+	}
+	if flags&0x80 != 0 && major < 4 {
+		body = unsynchronise(body)
+	}
+	if flags&0x40 != 0 && major > 2 && len(body) >= 4 {
+		skip := int64(be32(body[:4])) + 4
+		if major == 4 {
+			skip = syncsafe(body[:4])
+		}
+		if skip > int64(len(body)) {
+			return 0, invalid("invalid ID3v2 extended header")
+		}
+		body = body[skip:]
+	}
+
+	frames := map[string][]byte{}
+	for len(body) > 0 && body[0] != 0 {
+		var id string
+		var frameSize int64
+		var frameFlags byte
+		if major == 2 {
+			if len(body) < 6 {
+				break
+			}
+			id = id3v22Frames[string(body[:3])]
+			frameSize = int64(body[3])<<16 | int64(body[4])<<8 | int64(body[5])
+			body = body[6:]
+		} else {
+			if len(body) < 10 {
+				break
+			}
+			id = string(body[:4])
+			frameSize = int64(be32(body[4:8]))
+			if major == 4 {
+				frameSize = syncsafe(body[4:8])
+			}
+			frameFlags = body[9]
+			body = body[10:]
+		}
+		if frameSize > int64(len(body)) {
+			break
+		}
+		data := body[:frameSize]
+		body = body[frameSize:]
+		if _, seen := frames[id]; seen || id == "" {
+			continue
+		}
+		if data = frameData(major, frameFlags, data); data != nil {
+			frames[id] = data
+		}
+	}
+
+	text := func(id string) []string { return textValues(frames[id]) }
+	first := func(id string) string {
+		if values := text(id); len(values) > 0 {
+			return values[0]
+		}
+		return ""
+	}
+	tags.Title = first("TIT2")
+	tags.Artist = strings.Join(text("TPE1"), ", ")
+	tags.Album = first("TALB")
+	tags.ISRC = first("TSRC")
+	tags.BPM = first("TBPM")
+	tags.Key = first("TKEY")
+	tags.Date = first("TDRC")
+	if year := first("TYER"); tags.Date == "" && year != "" {
+		tags.Date = year
+		// TDAT is DDMM
+		if day := first("TDAT"); len(day) == 4 {
+			tags.Date += "-" + day[2:] + "-" + day[:2]
+		}
+	}
+	if uslt := frames["USLT"]; len(uslt) > 4 {
+		tags.LyricsLanguage = strings.TrimRight(string(uslt[1:4]), "\x00 ")
+		_, lyrics := splitTerminated(uslt[0], uslt[4:])
+		tags.Lyrics = strings.TrimSpace(decodeText(uslt[0], lyrics))
+	}
+	if tags.LyricsLanguage == "" {
+		tags.LyricsLanguage = first("TLAN")
+	}
+	return total, nil
+}
+
+// frameData strips the extra header bytes of a frame and undoes its unsynchronisation, returning
+// nil for compressed and encrypted frames, which are not read
+func frameData(major, flags byte, data []byte) []byte {
+	switch major {
+	case 3:
+		if flags&0xc0 != 0 {
+			return nil
+		}
+		if flags&0x20 != 0 && len(data) > 0 {
+			data = data[1:]
+		}
+	case 4:
+		if flags&0x0c != 0 {
+			return nil
+		}
+		if flags&0x40 != 0 && len(data) > 0 {
+			data = data[1:]
+		}
+		if flags&0x01 != 0 && len(data) >= 4 {
+			data = data[4:]
+		}
+		if flags&0x02 != 0 {
+			data = unsynchronise(data)
+		}
+	}
+	return data
+}
+
+// textValues decodes a text frame, whose values are separated by zero characters
+func textValues(data []byte) []string {
+	if len(data) < 2 {
+		return nil
+	}
+	var values []string
+	for _, value := range strings.Split(decodeText(data[0], data[1:]), "\x00") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// splitTerminated splits data after the terminator of the first string in an encoding
+func splitTerminated(encoding byte, data []byte) ([]byte, []byte) {
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(data); i += 2 {
+			if data[i] == 0 && data[i+1] == 0 {
+				return data[:i], data[i+2:]
+			}
+		}
+		return data, nil
+	}
+	if i := bytes.IndexByte(data, 0); i >= 0 {
+		return data[:i], data[i+1:]
+	}
+	return data, nil
+}
+
+// decodeText decodes ID3 text in an encoding: ISO-8859-1, UTF-16 with a byte order mark,
+// UTF-16BE or UTF-8
+func decodeText(encoding byte, data []byte) string {
+	switch encoding {
+	case 1, 2:
+		bigEndian := encoding == 2
+		units := make([]uint16, 0, len(data)/2)
+		for i := 0; i+1 < len(data); i += 2 {
+			switch {
+			case data[i] == 0xff && data[i+1] == 0xfe:
+				bigEndian = false
+			case data[i] == 0xfe && data[i+1] == 0xff:
+				bigEndian = true
+			case bigEndian:
+				units = append(units, uint16(data[i])<<8|uint16(data[i+1]))
+			default:
+				units = append(units, uint16(data[i+1])<<8|uint16(data[i]))
+			}
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	case 3:
+		return strings.TrimRight(strings.ToValidUTF8(string(data), "�"), "\x00")
+	default:
+		return strings.TrimRight(decodeLatin1(data), "\x00")
+	}
+}
+
+func decodeLatin1(data []byte) string {
+	runes := make([]rune, len(data))
+	for i, b := range data {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
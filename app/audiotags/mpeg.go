@@ -0,0 +1,140 @@
+package audiotags
+
+import (
+	"io"
+	"time"
+)
+
+// syncWindow is how far past the tags the first MPEG frame is looked for
+const syncWindow = 64 << 10
+
+var (
+	// mpeg1Bitrates and mpeg2Bitrates are the Layer III bitrates in kbit/s by bitrate index
+	mpeg1Bitrates = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	mpeg2Bitrates = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+	// sampleRates are the sample rates of MPEG 1, 2 and 2.5 by sample rate index
+	sampleRates = [3][3]int{{44100, 48000, 32000}, {22050, 24000, 16000}, {11025, 12000, 8000}}
+)
+
+// frameHeader is a decoded MPEG audio Layer III frame header
+type frameHeader struct {
+	mpeg1      bool
+	bitrate    int
+	sampleRate int
+	mono       bool
+	length     int64
+}
+
+// samples is the number of samples per channel in a frame
+func (h frameHeader) samples() int64 {
+	if h.mpeg1 {
+		return 1152
+	}
+	return 576
+}
+
+// sideInfo is the size of the side information that follows the header, where Xing headers start
+func (h frameHeader) sideInfo() int {
+	switch {
+	case h.mpeg1 && h.mono:
+		return 17
+	case h.mpeg1:
+		return 32
+	case h.mono:
+		return 9
+	default:
+		return 17
+	}
+}
+
+func parseFrameHeader(b []byte) (frameHeader, bool) {
+	if b[0] != 0xff || b[1]&0xe0 != 0xe0 {
+		return frameHeader{}, false
+	}
+	version, layer := (b[1]>>3)&3, (b[1]>>1)&3
+	bitrateIndex, rateIndex, padding := b[2]>>4, (b[2]>>2)&3, int64(b[2]>>1)&1
+	if version == 1 || layer != 1 || bitrateIndex == 0 || bitrateIndex == 15 || rateIndex == 3 {
+		return frameHeader{}, false
+	}
+	h := frameHeader{mpeg1: version == 3, mono: b[3]>>6 == 3}
+	switch version {
+	case 3:
+		h.bitrate, h.sampleRate = mpeg1Bitrates[bitrateIndex], sampleRates[0][rateIndex]
+		h.length = 144*int64(h.bitrate)*1000/int64(h.sampleRate) + padding
+	case 2:
+		h.bitrate, h.sampleRate = mpeg2Bitrates[bitrateIndex], sampleRates[1][rateIndex]
+		h.length = 72*int64(h.bitrate)*1000/int64(h.sampleRate) + padding
+	default:
+		h.bitrate, h.sampleRate = mpeg2Bitrates[bitrateIndex], sampleRates[2][rateIndex]
+		h.length = 72*int64(h.bitrate)*1000/int64(h.sampleRate) + padding
+	}
+	return h, true
+}
+
+// readMPEG finds the first MPEG Layer III frame between start and end and works out the duration,
+// from its Xing or VBRI header if it has one and from its bitrate otherwise
+func readMPEG(r io.ReaderAt, start, end int64, f *File) error {
+	window := make([]byte, min(syncWindow, max(0, end-start)))
+	n, _ := r.ReadAt(window, start)
+	window = window[:n]
+
+	for i := 0; i+4 <= len(window); i++ {
+		h, ok := parseFrameHeader(window[i:])
+		if !ok {
+			continue
+		}
+		// A sync pattern in junk is told apart from a frame by the frame that follows it
+		offset := start + int64(i)
+		next := make([]byte, 4)
+		if offset+h.length+4 <= end {
+			if _, err := r.ReadAt(next, offset+h.length); err != nil {
+				continue
+			}
+			if following, ok := parseFrameHeader(next); !ok || following.sampleRate != h.sampleRate {
+				continue
+			}
+		}
+
+		f.SampleRate = h.sampleRate
+		f.audioBytes = end - offset
+		first := make([]byte, min(h.length, end-offset))
+		n, _ := r.ReadAt(first, offset)
+		if frames, audioSize, ok := vbrHeader(h, first[:n]); ok {
+			// The frame count is 32 bits, so the time always fits
+			f.Duration, _ = sampleDuration(frames*h.samples(), h.sampleRate)
+			if audioSize > 0 && f.Duration > 0 {
+				f.Bitrate = int(float64(audioSize*8) / f.Duration.Seconds() / 1000)
+			}
+		} else {
+			f.Bitrate = h.bitrate
+			f.Duration = time.Duration(float64(f.audioBytes*8) / float64(h.bitrate*1000) * float64(time.Second))
+		}
+		return nil
+	}
+	return ErrUnsupported
+}
+
+// vbrHeader reads the number of frames and audio bytes from the Xing, Info or VBRI header in the
+// first frame of a file
+func vbrHeader(h frameHeader, frame []byte) (int64, int64, bool) {
+	if at := 4 + h.sideInfo(); len(frame) >= at+16 {
+		if tag := string(frame[at : at+4]); tag == "Xing" || tag == "Info" {
+			flags := be32(frame[at+4:])
+			var frames, audioSize int64
+			field := at + 8
+			if flags&1 != 0 {
+				frames = int64(be32(frame[field:]))
+				field += 4
+			}
+			if flags&2 != 0 && len(frame) >= field+4 {
+				audioSize = int64(be32(frame[field:]))
+			}
+			return frames, audioSize, frames > 0
+		}
+	}
+	if len(frame) >= 36+18 && string(frame[36:40]) == "VBRI" {
+		audioSize, frames := int64(be32(frame[46:])), int64(be32(frame[50:]))
+		return frames, audioSize, frames > 0
+	}
+	return 0, 0, false
+}
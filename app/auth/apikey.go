@@ -0,0 +1,133 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"song_library/db"
+	"strings"
+	"time"
+)
+
+// APIKeyPrefix marks tokens that are API keys rather than JWTs
+const APIKeyPrefix = "sl_"
+
+var ErrInvalidAPIKey = errors.New("invalid API key")
+
+type APIKey struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt *time.Time `json:"lastUsedAt,omitempty"`
+	RevokedAt  *time.Time `json:"revokedAt,omitempty"`
+}
+
+func hashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// parseAPIKey splits a key of the form sl_<prefix>_<secret> and returns its prefix
+func parseAPIKey(key string) (string, bool) {
+	rest, ok := strings.CutPrefix(key, APIKeyPrefix)
+	if !ok {
+		return "", false
+	}
+	prefix, secret, ok := strings.Cut(rest, "_")
+	if !ok || prefix == "" || secret == "" {
+		return "", false
+	}
+	return prefix, true
+}
+
+// CreateAPIKey mints a new API key, stores its hash and returns the plaintext key once
+func CreateAPIKey(ctx context.Context, name string) (*APIKey, string, error) {
+	prefixBytes := make([]byte, 4)
+	secretBytes := make([]byte, 32)
+	if _, err := rand.Read(prefixBytes); err != nil {
+		return nil, "", err
+	}
+	if _, err := rand.Read(secretBytes); err != nil {
+		return nil, "", err
+	}
+
+	prefix := hex.EncodeToString(prefixBytes)
+	plain := fmt.Sprintf("%s%s_%s", APIKeyPrefix, prefix, base64.RawURLEncoding.EncodeToString(secretBytes))
+
+	key := &APIKey{Name: name, Prefix: prefix}
+	err := db.Db.QueryRowContext(ctx,
+		"INSERT INTO api_keys (name, key_prefix, key_hash) VALUES ($1, $2, $3) RETURNING key_id, created_at",
+		name, prefix, hashAPIKey(plain),
+	).Scan(&key.ID, &key.CreatedAt)
+	if err != nil {
+		return nil, "", err
+	}
+	return key, plain, nil
+}
+
+// ListAPIKeys returns all API keys, including revoked ones
+func ListAPIKeys(ctx context.Context) ([]APIKey, error) {
+	rows, err := db.Db.QueryContext(ctx, "SELECT key_id, name, key_prefix, created_at, last_used_at, revoked_at FROM api_keys ORDER BY key_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		var k APIKey
+		if err := rows.Scan(&k.ID, &k.Name, &k.Prefix, &k.CreatedAt, &k.LastUsedAt, &k.RevokedAt); err != nil {
+			return nil, err
+		}
+		keys = append(keys, k)
+	}
+	return keys, rows.Err()
+}
+
+// RevokeAPIKey marks an API key as revoked; it reports false if no active key has the ID
+func RevokeAPIKey(ctx context.Context, id int) (bool, error) {
+	result, err := db.Db.ExecContext(ctx, "UPDATE api_keys SET revoked_at = NOW() WHERE key_id = $1 AND revoked_at IS NULL", id)
+	if err != nil {
+		return false, err
+	}
+	count, _ := result.RowsAffected()
+	return count > 0, nil
+}
+
+// VerifyAPIKey checks a plaintext key against the stored hashes and returns its principal
+func VerifyAPIKey(ctx context.Context, plain string) (*Principal, error) {
+	prefix, ok := parseAPIKey(plain)
+	if !ok {
+		return nil, ErrInvalidAPIKey
+	}
+
+	var (
+		id        int
+		name      string
+		hash      string
+		revokedAt *time.Time
+	)
+	err := db.Db.QueryRowContext(ctx,
+		"SELECT key_id, name, key_hash, revoked_at FROM api_keys WHERE key_prefix = $1", prefix,
+	).Scan(&id, &name, &hash, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return nil, err
+	}
+	if revokedAt != nil || subtle.ConstantTimeCompare([]byte(hash), []byte(hashAPIKey(plain))) != 1 {
+		return nil, ErrInvalidAPIKey
+	}
+
+	db.Db.ExecContext(ctx, "UPDATE api_keys SET last_used_at = NOW() WHERE key_id = $1", id)
+
+	return &Principal{Subject: fmt.Sprintf("apikey:%d", id), Name: name, Method: MethodAPIKey}, nil
+}
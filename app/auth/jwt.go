@@ -0,0 +1,193 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"math/big"
+	"os"
+	"song_library/config"
+)
+
+var ErrJWTNotConfigured = errors.New("JWT authentication is not configured")
+
+// JWTVerifier validates bearer tokens signed with a shared HMAC secret or a key from a JWKS file
+type JWTVerifier struct {
+	secret   []byte
+	keys     map[string]interface{}
+	issuer   string
+	audience string
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Crv string `json:"crv"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// NewJWTVerifier builds a verifier from the configuration; it returns nil if neither a secret nor a JWKS file is set
+func NewJWTVerifier(cfg *config.Config) (*JWTVerifier, error) {
+	if cfg.JWTSecret == "" && cfg.JWTJWKSFile == "" {
+		return nil, nil
+	}
+
+	v := &JWTVerifier{
+		secret:   []byte(cfg.JWTSecret),
+		keys:     map[string]interface{}{},
+		issuer:   cfg.JWTIssuer,
+		audience: cfg.JWTAudience,
+	}
+	if cfg.JWTJWKSFile != "" {
+		if err := v.loadJWKS(cfg.JWTJWKSFile); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
+
+func (v *JWTVerifier) loadJWKS(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("reading JWKS file: %w", err)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return fmt.Errorf("parsing JWKS file: %w", err)
+	}
+
+	for _, k := range set.Keys {
+		key, err := k.publicKey()
+		if err != nil {
+			return fmt.Errorf("JWKS key %q: %w", k.Kid, err)
+		}
+		v.keys[k.Kid] = key
+	}
+	if len(v.keys) == 0 {
+		return errors.New("JWKS file contains no keys")
+	}
+	return nil
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func (v *JWTVerifier) keyFunc(token *jwt.Token) (interface{}, error) {
+	switch token.Method.(type) {
+	case *jwt.SigningMethodHMAC:
+		if len(v.secret) == 0 {
+			return nil, errors.New("HMAC tokens are not accepted")
+		}
+		return v.secret, nil
+	case *jwt.SigningMethodRSA, *jwt.SigningMethodRSAPSS, *jwt.SigningMethodECDSA:
+		kid, _ := token.Header["kid"].(string)
+		if key, ok := v.keys[kid]; ok {
+			return key, nil
+		}
+		if kid == "" && len(v.keys) == 1 {
+			for _, key := range v.keys {
+				return key, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown key id %q", kid)
+	default:
+		return nil, fmt.Errorf("unexpected signing method %s", token.Method.Alg())
+	}
+}
+
+// Verify parses and validates a bearer token and returns its principal
+func (v *JWTVerifier) Verify(raw string) (*Principal, error) {
+	if v == nil {
+		return nil, ErrJWTNotConfigured
+	}
+
+	options := []jwt.ParserOption{jwt.WithExpirationRequired()}
+	if v.issuer != "" {
+		options = append(options, jwt.WithIssuer(v.issuer))
+	}
+	if v.audience != "" {
+		options = append(options, jwt.WithAudience(v.audience))
+	}
+
+	claims := jwt.MapClaims{}
+	if _, err := jwt.ParseWithClaims(raw, claims, v.keyFunc, options...); err != nil {
+		return nil, err
+	}
+
+	subject, err := claims.GetSubject()
+	if err != nil || subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	name, _ := claims["name"].(string)
+	if name == "" {
+		name, _ = claims["preferred_username"].(string)
+	}
+	if name == "" {
+		name = subject
+	}
+
+	var roles []string
+	if values, ok := claims["roles"].([]interface{}); ok {
+		for _, value := range values {
+			if role, ok := value.(string); ok {
+				roles = append(roles, role)
+			}
+		}
+	}
+
+	return &Principal{Subject: subject, Name: name, Method: MethodJWT, Roles: roles}, nil
+}
@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"net/http"
+	"song_library/config"
+	"song_library/db"
+	"song_library/logging"
+	"strings"
+)
+
+func unauthorized(c *gin.Context, message string) {
+	c.Header("WWW-Authenticate", `Bearer realm="song_library"`)
+	c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": message})
+}
+
+// Middleware authenticates requests with an X-API-Key header or an Authorization bearer token
+// and places the resulting principal on the gin context
+func Middleware(cfg *config.Config) gin.HandlerFunc {
+	verifier, err := NewJWTVerifier(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("JWT verifier initialization failed")
+	}
+	if verifier == nil {
+		log.Warn().Msg("JWT_SECRET and JWT_JWKS_FILE are not set, only API keys are accepted")
+	}
+
+	return func(c *gin.Context) {
+		logger := logging.Ctx(c)
+		token := c.GetHeader("X-API-Key")
+		if token == "" {
+			scheme, value, found := strings.Cut(c.GetHeader("Authorization"), " ")
+			if found && strings.EqualFold(scheme, "Bearer") {
+				token = strings.TrimSpace(value)
+			}
+		}
+		if token == "" {
+			logger.Debug().Msg("Request has no credentials")
+			unauthorized(c, "Authentication required")
+			return
+		}
+
+		var (
+			principal *Principal
+			err       error
+		)
+		if strings.HasPrefix(token, APIKeyPrefix) {
+			principal, err = VerifyAPIKey(c.Request.Context(), token)
+			if err != nil && !errors.Is(err, ErrInvalidAPIKey) {
+				logger.Error().Err(err).Msg("API key lookup failed")
+				status, message := db.HTTPStatus(c.Request.Context(), err)
+				c.AbortWithStatusJSON(status, gin.H{"error": message})
+				return
+			}
+		} else {
+			principal, err = verifier.Verify(token)
+		}
+		if err != nil {
+			logger.Warn().Err(err).Msg("Authentication failed")
+			unauthorized(c, "Invalid credentials")
+			return
+		}
+
+		if err := loadPermissions(c.Request.Context(), principal); err != nil {
+			logger.Error().Err(err).Msg("Loading permissions failed")
+			status, message := db.HTTPStatus(c.Request.Context(), err)
+			c.AbortWithStatusJSON(status, gin.H{"error": message})
+			return
+		}
+
+		logging.With(c, func(l zerolog.Context) zerolog.Context {
+			return l.Str("principal", principal.Subject)
+		})
+		logger.Debug().Str("method", principal.Method).Strs("roles", principal.Roles).Msg("Authenticated")
+		SetPrincipal(c, principal)
+		c.Next()
+	}
+}
+
+// QueryToken lets clients that cannot set headers, such as EventSource and browser WebSockets,
+// pass their API key or bearer token in a query parameter. It goes before Middleware and is meant
+// only for routes whose URLs are not shared, since query strings end up in proxy logs.
+func QueryToken(param string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		query := c.Request.URL.Query()
+		token := query.Get(param)
+		if token != "" && c.GetHeader("X-API-Key") == "" && c.GetHeader("Authorization") == "" {
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+		}
+		if query.Has(param) {
+			query.Del(param)
+			c.Request.URL.RawQuery = query.Encode()
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import "github.com/gin-gonic/gin"
+
+const principalKey = "principal"
+
+// Principal identifies the caller of a request
+type Principal struct {
+	Subject string   `json:"subject"`
+	Name    string   `json:"name"`
+	Method  string   `json:"method"`
+	Roles   []string `json:"roles"`
+
+	permissions map[string]bool
+}
+
+// Can reports whether the principal has been granted a permission through any of its roles
+func (p *Principal) Can(permission string) bool {
+	return p != nil && p.permissions[permission]
+}
+
+const (
+	MethodAPIKey = "api_key"
+	MethodJWT    = "jwt"
+)
+
+// SetPrincipal stores the authenticated caller on the gin context
+func SetPrincipal(c *gin.Context, p *Principal) {
+	c.Set(principalKey, p)
+}
+
+// PrincipalFrom returns the authenticated caller stored on the gin context, or nil
+func PrincipalFrom(c *gin.Context) *Principal {
+	value, ok := c.Get(principalKey)
+	if !ok {
+		return nil
+	}
+	p, _ := value.(*Principal)
+	return p
+}
+
+// SubjectFrom returns the subject of the authenticated caller, or "anonymous"
+func SubjectFrom(c *gin.Context) string {
+	if p := PrincipalFrom(c); p != nil {
+		return p.Subject
+	}
+	return "anonymous"
+}
@@ -0,0 +1,164 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgtype"
+	"net/http"
+	"song_library/db"
+	"song_library/logging"
+	"sort"
+)
+
+const (
+	PermSongsRead      = "songs:read"
+	PermSongsWrite     = "songs:write"
+	PermSongsDelete    = "songs:delete"
+	PermPlaylistsWrite = "playlists:write"
+	PermWebhooksManage = "webhooks:manage"
+	PermAdmin          = "admin"
+)
+
+var ErrUnknownRole = errors.New("unknown role")
+
+type Role struct {
+	Name        string   `json:"name"`
+	Description string   `json:"description"`
+	Permissions []string `json:"permissions"`
+}
+
+// Problem is an RFC 7807 problem details response body
+type Problem struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Status int    `json:"status"`
+	Detail string `json:"detail,omitempty"`
+}
+
+// loadPermissions merges the roles granted to the subject in the database with the roles
+// already carried by the principal and resolves them to permissions
+func loadPermissions(ctx context.Context, p *Principal) error {
+	rows, err := db.Db.QueryContext(ctx, `
+		SELECT DISTINCT rp.role_name, rp.permission
+		FROM role_permissions rp
+		WHERE rp.role_name IN (SELECT role_name FROM subject_roles WHERE subject = $1)
+		   OR rp.role_name = ANY($2)
+	`, p.Subject, p.Roles)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	roles := map[string]bool{}
+	p.permissions = map[string]bool{}
+	for rows.Next() {
+		var role, permission string
+		if err := rows.Scan(&role, &permission); err != nil {
+			return err
+		}
+		roles[role] = true
+		p.permissions[permission] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	p.Roles = p.Roles[:0]
+	for role := range roles {
+		p.Roles = append(p.Roles, role)
+	}
+	sort.Strings(p.Roles)
+	return nil
+}
+
+// Require aborts with 403 unless the authenticated principal has the given permission
+func Require(permission string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p := PrincipalFrom(c)
+		if p.Can(permission) {
+			c.Next()
+			return
+		}
+
+		logging.Ctx(c).Warn().Str("permission", permission).Msg("Permission denied")
+		c.Header("Content-Type", "application/problem+json")
+		c.AbortWithStatusJSON(http.StatusForbidden, Problem{
+			Type:   "about:blank",
+			Title:  "Forbidden",
+			Status: http.StatusForbidden,
+			Detail: "Missing permission " + permission,
+		})
+	}
+}
+
+// ListRoles returns every role with its permissions
+func ListRoles(ctx context.Context) ([]Role, error) {
+	rows, err := db.Db.QueryContext(ctx, `
+		SELECT r.role_name, r.description, COALESCE(array_agg(rp.permission ORDER BY rp.permission)
+			FILTER (WHERE rp.permission IS NOT NULL), '{}')
+		FROM roles r
+		LEFT JOIN role_permissions rp ON rp.role_name = r.role_name
+		GROUP BY r.role_name, r.description
+		ORDER BY r.role_name
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := pgtype.NewMap()
+	roles := []Role{}
+	for rows.Next() {
+		var r Role
+		if err := rows.Scan(&r.Name, &r.Description, types.SQLScanner(&r.Permissions)); err != nil {
+			return nil, err
+		}
+		roles = append(roles, r)
+	}
+	return roles, rows.Err()
+}
+
+// SubjectRoles returns the roles granted to a subject in the database
+func SubjectRoles(ctx context.Context, subject string) ([]string, error) {
+	rows, err := db.Db.QueryContext(ctx, "SELECT role_name FROM subject_roles WHERE subject = $1 ORDER BY role_name", subject)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	roles := []string{}
+	for rows.Next() {
+		var role string
+		if err := rows.Scan(&role); err != nil {
+			return nil, err
+		}
+		roles = append(roles, role)
+	}
+	return roles, rows.Err()
+}
+
+// GrantRole assigns a role to a subject; granting an already held role is a no-op
+func GrantRole(ctx context.Context, subject, role string) error {
+	var exists bool
+	err := db.Db.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM roles WHERE role_name = $1)", role).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return ErrUnknownRole
+	}
+
+	_, err = db.Db.ExecContext(ctx, "INSERT INTO subject_roles (subject, role_name) VALUES ($1, $2) ON CONFLICT DO NOTHING", subject, role)
+	return err
+}
+
+// RevokeRole removes a role from a subject; it reports false if the subject did not hold it
+func RevokeRole(ctx context.Context, subject, role string) (bool, error) {
+	result, err := db.Db.ExecContext(ctx, "DELETE FROM subject_roles WHERE subject = $1 AND role_name = $2", subject, role)
+	if err != nil {
+		return false, err
+	}
+	count, _ := result.RowsAffected()
+	return count > 0, nil
+}
@@ -0,0 +1,66 @@
+package blobstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"song_library/config"
+	"strings"
+
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// BlobStore keeps binary objects by key. Keys are slash-separated paths of letters, digits, dots,
+// dashes and underscores; writing an existing key replaces the object.
+type BlobStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64, contentType string) error
+	// Get returns ErrNotFound for a missing key
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	// Delete succeeds for a missing key
+	Delete(ctx context.Context, key string) error
+}
+
+// New opens the blob store selected by attachments.backend
+func New(cfg *config.Config) (BlobStore, error) {
+	switch cfg.AttachmentsBackend {
+	case "s3":
+		endpoint, err := url.Parse(cfg.S3Endpoint)
+		if err != nil {
+			return nil, err
+		}
+		return &S3{
+			Endpoint:  endpoint,
+			Region:    cfg.S3Region,
+			Bucket:    cfg.S3Bucket,
+			AccessKey: cfg.S3AccessKey,
+			SecretKey: cfg.S3SecretKey,
+			PathStyle: cfg.S3PathStyle,
+			HTTP:      &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)},
+		}, nil
+	default:
+		return NewLocal(cfg.AttachmentsDir)
+	}
+}
+
+// checkKey rejects keys that could escape the store, such as ../etc/passwd
+func checkKey(key string) error {
+	if key == "" || strings.HasPrefix(key, "/") || strings.HasSuffix(key, "/") {
+		return fmt.Errorf("invalid blob key %q", key)
+	}
+	for _, part := range strings.Split(key, "/") {
+		if part == "" || part == "." || part == ".." {
+			return fmt.Errorf("invalid blob key %q", key)
+		}
+		for _, r := range part {
+			if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '-' || r == '_') {
+				return fmt.Errorf("invalid blob key %q", key)
+			}
+		}
+	}
+	return nil
+}
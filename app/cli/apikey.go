@@ -0,0 +1,81 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"song_library/auth"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+func runAPIKey(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	switch args[0] {
+	case "create":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "apikey create requires a name")
+			return 2
+		}
+		key, plain, err := auth.CreateAPIKey(context.Background(), strings.Join(args[1:], " "))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "creating API key: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Created API key %d (%s)\n", key.ID, key.Name)
+		fmt.Println("Store this key now, it will not be shown again:")
+		fmt.Println(plain)
+		return 0
+	case "list":
+		keys, err := auth.ListAPIKeys(context.Background())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "listing API keys: %v\n", err)
+			return 1
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tPREFIX\tCREATED\tLAST USED\tREVOKED")
+		for _, k := range keys {
+			fmt.Fprintf(w, "%d\t%s\t%s%s\t%s\t%s\t%s\n", k.ID, k.Name, auth.APIKeyPrefix, k.Prefix,
+				k.CreatedAt.Format(time.RFC3339), formatTime(k.LastUsedAt), formatTime(k.RevokedAt))
+		}
+		w.Flush()
+		return 0
+	case "revoke":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "apikey revoke requires an ID")
+			return 2
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "invalid API key ID %q\n", args[1])
+			return 2
+		}
+		revoked, err := auth.RevokeAPIKey(context.Background(), id)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "revoking API key: %v\n", err)
+			return 1
+		}
+		if !revoked {
+			fmt.Fprintf(os.Stderr, "no active API key with ID %d\n", id)
+			return 1
+		}
+		fmt.Printf("Revoked API key %d\n", id)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown apikey command %q\n%s\n", args[0], usage)
+		return 2
+	}
+}
+
+func formatTime(t *time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	return t.Format(time.RFC3339)
+}
@@ -0,0 +1,68 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"song_library/config"
+	"song_library/db"
+)
+
+const usage = `Usage:
+  app [flags]                  run the HTTP server
+  app [flags] config print     print the effective configuration with secrets redacted
+  app apikey create <name>     mint a new API key
+  app apikey list              list API keys
+  app apikey revoke <id>       revoke an API key
+  app role list                list roles and their permissions
+  app role grant <subject> <role>
+                               grant a role, e.g. "app role grant apikey:1 admin"
+  app role revoke <subject> <role>
+                               revoke a role
+  app songs export [file]      export songs as CSV with COPY, to stdout by default
+  app songs import <file>      import songs from CSV with COPY, - reads stdin
+  app songs ingest <path>...   create songs from the tags of MP3 and FLAC files, searching directories recursively
+  app lyrics import            split plain-text lyrics of songs without sections into sections`
+
+// openDB connects to the database for subcommands that need it
+func openDB(cfg *config.Config) (func(), error) {
+	// Bulk commands may run for minutes; statement_timeout only guards request handling
+	local := *cfg
+	local.DBStatementTimeout = 0
+	if err := db.InitDB(&local); err != nil {
+		return nil, err
+	}
+	db.Migrate()
+	return func() { db.Close() }, nil
+}
+
+func withDB(cfg *config.Config, run func() int) int {
+	closeDB, err := openDB(cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "connecting to database: %v\n", err)
+		return 1
+	}
+	defer closeDB()
+	return run()
+}
+
+// Run executes a command-line subcommand and returns the process exit code
+func Run(cfg *config.Config, args []string) int {
+	switch args[0] {
+	case "config":
+		return runConfig(cfg, args[1:])
+	case "apikey":
+		return withDB(cfg, func() int { return runAPIKey(args[1:]) })
+	case "role":
+		return withDB(cfg, func() int { return runRole(args[1:]) })
+	case "songs":
+		return withDB(cfg, func() int { return runSongs(args[1:]) })
+	case "lyrics":
+		return withDB(cfg, func() int { return runLyrics(args[1:]) })
+	case "help", "-h", "--help":
+		fmt.Println(usage)
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command %q\n%s\n", args[0], usage)
+		return 2
+	}
+}
@@ -0,0 +1,22 @@
+package cli
+
+import (
+	"fmt"
+	"os"
+	"song_library/config"
+)
+
+func runConfig(cfg *config.Config, args []string) int {
+	if len(args) != 1 || args[0] != "print" {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	out, err := cfg.Print()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	fmt.Print(out)
+	return 0
+}
@@ -0,0 +1,87 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"song_library/ingest"
+	"song_library/models"
+	"strings"
+)
+
+// audioExtensions are the extensions of the files songs ingest picks up in directories
+var audioExtensions = []string{".mp3", ".flac"}
+
+// audioFiles lists the files named on the command line and the audio files in the directories
+// named there, recursively
+func audioFiles(paths []string) ([]string, error) {
+	var files []string
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		err = filepath.WalkDir(path, func(name string, entry fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			if !entry.IsDir() && slices.Contains(audioExtensions, strings.ToLower(filepath.Ext(name))) {
+				files = append(files, name)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+func ingestFile(ctx context.Context, path string) (models.IngestResult, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return models.IngestResult{File: path, Status: models.IngestFailed}, err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return models.IngestResult{File: path, Status: models.IngestFailed}, err
+	}
+	return ingest.Ingest(ctx, path, file, info.Size(), "cli")
+}
+
+func runIngest(paths []string) int {
+	files, err := audioFiles(paths)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "listing audio files: %v\n", err)
+		return 1
+	}
+
+	counts := map[string]int{}
+	for _, path := range files {
+		result, err := ingestFile(context.Background(), path)
+		if err != nil {
+			result.Error = err.Error()
+		}
+		counts[result.Status]++
+		switch result.Status {
+		case models.IngestFailed:
+			fmt.Printf("%s\t%s\t%s\n", result.Status, path, result.Error)
+		default:
+			fmt.Printf("%s\t%s\tsong %d: %s - %s\n", result.Status, path, result.SongID, result.Group, result.Song)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Ingested %d files: %d created, %d matched, %d duplicates, %d failed\n", len(files),
+		counts[models.IngestCreated], counts[models.IngestMatched], counts[models.IngestDuplicate], counts[models.IngestFailed])
+	if counts[models.IngestFailed] > 0 {
+		return 1
+	}
+	return 0
+}
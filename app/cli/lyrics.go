@@ -0,0 +1,23 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"song_library/lyrics"
+)
+
+func runLyrics(args []string) int {
+	if len(args) != 1 || args[0] != "import" {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	count, err := lyrics.ImportPlainText(context.Background())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "importing lyrics: %v (%d songs imported)\n", err, count)
+		return 1
+	}
+	fmt.Printf("Split the lyrics of %d songs into sections\n", count)
+	return 0
+}
@@ -0,0 +1,64 @@
+package cli
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"song_library/auth"
+	"strings"
+)
+
+func runRole(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	switch args[0] {
+	case "list":
+		roles, err := auth.ListRoles(context.Background())
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "listing roles: %v\n", err)
+			return 1
+		}
+		for _, r := range roles {
+			fmt.Printf("%-10s %s\n", r.Name, strings.Join(r.Permissions, ", "))
+		}
+		return 0
+	case "grant":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "role grant requires a subject and a role")
+			return 2
+		}
+		if err := auth.GrantRole(context.Background(), args[1], args[2]); err != nil {
+			if errors.Is(err, auth.ErrUnknownRole) {
+				fmt.Fprintf(os.Stderr, "unknown role %q\n", args[2])
+				return 1
+			}
+			fmt.Fprintf(os.Stderr, "granting role: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Granted %s to %s\n", args[2], args[1])
+		return 0
+	case "revoke":
+		if len(args) != 3 {
+			fmt.Fprintln(os.Stderr, "role revoke requires a subject and a role")
+			return 2
+		}
+		revoked, err := auth.RevokeRole(context.Background(), args[1], args[2])
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "revoking role: %v\n", err)
+			return 1
+		}
+		if !revoked {
+			fmt.Fprintf(os.Stderr, "%s does not have role %s\n", args[1], args[2])
+			return 1
+		}
+		fmt.Printf("Revoked %s from %s\n", args[2], args[1])
+		return 0
+	default:
+		fmt.Fprintf(os.Stderr, "unknown role command %q\n%s\n", args[0], usage)
+		return 2
+	}
+}
@@ -0,0 +1,73 @@
+package cli
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"song_library/db"
+)
+
+// songColumns are the CSV columns of songs import and export, in order
+const songColumns = "group_name, song_name, release_date, lyrics, link, isrc, iswc, mb_recording_id, mb_work_id, duration_seconds, bpm, musical_key, explicit"
+
+func runSongs(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, usage)
+		return 2
+	}
+
+	switch args[0] {
+	case "export":
+		out := io.Writer(os.Stdout)
+		if len(args) > 1 && args[1] != "-" {
+			file, err := os.Create(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "creating export file: %v\n", err)
+				return 1
+			}
+			defer file.Close()
+			out = file
+		}
+		count, err := db.CopyTo(context.Background(), out,
+			"COPY (SELECT "+songColumns+" FROM songs ORDER BY song_id) TO STDOUT WITH (FORMAT csv, HEADER)")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "exporting songs: %v\n", err)
+			return 1
+		}
+		fmt.Fprintf(os.Stderr, "Exported %d songs\n", count)
+		return 0
+	case "import":
+		if len(args) != 2 {
+			fmt.Fprintln(os.Stderr, "songs import requires a CSV file, - for stdin")
+			return 2
+		}
+		in := io.Reader(os.Stdin)
+		if args[1] != "-" {
+			file, err := os.Open(args[1])
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "opening import file: %v\n", err)
+				return 1
+			}
+			defer file.Close()
+			in = file
+		}
+		count, err := db.CopyFrom(context.Background(), in,
+			"COPY songs ("+songColumns+") FROM STDIN WITH (FORMAT csv, HEADER)")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "importing songs: %v\n", err)
+			return 1
+		}
+		fmt.Printf("Imported %d songs\n", count)
+		return 0
+	case "ingest":
+		if len(args) < 2 {
+			fmt.Fprintln(os.Stderr, "songs ingest requires audio files or directories")
+			return 2
+		}
+		return runIngest(args[1:])
+	default:
+		fmt.Fprintf(os.Stderr, "unknown songs command %q\n%s\n", args[0], usage)
+		return 2
+	}
+}
@@ -1,53 +1,113 @@
 package config
 
 import (
-	"github.com/rs/zerolog/log"
-	"os"
+	"time"
 )
 
+// Config holds the application settings. Every field is resolved from, in increasing priority,
+// its default, the config file, the environment and command-line flags:
+//   - key is the dotted path in the YAML/TOML file; the flag name is derived from it (db.max_open_conns -> --db-max-open-conns)
+//   - env is the environment variable; <env>_FILE may point to a file holding the value instead, and
+//     an empty variable clears the value
+//   - secret fields are redacted when the configuration is printed or logged
 type Config struct {
-	DBHost         string
-	DBPort         string
-	DBUser         string
-	DBPassword     string
-	DBName         string
-	AppPort        string
-	ExternalAPIURL string
-}
+	DatabaseURL        string        `key:"db.url" env:"DATABASE_URL" secret:"true" desc:"Postgres connection URL; overrides the individual db.* connection settings"`
+	DatabaseReplicaURL string        `key:"db.replica_url" env:"DATABASE_REPLICA_URL" secret:"true" desc:"Postgres read replica URL used for song listings and lyrics"`
+	DBConnectTimeout   time.Duration `key:"db.connect_timeout" env:"DB_CONNECT_TIMEOUT" default:"60s" desc:"How long to retry connecting at startup"`
+	DBHost             string        `key:"db.host" env:"POSTGRES_HOST" desc:"Postgres host"`
+	DBPort             string        `key:"db.port" env:"POSTGRES_PORT" default:"5432" desc:"Postgres port"`
+	DBUser             string        `key:"db.user" env:"POSTGRES_USER" desc:"Postgres user"`
+	DBPassword         string        `key:"db.password" env:"POSTGRES_PASSWORD" secret:"true" desc:"Postgres password"`
+	DBName             string        `key:"db.name" env:"POSTGRES_DB" desc:"Postgres database"`
+	DBSSLMode          string        `key:"db.sslmode" env:"POSTGRES_SSLMODE" default:"disable" desc:"Postgres sslmode: disable, require, verify-ca or verify-full"`
+	DBSSLRootCert      string        `key:"db.sslrootcert" env:"POSTGRES_SSLROOTCERT" desc:"CA certificate used to verify the server"`
+	DBSSLCert          string        `key:"db.sslcert" env:"POSTGRES_SSLCERT" desc:"Client certificate"`
+	DBSSLKey           string        `key:"db.sslkey" env:"POSTGRES_SSLKEY" desc:"Client certificate key"`
+	DBMaxOpenConns     int           `key:"db.max_open_conns" env:"DB_MAX_OPEN_CONNS" default:"25" desc:"Maximum open connections"`
+	DBMaxIdleConns     int           `key:"db.max_idle_conns" env:"DB_MAX_IDLE_CONNS" default:"10" desc:"Connections kept open while idle"`
+	DBConnMaxLifetime  time.Duration `key:"db.conn_max_lifetime" env:"DB_CONN_MAX_LIFETIME" default:"30m" desc:"Maximum lifetime of a connection"`
+	DBConnMaxIdleTime  time.Duration `key:"db.conn_max_idle_time" env:"DB_CONN_MAX_IDLE_TIME" default:"5m" desc:"Maximum idle time of a connection"`
+	DBStatementTimeout time.Duration `key:"db.statement_timeout" env:"DB_STATEMENT_TIMEOUT" default:"5s" desc:"Postgres statement_timeout of every connection, 0 to disable"`
+	DBQueryExecMode    string        `key:"db.query_exec_mode" env:"DB_QUERY_EXEC_MODE" default:"cache_statement" desc:"pgx query mode: cache_statement, cache_describe, describe_exec, exec or simple_protocol"`
+	DBStatementCache   int           `key:"db.statement_cache_capacity" env:"DB_STATEMENT_CACHE_CAPACITY" default:"512" desc:"Prepared statements cached per connection"`
+
+	AppPort   string `key:"app.port" env:"APP_PORT" default:"8080" desc:"HTTP port"`
+	GinMode   string `key:"app.gin_mode" env:"GIN_MODE" default:"debug" desc:"Gin mode: debug, release or test"`
+	LogLevel  string `key:"log.level" env:"LOG_LEVEL" default:"info" desc:"Log level"`
+	LogFormat string `key:"log.format" env:"LOG_FORMAT" default:"json" desc:"Log format: json or console"`
+
+	ExternalAPIURL       string `key:"external_api.url" env:"EXTERNAL_API_URL" desc:"Base URL of the song enrichment API"`
+	MusicBrainzURL       string `key:"musicbrainz.url" env:"MUSICBRAINZ_URL" default:"https://musicbrainz.org/ws/2" desc:"MusicBrainz web service that resolves songs added by ISRC or recording ID, empty to disable"`
+	MusicBrainzUserAgent string `key:"musicbrainz.user_agent" env:"MUSICBRAINZ_USER_AGENT" default:"song_library/1.0" desc:"User agent sent to MusicBrainz, preferably with a contact address"`
+
+	LinkCheckInterval    time.Duration `key:"links.check_interval" env:"LINK_CHECK_INTERVAL" default:"10m" desc:"How often song links due a check are checked, 0 to disable the link checker"`
+	LinkCheckConcurrency int           `key:"links.check_concurrency" env:"LINK_CHECK_CONCURRENCY" default:"4" desc:"Song links checked at the same time"`
+	LinkCheckTimeout     time.Duration `key:"links.check_timeout" env:"LINK_CHECK_TIMEOUT" default:"10s" desc:"Timeout of each song link check"`
+	LinkRecheckAfter     time.Duration `key:"links.recheck_after" env:"LINK_RECHECK_AFTER" default:"24h" desc:"Age of the last check after which a song link is checked again"`
+
+	AttachmentsBackend      string        `key:"attachments.backend" env:"ATTACHMENTS_BACKEND" default:"local" desc:"Blob store of attachments: local or s3"`
+	AttachmentsDir          string        `key:"attachments.dir" env:"ATTACHMENTS_DIR" default:"data/attachments" desc:"Directory of the local blob store"`
+	AttachmentsMaxBytes     int64         `key:"attachments.max_bytes" env:"ATTACHMENTS_MAX_BYTES" default:"10485760" desc:"Maximum size of an uploaded attachment"`
+	AttachmentsAllowedTypes []string      `key:"attachments.allowed_types" env:"ATTACHMENTS_ALLOWED_TYPES" default:"image/jpeg,image/png,image/gif,image/webp,application/pdf,text/plain" desc:"Comma-separated content types accepted for attachments, as sniffed from their content"`
+	AttachmentsURLSecret    string        `key:"attachments.url_secret" env:"ATTACHMENTS_URL_SECRET" secret:"true" desc:"HMAC secret of signed download URLs, shared by all replicas; a random one is used when empty"`
+	AttachmentsURLTTL       time.Duration `key:"attachments.url_ttl" env:"ATTACHMENTS_URL_TTL" default:"15m" desc:"Validity of signed download URLs"`
+	AttachmentsPublicURL    string        `key:"attachments.public_url" env:"ATTACHMENTS_PUBLIC_URL" desc:"Base URL of signed download URLs, empty for URLs relative to the API"`
+
+	WebhookInterval    time.Duration `key:"webhooks.interval" env:"WEBHOOK_INTERVAL" default:"5s" desc:"How often the webhook dispatcher looks for events and due deliveries, 0 to disable it"`
+	WebhookConcurrency int           `key:"webhooks.concurrency" env:"WEBHOOK_CONCURRENCY" default:"4" desc:"Webhook deliveries sent at the same time"`
+	WebhookTimeout     time.Duration `key:"webhooks.timeout" env:"WEBHOOK_TIMEOUT" default:"10s" desc:"Timeout of each webhook delivery attempt"`
+	WebhookMaxAttempts int           `key:"webhooks.max_attempts" env:"WEBHOOK_MAX_ATTEMPTS" default:"10" desc:"Attempts after which a failing webhook delivery is dead-lettered"`
+	WebhookRetryBase   time.Duration `key:"webhooks.retry_base" env:"WEBHOOK_RETRY_BASE" default:"30s" desc:"Delay before the first retry of a webhook delivery, doubled on every further failure"`
+	WebhookRetryMax    time.Duration `key:"webhooks.retry_max" env:"WEBHOOK_RETRY_MAX" default:"6h" desc:"Longest delay between retries of a webhook delivery"`
+
+	EventHeartbeat time.Duration `key:"events.heartbeat" env:"EVENT_HEARTBEAT" default:"15s" desc:"Interval of the keepalive comments and pings of event streams"`
+	EventRetention time.Duration `key:"events.retention" env:"EVENT_RETENTION" default:"720h" desc:"Age after which events are deleted from the event log, 0 to keep them"`
+
+	IngestMaxBytes int64 `key:"ingest.max_bytes" env:"INGEST_MAX_BYTES" default:"209715200" desc:"Maximum size of an audio ingestion request"`
+
+	S3Endpoint  string `key:"s3.endpoint" env:"S3_ENDPOINT" desc:"S3-compatible endpoint of the s3 attachments backend, e.g. http://minio:9000"`
+	S3Region    string `key:"s3.region" env:"S3_REGION" default:"us-east-1" desc:"S3 region"`
+	S3Bucket    string `key:"s3.bucket" env:"S3_BUCKET" desc:"S3 bucket of attachments"`
+	S3AccessKey string `key:"s3.access_key" env:"S3_ACCESS_KEY" desc:"S3 access key ID"`
+	S3SecretKey string `key:"s3.secret_key" env:"S3_SECRET_KEY" secret:"true" desc:"S3 secret access key"`
+	S3PathStyle bool   `key:"s3.path_style" env:"S3_PATH_STYLE" default:"true" desc:"Address buckets by path, as MinIO expects, rather than by virtual host"`
+
+	JWTSecret   string `key:"auth.jwt_secret" env:"JWT_SECRET" secret:"true" desc:"HMAC secret for JWT bearer tokens"`
+	JWTJWKSFile string `key:"auth.jwks_file" env:"JWT_JWKS_FILE" desc:"JWKS file with public keys for JWT bearer tokens"`
+	JWTIssuer   string `key:"auth.jwt_issuer" env:"JWT_ISSUER" desc:"Required JWT issuer"`
+	JWTAudience string `key:"auth.jwt_audience" env:"JWT_AUDIENCE" desc:"Required JWT audience"`
+
+	CORSAllowedOrigins []string `key:"cors.allowed_origins" env:"CORS_ALLOWED_ORIGINS" desc:"Comma-separated origins allowed by CORS, * for any"`
+
+	RateLimitBackend         string `key:"ratelimit.backend" env:"RATE_LIMIT_BACKEND" default:"memory" desc:"Rate limiter backend: memory, postgres or off"`
+	RateLimitReadPerMinute   int    `key:"ratelimit.read_per_minute" env:"RATE_LIMIT_READ_PER_MINUTE" default:"300" desc:"Read requests per minute per client"`
+	RateLimitReadBurst       int    `key:"ratelimit.read_burst" env:"RATE_LIMIT_READ_BURST" default:"60" desc:"Read request burst per client"`
+	RateLimitWritePerMinute  int    `key:"ratelimit.write_per_minute" env:"RATE_LIMIT_WRITE_PER_MINUTE" default:"60" desc:"Write requests per minute per client"`
+	RateLimitWriteBurst      int    `key:"ratelimit.write_burst" env:"RATE_LIMIT_WRITE_BURST" default:"20" desc:"Write request burst per client"`
+	RateLimitEnrichPerMinute int    `key:"ratelimit.enrich_per_minute" env:"RATE_LIMIT_ENRICH_PER_MINUTE" default:"10" desc:"Enrichment requests per minute per client"`
+	RateLimitEnrichBurst     int    `key:"ratelimit.enrich_burst" env:"RATE_LIMIT_ENRICH_BURST" default:"5" desc:"Enrichment request burst per client"`
+	RateLimitIPPerMinute     int    `key:"ratelimit.ip_per_minute" env:"RATE_LIMIT_IP_PER_MINUTE" default:"600" desc:"Requests per minute per client IP, counted before authentication"`
+	RateLimitIPBurst         int    `key:"ratelimit.ip_burst" env:"RATE_LIMIT_IP_BURST" default:"120" desc:"Request burst per client IP, counted before authentication"`
+	MaxPageSize              int    `key:"api.max_page_size" env:"MAX_PAGE_SIZE" default:"100" desc:"Maximum page size of song listings"`
+
+	ServiceName        string  `key:"tracing.service_name" env:"OTEL_SERVICE_NAME" default:"song_library" desc:"Service name reported in traces"`
+	TracingExporter    string  `key:"tracing.exporter" env:"TRACING_EXPORTER" default:"none" desc:"Trace exporter: none, otlp or stdout"`
+	TracingSampleRatio float64 `key:"tracing.sample_ratio" env:"TRACING_SAMPLE_RATIO" default:"1" desc:"Share of traces sampled, 0 to 1"`
+
+	MetricsLibraryInterval time.Duration `key:"metrics.library_interval" env:"METRICS_LIBRARY_INTERVAL" default:"1m" desc:"How often the library gauges of /metrics are recomputed; scrapes serve the last values"`
+
+	HealthCheckTimeout     time.Duration `key:"health.timeout" env:"HEALTH_CHECK_TIMEOUT" default:"2s" desc:"Timeout of each readiness check"`
+	HealthCheckExternalAPI bool          `key:"health.check_external_api" env:"HEALTH_CHECK_EXTERNAL_API" default:"false" desc:"Include the external API in readiness"`
 
-func LoadConfig() *Config {
-	log.Info().Msg("Loading application configuration")
-
-	cfg := &Config{
-		DBHost:         os.Getenv("POSTGRES_HOST"),
-		DBPort:         os.Getenv("POSTGRES_PORT"),
-		DBUser:         os.Getenv("POSTGRES_USER"),
-		DBPassword:     os.Getenv("POSTGRES_PASSWORD"),
-		DBName:         os.Getenv("POSTGRES_DB"),
-		AppPort:        os.Getenv("APP_PORT"),
-		ExternalAPIURL: os.Getenv("EXTERNAL_API_URL"),
-	}
-
-	log.Debug().
-		Str("DBHost", cfg.DBHost).
-		Str("DBPort", cfg.DBPort).
-		Str("DBUser", cfg.DBUser).
-		Str("DBName", cfg.DBName).
-		Str("AppPort", cfg.AppPort).
-		Str("ExternalAPIURL", cfg.ExternalAPIURL).
-		Msg("Loaded configuration")
-
-	if cfg.DBHost == "" || cfg.DBPort == "" || cfg.DBUser == "" || cfg.DBPassword == "" || cfg.DBName == "" {
-		log.Fatal().Msg("Missing required database configuration")
-	}
-	if cfg.AppPort == "" {
-		log.Warn().Msg("APP_PORT is not set, using default 8080")
-		cfg.AppPort = "8080"
-	}
-	if cfg.ExternalAPIURL == "" {
-		log.Warn().Msg("EXTERNAL_API_URL is not set, external API calls may fail")
-	}
-
-	log.Info().Msg("Configuration loaded successfully")
-	return cfg
+	HTTPReadTimeout       time.Duration `key:"http.read_timeout" env:"HTTP_READ_TIMEOUT" default:"15s" desc:"HTTP read timeout"`
+	HTTPReadHeaderTimeout time.Duration `key:"http.read_header_timeout" env:"HTTP_READ_HEADER_TIMEOUT" default:"5s" desc:"HTTP read header timeout"`
+	HTTPWriteTimeout      time.Duration `key:"http.write_timeout" env:"HTTP_WRITE_TIMEOUT" default:"30s" desc:"HTTP write timeout"`
+	HTTPIdleTimeout       time.Duration `key:"http.idle_timeout" env:"HTTP_IDLE_TIMEOUT" default:"120s" desc:"HTTP keep-alive idle timeout"`
+	HTTPMaxHeaderBytes    int           `key:"http.max_header_bytes" env:"HTTP_MAX_HEADER_BYTES" default:"1048576" desc:"Maximum size of request headers"`
+	HTTPMaxBodyBytes      int64         `key:"http.max_body_bytes" env:"HTTP_MAX_BODY_BYTES" default:"1048576" desc:"Maximum size of request bodies"`
+	HTTPRequestTimeout    time.Duration `key:"http.request_timeout" env:"HTTP_REQUEST_TIMEOUT" default:"10s" desc:"Deadline of the database queries and outbound calls of a request"`
+	HTTPUploadTimeout     time.Duration `key:"http.upload_timeout" env:"HTTP_UPLOAD_TIMEOUT" default:"10m" desc:"Deadline of audio ingestion and attachment uploads, from reading the body to the last query, in place of the read and request timeouts"`
+	HTTPTrustedProxies    []string      `key:"http.trusted_proxies" env:"HTTP_TRUSTED_PROXIES" desc:"Comma-separated IPs or CIDRs of reverse proxies whose X-Forwarded-For and X-Real-IP headers name the client; none by default, so the client is the peer address"`
+	ShutdownTimeout       time.Duration `key:"shutdown.timeout" env:"SHUTDOWN_TIMEOUT" default:"30s" desc:"Time allowed to drain requests and workers"`
+	ShutdownDrainDelay    time.Duration `key:"shutdown.drain_delay" env:"SHUTDOWN_DRAIN_DELAY" default:"5s" desc:"Delay between failing readiness and closing the listener"`
 }
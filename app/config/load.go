@@ -0,0 +1,231 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"github.com/pelletier/go-toml/v2"
+	"gopkg.in/yaml.v3"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ConfigFileEnv names the environment variable holding the config file path; the --config flag overrides it
+const ConfigFileEnv = "CONFIG_FILE"
+
+type field struct {
+	key    string
+	env    string
+	flag   string
+	def    string
+	desc   string
+	secret bool
+	value  reflect.Value
+}
+
+func fields(cfg *Config) []field {
+	v := reflect.ValueOf(cfg).Elem()
+	t := v.Type()
+
+	var result []field
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		key := sf.Tag.Get("key")
+		if key == "" {
+			continue
+		}
+		result = append(result, field{
+			key:    key,
+			env:    sf.Tag.Get("env"),
+			flag:   strings.NewReplacer(".", "-", "_", "-").Replace(key),
+			def:    sf.Tag.Get("default"),
+			desc:   sf.Tag.Get("desc"),
+			secret: sf.Tag.Get("secret") == "true",
+			value:  v.Field(i),
+		})
+	}
+	return result
+}
+
+func (f field) set(raw string) error {
+	switch f.value.Interface().(type) {
+	case string:
+		f.value.SetString(raw)
+	case []string:
+		var items []string
+		for _, item := range strings.Split(raw, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		f.value.Set(reflect.ValueOf(items))
+	case int, int64:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not an integer", f.key, raw)
+		}
+		f.value.SetInt(n)
+	case float64:
+		x, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a number", f.key, raw)
+		}
+		f.value.SetFloat(x)
+	case bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a boolean", f.key, raw)
+		}
+		f.value.SetBool(b)
+	case time.Duration:
+		d, err := time.ParseDuration(raw)
+		if err != nil {
+			return fmt.Errorf("%s: %q is not a duration", f.key, raw)
+		}
+		f.value.SetInt(int64(d))
+	default:
+		return fmt.Errorf("%s: unsupported type %s", f.key, f.value.Type())
+	}
+	return nil
+}
+
+// flatten turns nested file sections into dotted keys with string values
+func flatten(prefix string, in map[string]interface{}, out map[string]string) {
+	for k, v := range in {
+		key := k
+		if prefix != "" {
+			key = prefix + "." + k
+		}
+		switch value := v.(type) {
+		case map[string]interface{}:
+			flatten(key, value, out)
+		case []interface{}:
+			items := make([]string, len(value))
+			for i, item := range value {
+				items[i] = fmt.Sprint(item)
+			}
+			out[key] = strings.Join(items, ",")
+		case nil:
+		default:
+			out[key] = fmt.Sprint(value)
+		}
+	}
+}
+
+func readFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading config file: %w", err)
+	}
+
+	raw := map[string]interface{}{}
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(data, &raw)
+	case ".toml":
+		err = toml.Unmarshal(data, &raw)
+	default:
+		return nil, fmt.Errorf("config file %s must be .yaml, .yml or .toml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("parsing config file %s: %w", path, err)
+	}
+
+	values := map[string]string{}
+	flatten("", raw, values)
+	return values, nil
+}
+
+// envValue reads NAME or, if it is unset or empty, the file named by NAME_FILE. NAME set to an
+// empty string without NAME_FILE is found, so that the environment can clear a default.
+func envValue(name string) (string, bool, error) {
+	value, set := os.LookupEnv(name)
+	if value != "" {
+		return value, true, nil
+	}
+	path := os.Getenv(name + "_FILE")
+	if path == "" {
+		return "", set, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", false, fmt.Errorf("reading %s_FILE: %w", name, err)
+	}
+	return strings.TrimRight(string(data), "\r\n"), true, nil
+}
+
+// Load resolves the configuration from defaults, the config file, the environment and the leading
+// command-line flags in args. It returns the arguments left after the flags, e.g. a subcommand.
+func Load(args []string) (*Config, []string, error) {
+	cfg := &Config{}
+	fs := fields(cfg)
+
+	flags := flag.NewFlagSet("app", flag.ContinueOnError)
+	configPath := flags.String("config", os.Getenv(ConfigFileEnv), "Path to a YAML or TOML config file")
+	flagValues := map[string]*string{}
+	for _, f := range fs {
+		usage := f.desc
+		if f.env != "" {
+			usage += " (env " + f.env + ")"
+		}
+		flagValues[f.key] = flags.String(f.flag, "", usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, nil, err
+	}
+	explicit := map[string]bool{}
+	flags.Visit(func(fl *flag.Flag) { explicit[fl.Name] = true })
+
+	fileValues := map[string]string{}
+	if *configPath != "" {
+		var err error
+		if fileValues, err = readFile(*configPath); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	known := map[string]bool{}
+	var errs []error
+	for _, f := range fs {
+		known[f.key] = true
+
+		raw, found := f.def, f.def != ""
+		if value, ok := fileValues[f.key]; ok {
+			raw, found = value, true
+		}
+		if f.env != "" {
+			value, ok, err := envValue(f.env)
+			if err != nil {
+				errs = append(errs, err)
+			} else if ok {
+				raw, found = value, true
+			}
+		}
+		if explicit[f.flag] {
+			raw, found = *flagValues[f.key], true
+		}
+
+		if found {
+			if err := f.set(raw); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	for key := range fileValues {
+		if !known[key] {
+			errs = append(errs, fmt.Errorf("unknown config file key %q", key))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, flags.Args(), nil
+}
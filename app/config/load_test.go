@@ -0,0 +1,223 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// setRequired sets the database settings every configuration needs through the environment and
+// clears the variables the tests use, so that the environment of the test run does not leak in
+func setRequired(t *testing.T) {
+	t.Helper()
+	for name, value := range map[string]string{
+		"POSTGRES_HOST": "db", "POSTGRES_USER": "postgres", "POSTGRES_PASSWORD": "secret", "POSTGRES_DB": "songs",
+	} {
+		t.Setenv(name, value)
+	}
+	for _, name := range []string{
+		ConfigFileEnv, "APP_PORT", "LOG_LEVEL", "S3_REGION", "CORS_ALLOWED_ORIGINS", "HTTP_READ_TIMEOUT",
+		"POSTGRES_PASSWORD_FILE", "JWT_SECRET", "JWT_SECRET_FILE", "DATABASE_URL",
+	} {
+		unsetenv(t, name)
+	}
+}
+
+// unsetenv unsets a variable for the duration of a test
+func unsetenv(t *testing.T, name string) {
+	t.Helper()
+	t.Setenv(name, "")
+	os.Unsetenv(name)
+}
+
+func writeFile(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	file := "app:\n  port: \"8081\"\n  gin_mode: release\n"
+	tests := []struct {
+		name string
+		file bool
+		env  string
+		args []string
+		want string
+	}{
+		{name: "default", want: "8080"},
+		{name: "file", file: true, want: "8081"},
+		{name: "env over file", file: true, env: "8082", want: "8082"},
+		{name: "flag over env", file: true, env: "8082", args: []string{"--app-port", "8083"}, want: "8083"},
+		{name: "flag over default", args: []string{"--app-port=8084"}, want: "8084"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRequired(t)
+			if tt.file {
+				t.Setenv(ConfigFileEnv, writeFile(t, "config.yaml", file))
+			}
+			if tt.env != "" {
+				t.Setenv("APP_PORT", tt.env)
+			}
+			cfg, _, err := Load(tt.args)
+			if err != nil {
+				t.Fatalf("Load: %v", err)
+			}
+			if cfg.AppPort != tt.want {
+				t.Errorf("app.port = %q, want %q", cfg.AppPort, tt.want)
+			}
+			// Keys the sources leave alone keep their own values
+			if wantMode := map[bool]string{true: "release", false: "debug"}[tt.file]; cfg.GinMode != wantMode {
+				t.Errorf("app.gin_mode = %q, want %q", cfg.GinMode, wantMode)
+			}
+		})
+	}
+}
+
+func TestLoadTypes(t *testing.T) {
+	setRequired(t)
+	path := writeFile(t, "config.toml", `
+[http]
+read_timeout = "3s"
+trusted_proxies = ["10.0.0.0/8", "192.168.1.1"]
+
+[tracing]
+sample_ratio = 0.25
+`)
+	cfg, rest, err := Load([]string{"--config", path, "--health-check-external-api=true", "config", "print"})
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.HTTPReadTimeout != 3*time.Second || cfg.TracingSampleRatio != 0.25 || !cfg.HealthCheckExternalAPI {
+		t.Errorf("read_timeout = %v, sample_ratio = %v, check_external_api = %v", cfg.HTTPReadTimeout, cfg.TracingSampleRatio, cfg.HealthCheckExternalAPI)
+	}
+	if want := []string{"10.0.0.0/8", "192.168.1.1"}; !reflect.DeepEqual(cfg.HTTPTrustedProxies, want) {
+		t.Errorf("trusted_proxies = %v, want %v", cfg.HTTPTrustedProxies, want)
+	}
+	if want := []string{"config", "print"}; !reflect.DeepEqual(rest, want) {
+		t.Errorf("remaining args = %v, want %v", rest, want)
+	}
+}
+
+func TestLoadSecretFile(t *testing.T) {
+	setRequired(t)
+	unsetenv(t, "POSTGRES_PASSWORD")
+	t.Setenv("POSTGRES_PASSWORD_FILE", writeFile(t, "password", "from-file\n"))
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.DBPassword != "from-file" {
+		t.Errorf("db.password = %q, want the file content without the trailing newline", cfg.DBPassword)
+	}
+
+	// The variable itself wins over the file, and an empty one falls back to it
+	t.Setenv("POSTGRES_PASSWORD", "from-env")
+	if cfg, _, err = Load(nil); err != nil || cfg.DBPassword != "from-env" {
+		t.Errorf("db.password = %q, %v, want from-env", cfg.DBPassword, err)
+	}
+	t.Setenv("POSTGRES_PASSWORD", "")
+	if cfg, _, err = Load(nil); err != nil || cfg.DBPassword != "from-file" {
+		t.Errorf("db.password = %q, %v, want from-file", cfg.DBPassword, err)
+	}
+
+	t.Setenv("JWT_SECRET_FILE", filepath.Join(t.TempDir(), "missing"))
+	if _, _, err := Load(nil); err == nil || !strings.Contains(err.Error(), "JWT_SECRET_FILE") {
+		t.Errorf("Load with a missing secret file: %v", err)
+	}
+}
+
+func TestLoadEmptyEnv(t *testing.T) {
+	setRequired(t)
+	t.Setenv(ConfigFileEnv, writeFile(t, "config.yaml", "cors:\n  allowed_origins: [\"*\"]\n"))
+	t.Setenv("CORS_ALLOWED_ORIGINS", "")
+	t.Setenv("S3_REGION", "")
+	cfg, _, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+	if cfg.CORSAllowedOrigins != nil {
+		t.Errorf("cors.allowed_origins = %v, want it cleared", cfg.CORSAllowedOrigins)
+	}
+	if cfg.S3Region != "" {
+		t.Errorf("s3.region = %q, want the default cleared", cfg.S3Region)
+	}
+
+	// Values that cannot be empty are rejected rather than silently defaulted
+	t.Setenv("HTTP_READ_TIMEOUT", "")
+	if _, _, err := Load(nil); err == nil || !strings.Contains(err.Error(), "http.read_timeout") {
+		t.Errorf("Load with an empty duration: %v", err)
+	}
+}
+
+func TestLoadErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		args []string
+		want []string
+	}{
+		{
+			name: "unknown file keys",
+			file: "app:\n  port: \"8081\"\n  prot: \"8082\"\nunknown: 1\n",
+			want: []string{`unknown config file key "app.prot"`, `unknown config file key "unknown"`},
+		},
+		{
+			name: "invalid values",
+			args: []string{"--app-port", "8080", "--db-max-open-conns", "many", "--http-read-timeout", "soon"},
+			want: []string{`db.max_open_conns: "many" is not an integer`, `http.read_timeout: "soon" is not a duration`},
+		},
+		{
+			name: "validation",
+			args: []string{"--log-level", "loud", "--links-check-concurrency", "0", "--api-max-page-size", "0", "--http-trusted-proxies", "proxy"},
+			want: []string{"log.level", "links.check_concurrency must be positive", "api.max_page_size must be positive", `"proxy"`},
+		},
+		{name: "unknown flag", args: []string{"--no-such-flag"}, want: []string{"no-such-flag"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			setRequired(t)
+			if tt.file != "" {
+				t.Setenv(ConfigFileEnv, writeFile(t, "config.yml", tt.file))
+			}
+			_, _, err := Load(tt.args)
+			if err == nil {
+				t.Fatal("Load succeeded")
+			}
+			// Every problem is reported at once
+			for _, want := range tt.want {
+				if !strings.Contains(err.Error(), want) {
+					t.Errorf("error %q does not mention %s", err, want)
+				}
+			}
+		})
+	}
+}
+
+func TestValidateRequired(t *testing.T) {
+	setRequired(t)
+	for _, name := range []string{"POSTGRES_HOST", "POSTGRES_USER", "POSTGRES_PASSWORD", "POSTGRES_DB"} {
+		unsetenv(t, name)
+	}
+	_, _, err := Load(nil)
+	if err == nil {
+		t.Fatal("Load succeeded without database settings")
+	}
+	for _, key := range []string{"db.host", "db.user", "db.password", "db.name"} {
+		if !strings.Contains(err.Error(), key+" is required") {
+			t.Errorf("error %q does not mention %s", err, key)
+		}
+	}
+
+	t.Setenv("DATABASE_URL", "postgres://postgres@db/songs")
+	if _, _, err := Load(nil); err != nil {
+		t.Errorf("Load with db.url: %v", err)
+	}
+}
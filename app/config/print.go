@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"strings"
+	"time"
+)
+
+const redacted = "REDACTED"
+
+// Redacted returns the configuration as nested sections keyed like the config file, with secrets masked
+func (cfg *Config) Redacted() map[string]interface{} {
+	out := map[string]interface{}{}
+	for _, f := range fields(cfg) {
+		var value interface{}
+		switch v := f.value.Interface().(type) {
+		case time.Duration:
+			value = v.String()
+		case []string:
+			value = append([]string{}, v...)
+		default:
+			value = v
+		}
+		if f.secret && !f.value.IsZero() {
+			value = redacted
+		}
+
+		section := out
+		parts := strings.Split(f.key, ".")
+		for _, part := range parts[:len(parts)-1] {
+			next, ok := section[part].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				section[part] = next
+			}
+			section = next
+		}
+		section[parts[len(parts)-1]] = value
+	}
+	return out
+}
+
+// Print renders the redacted configuration as YAML that can be used as a config file
+func (cfg *Config) Print() (string, error) {
+	data, err := yaml.Marshal(cfg.Redacted())
+	if err != nil {
+		return "", fmt.Errorf("rendering configuration: %w", err)
+	}
+	return string(data), nil
+}
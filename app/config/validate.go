@@ -0,0 +1,185 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/rs/zerolog"
+	"net/netip"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"time"
+)
+
+func oneOf(name, value string, allowed ...string) error {
+	if slices.Contains(allowed, value) {
+		return nil
+	}
+	return fmt.Errorf("%s must be one of %v, got %q", name, allowed, value)
+}
+
+// Validate checks required settings, enumerations and ranges and reports every problem at once
+func (cfg *Config) Validate() error {
+	var errs []error
+	add := func(err error) {
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	required := []struct{ name, value string }{
+		{"db.host", cfg.DBHost}, {"db.user", cfg.DBUser}, {"db.password", cfg.DBPassword}, {"db.name", cfg.DBName},
+	}
+	if cfg.DatabaseURL == "" {
+		for _, r := range required {
+			if r.value == "" {
+				add(fmt.Errorf("%s is required unless db.url is set", r.name))
+			}
+		}
+		if _, err := strconv.ParseUint(cfg.DBPort, 10, 16); err != nil {
+			add(fmt.Errorf("db.port must be a port number, got %q", cfg.DBPort))
+		}
+	}
+	for _, u := range []struct{ name, value string }{{"db.url", cfg.DatabaseURL}, {"db.replica_url", cfg.DatabaseReplicaURL}} {
+		if u.value == "" {
+			continue
+		}
+		if parsed, err := url.Parse(u.value); err != nil || (parsed.Scheme != "postgres" && parsed.Scheme != "postgresql") {
+			add(fmt.Errorf("%s must be a postgres:// URL", u.name))
+		}
+	}
+	for _, proxy := range cfg.HTTPTrustedProxies {
+		if _, err := netip.ParsePrefix(proxy); err == nil {
+			continue
+		}
+		if _, err := netip.ParseAddr(proxy); err != nil {
+			add(fmt.Errorf("http.trusted_proxies must hold IP addresses or CIDRs, got %q", proxy))
+		}
+	}
+	for _, f := range []struct{ name, path string }{
+		{"db.sslrootcert", cfg.DBSSLRootCert}, {"db.sslcert", cfg.DBSSLCert}, {"db.sslkey", cfg.DBSSLKey},
+	} {
+		if f.path == "" {
+			continue
+		}
+		if _, err := os.Stat(f.path); err != nil {
+			add(fmt.Errorf("%s: %w", f.name, err))
+		}
+	}
+	if (cfg.DBSSLCert == "") != (cfg.DBSSLKey == "") {
+		add(errors.New("db.sslcert and db.sslkey must be set together"))
+	}
+	if cfg.DBConnectTimeout <= 0 {
+		add(errors.New("db.connect_timeout must be positive"))
+	}
+	if _, err := strconv.ParseUint(cfg.AppPort, 10, 16); err != nil {
+		add(fmt.Errorf("app.port must be a port number, got %q", cfg.AppPort))
+	}
+	add(oneOf("db.sslmode", cfg.DBSSLMode, "disable", "allow", "prefer", "require", "verify-ca", "verify-full"))
+	if cfg.DBMaxOpenConns < 1 {
+		add(errors.New("db.max_open_conns must be positive"))
+	}
+	if cfg.DBMaxIdleConns < 0 {
+		add(errors.New("db.max_idle_conns must not be negative"))
+	}
+	if cfg.DBStatementTimeout < 0 {
+		add(errors.New("db.statement_timeout must not be negative"))
+	}
+	add(oneOf("db.query_exec_mode", cfg.DBQueryExecMode, "cache_statement", "cache_describe", "describe_exec", "exec", "simple_protocol"))
+	if cfg.DBStatementCache < 1 {
+		add(errors.New("db.statement_cache_capacity must be positive"))
+	}
+	if cfg.DBMaxIdleConns > cfg.DBMaxOpenConns {
+		add(errors.New("db.max_idle_conns must not exceed db.max_open_conns"))
+	}
+
+	add(oneOf("app.gin_mode", cfg.GinMode, "debug", "release", "test"))
+	if _, err := zerolog.ParseLevel(cfg.LogLevel); err != nil {
+		add(fmt.Errorf("log.level: %w", err))
+	}
+	add(oneOf("log.format", cfg.LogFormat, "json", "console"))
+	add(oneOf("ratelimit.backend", cfg.RateLimitBackend, "memory", "postgres", "off"))
+	add(oneOf("tracing.exporter", cfg.TracingExporter, "none", "otlp", "stdout"))
+	add(oneOf("attachments.backend", cfg.AttachmentsBackend, "local", "s3"))
+	if cfg.AttachmentsBackend == "s3" && (cfg.S3Endpoint == "" || cfg.S3Bucket == "" || cfg.S3AccessKey == "" || cfg.S3SecretKey == "") {
+		add(errors.New("s3.endpoint, s3.bucket, s3.access_key and s3.secret_key are required by the s3 attachments backend"))
+	}
+	if cfg.AttachmentsBackend == "local" && cfg.AttachmentsDir == "" {
+		add(errors.New("attachments.dir is required by the local attachments backend"))
+	}
+	if cfg.AttachmentsMaxBytes <= 0 {
+		add(errors.New("attachments.max_bytes must be positive"))
+	}
+	if cfg.IngestMaxBytes <= 0 {
+		add(errors.New("ingest.max_bytes must be positive"))
+	}
+
+	for _, u := range []struct{ name, value string }{
+		{"external_api.url", cfg.ExternalAPIURL},
+		{"musicbrainz.url", cfg.MusicBrainzURL},
+		{"attachments.public_url", cfg.AttachmentsPublicURL},
+		{"s3.endpoint", cfg.S3Endpoint},
+	} {
+		if u.value == "" {
+			continue
+		}
+		if parsed, err := url.Parse(u.value); err != nil || parsed.Scheme == "" || parsed.Host == "" {
+			add(fmt.Errorf("%s must be an absolute URL, got %q", u.name, u.value))
+		}
+	}
+	if cfg.LinkCheckInterval < 0 {
+		add(errors.New("links.check_interval must not be negative"))
+	}
+	if cfg.LinkCheckConcurrency < 1 {
+		add(errors.New("links.check_concurrency must be positive"))
+	}
+	if cfg.WebhookInterval < 0 {
+		add(errors.New("webhooks.interval must not be negative"))
+	}
+	if cfg.WebhookConcurrency < 1 || cfg.WebhookMaxAttempts < 1 {
+		add(errors.New("webhooks.concurrency and webhooks.max_attempts must be positive"))
+	}
+	if cfg.WebhookRetryMax < cfg.WebhookRetryBase {
+		add(errors.New("webhooks.retry_max must not be shorter than webhooks.retry_base"))
+	}
+	if cfg.EventRetention < 0 {
+		add(errors.New("events.retention must not be negative"))
+	}
+	if cfg.MaxPageSize < 1 {
+		add(errors.New("api.max_page_size must be positive"))
+	}
+	if cfg.TracingSampleRatio < 0 || cfg.TracingSampleRatio > 1 {
+		add(errors.New("tracing.sample_ratio must be between 0 and 1"))
+	}
+	if cfg.HTTPMaxHeaderBytes <= 0 || cfg.HTTPMaxBodyBytes <= 0 {
+		add(errors.New("http.max_header_bytes and http.max_body_bytes must be positive"))
+	}
+	timeouts := []struct {
+		name  string
+		value time.Duration
+	}{
+		{"health.timeout", cfg.HealthCheckTimeout},
+		{"http.read_timeout", cfg.HTTPReadTimeout},
+		{"http.read_header_timeout", cfg.HTTPReadHeaderTimeout},
+		{"http.write_timeout", cfg.HTTPWriteTimeout},
+		{"http.idle_timeout", cfg.HTTPIdleTimeout},
+		{"http.request_timeout", cfg.HTTPRequestTimeout},
+		{"http.upload_timeout", cfg.HTTPUploadTimeout},
+		{"links.check_timeout", cfg.LinkCheckTimeout},
+		{"links.recheck_after", cfg.LinkRecheckAfter},
+		{"metrics.library_interval", cfg.MetricsLibraryInterval},
+		{"attachments.url_ttl", cfg.AttachmentsURLTTL},
+		{"webhooks.timeout", cfg.WebhookTimeout},
+		{"webhooks.retry_base", cfg.WebhookRetryBase},
+		{"events.heartbeat", cfg.EventHeartbeat},
+		{"shutdown.timeout", cfg.ShutdownTimeout},
+	}
+	for _, t := range timeouts {
+		if t.value <= 0 {
+			add(fmt.Errorf("%s must be positive", t.name))
+		}
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,368 @@
+package credits
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgtype"
+	"slices"
+	"song_library/db"
+	"song_library/models"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrPersonNotFound = errors.New("person not found")
+	ErrSongNotFound   = errors.New("song not found")
+	ErrCreditNotFound = errors.New("credit not found")
+	ErrPersonCredited = errors.New("person is credited on songs")
+	ErrInvalid        = errors.New("invalid credit")
+)
+
+// maxNameLength matches people.name
+const maxNameLength = 128
+
+// roleOrder sorts credits by role in the order of models.CreditRoles
+const roleOrder = "array_position(ARRAY['writer', 'composer', 'lyricist', 'producer', 'featured']::varchar[], c.role)"
+
+// CheckRole lowercases a credit role and checks that it is one of models.CreditRoles
+func CheckRole(role string) (string, error) {
+	role = strings.ToLower(strings.TrimSpace(role))
+	if !slices.Contains(models.CreditRoles, role) {
+		return "", fmt.Errorf("%w: role must be one of %v", ErrInvalid, models.CreditRoles)
+	}
+	return role, nil
+}
+
+// Filter selects the songs a person is credited on, in any role when Role is empty
+type Filter struct {
+	PersonID int
+	Role     string
+}
+
+// ParseFilter parses a person ID, optionally prefixed with a role as in writer:12
+func ParseFilter(s string) (Filter, error) {
+	var f Filter
+	id := s
+	if role, rest, found := strings.Cut(s, ":"); found {
+		var err error
+		if f.Role, err = CheckRole(role); err != nil {
+			return Filter{}, err
+		}
+		id = rest
+	}
+	personID, err := strconv.Atoi(id)
+	if err != nil || personID < 1 {
+		return Filter{}, fmt.Errorf("%w: %q is not a credit, expected a person ID or role:person ID", ErrInvalid, s)
+	}
+	f.PersonID = personID
+	return f, nil
+}
+
+// Condition returns an SQL condition on songs.song_id that matches the songs credited to the filter's
+// person. Its placeholders start at $arg.
+func Condition(f Filter, arg int) (string, []any) {
+	return fmt.Sprintf(
+		"song_id IN (SELECT song_id FROM song_credits WHERE person_id = $%d AND ($%d = '' OR role = $%d))",
+		arg, arg+1, arg+1,
+	), []any{f.PersonID, f.Role}
+}
+
+// MissingCondition returns an SQL condition on songs.song_id that matches the songs nobody is credited
+// on in role. Its placeholder is $arg.
+func MissingCondition(role string, arg int) (string, []any) {
+	return fmt.Sprintf("song_id NOT IN (SELECT song_id FROM song_credits WHERE role = $%d)", arg), []any{role}
+}
+
+// querier is satisfied by *sql.DB and *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+func normalizeName(name string) (string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" || utf8.RuneCountInString(name) > maxNameLength {
+		return "", fmt.Errorf("%w: name must have 1 to %d characters", ErrInvalid, maxNameLength)
+	}
+	return name, nil
+}
+
+// ListPeople returns a page of people by name; a non-empty name narrows it to names containing it
+func ListPeople(ctx context.Context, name string, limit, offset int) ([]models.Person, error) {
+	rows, err := db.Reader().QueryContext(ctx, `
+		SELECT person_id, name FROM people
+		WHERE $1 = '' OR name ILIKE '%' || $1 || '%'
+		ORDER BY lower(name), person_id
+		LIMIT $2 OFFSET $3
+	`, name, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	people := []models.Person{}
+	for rows.Next() {
+		var p models.Person
+		if err := rows.Scan(&p.ID, &p.Name); err != nil {
+			return nil, err
+		}
+		people = append(people, p)
+	}
+	return people, rows.Err()
+}
+
+func getPerson(ctx context.Context, q querier, personID int) (models.Person, error) {
+	var p models.Person
+	err := q.QueryRowContext(ctx, "SELECT person_id, name FROM people WHERE person_id = $1", personID).Scan(&p.ID, &p.Name)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Person{}, ErrPersonNotFound
+	}
+	return p, err
+}
+
+// GetPerson returns a person by ID
+func GetPerson(ctx context.Context, personID int) (models.Person, error) {
+	return getPerson(ctx, db.Reader(), personID)
+}
+
+// CreatePerson stores a new person
+func CreatePerson(ctx context.Context, name string) (models.Person, error) {
+	name, err := normalizeName(name)
+	if err != nil {
+		return models.Person{}, err
+	}
+	p := models.Person{Name: name}
+	err = db.Db.QueryRowContext(ctx, "INSERT INTO people (name) VALUES ($1) RETURNING person_id", name).Scan(&p.ID)
+	if err != nil {
+		return models.Person{}, err
+	}
+	return p, nil
+}
+
+// RenamePerson changes the name of a person
+func RenamePerson(ctx context.Context, personID int, name string) (models.Person, error) {
+	name, err := normalizeName(name)
+	if err != nil {
+		return models.Person{}, err
+	}
+	result, err := db.Db.ExecContext(ctx, "UPDATE people SET name = $2 WHERE person_id = $1", personID, name)
+	if err != nil {
+		return models.Person{}, err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return models.Person{}, err
+	} else if count == 0 {
+		return models.Person{}, ErrPersonNotFound
+	}
+	return models.Person{ID: personID, Name: name}, nil
+}
+
+// DeletePerson removes a person who is not credited on any song
+func DeletePerson(ctx context.Context, personID int) error {
+	return db.InTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, "SELECT person_id FROM people WHERE person_id = $1 FOR UPDATE", personID).Scan(&personID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrPersonNotFound
+		} else if err != nil {
+			return err
+		}
+		var credited bool
+		err = tx.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM song_credits WHERE person_id = $1)", personID).Scan(&credited)
+		if err != nil {
+			return err
+		}
+		if credited {
+			return ErrPersonCredited
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM people WHERE person_id = $1", personID)
+		return err
+	})
+}
+
+// PersonSongs returns a page of the songs a person is credited on, in role when it is not empty,
+// with the person's roles on each song
+func PersonSongs(ctx context.Context, personID int, role string, limit, offset int) ([]models.CreditedSong, error) {
+	if _, err := getPerson(ctx, db.Reader(), personID); err != nil {
+		return nil, err
+	}
+	rows, err := db.Reader().QueryContext(ctx, `
+		SELECT s.song_id, s.group_name, s.song_name, COALESCE(s.release_date, ''), COALESCE(s.link, ''),
+			array_agg(c.role ORDER BY `+roleOrder+`)
+		FROM song_credits c
+		JOIN songs s ON s.song_id = c.song_id
+		WHERE c.person_id = $1
+		GROUP BY s.song_id
+		HAVING $2 = '' OR $2 = ANY(array_agg(c.role))
+		ORDER BY s.song_id
+		LIMIT $3 OFFSET $4
+	`, personID, role, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	types := pgtype.NewMap()
+	songs := []models.CreditedSong{}
+	for rows.Next() {
+		var cs models.CreditedSong
+		s := &cs.Song
+		if err := rows.Scan(&s.ID, &s.Group, &s.Song, &s.ReleaseDate, &s.Link, types.SQLScanner(&cs.Roles)); err != nil {
+			return nil, err
+		}
+		songs = append(songs, cs)
+	}
+	return songs, rows.Err()
+}
+
+// songCredits returns the credits of a song by role and then in the order they were given
+func songCredits(ctx context.Context, q querier, songID int) ([]models.Credit, error) {
+	err := q.QueryRowContext(ctx, "SELECT song_id FROM songs WHERE song_id = $1", songID).Scan(&songID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSongNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	rows, err := q.QueryContext(ctx, `
+		SELECT c.person_id, p.name, c.role
+		FROM song_credits c JOIN people p ON p.person_id = c.person_id
+		WHERE c.song_id = $1
+		ORDER BY `+roleOrder+`, c.position
+	`, songID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []models.Credit{}
+	for rows.Next() {
+		var c models.Credit
+		if err := rows.Scan(&c.PersonID, &c.Name, &c.Role); err != nil {
+			return nil, err
+		}
+		list = append(list, c)
+	}
+	return list, rows.Err()
+}
+
+// SongCredits returns the credits of a song
+func SongCredits(ctx context.Context, songID int) ([]models.Credit, error) {
+	return songCredits(ctx, db.Reader(), songID)
+}
+
+// lockSong locks a song while its credits change
+func lockSong(ctx context.Context, tx *sql.Tx, songID int) error {
+	err := tx.QueryRowContext(ctx, "SELECT song_id FROM songs WHERE song_id = $1 FOR UPDATE", songID).Scan(&songID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSongNotFound
+	}
+	return err
+}
+
+// checkPeople returns ErrPersonNotFound unless every person exists
+func checkPeople(ctx context.Context, tx *sql.Tx, personIDs []int) error {
+	var missing sql.NullInt64
+	err := tx.QueryRowContext(ctx, `
+		SELECT id FROM unnest($1::int[]) AS id WHERE id NOT IN (SELECT person_id FROM people) LIMIT 1
+	`, personIDs).Scan(&missing)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+	return fmt.Errorf("%w: %d", ErrPersonNotFound, missing.Int64)
+}
+
+// SetSongCredits replaces the credits of a song; within a role credits keep the order they are given in
+func SetSongCredits(ctx context.Context, songID int, list []models.Credit) ([]models.Credit, error) {
+	people := make([]int, len(list))
+	roles := make([]string, len(list))
+	for i, c := range list {
+		role, err := CheckRole(c.Role)
+		if err != nil {
+			return nil, fmt.Errorf("credit %d: %w", i+1, err)
+		}
+		people[i], roles[i] = c.PersonID, role
+	}
+
+	var stored []models.Credit
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		if err := lockSong(ctx, tx, songID); err != nil {
+			return err
+		}
+		if err := checkPeople(ctx, tx, people); err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "DELETE FROM song_credits WHERE song_id = $1", songID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO song_credits (song_id, person_id, role, position)
+			SELECT $1, p, r, n FROM unnest($2::int[], $3::varchar[]) WITH ORDINALITY AS c (p, r, n)
+			ON CONFLICT DO NOTHING
+		`, songID, people, roles)
+		if err != nil {
+			return err
+		}
+		stored, err = songCredits(ctx, tx, songID)
+		return err
+	})
+	return stored, err
+}
+
+// AddCredit credits a person on a song in a role, after the credits the role already has
+func AddCredit(ctx context.Context, songID, personID int, role string) (models.Credit, error) {
+	role, err := CheckRole(role)
+	if err != nil {
+		return models.Credit{}, err
+	}
+	var p models.Person
+	err = db.InTx(ctx, func(tx *sql.Tx) error {
+		if err := lockSong(ctx, tx, songID); err != nil {
+			return err
+		}
+		if p, err = getPerson(ctx, tx, personID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO song_credits (song_id, person_id, role, position)
+			SELECT $1, $2, $3, COALESCE(MAX(position), 0) + 1 FROM song_credits WHERE song_id = $1 AND role = $3
+			ON CONFLICT DO NOTHING
+		`, songID, personID, role)
+		return err
+	})
+	if err != nil {
+		return models.Credit{}, err
+	}
+	return models.Credit{PersonID: p.ID, Name: p.Name, Role: role}, nil
+}
+
+// RemoveCredit removes a person's credit on a song in role, or in every role when role is empty
+func RemoveCredit(ctx context.Context, songID, personID int, role string) error {
+	if role != "" {
+		var err error
+		if role, err = CheckRole(role); err != nil {
+			return err
+		}
+	}
+	return db.InTx(ctx, func(tx *sql.Tx) error {
+		if err := lockSong(ctx, tx, songID); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, `
+			DELETE FROM song_credits WHERE song_id = $1 AND person_id = $2 AND ($3 = '' OR role = $3)
+		`, songID, personID, role)
+		if err != nil {
+			return err
+		}
+		if count, err := result.RowsAffected(); err != nil {
+			return err
+		} else if count == 0 {
+			return ErrCreditNotFound
+		}
+		return nil
+	})
+}
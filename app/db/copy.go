@@ -0,0 +1,36 @@
+package db
+
+import (
+	"context"
+	"io"
+)
+
+// CopyFrom streams r into the server with a COPY ... FROM STDIN statement and returns the number of rows copied
+func CopyFrom(ctx context.Context, r io.Reader, statement string) (int64, error) {
+	conn, err := Pool.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+
+	tag, err := conn.Conn().PgConn().CopyFrom(ctx, r, statement)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// CopyTo streams the output of a COPY ... TO STDOUT statement into w and returns the number of rows copied
+func CopyTo(ctx context.Context, w io.Writer, statement string) (int64, error) {
+	conn, err := Pool.Acquire(ctx)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Release()
+
+	tag, err := conn.Conn().PgConn().CopyTo(ctx, w, statement)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
@@ -1,28 +1,183 @@
 package db
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
+	"github.com/XSAM/otelsql"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/jackc/pgx/v5/stdlib"
 	"github.com/rs/zerolog/log"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
 	"song_library/config"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Db is the primary connection pool; all writes go through it
 var Db *sql.DB
 
-func InitDB(cfg *config.Config) {
-	var err error
+// Pool is the pgx pool behind Db, for COPY, LISTEN and other native protocol features
+var Pool *pgxpool.Pool
+
+// Replica is an optional read-only pool; nil when no replica is configured
+var Replica *sql.DB
+
+// ReplicaPool is the pgx pool behind Replica
+var ReplicaPool *pgxpool.Pool
+
+// Reader returns the pool for read-only queries that tolerate replication lag
+func Reader() *sql.DB {
+	if Replica != nil {
+		return Replica
+	}
+	return Db
+}
+
+// quoteDSNValue quotes a value for a key=value connection string
+func quoteDSNValue(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
+
+// primaryDSN prefers DATABASE_URL and otherwise builds a key=value DSN from the individual settings
+func primaryDSN(cfg *config.Config) string {
+	if cfg.DatabaseURL != "" {
+		return cfg.DatabaseURL
+	}
+
+	params := [][2]string{
+		{"host", cfg.DBHost},
+		{"port", cfg.DBPort},
+		{"user", cfg.DBUser},
+		{"password", cfg.DBPassword},
+		{"dbname", cfg.DBName},
+		{"sslmode", cfg.DBSSLMode},
+		{"sslrootcert", cfg.DBSSLRootCert},
+		{"sslcert", cfg.DBSSLCert},
+		{"sslkey", cfg.DBSSLKey},
+	}
+	var parts []string
+	for _, p := range params {
+		if p[1] != "" {
+			parts = append(parts, p[0]+"="+quoteDSNValue(p[1]))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// queryExecModes maps db.query_exec_mode to pgx; the cached modes prepare each distinct query once
+// per connection, exec and simple_protocol suit poolers that cannot keep prepared statements
+var queryExecModes = map[string]pgx.QueryExecMode{
+	"cache_statement": pgx.QueryExecModeCacheStatement,
+	"cache_describe":  pgx.QueryExecModeCacheDescribe,
+	"describe_exec":   pgx.QueryExecModeDescribeExec,
+	"exec":            pgx.QueryExecModeExec,
+	"simple_protocol": pgx.QueryExecModeSimpleProtocol,
+}
+
+// poolConfig parses the DSN and applies the pool, statement cache and statement_timeout settings
+func poolConfig(cfg *config.Config, dsn string) (*pgxpool.Config, error) {
+	poolCfg, err := pgxpool.ParseConfig(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("parsing connection settings: %w", err)
+	}
 
-	dbinfo := fmt.Sprintf("host=%s port=%s user=%s password=%s dbname=%s sslmode=disable",
-		cfg.DBHost, cfg.DBPort, cfg.DBUser, cfg.DBPassword, cfg.DBName)
+	poolCfg.MaxConns = int32(cfg.DBMaxOpenConns)
+	poolCfg.MinConns = int32(cfg.DBMaxIdleConns)
+	poolCfg.MaxConnLifetime = cfg.DBConnMaxLifetime
+	poolCfg.MaxConnIdleTime = cfg.DBConnMaxIdleTime
 
-	Db, err = sql.Open("postgres", dbinfo)
+	connCfg := poolCfg.ConnConfig
+	connCfg.DefaultQueryExecMode = queryExecModes[cfg.DBQueryExecMode]
+	connCfg.StatementCacheCapacity = cfg.DBStatementCache
+	connCfg.DescriptionCacheCapacity = cfg.DBStatementCache
+	if _, set := connCfg.RuntimeParams["statement_timeout"]; !set && cfg.DBStatementTimeout > 0 {
+		// Sent in the startup message, so every connection of the pool starts with the limit applied
+		connCfg.RuntimeParams["statement_timeout"] = strconv.FormatInt(cfg.DBStatementTimeout.Milliseconds(), 10)
+	}
+	return poolCfg, nil
+}
+
+// open creates a pgx pool and a database/sql handle on top of it. The handle keeps no idle
+// connections of its own, so connections and their statement caches live in the pgx pool and
+// are shared with native callers such as COPY and LISTEN.
+func open(cfg *config.Config, dsn, role string) (*pgxpool.Pool, *sql.DB, error) {
+	poolCfg, err := poolConfig(cfg, dsn)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s pool: %w", role, err)
+	}
+	pool, err := pgxpool.NewWithConfig(context.Background(), poolCfg)
 	if err != nil {
-		log.Fatal().Err(err).Msg("Database connection failed")
+		return nil, nil, fmt.Errorf("opening %s pool: %w", role, err)
 	}
 
-	err = Db.Ping()
+	handle := otelsql.OpenDB(stdlib.GetPoolConnector(pool),
+		otelsql.WithAttributes(semconv.DBSystemPostgreSQL, semconv.DBNamespace(poolCfg.ConnConfig.Database)),
+		otelsql.WithSpanOptions(otelsql.SpanOptions{DisableErrSkip: true, OmitConnResetSession: true}),
+	)
+	handle.SetMaxOpenConns(cfg.DBMaxOpenConns)
+	handle.SetMaxIdleConns(0)
+
+	if err := pingWithRetry(handle, cfg.DBConnectTimeout, role); err != nil {
+		handle.Close()
+		pool.Close()
+		return nil, nil, err
+	}
+	return pool, handle, nil
+}
+
+// pingWithRetry pings with exponential backoff until the database answers or the timeout elapses
+func pingWithRetry(pool *sql.DB, timeout time.Duration, role string) error {
+	deadline := time.Now().Add(timeout)
+	backoff := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err := pool.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return nil
+		}
+
+		if time.Now().Add(backoff).After(deadline) {
+			return fmt.Errorf("%s database is unreachable after %d attempts: %w", role, attempt, err)
+		}
+		log.Warn().Err(err).Str("pool", role).Int("attempt", attempt).Dur("backoff", backoff).Msg("Database ping failed, retrying")
+		time.Sleep(backoff)
+		backoff = min(backoff*2, 10*time.Second)
+	}
+}
+
+// InitDB connects the primary pool and, if configured, the read replica pool
+func InitDB(cfg *config.Config) error {
+	var err error
+	Pool, Db, err = open(cfg, primaryDSN(cfg), "primary")
 	if err != nil {
-		log.Fatal().Err(err).Msg("Database ping failed")
+		return err
 	}
 	log.Info().Msg("Connected to database!")
+
+	if cfg.DatabaseReplicaURL != "" {
+		ReplicaPool, Replica, err = open(cfg, cfg.DatabaseReplicaURL, "replica")
+		if err != nil {
+			Close()
+			return err
+		}
+		log.Info().Msg("Connected to read replica")
+	}
+	return nil
+}
+
+// Close closes the primary and replica pools
+func Close() error {
+	if Replica != nil {
+		Replica.Close()
+		ReplicaPool.Close()
+	}
+	err := Db.Close()
+	Pool.Close()
+	return err
 }
@@ -0,0 +1,60 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5/pgconn"
+	"net/http"
+)
+
+// StatusClientClosedRequest is the nginx convention for a client that went away before the response
+const StatusClientClosedRequest = 499
+
+// SQLSTATE codes mapped to responses
+const (
+	foreignKeyViolation = "23503"
+	uniqueViolation     = "23505"
+	checkViolation      = "23514"
+	queryCanceled       = "57014"
+)
+
+// pgCode returns the SQLSTATE of a server error, or "" for any other error
+func pgCode(err error) string {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgErr.Code
+	}
+	return ""
+}
+
+// IsUniqueViolation reports whether err comes from a unique constraint or index
+func IsUniqueViolation(err error) bool {
+	return pgCode(err) == uniqueViolation
+}
+
+// IsTimeout reports whether err comes from an expired request deadline or the server-side statement_timeout
+func IsTimeout(err error) bool {
+	return errors.Is(err, context.DeadlineExceeded) || pgCode(err) == queryCanceled
+}
+
+// HTTPStatus maps a database error of a request with context ctx to a response status and message:
+// 499 when the client disconnected, 504 when the query ran out of time, 409 for unique and foreign
+// key violations, 422 for check constraint violations and 500 otherwise. A foreign key violation
+// means that a row the request refers to was deleted meanwhile. The context is consulted first
+// because a query canceled on the client's behalf is reported by the server as 57014 too.
+func HTTPStatus(ctx context.Context, err error) (int, string) {
+	switch {
+	case errors.Is(ctx.Err(), context.Canceled), errors.Is(err, context.Canceled):
+		return StatusClientClosedRequest, "Client closed request"
+	case IsTimeout(err):
+		return http.StatusGatewayTimeout, "Database timeout"
+	case pgCode(err) == uniqueViolation:
+		return http.StatusConflict, "Resource already exists"
+	case pgCode(err) == foreignKeyViolation:
+		return http.StatusConflict, "Referenced resource no longer exists"
+	case pgCode(err) == checkViolation:
+		return http.StatusUnprocessableEntity, "Value violates a constraint"
+	default:
+		return http.StatusInternalServerError, "Database error"
+	}
+}
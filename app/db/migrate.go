@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/rs/zerolog/log"
+	"io/fs"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+type migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+func loadMigrations() ([]migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, entry := range entries {
+		name := entry.Name()
+		prefix, _, found := strings.Cut(name, "_")
+		if !found {
+			return nil, fmt.Errorf("migration %s has no version prefix", name)
+		}
+		version, err := strconv.Atoi(prefix)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s has invalid version: %w", name, err)
+		}
+		body, err := migrationFiles.ReadFile("migrations/" + name)
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, migration{Version: version, Name: name, SQL: string(body)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// migrationLock is the advisory lock key that serializes migrations across replicas
+const migrationLock = "schema_migrations"
+
+// Migrate applies every embedded migration that is not yet recorded in schema_migrations. It holds
+// a session advisory lock for the whole run, so replicas starting together apply each migration
+// once: the others wait and then find it recorded.
+func Migrate() {
+	log.Info().Msg("Applying database migrations")
+	ctx := context.Background()
+
+	// The lock belongs to the session, so everything runs on one connection
+	conn, err := Db.Conn(ctx)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Getting a connection for migrations failed")
+	}
+	defer conn.Close()
+	lockTx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Starting migration lock transaction failed")
+	}
+	// Waiting for another replica to finish its migrations may take longer than statement_timeout
+	if _, err := lockTx.ExecContext(ctx, "SET LOCAL statement_timeout = 0"); err != nil {
+		lockTx.Rollback()
+		log.Fatal().Err(err).Msg("Disabling statement timeout for the migration lock failed")
+	}
+	if _, err := lockTx.ExecContext(ctx, "SELECT pg_advisory_lock(hashtext($1), 0)", migrationLock); err != nil {
+		lockTx.Rollback()
+		log.Fatal().Err(err).Msg("Taking the migration lock failed")
+	}
+	// A session lock outlives the transaction that took it
+	if err := lockTx.Commit(); err != nil {
+		log.Fatal().Err(err).Msg("Taking the migration lock failed")
+	}
+	defer func() {
+		if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_unlock(hashtext($1), 0)", migrationLock); err != nil {
+			log.Error().Err(err).Msg("Releasing the migration lock failed")
+		}
+	}()
+
+	_, err = conn.ExecContext(ctx, `
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT PRIMARY KEY,
+			name VARCHAR(128) NOT NULL,
+			applied_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+		)
+	`)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Creating schema_migrations table failed")
+	}
+
+	migrations, err := loadMigrations()
+	if err != nil {
+		log.Fatal().Err(err).Msg("Loading migrations failed")
+	}
+
+	for _, m := range migrations {
+		var applied bool
+		err := conn.QueryRowContext(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", m.Version).Scan(&applied)
+		if err != nil {
+			log.Fatal().Err(err).Msgf("Checking migration %s failed", m.Name)
+		}
+		if applied {
+			log.Debug().Msgf("Migration %s already applied", m.Name)
+			continue
+		}
+
+		tx, err := conn.BeginTx(ctx, nil)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Starting migration transaction failed")
+		}
+		// Migrations may rewrite large tables; the per-connection statement_timeout is meant for requests
+		if _, err := tx.ExecContext(ctx, "SET LOCAL statement_timeout = 0"); err != nil {
+			tx.Rollback()
+			log.Fatal().Err(err).Msg("Disabling statement timeout for migrations failed")
+		}
+		if _, err := tx.ExecContext(ctx, m.SQL); err != nil {
+			tx.Rollback()
+			log.Fatal().Err(err).Msgf("Migration %s failed", m.Name)
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)", m.Version, m.Name); err != nil {
+			tx.Rollback()
+			log.Fatal().Err(err).Msgf("Recording migration %s failed", m.Name)
+		}
+		if err := tx.Commit(); err != nil {
+			log.Fatal().Err(err).Msgf("Committing migration %s failed", m.Name)
+		}
+		log.Info().Msgf("Migration %s applied", m.Name)
+	}
+
+	log.Info().Msg("Database migrations are up to date")
+}
+
+// PendingMigrations returns the names of embedded migrations that are not yet applied
+func PendingMigrations(ctx context.Context) ([]string, error) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := Db.QueryContext(ctx, "SELECT version FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := map[int]bool{}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			return nil, err
+		}
+		applied[version] = true
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	pending := []string{}
+	for _, m := range migrations {
+		if !applied[m.Version] {
+			pending = append(pending, m.Name)
+		}
+	}
+	return pending, nil
+}
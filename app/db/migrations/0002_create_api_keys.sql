@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS api_keys (
+    key_id SERIAL PRIMARY KEY,
+    name VARCHAR(64) NOT NULL,
+    key_prefix VARCHAR(16) NOT NULL UNIQUE,
+    key_hash CHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
@@ -0,0 +1,33 @@
+CREATE TABLE IF NOT EXISTS roles (
+    role_name VARCHAR(32) PRIMARY KEY,
+    description VARCHAR(128) NOT NULL DEFAULT ''
+);
+
+CREATE TABLE IF NOT EXISTS role_permissions (
+    role_name VARCHAR(32) NOT NULL REFERENCES roles (role_name) ON DELETE CASCADE,
+    permission VARCHAR(32) NOT NULL,
+    PRIMARY KEY (role_name, permission)
+);
+
+CREATE TABLE IF NOT EXISTS subject_roles (
+    subject VARCHAR(128) NOT NULL,
+    role_name VARCHAR(32) NOT NULL REFERENCES roles (role_name) ON DELETE CASCADE,
+    granted_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (subject, role_name)
+);
+
+INSERT INTO roles (role_name, description) VALUES
+    ('reader', 'Can list songs and read lyrics'),
+    ('editor', 'Can add and update songs'),
+    ('admin', 'Can delete songs and manage roles')
+ON CONFLICT DO NOTHING;
+
+INSERT INTO role_permissions (role_name, permission) VALUES
+    ('reader', 'songs:read'),
+    ('editor', 'songs:read'),
+    ('editor', 'songs:write'),
+    ('admin', 'songs:read'),
+    ('admin', 'songs:write'),
+    ('admin', 'songs:delete'),
+    ('admin', 'admin')
+ON CONFLICT DO NOTHING;
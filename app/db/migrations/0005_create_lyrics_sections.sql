@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS lyrics_sections (
+    section_id SERIAL PRIMARY KEY,
+    song_id INT NOT NULL REFERENCES songs (song_id) ON DELETE CASCADE,
+    position INT NOT NULL CHECK (position > 0),
+    section_type VARCHAR(16) NOT NULL CHECK (section_type IN ('verse', 'chorus', 'bridge', 'intro', 'outro')),
+    label VARCHAR(64),
+    text TEXT NOT NULL DEFAULT '',
+    UNIQUE (song_id, position)
+);
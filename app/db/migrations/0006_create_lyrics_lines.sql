@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS lyrics_lines (
+    song_id INT NOT NULL REFERENCES songs (song_id) ON DELETE CASCADE,
+    line_no INT NOT NULL CHECK (line_no > 0),
+    time_ms INT NOT NULL CHECK (time_ms >= 0),
+    text TEXT NOT NULL DEFAULT '',
+    words JSONB,
+    PRIMARY KEY (song_id, line_no)
+);
+
+CREATE INDEX IF NOT EXISTS lyrics_lines_song_time_idx ON lyrics_lines (song_id, time_ms);
@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS lyrics_languages (
+    song_id INT NOT NULL REFERENCES songs (song_id) ON DELETE CASCADE,
+    language VARCHAR(3) NOT NULL CHECK (language ~ '^[a-z]{2,3}$'),
+    is_original BOOLEAN NOT NULL DEFAULT FALSE,
+    translator VARCHAR(128),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (song_id, language)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS lyrics_languages_original_idx ON lyrics_languages (song_id) WHERE is_original;
+
+-- Sections stored so far are the original lyrics in an undetermined language (ISO 639-2 "und")
+INSERT INTO lyrics_languages (song_id, language, is_original)
+SELECT DISTINCT song_id, 'und', TRUE FROM lyrics_sections
+ON CONFLICT DO NOTHING;
+
+ALTER TABLE lyrics_sections ADD COLUMN IF NOT EXISTS language VARCHAR(3) NOT NULL DEFAULT 'und';
+ALTER TABLE lyrics_sections DROP CONSTRAINT IF EXISTS lyrics_sections_song_id_position_key;
+ALTER TABLE lyrics_sections ADD CONSTRAINT lyrics_sections_song_language_position_key UNIQUE (song_id, language, position);
+ALTER TABLE lyrics_sections ADD CONSTRAINT lyrics_sections_language_fkey
+    FOREIGN KEY (song_id, language) REFERENCES lyrics_languages (song_id, language) ON DELETE CASCADE ON UPDATE CASCADE;
+ALTER TABLE lyrics_sections ALTER COLUMN language DROP DEFAULT;
@@ -0,0 +1,32 @@
+CREATE TABLE IF NOT EXISTS playlists (
+    playlist_id SERIAL PRIMARY KEY,
+    name VARCHAR(128) NOT NULL CHECK (name <> ''),
+    description TEXT NOT NULL DEFAULT '',
+    owner VARCHAR(128) NOT NULL,
+    visibility VARCHAR(16) NOT NULL DEFAULT 'private' CHECK (visibility IN ('private', 'unlisted', 'public')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS playlists_owner_idx ON playlists (owner);
+CREATE INDEX IF NOT EXISTS playlists_public_idx ON playlists (playlist_id) WHERE visibility = 'public';
+
+-- Items are ordered by a fractional rank: an item is inserted or moved between its neighbours
+-- by taking the midpoint of their ranks, so only the moved row is written
+CREATE TABLE IF NOT EXISTS playlist_items (
+    item_id SERIAL PRIMARY KEY,
+    playlist_id INT NOT NULL REFERENCES playlists (playlist_id) ON DELETE CASCADE,
+    song_id INT NOT NULL REFERENCES songs (song_id) ON DELETE CASCADE,
+    rank DOUBLE PRECISION NOT NULL,
+    added_by VARCHAR(128) NOT NULL,
+    added_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS playlist_items_order_idx ON playlist_items (playlist_id, rank, item_id);
+CREATE INDEX IF NOT EXISTS playlist_items_song_idx ON playlist_items (song_id);
+
+INSERT INTO role_permissions (role_name, permission) VALUES
+    ('reader', 'playlists:write'),
+    ('editor', 'playlists:write'),
+    ('admin', 'playlists:write')
+ON CONFLICT DO NOTHING;
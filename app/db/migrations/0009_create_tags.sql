@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS tags (
+    tag_id SERIAL PRIMARY KEY,
+    tag_type VARCHAR(16) NOT NULL CHECK (tag_type IN ('genre', 'mood', 'language', 'decade')),
+    name VARCHAR(64) NOT NULL CHECK (name <> ''),
+    slug VARCHAR(64) NOT NULL CHECK (slug ~ '^[[:alnum:]]+(-[[:alnum:]]+)*$'),
+    -- Only genres form a hierarchy, e.g. rock -> alternative rock
+    parent_id INT REFERENCES tags (tag_id),
+    CHECK (parent_id IS NULL OR tag_type = 'genre'),
+    CHECK (parent_id <> tag_id),
+    UNIQUE (tag_type, slug)
+);
+
+CREATE INDEX IF NOT EXISTS tags_parent_idx ON tags (parent_id);
+CREATE INDEX IF NOT EXISTS tags_slug_idx ON tags (slug);
+
+CREATE TABLE IF NOT EXISTS song_tags (
+    song_id INT NOT NULL REFERENCES songs (song_id) ON DELETE CASCADE,
+    tag_id INT NOT NULL REFERENCES tags (tag_id) ON DELETE CASCADE,
+    PRIMARY KEY (song_id, tag_id)
+);
+
+CREATE INDEX IF NOT EXISTS song_tags_tag_idx ON song_tags (tag_id, song_id);
@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS people (
+    person_id SERIAL PRIMARY KEY,
+    name VARCHAR(128) NOT NULL CHECK (name <> ''),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS people_name_idx ON people (lower(name));
+
+-- People stay referenced by their credits: a credited person cannot be deleted
+CREATE TABLE IF NOT EXISTS song_credits (
+    song_id INT NOT NULL REFERENCES songs (song_id) ON DELETE CASCADE,
+    person_id INT NOT NULL REFERENCES people (person_id),
+    role VARCHAR(16) NOT NULL CHECK (role IN ('writer', 'composer', 'lyricist', 'producer', 'featured')),
+    position INT NOT NULL CHECK (position > 0),
+    PRIMARY KEY (song_id, person_id, role)
+);
+
+CREATE INDEX IF NOT EXISTS song_credits_person_idx ON song_credits (person_id, role);
@@ -0,0 +1,12 @@
+ALTER TABLE songs
+    ADD COLUMN IF NOT EXISTS isrc VARCHAR(12) CHECK (isrc ~ '^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$'),
+    ADD COLUMN IF NOT EXISTS iswc VARCHAR(11) CHECK (iswc ~ '^T[0-9]{10}$'),
+    ADD COLUMN IF NOT EXISTS mb_recording_id UUID,
+    ADD COLUMN IF NOT EXISTS mb_work_id UUID;
+
+-- A recording has one ISRC and one MusicBrainz recording ID
+CREATE UNIQUE INDEX IF NOT EXISTS songs_isrc_idx ON songs (isrc);
+CREATE UNIQUE INDEX IF NOT EXISTS songs_mb_recording_id_idx ON songs (mb_recording_id);
+-- Covers of a work share its ISWC and MusicBrainz work ID
+CREATE INDEX IF NOT EXISTS songs_iswc_idx ON songs (iswc);
+CREATE INDEX IF NOT EXISTS songs_mb_work_id_idx ON songs (mb_work_id);
@@ -0,0 +1,46 @@
+ALTER TABLE songs ALTER COLUMN link TYPE TEXT;
+
+CREATE TABLE IF NOT EXISTS song_links (
+    link_id SERIAL PRIMARY KEY,
+    song_id INT NOT NULL REFERENCES songs (song_id) ON DELETE CASCADE,
+    url TEXT NOT NULL CHECK (url ~* '^https?://'),
+    provider VARCHAR(16) NOT NULL DEFAULT 'other'
+        CHECK (provider IN ('youtube', 'spotify', 'apple_music', 'soundcloud', 'deezer', 'yandex_music', 'bandcamp', 'genius', 'other')),
+    kind VARCHAR(16) NOT NULL DEFAULT 'other' CHECK (kind IN ('video', 'audio', 'lyrics_source', 'other')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    -- Filled in by the link checker; a link is dead after several failed checks in a row
+    last_checked_at TIMESTAMPTZ,
+    last_status INT,
+    last_error TEXT,
+    failures INT NOT NULL DEFAULT 0,
+    dead BOOLEAN NOT NULL DEFAULT FALSE,
+    UNIQUE (song_id, url)
+);
+
+CREATE INDEX IF NOT EXISTS song_links_due_idx ON song_links (last_checked_at NULLS FIRST);
+CREATE INDEX IF NOT EXISTS song_links_dead_idx ON song_links (song_id) WHERE dead;
+
+INSERT INTO song_links (song_id, url, provider, kind)
+SELECT song_id, link,
+    CASE
+        WHEN link ~* '^https?://([a-z0-9-]+\.)*(youtube\.com|youtu\.be)/' THEN 'youtube'
+        WHEN link ~* '^https?://([a-z0-9-]+\.)*spotify\.com/' THEN 'spotify'
+        WHEN link ~* '^https?://music\.apple\.com/' THEN 'apple_music'
+        WHEN link ~* '^https?://([a-z0-9-]+\.)*soundcloud\.com/' THEN 'soundcloud'
+        WHEN link ~* '^https?://([a-z0-9-]+\.)*deezer\.com/' THEN 'deezer'
+        WHEN link ~* '^https?://music\.yandex\.(ru|com)/' THEN 'yandex_music'
+        WHEN link ~* '^https?://([a-z0-9-]+\.)*bandcamp\.com/' THEN 'bandcamp'
+        WHEN link ~* '^https?://([a-z0-9-]+\.)*genius\.com/' THEN 'genius'
+        ELSE 'other'
+    END,
+    'other'
+FROM songs
+WHERE link ~* '^https?://'
+ON CONFLICT DO NOTHING;
+
+UPDATE song_links SET kind = CASE provider
+    WHEN 'youtube' THEN 'video'
+    WHEN 'genius' THEN 'lyrics_source'
+    WHEN 'other' THEN 'other'
+    ELSE 'audio'
+END;
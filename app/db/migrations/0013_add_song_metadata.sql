@@ -0,0 +1,10 @@
+ALTER TABLE songs
+    ADD COLUMN IF NOT EXISTS duration_seconds INT CHECK (duration_seconds > 0),
+    ADD COLUMN IF NOT EXISTS bpm DOUBLE PRECISION CHECK (bpm > 0 AND bpm < 1000),
+    ADD COLUMN IF NOT EXISTS musical_key VARCHAR(3) CHECK (musical_key ~ '^[A-G][b#]?m?$'),
+    ADD COLUMN IF NOT EXISTS explicit BOOLEAN NOT NULL DEFAULT FALSE;
+
+CREATE INDEX IF NOT EXISTS songs_bpm_idx ON songs (bpm);
+CREATE INDEX IF NOT EXISTS songs_musical_key_idx ON songs (musical_key);
+-- The language of a song is the language of its original lyrics
+CREATE INDEX IF NOT EXISTS lyrics_languages_original_language_idx ON lyrics_languages (language, song_id) WHERE is_original;
@@ -0,0 +1,24 @@
+-- Uploaded files are stored once per content, under their SHA-256
+CREATE TABLE IF NOT EXISTS blobs (
+    sha256 CHAR(64) PRIMARY KEY CHECK (sha256 ~ '^[0-9a-f]{64}$'),
+    size BIGINT NOT NULL CHECK (size >= 0),
+    content_type VARCHAR(128) NOT NULL,
+    width INT,
+    height INT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS song_attachments (
+    attachment_id SERIAL PRIMARY KEY,
+    song_id INT NOT NULL REFERENCES songs (song_id) ON DELETE CASCADE,
+    kind VARCHAR(16) NOT NULL DEFAULT 'other' CHECK (kind IN ('cover', 'other')),
+    filename VARCHAR(255) NOT NULL,
+    sha256 CHAR(64) NOT NULL REFERENCES blobs (sha256),
+    uploaded_by VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (song_id, sha256)
+);
+
+-- A song has at most one cover
+CREATE UNIQUE INDEX IF NOT EXISTS song_attachments_cover_idx ON song_attachments (song_id) WHERE kind = 'cover';
+CREATE INDEX IF NOT EXISTS song_attachments_sha256_idx ON song_attachments (sha256);
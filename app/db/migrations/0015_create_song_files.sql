@@ -0,0 +1,21 @@
+-- Audio files ingested into the library. The audio hash leaves out the tags, so a retagged copy of
+-- a file is recognised too.
+CREATE TABLE IF NOT EXISTS song_files (
+    file_id SERIAL PRIMARY KEY,
+    song_id INT NOT NULL REFERENCES songs (song_id) ON DELETE CASCADE,
+    filename VARCHAR(255) NOT NULL,
+    format VARCHAR(8) NOT NULL CHECK (format IN ('mp3', 'flac')),
+    size BIGINT NOT NULL CHECK (size >= 0),
+    duration_ms INT CHECK (duration_ms > 0),
+    sample_rate INT,
+    bitrate INT,
+    album TEXT,
+    sha256 CHAR(64) NOT NULL UNIQUE CHECK (sha256 ~ '^[0-9a-f]{64}$'),
+    audio_sha256 CHAR(64) NOT NULL CHECK (audio_sha256 ~ '^[0-9a-f]{64}$'),
+    ingested_by VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS song_files_song_id_idx ON song_files (song_id);
+CREATE INDEX IF NOT EXISTS song_files_audio_sha256_idx ON song_files (audio_sha256);
+CREATE INDEX IF NOT EXISTS songs_group_song_lower_idx ON songs (lower(group_name), lower(song_name));
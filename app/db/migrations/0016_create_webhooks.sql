@@ -0,0 +1,51 @@
+-- Every change to a song is recorded in the transaction that makes it. The log doubles as the
+-- outbox of webhook deliveries: the dispatcher fans each event out to the matching subscriptions
+-- and marks it dispatched.
+CREATE TABLE IF NOT EXISTS song_events (
+    event_id BIGSERIAL PRIMARY KEY,
+    event_type VARCHAR(32) NOT NULL CHECK (event_type IN ('song.created', 'song.updated', 'song.deleted')),
+    -- Not a foreign key: the events of a deleted song outlive it
+    song_id INT NOT NULL,
+    group_name VARCHAR(64) NOT NULL,
+    song_name VARCHAR(64) NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    dispatched BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX IF NOT EXISTS song_events_undispatched_idx ON song_events (event_id) WHERE NOT dispatched;
+
+CREATE TABLE IF NOT EXISTS webhook_subscriptions (
+    subscription_id SERIAL PRIMARY KEY,
+    url TEXT NOT NULL CHECK (url ~* '^https?://'),
+    event_types VARCHAR(32)[] NOT NULL CHECK (cardinality(event_types) > 0),
+    -- Kept in plain text, as deliveries are signed with it
+    secret TEXT NOT NULL,
+    active BOOLEAN NOT NULL DEFAULT TRUE,
+    description VARCHAR(255),
+    created_by VARCHAR(255),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    delivery_id BIGSERIAL PRIMARY KEY,
+    subscription_id INT NOT NULL REFERENCES webhook_subscriptions (subscription_id) ON DELETE CASCADE,
+    event_id BIGINT NOT NULL REFERENCES song_events (event_id) ON DELETE CASCADE,
+    -- A delivery is dead once it has failed as many times as the dispatcher allows
+    status VARCHAR(16) NOT NULL DEFAULT 'pending' CHECK (status IN ('pending', 'delivered', 'dead')),
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    last_attempt_at TIMESTAMPTZ,
+    last_status INT,
+    last_error TEXT,
+    delivered_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (subscription_id, event_id)
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_due_idx ON webhook_deliveries (next_attempt_at) WHERE status = 'pending';
+CREATE INDEX IF NOT EXISTS webhook_deliveries_subscription_idx ON webhook_deliveries (subscription_id, delivery_id DESC);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_event_id_idx ON webhook_deliveries (event_id);
+
+INSERT INTO role_permissions (role_name, permission) VALUES
+    ('admin', 'webhooks:manage')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,2 @@
+-- Events past events.retention are pruned by age
+CREATE INDEX IF NOT EXISTS song_events_created_at_idx ON song_events (created_at);
@@ -0,0 +1,35 @@
+package db
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+)
+
+// Listen holds a dedicated connection subscribed to channel and calls handle for every notification
+// until ctx is done. It returns nil on cancellation and the error otherwise, e.g. when the connection
+// is lost; notifications sent while no listener is connected are not delivered, so callers that
+// restart it should catch up from their own state.
+func Listen(ctx context.Context, channel string, handle func(payload string)) error {
+	pooled, err := Pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	// The subscription outlives any query, so the connection is taken out of the pool for good
+	conn := pooled.Hijack()
+	defer conn.Close(context.Background())
+
+	if _, err := conn.Exec(ctx, "LISTEN "+pgx.Identifier{channel}.Sanitize()); err != nil {
+		return err
+	}
+
+	for {
+		notification, err := conn.WaitForNotification(ctx)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+		handle(notification.Payload)
+	}
+}
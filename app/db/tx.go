@@ -0,0 +1,20 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+)
+
+// InTx runs fn in a transaction on the primary, committing when it returns nil and rolling back otherwise
+func InTx(ctx context.Context, fn func(tx *sql.Tx) error) error {
+	tx, err := Db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
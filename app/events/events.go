@@ -0,0 +1,36 @@
+// Package events records changes to songs in the event log and streams them to listeners
+package events
+
+import (
+	"context"
+	"database/sql"
+)
+
+// Channel is the Postgres notification channel that carries the ID of every committed event
+const Channel = "song_events"
+
+// Emit records a change to a song in the transaction that makes it, with the group and song as
+// they are in the transaction, and notifies the listeners of every replica once it commits.
+// A deletion is emitted before the song is deleted.
+//
+// Events are emitted one transaction at a time, so that they commit in the order of their IDs and
+// a reader that has seen an event never misses an earlier one. The song row is locked before the
+// log, so that waiting for the log never deadlocks with the other writes of the song. Playlists
+// are locked before their songs, so a transaction that changes playlists does it before emitting.
+func Emit(ctx context.Context, tx *sql.Tx, eventType string, songID int) error {
+	if _, err := tx.ExecContext(ctx, "SELECT 1 FROM songs WHERE song_id = $1 FOR UPDATE", songID); err != nil {
+		return err
+	}
+	if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext($1), 0)", Channel); err != nil {
+		return err
+	}
+	_, err := tx.ExecContext(ctx, `
+		WITH event AS (
+			INSERT INTO song_events (event_type, song_id, group_name, song_name)
+			SELECT $1, song_id, group_name, song_name FROM songs WHERE song_id = $2
+			RETURNING event_id
+		)
+		SELECT pg_notify($3, event_id::text) FROM event
+	`, eventType, songID, Channel)
+	return err
+}
@@ -0,0 +1,138 @@
+package events
+
+import (
+	"context"
+	"song_library/config"
+	"song_library/db"
+	"song_library/worker"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// pruneInterval is how often events past the retention are deleted
+	pruneInterval = time.Hour
+	// maxListenBackoff caps the delay between attempts to listen again after losing the connection
+	maxListenBackoff = 30 * time.Second
+)
+
+// Subscription wakes a stream when new events may have been committed. Wake-ups coalesce: the
+// stream reads everything after the last event it sent, so one wake-up stands for any number.
+type Subscription struct {
+	C <-chan struct{}
+	c chan struct{}
+}
+
+// Hub listens for the events committed on any replica and wakes the streams of this one
+type Hub struct {
+	retention time.Duration
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// NewHub builds a hub from the configuration
+func NewHub(cfg *config.Config) *Hub {
+	return &Hub{retention: cfg.EventRetention, done: make(chan struct{}), subs: map[*Subscription]struct{}{}}
+}
+
+// Close tells the streams to end, so that clients reconnect to another replica while this one
+// shuts down
+func (h *Hub) Close() {
+	h.closeOnce.Do(func() { close(h.done) })
+}
+
+// Done is closed once the hub is closed
+func (h *Hub) Done() <-chan struct{} {
+	return h.done
+}
+
+// Subscribe registers a stream; it must be unsubscribed once it ends
+func (h *Hub) Subscribe() *Subscription {
+	c := make(chan struct{}, 1)
+	s := &Subscription{C: c, c: c}
+	h.mu.Lock()
+	h.subs[s] = struct{}{}
+	h.mu.Unlock()
+	return s
+}
+
+// Unsubscribe removes a stream
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	delete(h.subs, s)
+	h.mu.Unlock()
+}
+
+// Streams returns the number of subscribed streams
+func (h *Hub) Streams() int {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return len(h.subs)
+}
+
+func (h *Hub) wake() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		select {
+		case s.c <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Start runs the listener and, unless events.retention is 0, the pruning of the event log
+func (h *Hub) Start(workers *worker.Group) {
+	workers.Go("event-listener", h.listen)
+	if h.retention <= 0 {
+		log.Info().Msg("Event log pruning is disabled")
+		return
+	}
+	workers.Go("event-pruner", func(ctx context.Context) {
+		ticker := time.NewTicker(pruneInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				count, err := prune(ctx, h.retention)
+				if err != nil && ctx.Err() == nil {
+					log.Error().Err(err).Msg("Error pruning the event log")
+				} else if count > 0 {
+					log.Info().Int64("count", count).Msg("Pruned the event log")
+				}
+			}
+		}
+	})
+}
+
+// listen keeps a LISTEN connection open, reconnecting with backoff when it is lost. Notifications
+// sent while it is down are lost, so the streams are woken to catch up from the log before every
+// reconnect; they also read it on every heartbeat.
+func (h *Hub) listen(ctx context.Context) {
+	backoff := time.Second
+	for {
+		started := time.Now()
+		err := db.Listen(ctx, Channel, func(string) { h.wake() })
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(started) > maxListenBackoff {
+			backoff = time.Second
+		}
+		log.Error().Err(err).Dur("retry_in", backoff).Msg("Lost the event notification listener")
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(2*backoff, maxListenBackoff)
+		h.wake()
+	}
+}
@@ -0,0 +1,84 @@
+package events
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"song_library/db"
+	"song_library/models"
+	"time"
+)
+
+var ErrInvalid = errors.New("invalid event filter")
+
+// Filter selects events by type and song; empty fields match everything
+type Filter struct {
+	Types   []string
+	SongIDs []int
+}
+
+// Validate checks the event types of a filter
+func (f Filter) Validate() error {
+	for _, t := range f.Types {
+		if !slices.Contains(models.EventTypes, t) {
+			return fmt.Errorf("%w: type must be one of %v, got %q", ErrInvalid, models.EventTypes, t)
+		}
+	}
+	return nil
+}
+
+// Latest returns the ID of the last event, or 0 when the log is empty
+func Latest(ctx context.Context) (int64, error) {
+	var id int64
+	err := db.Db.QueryRowContext(ctx, "SELECT COALESCE(MAX(event_id), 0) FROM song_events").Scan(&id)
+	return id, err
+}
+
+// After returns up to limit events matching the filter that follow an event, oldest first. It reads
+// the primary, as a replica may not have the events listeners were just notified of yet.
+func After(ctx context.Context, eventID int64, filter Filter, limit int) ([]models.SongEvent, error) {
+	if filter.Types == nil {
+		filter.Types = []string{}
+	}
+	if filter.SongIDs == nil {
+		filter.SongIDs = []int{}
+	}
+	rows, err := db.Db.QueryContext(ctx, `
+		SELECT event_id, event_type, song_id, group_name, song_name, created_at FROM song_events
+		WHERE event_id > $1
+			AND (cardinality($2::text[]) = 0 OR event_type = ANY ($2))
+			AND (cardinality($3::int[]) = 0 OR song_id = ANY ($3))
+		ORDER BY event_id LIMIT $4
+	`, eventID, filter.Types, filter.SongIDs, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []models.SongEvent
+	for rows.Next() {
+		var e models.SongEvent
+		if err := rows.Scan(&e.ID, &e.Type, &e.SongID, &e.Group, &e.Song, &e.OccurredAt); err != nil {
+			return nil, err
+		}
+		list = append(list, e)
+	}
+	return list, rows.Err()
+}
+
+// prune deletes the events older than retention, keeping those active webhooks have yet to deliver
+func prune(ctx context.Context, retention time.Duration) (int64, error) {
+	result, err := db.Db.ExecContext(ctx, `
+		DELETE FROM song_events e
+		WHERE e.created_at < NOW() - $1 * INTERVAL '1 second' AND e.dispatched
+			AND NOT EXISTS (
+				SELECT 1 FROM webhook_deliveries d JOIN webhook_subscriptions s USING (subscription_id)
+				WHERE d.event_id = e.event_id AND d.status = 'pending' AND s.active
+			)
+	`, retention.Seconds())
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
@@ -0,0 +1,140 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"song_library/auth"
+	"song_library/logging"
+)
+
+// ListRoles returns all roles with their permissions
+// @Summary List roles
+// @Description Returns all roles together with the permissions they grant
+// @Tags Admin
+// @Produce json
+// @Success 200 {array} auth.Role
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Failure 500 {object} map[string]string "Database error"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/roles [get]
+func ListRoles(c *gin.Context) {
+	logger := logging.Ctx(c)
+	logger.Debug().Msg("Processing ListRoles request")
+	roles, err := auth.ListRoles(c.Request.Context())
+	if err != nil {
+		logger.Error().Err(err).Msg("Error listing roles")
+		respondDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// GetSubjectRoles returns the roles granted to a subject
+// @Summary Get roles of a subject
+// @Description Returns the roles granted to a subject such as "apikey:1" or a JWT subject
+// @Tags Admin
+// @Produce json
+// @Param subject path string true "Subject"
+// @Success 200 {array} string
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Failure 500 {object} map[string]string "Database error"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/subjects/{subject}/roles [get]
+func GetSubjectRoles(c *gin.Context) {
+	logger := logging.Ctx(c)
+	logger.Debug().Msg("Processing GetSubjectRoles request")
+	roles, err := auth.SubjectRoles(c.Request.Context(), c.Param("subject"))
+	if err != nil {
+		logger.Error().Err(err).Msg("Error loading subject roles")
+		respondDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, roles)
+}
+
+// GrantRole assigns a role to a subject
+// @Summary Grant a role
+// @Description Assigns a role to a subject
+// @Tags Admin
+// @Accept json
+// @Produce json
+// @Param subject path string true "Subject"
+// @Param role body object true "Role name (role)"
+// @Success 200 {object} map[string]string "Role granted successfully"
+// @Failure 400 {object} map[string]string "Invalid data format or unknown role"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Failure 500 {object} map[string]string "Database error"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/subjects/{subject}/roles [post]
+func GrantRole(c *gin.Context) {
+	logger := logging.Ctx(c)
+	logger.Debug().Msg("Processing GrantRole request")
+	var input struct {
+		Role string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
+		return
+	}
+
+	subject := c.Param("subject")
+	if err := auth.GrantRole(c.Request.Context(), subject, input.Role); err != nil {
+		if errors.Is(err, auth.ErrUnknownRole) {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Unknown role"})
+			return
+		}
+		logger.Error().Err(err).Msg("Error granting role")
+		respondDBError(c, err)
+		return
+	}
+
+	logger.Info().Str("subject", subject).Str("role", input.Role).Msg("Role granted")
+	c.JSON(http.StatusOK, gin.H{"message": "Role was granted"})
+}
+
+// RevokeRole removes a role from a subject
+// @Summary Revoke a role
+// @Description Removes a role from a subject
+// @Tags Admin
+// @Produce json
+// @Param subject path string true "Subject"
+// @Param role path string true "Role name"
+// @Success 200 {object} map[string]string "Role revoked successfully"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Failure 404 {object} map[string]string "Role is not granted"
+// @Failure 500 {object} map[string]string "Database error"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /admin/subjects/{subject}/roles/{role} [delete]
+func RevokeRole(c *gin.Context) {
+	logger := logging.Ctx(c)
+	logger.Debug().Msg("Processing RevokeRole request")
+	subject, role := c.Param("subject"), c.Param("role")
+	revoked, err := auth.RevokeRole(c.Request.Context(), subject, role)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error revoking role")
+		respondDBError(c, err)
+		return
+	}
+	if !revoked {
+		logger.Warn().Str("subject", subject).Str("role", role).Msg("Role is not granted")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Role is not granted"})
+		return
+	}
+
+	logger.Info().Str("subject", subject).Str("role", role).Msg("Role revoked")
+	c.JSON(http.StatusOK, gin.H{"message": "Role was revoked"})
+}
@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"io"
+	"mime"
+	"net/http"
+	"song_library/attachments"
+	"song_library/auth"
+	"song_library/blobstore"
+	"song_library/logging"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// respondAttachmentError answers the errors shared by the attachment endpoints
+func respondAttachmentError(c *gin.Context, err error) {
+	logger := logging.Ctx(c)
+	switch {
+	case errors.Is(err, attachments.ErrSongNotFound):
+		logger.Warn().Msg("Song not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song is not found"})
+	case errors.Is(err, attachments.ErrNotFound):
+		logger.Warn().Msg("Attachment not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Attachment is not found"})
+	case errors.Is(err, attachments.ErrInvalid):
+		logger.Warn().Err(err).Msg("Invalid attachment")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, attachments.ErrTooLarge):
+		logger.Warn().Err(err).Msg("Attachment too large")
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": err.Error()})
+	case errors.Is(err, attachments.ErrUnsupportedType):
+		logger.Warn().Err(err).Msg("Unsupported attachment type")
+		c.JSON(http.StatusUnsupportedMediaType, gin.H{"error": err.Error()})
+	case errors.Is(err, attachments.ErrNotScalable):
+		logger.Warn().Err(err).Msg("Attachment has no thumbnails")
+		c.JSON(http.StatusUnprocessableEntity, gin.H{"error": err.Error()})
+	case errors.Is(err, attachments.ErrBadSignature):
+		logger.Warn().Msg("Invalid download signature")
+		c.JSON(http.StatusForbidden, gin.H{"error": err.Error()})
+	case errors.Is(err, blobstore.ErrNotFound):
+		logger.Error().Err(err).Msg("Attachment content is missing from the blob store")
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Attachment content is missing"})
+	default:
+		logger.Error().Err(err).Msg("Attachment query failed")
+		respondDBError(c, err)
+	}
+}
+
+// attachmentParams parses the song and attachment IDs of a song attachment route
+func attachmentParams(c *gin.Context) (int, int, bool) {
+	songID, ok := intParam(c, "song_id", "Invalid song ID")
+	if !ok {
+		return 0, 0, false
+	}
+	attachmentID, ok := intParam(c, "attachment_id", "Invalid attachment ID")
+	if !ok {
+		return 0, 0, false
+	}
+	return songID, attachmentID, true
+}
+
+// GetSongAttachments returns the attachments of a song
+// @Summary List song attachments
+// @Description Returns the files attached to a song, cover first, with download URLs signed for a limited time and, for images, thumbnail URLs per size (small, medium, large)
+// @Tags Attachments
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Success 200 {array} models.Attachment
+// @Failure 400 {object} map[string]string "Invalid song ID"
+// @Failure 404 {object} map[string]string "Song not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/attachments [get]
+func GetSongAttachments(store *attachments.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		songID, ok := intParam(c, "song_id", "Invalid song ID")
+		if !ok {
+			return
+		}
+
+		list, err := store.List(c.Request.Context(), songID)
+		if err != nil {
+			respondAttachmentError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// GetSongAttachment returns an attachment of a song
+// @Summary Get a song attachment
+// @Tags Attachments
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param attachment_id path int true "Attachment ID"
+// @Success 200 {object} models.Attachment
+// @Failure 400 {object} map[string]string "Invalid ID"
+// @Failure 404 {object} map[string]string "Attachment not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/attachments/{attachment_id} [get]
+func GetSongAttachment(store *attachments.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		songID, attachmentID, ok := attachmentParams(c)
+		if !ok {
+			return
+		}
+
+		a, err := store.Get(c.Request.Context(), songID, attachmentID)
+		if err != nil {
+			respondAttachmentError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, a)
+	}
+}
+
+// UploadSongAttachment attaches a file to a song
+// @Summary Upload a song attachment
+// @Description Attaches a file to a song, as its cover or as another attachment. The type is sniffed from the content and must be one of ATTACHMENTS_ALLOWED_TYPES; a cover must be an image. Identical content is stored once: uploading a file the song already has returns the existing attachment with 200. A new cover replaces the previous one.
+// @Tags Attachments
+// @Accept multipart/form-data
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param file formData file true "File to attach"
+// @Param kind formData string false "cover or other (default: other)"
+// @Success 201 {object} models.Attachment
+// @Success 200 {object} models.Attachment "The song already had the file"
+// @Failure 400 {object} map[string]string "Missing file, invalid kind or corrupt image"
+// @Failure 404 {object} map[string]string "Song not found"
+// @Failure 413 {object} map[string]string "File too large"
+// @Failure 415 {object} map[string]string "Unsupported file type"
+// @Failure 500 {object} map[string]string "Database or storage error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/attachments [post]
+func UploadSongAttachment(store *attachments.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logger := logging.Ctx(c)
+		songID, ok := intParam(c, "song_id", "Invalid song ID")
+		if !ok {
+			return
+		}
+		header, err := c.FormFile("file")
+		if err != nil {
+			logger.Warn().Err(err).Msg("Missing upload")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a multipart form with a file"})
+			return
+		}
+		if header.Size > store.MaxBytes() {
+			respondAttachmentError(c, attachments.ErrTooLarge)
+			return
+		}
+		file, err := header.Open()
+		if err != nil {
+			logger.Error().Err(err).Msg("Error opening upload")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Couldn't read the file"})
+			return
+		}
+		defer file.Close()
+
+		a, created, err := store.Upload(c.Request.Context(), songID, c.PostForm("kind"), header.Filename, file, auth.SubjectFrom(c))
+		if err != nil {
+			respondAttachmentError(c, err)
+			return
+		}
+		status := http.StatusOK
+		if created {
+			status = http.StatusCreated
+		}
+		logger.Info().Int("song_id", songID).Int("attachment_id", a.ID).Str("kind", a.Kind).Str("sha256", a.SHA256).
+			Int64("size", a.Size).Bool("created", created).Msg("Song attachment uploaded")
+		c.JSON(status, a)
+	}
+}
+
+// DeleteSongAttachment removes an attachment from a song
+// @Summary Delete a song attachment
+// @Description Removes an attachment; its content is deleted unless other attachments share it
+// @Tags Attachments
+// @Param song_id path int true "Song ID"
+// @Param attachment_id path int true "Attachment ID"
+// @Success 200 {object} map[string]string "Attachment was deleted"
+// @Failure 400 {object} map[string]string "Invalid ID"
+// @Failure 404 {object} map[string]string "Attachment not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/attachments/{attachment_id} [delete]
+func DeleteSongAttachment(store *attachments.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		songID, attachmentID, ok := attachmentParams(c)
+		if !ok {
+			return
+		}
+
+		if err := store.Delete(c.Request.Context(), songID, attachmentID); err != nil {
+			respondAttachmentError(c, err)
+			return
+		}
+		logging.Ctx(c).Info().Int("song_id", songID).Int("attachment_id", attachmentID).Msg("Song attachment deleted")
+		c.JSON(http.StatusOK, gin.H{"message": "Attachment was deleted"})
+	}
+}
+
+// GetSongCover redirects to the cover of a song
+// @Summary Get a song cover
+// @Description Redirects to a signed download URL of the cover of a song, or of its thumbnail of a size
+// @Tags Attachments
+// @Param song_id path int true "Song ID"
+// @Param size query string false "Thumbnail size: small, medium or large"
+// @Success 302 "Redirect to the signed download URL"
+// @Failure 400 {object} map[string]string "Invalid song ID"
+// @Failure 404 {object} map[string]string "The song has no cover"
+// @Failure 422 {object} map[string]string "No thumbnail can be made of the cover"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/cover [get]
+func GetSongCover(store *attachments.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		songID, ok := intParam(c, "song_id", "Invalid song ID")
+		if !ok {
+			return
+		}
+
+		cover, err := store.Cover(c.Request.Context(), songID)
+		if err != nil {
+			respondAttachmentError(c, err)
+			return
+		}
+		target := cover.URL
+		if size := c.Query("size"); size != "" {
+			if target = cover.Thumbnails[size]; target == "" {
+				respondAttachmentError(c, attachments.ErrNotScalable)
+				return
+			}
+		}
+		c.Redirect(http.StatusFound, target)
+	}
+}
+
+// DownloadAttachment serves the content of an attachment to the holder of a signed download URL
+// @Summary Download an attachment
+// @Description Serves an attachment, or its JPEG thumbnail of a size, made on first use. The URL is the signed one returned with the attachment and needs no other authentication.
+// @Tags Attachments
+// @Produce octet-stream
+// @Param attachment_id path int true "Attachment ID"
+// @Param size query string false "Thumbnail size: small, medium or large"
+// @Param expires query int true "Expiry of the URL, Unix time"
+// @Param signature query string true "Signature of the URL"
+// @Success 200 {file} file "Attachment content"
+// @Success 304 "Not modified"
+// @Failure 403 {object} map[string]string "Invalid or expired signature"
+// @Failure 404 {object} map[string]string "Attachment not found"
+// @Failure 422 {object} map[string]string "No thumbnail can be made of the attachment"
+// @Failure 500 {object} map[string]string "Database or storage error"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Router /files/{attachment_id} [get]
+func DownloadAttachment(store *attachments.Store) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		attachmentID, err := strconv.Atoi(c.Param("attachment_id"))
+		if err != nil {
+			respondAttachmentError(c, attachments.ErrNotFound)
+			return
+		}
+		expires, err := strconv.ParseInt(c.Query("expires"), 10, 64)
+		if err != nil {
+			respondAttachmentError(c, attachments.ErrBadSignature)
+			return
+		}
+
+		download, err := store.Open(c.Request.Context(), attachmentID, c.Query("size"), expires, c.Query("signature"))
+		if err != nil {
+			respondAttachmentError(c, err)
+			return
+		}
+		defer download.Body.Close()
+
+		// Content never changes under an ETag, so caches may keep it as long as the URL is valid
+		c.Header("ETag", download.ETag)
+		c.Header("Cache-Control", "private, max-age="+strconv.FormatInt(max(0, expires-time.Now().Unix()), 10))
+		if c.GetHeader("If-None-Match") == download.ETag {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		disposition := "attachment"
+		if strings.HasPrefix(download.ContentType, "image/") {
+			disposition = "inline"
+		}
+		c.Header("Content-Disposition", mime.FormatMediaType(disposition, map[string]string{"filename": download.Filename}))
+		c.Header("X-Content-Type-Options", "nosniff")
+		if download.Size >= 0 {
+			c.Header("Content-Length", strconv.FormatInt(download.Size, 10))
+		}
+		c.Header("Content-Type", download.ContentType)
+		c.Status(http.StatusOK)
+		if _, err := io.Copy(c.Writer, download.Body); err != nil {
+			logging.Ctx(c).Warn().Err(err).Int("attachment_id", attachmentID).Msg("Attachment download interrupted")
+		}
+	}
+}
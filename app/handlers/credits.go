@@ -0,0 +1,411 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"song_library/config"
+	"song_library/credits"
+	"song_library/logging"
+	"song_library/models"
+	"strconv"
+)
+
+// respondCreditError answers the errors shared by the people and credit endpoints
+func respondCreditError(c *gin.Context, err error) {
+	logger := logging.Ctx(c)
+	switch {
+	case errors.Is(err, credits.ErrSongNotFound):
+		logger.Warn().Msg("Song not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song is not found"})
+	case errors.Is(err, credits.ErrPersonNotFound):
+		logger.Warn().Err(err).Msg("Person not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Person is not found"})
+	case errors.Is(err, credits.ErrCreditNotFound):
+		logger.Warn().Msg("Credit not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Credit is not found"})
+	case errors.Is(err, credits.ErrPersonCredited):
+		logger.Warn().Msg("Refusing to delete a credited person")
+		c.JSON(http.StatusConflict, gin.H{"error": "Person is credited on songs, remove the credits first"})
+	case errors.Is(err, credits.ErrInvalid):
+		logger.Warn().Err(err).Msg("Invalid credit")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logger.Error().Err(err).Msg("Credit query failed")
+		respondDBError(c, err)
+	}
+}
+
+// GetPeople returns people who can be credited on songs
+// @Summary List people
+// @Description Returns people by name; name narrows the list to names containing it
+// @Tags Credits
+// @Produce json
+// @Param name query string false "Part of the name"
+// @Param page query int false "Page number (default: 1)"
+// @Param limit query int false "Number of people per page (default: 10)"
+// @Success 200 {array} models.Person
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /people [get]
+func GetPeople(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+		if err != nil || limit < 1 {
+			limit = 10
+		}
+		limit = min(limit, cfg.MaxPageSize)
+
+		people, err := credits.ListPeople(c.Request.Context(), c.Query("name"), limit, (page-1)*limit)
+		if err != nil {
+			respondCreditError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, people)
+	}
+}
+
+// GetPerson returns a person
+// @Summary Get a person
+// @Tags Credits
+// @Produce json
+// @Param person_id path int true "Person ID"
+// @Success 200 {object} models.Person
+// @Failure 400 {object} map[string]string "Invalid person ID"
+// @Failure 404 {object} map[string]string "Person not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /people/{person_id} [get]
+func GetPerson(c *gin.Context) {
+	personID, ok := intParam(c, "person_id", "Invalid person ID")
+	if !ok {
+		return
+	}
+
+	p, err := credits.GetPerson(c.Request.Context(), personID)
+	if err != nil {
+		respondCreditError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// CreatePerson adds a person
+// @Summary Create a person
+// @Tags Credits
+// @Accept json
+// @Produce json
+// @Param person body object true "{\"name\": \"...\"}"
+// @Success 201 {object} models.Person
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /people [post]
+func CreatePerson(c *gin.Context) {
+	logger := logging.Ctx(c)
+	var input struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format, expected name"})
+		return
+	}
+
+	p, err := credits.CreatePerson(c.Request.Context(), input.Name)
+	if err != nil {
+		respondCreditError(c, err)
+		return
+	}
+	logger.Info().Int("person_id", p.ID).Msg("Person created")
+	c.JSON(http.StatusCreated, p)
+}
+
+// UpdatePerson renames a person
+// @Summary Rename a person
+// @Tags Credits
+// @Accept json
+// @Produce json
+// @Param person_id path int true "Person ID"
+// @Param person body object true "{\"name\": \"...\"}"
+// @Success 200 {object} models.Person
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 404 {object} map[string]string "Person not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /people/{person_id} [patch]
+func UpdatePerson(c *gin.Context) {
+	logger := logging.Ctx(c)
+	personID, ok := intParam(c, "person_id", "Invalid person ID")
+	if !ok {
+		return
+	}
+	var input struct {
+		Name string `json:"name" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format, expected name"})
+		return
+	}
+
+	p, err := credits.RenamePerson(c.Request.Context(), personID, input.Name)
+	if err != nil {
+		respondCreditError(c, err)
+		return
+	}
+	logger.Info().Int("person_id", personID).Msg("Person renamed")
+	c.JSON(http.StatusOK, p)
+}
+
+// DeletePerson removes a person
+// @Summary Delete a person
+// @Description Deletes a person who is not credited on any song
+// @Tags Credits
+// @Param person_id path int true "Person ID"
+// @Success 200 {object} map[string]string "Person deleted"
+// @Failure 400 {object} map[string]string "Invalid person ID"
+// @Failure 404 {object} map[string]string "Person not found"
+// @Failure 409 {object} map[string]string "Person is credited on songs"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /people/{person_id} [delete]
+func DeletePerson(c *gin.Context) {
+	personID, ok := intParam(c, "person_id", "Invalid person ID")
+	if !ok {
+		return
+	}
+
+	if err := credits.DeletePerson(c.Request.Context(), personID); err != nil {
+		respondCreditError(c, err)
+		return
+	}
+	logging.Ctx(c).Info().Int("person_id", personID).Msg("Person deleted")
+	c.JSON(http.StatusOK, gin.H{"message": "Person was deleted"})
+}
+
+// GetPersonSongs returns the songs a person is credited on
+// @Summary List a person's songs
+// @Description Returns the songs a person is credited on with the person's roles on each; role narrows the list to one role
+// @Tags Credits
+// @Produce json
+// @Param person_id path int true "Person ID"
+// @Param role query string false "Credit role: writer, composer, lyricist, producer or featured"
+// @Param page query int false "Page number (default: 1)"
+// @Param limit query int false "Number of songs per page (default: 10)"
+// @Success 200 {array} models.CreditedSong
+// @Failure 400 {object} map[string]string "Invalid person ID or role"
+// @Failure 404 {object} map[string]string "Person not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /people/{person_id}/songs [get]
+func GetPersonSongs(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		personID, ok := intParam(c, "person_id", "Invalid person ID")
+		if !ok {
+			return
+		}
+		role := c.Query("role")
+		if role != "" {
+			var err error
+			if role, err = credits.CheckRole(role); err != nil {
+				respondCreditError(c, err)
+				return
+			}
+		}
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+		if err != nil || limit < 1 {
+			limit = 10
+		}
+		limit = min(limit, cfg.MaxPageSize)
+
+		songs, err := credits.PersonSongs(c.Request.Context(), personID, role, limit, (page-1)*limit)
+		if err != nil {
+			respondCreditError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, songs)
+	}
+}
+
+// GetSongCredits returns the credits of a song
+// @Summary List the credits of a song
+// @Description Returns the people credited on a song by role (writer, composer, lyricist, producer, featured) and in the order they were given
+// @Tags Credits
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Success 200 {array} models.Credit
+// @Failure 400 {object} map[string]string "Invalid song ID"
+// @Failure 404 {object} map[string]string "Song not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/credits [get]
+func GetSongCredits(c *gin.Context) {
+	songID, ok := intParam(c, "song_id", "Invalid song ID")
+	if !ok {
+		return
+	}
+
+	list, err := credits.SongCredits(c.Request.Context(), songID)
+	if err != nil {
+		respondCreditError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// SetSongCredits replaces the credits of a song
+// @Summary Replace the credits of a song
+// @Description Sets the credits of a song; within a role people are listed in the given order
+// @Tags Credits
+// @Accept json
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param credits body object true "{\"credits\": [{\"personId\": 1, \"role\": \"writer\"}]}"
+// @Success 200 {array} models.Credit
+// @Failure 400 {object} map[string]string "Invalid role"
+// @Failure 404 {object} map[string]string "Song or person not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/credits [put]
+func SetSongCredits(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, ok := intParam(c, "song_id", "Invalid song ID")
+	if !ok {
+		return
+	}
+	var input struct {
+		Credits []models.Credit `json:"credits" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format, expected credits"})
+		return
+	}
+
+	list, err := credits.SetSongCredits(c.Request.Context(), songID, input.Credits)
+	if err != nil {
+		respondCreditError(c, err)
+		return
+	}
+	logger.Info().Int("song_id", songID).Int("count", len(list)).Msg("Song credits replaced")
+	c.JSON(http.StatusOK, list)
+}
+
+// AddSongCredit credits a person on a song
+// @Summary Credit a person on a song
+// @Description Credits a person on a song in a role after the people already credited in it; an existing credit is kept
+// @Tags Credits
+// @Accept json
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param credit body object true "{\"personId\": 1, \"role\": \"writer\"}"
+// @Success 200 {object} models.Credit
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 404 {object} map[string]string "Song or person not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/credits [post]
+func AddSongCredit(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, ok := intParam(c, "song_id", "Invalid song ID")
+	if !ok {
+		return
+	}
+	var input struct {
+		PersonID int    `json:"personId" binding:"required,min=1"`
+		Role     string `json:"role" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format, expected personId and role"})
+		return
+	}
+
+	credit, err := credits.AddCredit(c.Request.Context(), songID, input.PersonID, input.Role)
+	if err != nil {
+		respondCreditError(c, err)
+		return
+	}
+	logger.Info().Int("song_id", songID).Int("person_id", credit.PersonID).Str("role", credit.Role).Msg("Song credit added")
+	c.JSON(http.StatusOK, credit)
+}
+
+// RemoveSongCredit removes a person's credits from a song
+// @Summary Remove a song credit
+// @Description Removes the credit of a person on a song in role, or in every role without it
+// @Tags Credits
+// @Param song_id path int true "Song ID"
+// @Param person_id path int true "Person ID"
+// @Param role query string false "Credit role"
+// @Success 200 {object} map[string]string "Credit removed"
+// @Failure 400 {object} map[string]string "Invalid ID or role"
+// @Failure 404 {object} map[string]string "Song or credit not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/credits/{person_id} [delete]
+func RemoveSongCredit(c *gin.Context) {
+	songID, ok := intParam(c, "song_id", "Invalid song ID")
+	if !ok {
+		return
+	}
+	personID, ok := intParam(c, "person_id", "Invalid person ID")
+	if !ok {
+		return
+	}
+
+	if err := credits.RemoveCredit(c.Request.Context(), songID, personID, c.Query("role")); err != nil {
+		respondCreditError(c, err)
+		return
+	}
+	logging.Ctx(c).Info().Int("song_id", songID).Int("person_id", personID).Msg("Song credit removed")
+	c.JSON(http.StatusOK, gin.H{"message": "Credit was removed"})
+}
@@ -0,0 +1,12 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"song_library/db"
+)
+
+// respondDBError answers a failed query with 499, 504 or 500 depending on why it failed
+func respondDBError(c *gin.Context, err error) {
+	status, message := db.HTTPStatus(c.Request.Context(), err)
+	c.JSON(status, gin.H{"error": message})
+}
@@ -0,0 +1,252 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"io"
+	"net/http"
+	"song_library/config"
+	"song_library/events"
+	"song_library/logging"
+	"song_library/models"
+	"song_library/websocket"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// eventBatch is the most events a stream reads from the log at once
+	eventBatch = 100
+	// eventWriteWait bounds every write to a stream, so that stalled clients are dropped
+	eventWriteWait = 10 * time.Second
+	// eventRetry is the reconnection delay suggested to EventSource clients, in milliseconds
+	eventRetry = 5000
+)
+
+// queryValues returns the values of a repeatable query parameter, also split on commas
+func queryValues(c *gin.Context, name string) []string {
+	var values []string
+	for _, value := range c.QueryArray(name) {
+		for _, v := range strings.Split(value, ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				values = append(values, v)
+			}
+		}
+	}
+	return values
+}
+
+// eventFilter parses the type and songId filters of an event stream
+func eventFilter(c *gin.Context) (events.Filter, bool) {
+	logger := logging.Ctx(c)
+	filter := events.Filter{Types: queryValues(c, "type")}
+	for _, value := range queryValues(c, "songId") {
+		songID, err := strconv.Atoi(value)
+		if err != nil {
+			logger.Warn().Err(err).Msg("Invalid song ID")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid song ID"})
+			return events.Filter{}, false
+		}
+		filter.SongIDs = append(filter.SongIDs, songID)
+	}
+	if err := filter.Validate(); err != nil {
+		logger.Warn().Err(err).Msg("Invalid event filter")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return events.Filter{}, false
+	}
+	return filter, true
+}
+
+// eventStream sends the events of the log that match a filter as they are committed
+type eventStream struct {
+	hub       *events.Hub
+	sub       *events.Subscription
+	filter    events.Filter
+	lastID    int64
+	heartbeat time.Duration
+	timeout   time.Duration
+	sent      int
+}
+
+// run sends the events after the last one sent, then waits for a wake-up or a heartbeat, until
+// gone is closed, the hub is closed or a write fails. The log is read again on every heartbeat,
+// in case a notification was lost.
+func (s *eventStream) run(ctx context.Context, gone <-chan struct{}, send func(models.SongEvent) error, keepalive func() error) error {
+	ticker := time.NewTicker(s.heartbeat)
+	defer ticker.Stop()
+	for {
+		queryCtx, cancel := context.WithTimeout(ctx, s.timeout)
+		batch, err := events.After(queryCtx, s.lastID, s.filter, eventBatch)
+		cancel()
+		if err != nil {
+			return err
+		}
+		for _, e := range batch {
+			if err := send(e); err != nil {
+				return err
+			}
+			s.lastID = e.ID
+			s.sent++
+		}
+		if len(batch) == eventBatch {
+			continue
+		}
+
+		select {
+		case <-gone:
+			return nil
+		case <-s.hub.Done():
+			return nil
+		case <-s.sub.C:
+		case <-ticker.C:
+			if err := keepalive(); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// StreamEvents streams song change events over Server-Sent Events or a WebSocket
+// @Summary Stream song events
+// @Description Streams song.created, song.updated and song.deleted events as they are committed on any replica, as Server-Sent Events (event type as the SSE event, its ID as the SSE id and the event as JSON data) or, when the request asks for a WebSocket upgrade, as one JSON text message per event. A stream starts after the event given by the Last-Event-ID header or the lastEventId parameter, replaying the missed events from the event log, or with the next event when neither is given. Keepalive comments or pings are sent every events.heartbeat. Clients that cannot set headers can pass their API key or token as access_token.
+// @Tags Events
+// @Produce text/event-stream
+// @Param Last-Event-ID header int false "Resume after this event"
+// @Param lastEventId query int false "Resume after this event, for clients that cannot set headers"
+// @Param type query []string false "Only events of these types" collectionFormat(multi) Enums(song.created, song.updated, song.deleted)
+// @Param songId query []int false "Only events of these songs" collectionFormat(multi)
+// @Param access_token query string false "API key or bearer token, for clients that cannot set headers"
+// @Success 200 {object} models.SongEvent "Stream of events"
+// @Failure 400 {object} map[string]string "Invalid filter or event ID"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /events [get]
+func StreamEvents(hub *events.Hub, cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logger := logging.Ctx(c)
+		filter, ok := eventFilter(c)
+		if !ok {
+			return
+		}
+		// Subscribe before looking for the last event, so that no later one goes unnoticed
+		s := &eventStream{hub: hub, sub: hub.Subscribe(), filter: filter, heartbeat: cfg.EventHeartbeat, timeout: cfg.HTTPRequestTimeout}
+		defer hub.Unsubscribe(s.sub)
+
+		lastEventID := c.GetHeader("Last-Event-ID")
+		if lastEventID == "" {
+			lastEventID = c.Query("lastEventId")
+		}
+		if lastEventID != "" {
+			var err error
+			if s.lastID, err = strconv.ParseInt(lastEventID, 10, 64); err != nil || s.lastID < 0 {
+				logger.Warn().Str("last_event_id", lastEventID).Msg("Invalid last event ID")
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid last event ID"})
+				return
+			}
+		} else {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), cfg.HTTPRequestTimeout)
+			latest, err := events.Latest(ctx)
+			cancel()
+			if err != nil {
+				logger.Error().Err(err).Msg("Error querying the last event")
+				respondDBError(c, err)
+				return
+			}
+			s.lastID = latest
+		}
+
+		transport := "sse"
+		if websocket.IsUpgrade(c.Request) {
+			transport = "websocket"
+		}
+		logger.Info().Str("transport", transport).Int64("last_event_id", s.lastID).Strs("types", filter.Types).
+			Ints("song_ids", filter.SongIDs).Int("streams", hub.Streams()).Msg("Event stream opened")
+		var err error
+		if transport == "websocket" {
+			err = streamWebSocket(c, s)
+		} else {
+			err = streamSSE(c, s)
+		}
+		event := logger.Info()
+		if err != nil && c.Request.Context().Err() == nil {
+			event = logger.Warn().Err(err)
+		}
+		event.Str("transport", transport).Int("sent", s.sent).Int64("last_event_id", s.lastID).Msg("Event stream closed")
+	}
+}
+
+func streamSSE(c *gin.Context, s *eventStream) error {
+	rc := http.NewResponseController(c.Writer)
+	write := func(message string) error {
+		// The server's write timeout is meant for requests; a stream only needs each write to finish
+		if err := rc.SetWriteDeadline(time.Now().Add(eventWriteWait)); err != nil && !errors.Is(err, http.ErrNotSupported) {
+			return err
+		}
+		if _, err := io.WriteString(c.Writer, message); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// Tells nginx not to buffer the stream
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+	if err := write(fmt.Sprintf("retry: %d\n\n", eventRetry)); err != nil {
+		return err
+	}
+
+	send := func(e models.SongEvent) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return write(fmt.Sprintf("id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data))
+	}
+	keepalive := func() error { return write(": keepalive\n\n") }
+	return s.run(c.Request.Context(), c.Request.Context().Done(), send, keepalive)
+}
+
+func streamWebSocket(c *gin.Context, s *eventStream) error {
+	conn, err := websocket.Upgrade(c.Writer, c.Request, eventWriteWait)
+	if err != nil {
+		return err
+	}
+	// The request context outlives a hijacked connection, so the stream ends when the reader does
+	gone := make(chan struct{})
+	go func() {
+		defer close(gone)
+		conn.ReadLoop()
+	}()
+
+	send := func(e models.SongEvent) error {
+		data, err := json.Marshal(e)
+		if err != nil {
+			return err
+		}
+		return conn.WriteText(data)
+	}
+	err = s.run(c.Request.Context(), gone, send, conn.Ping)
+	select {
+	case <-s.hub.Done():
+		conn.Close(websocket.CloseGoingAway, "Server is shutting down")
+	default:
+		if err != nil {
+			conn.Close(websocket.CloseInternalError, "Stream failed")
+		} else {
+			conn.Close(websocket.CloseNormal, "")
+		}
+	}
+	<-gone
+	return err
+}
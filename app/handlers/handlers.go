@@ -1,297 +1,746 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
-	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
 	"net/http"
+	"net/url"
 	"song_library/config"
+	"song_library/credits"
 	"song_library/db"
+	"song_library/events"
+	"song_library/identifiers"
+	"song_library/links"
+	"song_library/logging"
+	"song_library/lyrics"
+	"song_library/metadata"
+	"song_library/metrics"
 	"song_library/models"
+	"song_library/playlists"
+	"song_library/tags"
 	"strconv"
 	"strings"
+	"time"
+	"unicode/utf8"
 )
 
+// songColumns are the songs columns read by scanSong, in order
+const songColumns = `song_id, group_name, song_name, release_date, lyrics, link,
+	COALESCE(isrc, ''), COALESCE(iswc, ''), COALESCE(mb_recording_id::text, ''), COALESCE(mb_work_id::text, ''),
+	duration_seconds, bpm, COALESCE(musical_key, ''), explicit, ` + songLanguage
+
+// maxNameLength matches songs.group_name and songs.song_name
+const maxNameLength = 64
+
+// songLanguage is the language of the original lyrics of a song, empty when unknown
+const songLanguage = `COALESCE((
+		SELECT language FROM lyrics_languages
+		WHERE lyrics_languages.song_id = songs.song_id AND is_original AND language <> 'und'
+	), '')`
+
+// scanSong scans a row of songColumns
+func scanSong(scan func(dest ...any) error) (models.Song, error) {
+	var s models.Song
+	err := scan(&s.ID, &s.Group, &s.Song, &s.ReleaseDate, &s.Text, &s.Link,
+		&s.ISRC, &s.ISWC, &s.MusicBrainzRecordingID, &s.MusicBrainzWorkID,
+		&s.Duration, &s.BPM, &s.Key, &s.Explicit, &s.Language)
+	return s, err
+}
+
+// songSorts maps the fields GetSongs sorts by to their columns
+var songSorts = map[string]string{
+	"id":       "song_id",
+	"group":    "group_name",
+	"song":     "song_name",
+	"duration": "duration_seconds",
+	"bpm":      "bpm",
+	"key":      "musical_key",
+}
+
+// songOrder builds the ORDER BY list of a sort parameter such as "bpm,-duration"; a minus sorts in
+// descending order, songs without a value come last and ties are broken by ID
+func songOrder(sort string) (string, error) {
+	order := []string{}
+	for _, field := range strings.Split(sort, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		direction := "ASC"
+		if name, ok := strings.CutPrefix(field, "-"); ok {
+			field, direction = name, "DESC"
+		}
+		column, ok := songSorts[field]
+		if !ok {
+			return "", fmt.Errorf("cannot sort by %q, expected id, group, song, duration, bpm or key", field)
+		}
+		order = append(order, column+" "+direction+" NULLS LAST")
+	}
+	return strings.Join(append(order, "song_id"), ", "), nil
+}
+
+// parseFloat and parseInt parse the values of numeric query filters
+func parseFloat(s string) (any, error) { return strconv.ParseFloat(s, 64) }
+func parseInt(s string) (any, error)   { return strconv.Atoi(s) }
+
 // GetSongs returns a list of songs with filtering and pagination
 // @Summary Get a list of songs
-// @Description Returns a list of songs with optional filters by group name, song name, and release date
+// @Description Returns a list of songs with optional filters by group name, song name, release date, tags, credits and metadata, sorted by ID or the sort fields. A genre tag also matches its subgenres. With facets=true the response is an object with the songs and the number of matching songs per tag, grouped by tag type.
 // @Tags Songs
 // @Produce json
 // @Param group query string false "Group name"
 // @Param song query string false "Song name"
 // @Param releaseDate query string false "Release date (format: DD.MM.YYYY)"
+// @Param tag query []string false "Tag, type:slug or slug; repeat to require several" collectionFormat(multi)
+// @Param credit query []string false "Person ID, optionally role:person ID (writer:12); repeat to require several" collectionFormat(multi)
+// @Param missingCredit query string false "Only songs nobody is credited on in this role"
+// @Param bpmMin query number false "Minimum BPM"
+// @Param bpmMax query number false "Maximum BPM"
+// @Param durationMin query int false "Minimum duration in seconds"
+// @Param durationMax query int false "Maximum duration in seconds"
+// @Param key query []string false "Musical key such as F#m, or Camelot position such as 11A; repeat to allow several" collectionFormat(multi)
+// @Param explicit query bool false "Only explicit songs, or with false only clean ones"
+// @Param language query string false "ISO 639 code of the original lyrics"
+// @Param sort query string false "Comma-separated fields among id, group, song, duration, bpm and key, - for descending (default: id)"
+// @Param facets query bool false "Return {songs, facets} with counts per tag for the filter"
 // @Param page query int false "Page number (default: 1)"
-// @Param limit query int false "Number of songs per page (default: 5)"
+// @Param limit query int false "Number of songs per page (default: 10, capped at MAX_PAGE_SIZE)"
 // @Success 200 {array} models.Song
+// @Failure 400 {object} map[string]string "Invalid filter or sort"
 // @Failure 500 {object} map[string]string "Database error"
+// @Failure 504 {object} map[string]string "Database timeout"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /songs [get]
-func GetSongs(c *gin.Context) {
-	log.Debug().Msg("Processing GetSongs request")
-	filters := []string{}
-	args := []interface{}{}
-	i := 1
-
-	if group := c.Query("group"); group != "" {
-		filters = append(filters, fmt.Sprintf("group_name ILIKE $%d", i))
-		args = append(args, "%"+group+"%")
-		i++
-	}
-	if song := c.Query("song"); song != "" {
-		filters = append(filters, fmt.Sprintf("song_name ILIKE $%d", i))
-		args = append(args, "%"+song+"%")
-		i++
-	}
-	if releaseDate := c.Query("releaseDate"); releaseDate != "" {
-		filters = append(filters, fmt.Sprintf("release_date = $%d", i))
-		args = append(args, releaseDate)
-		i++
-	}
+func GetSongs(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logger := logging.Ctx(c)
+		logger.Debug().Msg("Processing GetSongs request")
+		filters := []string{}
+		args := []interface{}{}
+		i := 1
 
-	query := "SELECT song_id, group_name, song_name, release_date, lyrics, link FROM songs"
-	if len(filters) > 0 {
-		query += " WHERE " + strings.Join(filters, " AND ")
-	}
+		if group := c.Query("group"); group != "" {
+			filters = append(filters, fmt.Sprintf("group_name ILIKE $%d", i))
+			args = append(args, "%"+group+"%")
+			i++
+		}
+		if song := c.Query("song"); song != "" {
+			filters = append(filters, fmt.Sprintf("song_name ILIKE $%d", i))
+			args = append(args, "%"+song+"%")
+			i++
+		}
+		if releaseDate := c.Query("releaseDate"); releaseDate != "" {
+			filters = append(filters, fmt.Sprintf("release_date = $%d", i))
+			args = append(args, releaseDate)
+			i++
+		}
 
-	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
-	limit, _ := strconv.Atoi(c.DefaultQuery("limit", "10"))
-	offset := (page - 1) * limit
-	query += fmt.Sprintf(" ORDER BY song_id LIMIT %d OFFSET %d", limit, offset)
+		for _, raw := range c.QueryArray("tag") {
+			ref, err := tags.ParseRef(raw)
+			if err != nil {
+				respondTagError(c, err)
+				return
+			}
+			condition, tagArgs := tags.Condition(ref, i)
+			filters = append(filters, condition)
+			args = append(args, tagArgs...)
+			i += len(tagArgs)
+		}
+		for _, raw := range c.QueryArray("credit") {
+			filter, err := credits.ParseFilter(raw)
+			if err != nil {
+				respondCreditError(c, err)
+				return
+			}
+			condition, creditArgs := credits.Condition(filter, i)
+			filters = append(filters, condition)
+			args = append(args, creditArgs...)
+			i += len(creditArgs)
+		}
+		if raw := c.Query("missingCredit"); raw != "" {
+			role, err := credits.CheckRole(raw)
+			if err != nil {
+				respondCreditError(c, err)
+				return
+			}
+			condition, creditArgs := credits.MissingCondition(role, i)
+			filters = append(filters, condition)
+			args = append(args, creditArgs...)
+			i += len(creditArgs)
+		}
 
-	log.Debug().Msgf("Executing database query: %s with args %v", query, args)
+		ranges := []struct {
+			param, condition string
+			parse            func(string) (any, error)
+		}{
+			{"bpmMin", "bpm >= $%d", parseFloat},
+			{"bpmMax", "bpm <= $%d", parseFloat},
+			{"durationMin", "duration_seconds >= $%d", parseInt},
+			{"durationMax", "duration_seconds <= $%d", parseInt},
+		}
+		for _, r := range ranges {
+			raw := c.Query(r.param)
+			if raw == "" {
+				continue
+			}
+			value, err := r.parse(raw)
+			if err != nil {
+				logger.Warn().Err(err).Str("param", r.param).Msg("Invalid range filter")
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid " + r.param + ", expected a number"})
+				return
+			}
+			filters = append(filters, fmt.Sprintf(r.condition, i))
+			args = append(args, value)
+			i++
+		}
+		if raws := c.QueryArray("key"); len(raws) > 0 {
+			keys := make([]string, len(raws))
+			for n, raw := range raws {
+				key, err := metadata.NormalizeKey(raw)
+				if err != nil {
+					logger.Warn().Err(err).Msg("Invalid key filter")
+					c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+					return
+				}
+				keys[n] = key
+			}
+			filters = append(filters, fmt.Sprintf("musical_key = ANY($%d)", i))
+			args = append(args, keys)
+			i++
+		}
+		if raw := c.Query("explicit"); raw != "" {
+			explicit, err := strconv.ParseBool(raw)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid explicit, expected true or false"})
+				return
+			}
+			filters = append(filters, fmt.Sprintf("explicit = $%d", i))
+			args = append(args, explicit)
+			i++
+		}
+		if raw := c.Query("language"); raw != "" {
+			language, ok := lyrics.NormalizeLanguage(raw)
+			if !ok {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid language, expected an ISO 639 code"})
+				return
+			}
+			filters = append(filters, fmt.Sprintf(`EXISTS (
+				SELECT 1 FROM lyrics_languages
+				WHERE lyrics_languages.song_id = songs.song_id AND is_original AND language = $%d
+			)`, i))
+			args = append(args, language)
+			i++
+		}
+		order, err := songOrder(c.Query("sort"))
+		if err != nil {
+			logger.Warn().Err(err).Msg("Invalid sort")
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		withFacets, _ := strconv.ParseBool(c.Query("facets"))
 
-	rows, err := db.Db.Query(query, args...)
-	if err != nil {
-		log.Error().Err(err).Msg("Database request error")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		return
-	}
-	defer rows.Close()
+		where := ""
+		if len(filters) > 0 {
+			where = " WHERE " + strings.Join(filters, " AND ")
+		}
+		query := "SELECT " + songColumns + " FROM songs" + where
 
-	var songs []models.Song
-	for rows.Next() {
-		var s models.Song
-		err := rows.Scan(&s.ID, &s.Group, &s.Song, &s.ReleaseDate, &s.Text, &s.Link)
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+		if err != nil || limit < 1 {
+			limit = 10
+		}
+		if limit > cfg.MaxPageSize {
+			logger.Debug().Int("limit", limit).Int("max_page_size", cfg.MaxPageSize).Msg("Limit exceeds maximum page size")
+			limit = cfg.MaxPageSize
+		}
+		offset := (page - 1) * limit
+		// Bound as parameters so every page shares one cached prepared statement
+		query += fmt.Sprintf(" ORDER BY %s LIMIT $%d OFFSET $%d", order, i, i+1)
+		args = append(args, limit, offset)
+
+		logger.Debug().Str("query", query).Int("arg_count", len(args)).Msg("Executing database query")
+
+		rows, err := db.Reader().QueryContext(c.Request.Context(), query, args...)
 		if err != nil {
-			log.Error().Err(err).Msg("Error scanning song row")
-			continue
+			logger.Error().Err(err).Msg("Database request error")
+			respondDBError(c, err)
+			return
+		}
+		defer rows.Close()
+
+		var songs []models.Song
+		for rows.Next() {
+			s, err := scanSong(rows.Scan)
+			if err != nil {
+				logger.Error().Err(err).Msg("Error scanning song row")
+				continue
+			}
+			songs = append(songs, s)
+		}
+		if err := rows.Err(); err != nil {
+			logger.Error().Err(err).Msg("Error reading song rows")
+			respondDBError(c, err)
+			return
+		}
+		logger.Info().Int("count", len(songs)).Msg("Found songs")
+		if !withFacets {
+			c.JSON(http.StatusOK, songs)
+			return
+		}
+
+		facets, err := tags.Facets(c.Request.Context(), where, args[:len(args)-2])
+		if err != nil {
+			logger.Error().Err(err).Msg("Error counting tag facets")
+			respondDBError(c, err)
+			return
+		}
+		if songs == nil {
+			songs = []models.Song{}
 		}
-		songs = append(songs, s)
+		c.JSON(http.StatusOK, models.SongsWithFacets{Songs: songs, Facets: facets})
 	}
-	log.Info().Msgf("Found %d songs", len(songs))
-	c.JSON(http.StatusOK, songs)
 }
 
-// GetSongLyrics returns song lyrics with pagination by verses
+// GetSongLyrics returns song lyrics with pagination by sections
 // @Summary Get song lyrics
-// @Description Returns the lyrics of a song, split into verses, with pagination support
+// @Description Returns the lyrics of a song as ordered sections (verse, chorus, bridge, intro, outro) with the total count. Without page and limit all sections are returned. The language is lang if the song has it, else the best match of Accept-Language, else the original.
 // @Tags Lyrics
 // @Produce json
 // @Param song_id path int true "Song ID"
+// @Param lang query string false "ISO 639 language code"
+// @Param Accept-Language header string false "Preferred languages"
 // @Param page query int false "Page number (default: 1)"
-// @Param limit query int false "Number of verses per page (default: 1)"
-// @Success 200 {array} string
+// @Param limit query int false "Number of sections per page (default: 1)"
+// @Success 200 {object} models.LyricsPage
 // @Failure 400 {object} map[string]string "Invalid song ID"
 // @Failure 404 {object} map[string]string "Song not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 504 {object} map[string]string "Database timeout"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /songs/lyrics/{song_id} [get]
 func GetSongLyrics(c *gin.Context) {
-	log.Debug().Msg("Processing GetSongLyrics request")
+	logger := logging.Ctx(c)
+	logger.Debug().Msg("Processing GetSongLyrics request")
 	songID, err := strconv.Atoi(c.Param("song_id"))
 	if err != nil {
-		log.Error().Err(err).Msg("Invalid song ID")
+		logger.Error().Err(err).Msg("Invalid song ID")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid song ID"})
 		return
 	}
 
-	var lyrics string
-	err = db.Db.QueryRow("SELECT lyrics FROM songs WHERE song_id = $1", songID).Scan(&lyrics)
-	if err != nil {
-		log.Error().Err(err).Msgf("Song with ID %d not found", songID)
+	sections, lang, err := lyrics.LoadPreferred(c.Request.Context(), songID, c.Query("lang"), c.GetHeader("Accept-Language"))
+	if errors.Is(err, lyrics.ErrSongNotFound) {
+		logger.Warn().Int("song_id", songID).Msg("Song not found")
 		c.JSON(http.StatusNotFound, gin.H{"error": "Song is not found"})
 		return
+	} else if err != nil {
+		logger.Error().Err(err).Int("song_id", songID).Msg("Error loading lyrics")
+		respondDBError(c, err)
+		return
 	}
 
-	verses := strings.Split(lyrics, "\n\n")
-	if len(verses) == 1 {
-		verses = strings.Split(lyrics, "\n")
+	result := models.LyricsPage{
+		SongID: songID, Language: lang.Language, Original: lang.Original, Translator: lang.Translator,
+		Page: 1, Limit: len(sections), Total: len(sections), Sections: sections,
 	}
-
 	pageStr := c.Query("page")
 	limitStr := c.Query("limit")
+	if pageStr != "" || limitStr != "" {
+		page, err := strconv.Atoi(pageStr)
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(limitStr)
+		if err != nil || limit < 1 {
+			limit = 1
+		}
 
-	if pageStr == "" && limitStr == "" {
-		c.JSON(http.StatusOK, verses)
-		return
-	}
-
-	page, err := strconv.Atoi(pageStr)
-	if err != nil || page < 1 {
-		page = 1
-	}
-	limit, err := strconv.Atoi(limitStr)
-	if err != nil || limit < 1 {
-		limit = 1
-	}
-
-	start := (page - 1) * limit
-	end := start + limit
-
-	if start >= len(verses) {
-		c.JSON(http.StatusOK, []string{})
-		return
-	}
-
-	if end > len(verses) {
-		end = len(verses)
+		start := min((page-1)*limit, len(sections))
+		end := min(start+limit, len(sections))
+		result.Page, result.Limit, result.Sections = page, limit, sections[start:end]
 	}
 
-	log.Info().Msgf("Returning lyrics for song ID %d", songID)
-	c.JSON(http.StatusOK, verses[start:end])
+	logger.Info().Int("song_id", songID).Str("language", lang.Language).Int("total", result.Total).Msg("Returning lyrics")
+	c.Header("Content-Language", lang.Language)
+	c.Writer.Header().Add("Vary", "Accept-Language")
+	c.JSON(http.StatusOK, result)
 }
 
 // DeleteSong removes a song by ID
 // @Summary Delete a song
-// @Description Deletes a song by the specified ID
+// @Description Deletes a song by the specified ID and removes it from every playlist
 // @Tags Songs
 // @Param song_id path int true "Song ID"
 // @Success 200 {object} map[string]string "Song deleted successfully"
 // @Failure 400 {object} map[string]string "Invalid song ID"
 // @Failure 404 {object} map[string]string "Song not found"
 // @Failure 500 {object} map[string]string "Database error"
+// @Failure 504 {object} map[string]string "Database timeout"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /songs/{song_id} [delete]
 func DeleteSong(c *gin.Context) {
-	log.Debug().Msg("Processing DeleteSong request")
+	logger := logging.Ctx(c)
+	logger.Debug().Msg("Processing DeleteSong request")
 	songID, err := strconv.Atoi(c.Param("song_id"))
 	if err != nil {
-		log.Error().Err(err).Msg("Invalid song ID")
+		logger.Error().Err(err).Msg("Invalid song ID")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid song ID"})
 		return
 	}
 
-	result, err := db.Db.Exec("DELETE FROM songs WHERE song_id = $1", songID)
+	var count int64
+	var inPlaylists int
+	err = db.InTx(c.Request.Context(), func(tx *sql.Tx) error {
+		// Playlists first, in the order playlists.AddItem locks them and their songs
+		if inPlaylists, err = playlists.RemoveSong(c.Request.Context(), tx, songID); err != nil {
+			return err
+		}
+		if err := events.Emit(c.Request.Context(), tx, models.EventSongDeleted, songID); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(c.Request.Context(), "DELETE FROM songs WHERE song_id = $1", songID)
+		if err != nil {
+			return err
+		}
+		count, err = result.RowsAffected()
+		return err
+	})
 	if err != nil {
-		log.Error().Err(err).Msg("Error deleting song")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+		logger.Error().Err(err).Msg("Error deleting song")
+		respondDBError(c, err)
 		return
 	}
-	count, _ := result.RowsAffected()
 	if count == 0 {
-		log.Warn().Msgf("Song with ID %d not found", songID)
+		logger.Warn().Int("song_id", songID).Msg("Song not found")
 		c.JSON(http.StatusNotFound, gin.H{"error": "Song is not found"})
 		return
 	}
 
-	log.Info().Msgf("Song with ID %d deleted", songID)
+	logger.Info().Int("song_id", songID).Int("playlists", inPlaylists).Msg("Song deleted")
 	c.JSON(http.StatusOK, gin.H{"message": "Song was deleted"})
 }
 
 // UpdateSong updates song details
 // @Summary Update a song
-// @Description Updates song details by its ID
+// @Description Updates song details by its ID. ISRC, ISWC and MusicBrainz IDs are validated; identifiers and metadata left out are kept. The language is that of the lyrics sent.
 // @Tags Songs
 // @Accept json
 // @Produce json
 // @Param song_id path int true "Song ID"
 // @Param song body models.Song true "Updated song details"
 // @Success 200 {object} map[string]string "Song updated successfully"
-// @Failure 400 {object} map[string]string "Invalid data format"
+// @Failure 400 {object} map[string]string "Invalid data format, identifier or metadata"
 // @Failure 404 {object} map[string]string "Song not found"
+// @Failure 409 {object} map[string]string "Another song has the ISRC or recording ID, or the song has a translation into the language"
 // @Failure 500 {object} map[string]string "Database error"
+// @Failure 504 {object} map[string]string "Database timeout"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /songs/{song_id} [put]
 func UpdateSong(c *gin.Context) {
-	log.Debug().Msg("Processing UpdateSong request")
+	logger := logging.Ctx(c)
+	logger.Debug().Msg("Processing UpdateSong request")
 	songID, err := strconv.Atoi(c.Param("song_id"))
 	if err != nil {
-		log.Error().Err(err).Msg("Invalid song ID")
+		logger.Error().Err(err).Msg("Invalid song ID")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid song ID"})
 		return
 	}
 
 	var s models.Song
 	if err := c.ShouldBindJSON(&s); err != nil {
-		log.Error().Err(err).Msg("Error binding JSON")
+		logger.Error().Err(err).Msg("Error binding JSON")
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
 		return
 	}
+	if err := identifiers.Normalize(&s.ISRC, &s.ISWC, &s.MusicBrainzRecordingID, &s.MusicBrainzWorkID); err != nil {
+		logger.Warn().Err(err).Msg("Invalid song identifier")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	if err := metadata.Normalize(&s.SongMetadata); err != nil {
+		logger.Warn().Err(err).Msg("Invalid song metadata")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+	// Identifiers and metadata left out of the body are kept
 	query := `
 		UPDATE songs 
-		SET group_name = $1, song_name = $2, release_date = $3, lyrics = $4, link = $5
-		WHERE song_id = $6
+		SET group_name = $1, song_name = $2, release_date = $3, link = $4,
+			isrc = COALESCE(NULLIF($6, ''), isrc), iswc = COALESCE(NULLIF($7, ''), iswc),
+			mb_recording_id = COALESCE(NULLIF($8, '')::uuid, mb_recording_id),
+			mb_work_id = COALESCE(NULLIF($9, '')::uuid, mb_work_id),
+			duration_seconds = COALESCE($10, duration_seconds), bpm = COALESCE($11, bpm),
+			musical_key = COALESCE(NULLIF($12, ''), musical_key), explicit = COALESCE($13, explicit)
+		WHERE song_id = $5
 	`
-	result, err := db.Db.Exec(query, s.Group, s.Song, s.ReleaseDate, s.Text, s.Link, songID)
-	affectedRows, _ := result.RowsAffected()
-	if err != nil {
-		log.Error().Err(err).Msg("Error updating song")
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
-		return
-	} else if affectedRows == 0 {
-		log.Warn().Msgf("Song with ID %d not found", songID)
+	err = db.InTx(c.Request.Context(), func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(c.Request.Context(), query, s.Group, s.Song, s.ReleaseDate, s.Link, songID,
+			s.ISRC, s.ISWC, s.MusicBrainzRecordingID, s.MusicBrainzWorkID,
+			s.Duration, s.BPM, s.Key, s.Explicit)
+		if err != nil {
+			return err
+		}
+		if affectedRows, _ := result.RowsAffected(); affectedRows == 0 {
+			return lyrics.ErrSongNotFound
+		}
+		// The plain text replaces all sections; lyrics.Replace also writes the normalized text back
+		if _, err := lyrics.Replace(c.Request.Context(), tx, songID, lyrics.Parse(s.Text)); err != nil {
+			return err
+		}
+		if s.Language != "" {
+			if err := lyrics.Relabel(c.Request.Context(), tx, songID, s.Language); err != nil {
+				return err
+			}
+		}
+		if err := links.Save(c.Request.Context(), tx, songID, s.Link); err != nil {
+			return err
+		}
+		return events.Emit(c.Request.Context(), tx, models.EventSongUpdated, songID)
+	})
+	if errors.Is(err, lyrics.ErrSongNotFound) {
+		logger.Warn().Int("song_id", songID).Msg("Song not found")
 		c.JSON(http.StatusNotFound, gin.H{"error": "Song is not found"})
 		return
+	} else if errors.Is(err, lyrics.ErrLanguageConflict) {
+		logger.Warn().Int("song_id", songID).Str("language", s.Language).Msg("Song has a translation into the language")
+		c.JSON(http.StatusConflict, gin.H{"error": languageConflictMessage})
+		return
+	} else if err != nil {
+		logger.Error().Err(err).Msg("Error updating song")
+		respondDBError(c, err)
+		return
 	}
 
-	log.Info().Msgf("Song with ID %d updated", songID)
+	logger.Info().Int("song_id", songID).Msg("Song updated")
 	c.JSON(http.StatusOK, gin.H{"message": "Song was updated"})
 }
 
+// respondExternalError answers a failed outbound call with 499 or 504 when the request was cancelled
+// or ran out of time, and 400 otherwise
+func respondExternalError(c *gin.Context) {
+	switch ctx := c.Request.Context(); {
+	case errors.Is(ctx.Err(), context.Canceled):
+		c.JSON(db.StatusClientClosedRequest, gin.H{"error": "Client closed request"})
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		c.JSON(http.StatusGatewayTimeout, gin.H{"error": "External API timeout"})
+	default:
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Couldn't get song info"})
+	}
+}
+
+// externalClient propagates the W3C trace context to the enrichment API and records a span per call
+var externalClient = &http.Client{Transport: otelhttp.NewTransport(http.DefaultTransport)}
+
 // AddSong adds a new song using external API data
 // @Summary Add a song
-// @Description Adds a new song by fetching its details from an external API
+// @Description Adds a new song by fetching its details from an external API. Instead of group and song the request may carry an ISRC or a MusicBrainz recording ID, which are resolved through MusicBrainz; ISWC and MusicBrainz work ID may be given too, and so may the metadata: duration in seconds, bpm, key, explicit and the language of the lyrics.
 // @Tags Songs
 // @Accept json
 // @Produce json
-// @Param song body object true "Song data (group, song)"
+// @Param song body object true "Song data (group, song) or identifiers (isrc, musicbrainzRecordingId, iswc, musicbrainzWorkId), with optional metadata (duration, bpm, key, explicit, language)"
 // @Success 200 {object} map[string]int "Added song ID"
-// @Failure 400 {object} map[string]string "Invalid request or missing song information"
+// @Failure 400 {object} map[string]string "Invalid request, identifier, metadata or missing song information"
+// @Failure 404 {object} map[string]string "No recording has the identifier"
+// @Failure 409 {object} map[string]string "Song with the identifier exists"
+// @Failure 422 {object} map[string]string "Artist or title of the recording is too long"
 // @Failure 500 {object} map[string]string "Database error"
+// @Failure 504 {object} map[string]string "External API or database timeout"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
 // @Router /songs [post]
 func AddSong(cfg *config.Config) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		log.Debug().Msg("Processing AddSong request")
+		logger := logging.Ctx(c)
+		logger.Debug().Msg("Processing AddSong request")
 		var input struct {
-			Group string `json:"group" binding:"required"`
-			Song  string `json:"song" binding:"required"`
+			Group                  string `json:"group"`
+			Song                   string `json:"song"`
+			ISRC                   string `json:"isrc"`
+			ISWC                   string `json:"iswc"`
+			MusicBrainzRecordingID string `json:"musicbrainzRecordingId"`
+			MusicBrainzWorkID      string `json:"musicbrainzWorkId"`
+			models.SongMetadata
 		}
 		if err := c.ShouldBindJSON(&input); err != nil {
-			log.Error().Err(err).Msg("Error binding JSON")
+			logger.Error().Err(err).Msg("Error binding JSON")
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
 			return
 		}
+		if (input.Group == "") != (input.Song == "") || (input.Group == "" && input.ISRC == "" && input.MusicBrainzRecordingID == "") {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format, expected group and song, an ISRC or a MusicBrainz recording ID"})
+			return
+		}
+		if err := identifiers.Normalize(&input.ISRC, &input.ISWC, &input.MusicBrainzRecordingID, &input.MusicBrainzWorkID); err != nil {
+			logger.Warn().Err(err).Msg("Invalid song identifier")
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		if err := metadata.Normalize(&input.SongMetadata); err != nil {
+			logger.Warn().Err(err).Msg("Invalid song metadata")
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
 
-		groupEncoded := strings.ReplaceAll(input.Group, " ", "+")
-		songEncoded := strings.ReplaceAll(input.Song, " ", "+")
+		if utf8.RuneCountInString(input.Group) > maxNameLength || utf8.RuneCountInString(input.Song) > maxNameLength {
+			c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Group and song are limited to %d characters", maxNameLength)})
+			return
+		}
 
-		apiURL := fmt.Sprintf("%s/info?group=%s&song=%s", cfg.ExternalAPIURL, groupEncoded, songEncoded)
-		log.Info().Msgf("Calling external API: %s", apiURL)
-		resp, err := http.Get(apiURL)
-		if err != nil || resp.StatusCode != http.StatusOK {
-			log.Error().Err(err).Msg("External API call failed")
+		if input.Group == "" {
+			if cfg.MusicBrainzURL == "" {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Identifier lookup is disabled, send group and song"})
+				return
+			}
+			mb := &identifiers.MusicBrainz{BaseURL: cfg.MusicBrainzURL, UserAgent: cfg.MusicBrainzUserAgent, HTTP: externalClient}
+			var rec identifiers.Recording
+			var err error
+			if input.MusicBrainzRecordingID != "" {
+				rec, err = mb.Recording(c.Request.Context(), input.MusicBrainzRecordingID)
+			} else {
+				rec, err = mb.RecordingByISRC(c.Request.Context(), input.ISRC)
+			}
+			if errors.Is(err, identifiers.ErrRecordingNotFound) {
+				logger.Warn().Str("isrc", input.ISRC).Str("mb_recording_id", input.MusicBrainzRecordingID).Msg("Identifier not found in MusicBrainz")
+				c.JSON(http.StatusNotFound, gin.H{"error": "No recording has the identifier"})
+				return
+			} else if err != nil {
+				logger.Error().Err(err).Msg("MusicBrainz lookup failed")
+				respondExternalError(c)
+				return
+			}
+			// Credits of several artists easily run past the columns
+			if utf8.RuneCountInString(rec.Artist) > maxNameLength || utf8.RuneCountInString(rec.Title) > maxNameLength {
+				logger.Warn().Str("artist", rec.Artist).Str("title", rec.Title).Msg("Resolved names are too long")
+				c.JSON(http.StatusUnprocessableEntity, gin.H{
+					"error": fmt.Sprintf("The artist or title of the recording is longer than %d characters, send group and song instead", maxNameLength),
+				})
+				return
+			}
+			input.Group, input.Song = rec.Artist, rec.Title
+			input.MusicBrainzRecordingID = rec.ID
+			if input.ISRC == "" && len(rec.ISRCs) == 1 {
+				input.ISRC, _ = identifiers.NormalizeISRC(rec.ISRCs[0])
+			}
+			if input.MusicBrainzWorkID == "" {
+				input.MusicBrainzWorkID = rec.WorkID
+			}
+			if input.ISWC == "" && rec.ISWC != "" {
+				input.ISWC, _ = identifiers.NormalizeISWC(rec.ISWC)
+			}
+			logger.Info().Str("group", input.Group).Str("song", input.Song).Str("mb_recording_id", rec.ID).Msg("Resolved song identifier")
+		}
+
+		if input.ISRC != "" || input.MusicBrainzRecordingID != "" {
+			var existing int
+			err := db.Db.QueryRowContext(c.Request.Context(), `
+				SELECT song_id FROM songs WHERE isrc = NULLIF($1, '') OR mb_recording_id = NULLIF($2, '')::uuid LIMIT 1
+			`, input.ISRC, input.MusicBrainzRecordingID).Scan(&existing)
+			if err == nil {
+				logger.Warn().Int("song_id", existing).Msg("Song with the identifier exists")
+				c.JSON(http.StatusConflict, gin.H{"error": "Song with the identifier exists", "song_id": existing})
+				return
+			} else if !errors.Is(err, sql.ErrNoRows) {
+				logger.Error().Err(err).Msg("Error looking up song identifier")
+				respondDBError(c, err)
+				return
+			}
+		}
+
+		params := url.Values{"group": {input.Group}, "song": {input.Song}}
+		apiURL := cfg.ExternalAPIURL + "/info?" + params.Encode()
+		logger.Info().Str("url", apiURL).Msg("Calling external API")
+		req, err := http.NewRequestWithContext(c.Request.Context(), http.MethodGet, apiURL, nil)
+		if err != nil {
+			logger.Error().Err(err).Msg("Error building external API request")
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Couldn't get song info"})
 			return
 		}
+		start := time.Now()
+		resp, err := externalClient.Do(req)
+		outcome := metrics.ExternalCallOutcome(err, resp)
+		if outcome != metrics.OutcomeOK {
+			metrics.ObserveExternalCall(outcome, time.Since(start))
+			if resp != nil {
+				resp.Body.Close()
+			}
+			logger.Error().Err(err).Str("outcome", outcome).Msg("External API call failed")
+			respondExternalError(c)
+			return
+		}
 		defer resp.Body.Close()
 
 		var detail models.SongDetail
 		if err := json.NewDecoder(resp.Body).Decode(&detail); err != nil {
-			log.Error().Err(err).Msg("Error decoding external API response")
+			metrics.ObserveExternalCall(metrics.OutcomeDecodeError, time.Since(start))
+			logger.Error().Err(err).Msg("Error decoding external API response")
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Song info processing error"})
 			return
 		}
+		metrics.ObserveExternalCall(metrics.OutcomeOK, time.Since(start))
 
 		query := `
-			INSERT INTO songs (group_name, song_name, release_date, lyrics, link)
-			VALUES ($1, $2, $3, $4, $5)
+			INSERT INTO songs (group_name, song_name, release_date, lyrics, link, isrc, iswc, mb_recording_id, mb_work_id,
+				duration_seconds, bpm, musical_key, explicit)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), NULLIF($7, ''), NULLIF($8, '')::uuid, NULLIF($9, '')::uuid,
+				$10, $11, NULLIF($12, ''), COALESCE($13, FALSE))
 			RETURNING song_id
 		`
 		var songID int
-		err = db.Db.QueryRow(query, input.Group, input.Song, detail.ReleaseDate, detail.Text, detail.Link).Scan(&songID)
+		err = db.InTx(c.Request.Context(), func(tx *sql.Tx) error {
+			err := tx.QueryRowContext(c.Request.Context(), query, input.Group, input.Song, detail.ReleaseDate, detail.Text, detail.Link,
+				input.ISRC, input.ISWC, input.MusicBrainzRecordingID, input.MusicBrainzWorkID,
+				input.Duration, input.BPM, input.Key, input.Explicit).Scan(&songID)
+			if err != nil {
+				return err
+			}
+			if _, err := lyrics.Replace(c.Request.Context(), tx, songID, lyrics.Parse(detail.Text)); err != nil {
+				return err
+			}
+			if input.Language != "" {
+				if err := lyrics.Relabel(c.Request.Context(), tx, songID, input.Language); err != nil {
+					return err
+				}
+			}
+			if err := links.Save(c.Request.Context(), tx, songID, detail.Link); err != nil {
+				return err
+			}
+			return events.Emit(c.Request.Context(), tx, models.EventSongCreated, songID)
+		})
 		if err != nil {
-			log.Error().Err(err).Msg("Error inserting song into database")
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Database error"})
+			logger.Error().Err(err).Msg("Error inserting song into database")
+			respondDBError(c, err)
 			return
 		}
 
-		log.Info().Msgf("Song with ID %d added", songID)
+		logger.Info().Int("song_id", songID).Msg("Song added")
 		c.JSON(http.StatusOK, gin.H{"song_id": songID})
 	}
 }
@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"song_library/config"
+	"song_library/health"
+	"song_library/logging"
+)
+
+// Liveness reports that the process is running
+// @Summary Liveness probe
+// @Description Returns 200 while the process is alive, without checking dependencies
+// @Tags Health
+// @Produce json
+// @Success 200 {object} map[string]string "Process is alive"
+// @Router /healthz [get]
+func Liveness(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": health.StatusOK})
+}
+
+// Readiness reports whether the service can serve traffic
+// @Summary Readiness probe
+// @Description Checks the database connection, migration status and optionally the external API; fails while the server is shutting down
+// @Tags Health
+// @Produce json
+// @Success 200 {object} health.Report "Service is ready"
+// @Failure 503 {object} health.Report "Service is not ready"
+// @Router /readyz [get]
+func Readiness(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		report := health.Ready(c.Request.Context(), cfg)
+		if report.Status != health.StatusOK {
+			logging.Ctx(c).Warn().Interface("checks", report.Checks).Msg("Readiness check failed")
+			c.JSON(http.StatusServiceUnavailable, report)
+			return
+		}
+		c.JSON(http.StatusOK, report)
+	}
+}
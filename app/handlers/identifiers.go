@@ -0,0 +1,135 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"song_library/db"
+	"song_library/identifiers"
+	"song_library/logging"
+	"song_library/models"
+)
+
+// findSongs returns the songs matching an SQL condition on songs, by ID
+func findSongs(c *gin.Context, condition string, args ...any) ([]models.Song, error) {
+	rows, err := db.Reader().QueryContext(c.Request.Context(), "SELECT "+songColumns+" FROM songs WHERE "+condition+" ORDER BY song_id", args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	songs := []models.Song{}
+	for rows.Next() {
+		s, err := scanSong(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		songs = append(songs, s)
+	}
+	return songs, rows.Err()
+}
+
+// GetSongByISRC looks a song up by its ISRC
+// @Summary Find a song by ISRC
+// @Description Returns the song with an ISRC, written with or without hyphens
+// @Tags Songs
+// @Produce json
+// @Param isrc path string true "ISRC, e.g. US-RC1-76-07839"
+// @Success 200 {object} models.Song
+// @Failure 400 {object} map[string]string "Invalid ISRC"
+// @Failure 404 {object} map[string]string "Song not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/by-isrc/{isrc} [get]
+func GetSongByISRC(c *gin.Context) {
+	logger := logging.Ctx(c)
+	isrc, err := identifiers.NormalizeISRC(c.Param("isrc"))
+	if err != nil {
+		logger.Warn().Err(err).Msg("Invalid ISRC")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	s, err := scanSong(db.Reader().QueryRowContext(c.Request.Context(), "SELECT "+songColumns+" FROM songs WHERE isrc = $1", isrc).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		logger.Warn().Str("isrc", isrc).Msg("Song not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song is not found"})
+		return
+	} else if err != nil {
+		logger.Error().Err(err).Msg("Error looking up ISRC")
+		respondDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// GetSongsByISWC returns the recordings of a musical work
+// @Summary Find songs by ISWC
+// @Description Returns the songs that are recordings of the work with an ISWC, written with or without separators
+// @Tags Songs
+// @Produce json
+// @Param iswc path string true "ISWC, e.g. T-034.524.680-1"
+// @Success 200 {array} models.Song
+// @Failure 400 {object} map[string]string "Invalid ISWC"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/by-iswc/{iswc} [get]
+func GetSongsByISWC(c *gin.Context) {
+	logger := logging.Ctx(c)
+	iswc, err := identifiers.NormalizeISWC(c.Param("iswc"))
+	if err != nil {
+		logger.Warn().Err(err).Msg("Invalid ISWC")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	songs, err := findSongs(c, "iswc = $1", iswc)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error looking up ISWC")
+		respondDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, songs)
+}
+
+// GetSongsByMBID returns the songs with a MusicBrainz recording or work ID
+// @Summary Find songs by MusicBrainz ID
+// @Description Returns the song with a MusicBrainz recording ID, or the recordings of a MusicBrainz work
+// @Tags Songs
+// @Produce json
+// @Param mbid path string true "MusicBrainz recording or work ID"
+// @Success 200 {array} models.Song
+// @Failure 400 {object} map[string]string "Invalid MusicBrainz ID"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/by-mbid/{mbid} [get]
+func GetSongsByMBID(c *gin.Context) {
+	logger := logging.Ctx(c)
+	mbid, err := identifiers.NormalizeMBID(c.Param("mbid"))
+	if err != nil {
+		logger.Warn().Err(err).Msg("Invalid MusicBrainz ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	songs, err := findSongs(c, "mb_recording_id = $1::uuid OR mb_work_id = $1::uuid", mbid)
+	if err != nil {
+		logger.Error().Err(err).Msg("Error looking up MusicBrainz ID")
+		respondDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, songs)
+}
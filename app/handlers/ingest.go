@@ -0,0 +1,103 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"song_library/auth"
+	"song_library/ingest"
+	"song_library/logging"
+	"song_library/models"
+)
+
+// IngestSongs creates songs from the tags of uploaded audio files
+// @Summary Ingest audio files
+// @Description Reads the ID3v2 or Vorbis comment tags of MP3 and FLAC files (title, artist, album, date, ISRC, BPM, key and embedded lyrics) and records each file against the song they describe. A song with the same ISRC, or the same group and song ignoring case, gets the values it lacks; otherwise a song is created. A file whose content, or whose audio without tags, was ingested before is reported as a duplicate. Each file is reported separately, with status created, matched, duplicate or failed.
+// @Tags Songs
+// @Accept multipart/form-data
+// @Produce json
+// @Param file formData file true "MP3 or FLAC file; repeat the field for several files"
+// @Success 200 {array} models.IngestResult
+// @Failure 400 {object} map[string]string "No files"
+// @Failure 413 {object} map[string]string "Request body is too large"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/ingest [post]
+func IngestSongs(c *gin.Context) {
+	logger := logging.Ctx(c)
+	form, err := c.MultipartForm()
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		logger.Warn().Int64("limit", tooLarge.Limit).Msg("Ingestion request too large")
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+		return
+	}
+	if err != nil || len(form.File["file"]) == 0 {
+		logger.Warn().Err(err).Msg("No files to ingest")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Expected a multipart form with one or more files"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	results := make([]models.IngestResult, 0, len(form.File["file"]))
+	for _, header := range form.File["file"] {
+		result := models.IngestResult{File: header.Filename, Status: models.IngestFailed}
+		if ctx.Err() != nil {
+			result.Error = "Request timed out before the file was ingested"
+			results = append(results, result)
+			continue
+		}
+		file, err := header.Open()
+		if err == nil {
+			result, err = ingest.Ingest(ctx, header.Filename, file, header.Size, auth.SubjectFrom(c))
+			file.Close()
+		}
+		if err != nil {
+			logger.Error().Err(err).Str("file", header.Filename).Msg("Error ingesting file")
+			result.Error = "Couldn't record the file"
+		}
+		logger.Info().Str("file", header.Filename).Str("status", result.Status).Int("song_id", result.SongID).
+			Str("sha256", result.SHA256).Msg("Audio file ingested")
+		results = append(results, result)
+	}
+	c.JSON(http.StatusOK, results)
+}
+
+// GetSongFiles returns the audio files ingested for a song
+// @Summary List song audio files
+// @Description Returns the audio files ingested for a song with their format, duration, sample rate, bitrate, album tag and hashes
+// @Tags Songs
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Success 200 {array} models.SongFile
+// @Failure 400 {object} map[string]string "Invalid song ID"
+// @Failure 404 {object} map[string]string "Song not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/files [get]
+func GetSongFiles(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, ok := intParam(c, "song_id", "Invalid song ID")
+	if !ok {
+		return
+	}
+
+	files, err := ingest.Files(c.Request.Context(), songID)
+	if errors.Is(err, ingest.ErrSongNotFound) {
+		logger.Warn().Int("song_id", songID).Msg("Song not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song is not found"})
+		return
+	} else if err != nil {
+		logger.Error().Err(err).Msg("Error querying song files")
+		respondDBError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, files)
+}
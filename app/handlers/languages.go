@@ -0,0 +1,242 @@
+package handlers
+
+import (
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"song_library/logging"
+	"song_library/lyrics"
+	"song_library/models"
+	"strconv"
+)
+
+// languageParams parses the song ID and language code of a language route
+func languageParams(c *gin.Context) (int, string, bool) {
+	songID, err := strconv.Atoi(c.Param("song_id"))
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Invalid song ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid song ID"})
+		return 0, "", false
+	}
+	language, ok := lyrics.NormalizeLanguage(c.Param("lang"))
+	if !ok {
+		logging.Ctx(c).Warn().Str("lang", c.Param("lang")).Msg("Invalid language code")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid language code, expected ISO 639"})
+		return 0, "", false
+	}
+	return songID, language, true
+}
+
+// ListLyricsLanguages returns the languages a song's lyrics are available in
+// @Summary List lyrics languages
+// @Description Returns the original language and the translations of a song's lyrics, original first
+// @Tags Lyrics
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Success 200 {array} models.LyricsLanguage
+// @Failure 400 {object} map[string]string "Invalid song ID"
+// @Failure 404 {object} map[string]string "Song not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/lyrics/languages [get]
+func ListLyricsLanguages(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, err := strconv.Atoi(c.Param("song_id"))
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid song ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid song ID"})
+		return
+	}
+
+	langs, err := lyrics.Languages(c.Request.Context(), songID)
+	if err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, langs)
+}
+
+// PutLyricsLanguage stores the lyrics of a song in one language
+// @Summary Store lyrics in a language
+// @Description Replaces the lyrics of a song in an ISO 639 language with sections or plain text. A language other than the original is stored as a translation with the given translator; the first lyrics of a song become its original.
+// @Tags Lyrics
+// @Accept json
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param lang path string true "ISO 639 language code"
+// @Param lyrics body object true "{\"sections\": [...]} or {\"text\": \"...\"}, with an optional \"translator\""
+// @Success 200 {object} models.LyricsPage
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 404 {object} map[string]string "Song not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/lyrics/languages/{lang} [put]
+func PutLyricsLanguage(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, language, ok := languageParams(c)
+	if !ok {
+		return
+	}
+
+	var input struct {
+		Sections   []models.LyricsSection `json:"sections"`
+		Text       *string                `json:"text"`
+		Translator string                 `json:"translator" binding:"max=128"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil || (input.Sections == nil) == (input.Text == nil) {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format, expected sections or text"})
+		return
+	}
+	sections := input.Sections
+	if input.Text != nil {
+		sections = lyrics.Parse(*input.Text)
+	}
+	if err := lyrics.Validate(sections); err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+
+	if err := lyrics.ReplaceLanguage(c.Request.Context(), songID, language, input.Translator, sections); err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+	stored, lang, err := lyrics.Load(c.Request.Context(), songID, language)
+	if err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+
+	logger.Info().Int("song_id", songID).Str("language", language).Bool("original", lang.Original).Msg("Lyrics language stored")
+	c.JSON(http.StatusOK, models.LyricsPage{
+		SongID: songID, Language: lang.Language, Original: lang.Original, Translator: lang.Translator,
+		Page: 1, Limit: len(stored), Total: len(stored), Sections: stored,
+	})
+}
+
+// DeleteLyricsLanguage removes a translation
+// @Summary Delete a translation
+// @Description Deletes the lyrics of a song in a language; the original lyrics cannot be deleted
+// @Tags Lyrics
+// @Param song_id path int true "Song ID"
+// @Param lang path string true "ISO 639 language code"
+// @Success 200 {object} map[string]string "Translation deleted"
+// @Failure 400 {object} map[string]string "Invalid song ID or language"
+// @Failure 404 {object} map[string]string "Song or language not found"
+// @Failure 409 {object} map[string]string "The language is the original"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/lyrics/languages/{lang} [delete]
+func DeleteLyricsLanguage(c *gin.Context) {
+	songID, language, ok := languageParams(c)
+	if !ok {
+		return
+	}
+
+	if err := lyrics.DeleteLanguage(c.Request.Context(), songID, language); err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+	logging.Ctx(c).Info().Int("song_id", songID).Str("language", language).Msg("Translation deleted")
+	c.JSON(http.StatusOK, gin.H{"message": "Translation was deleted"})
+}
+
+// SetOriginalLanguage marks the language of the original lyrics
+// @Summary Set the original language
+// @Description Makes the lyrics in the given language the original. An existing translation is promoted and the previous original becomes a translation (undetermined lyrics are dropped); otherwise the original lyrics are relabelled with the language.
+// @Tags Lyrics
+// @Accept json
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param language body object true "{\"language\": \"ru\"}"
+// @Success 200 {array} models.LyricsLanguage
+// @Failure 400 {object} map[string]string "Invalid language"
+// @Failure 404 {object} map[string]string "Song not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/lyrics/original [put]
+func SetOriginalLanguage(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, err := strconv.Atoi(c.Param("song_id"))
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid song ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid song ID"})
+		return
+	}
+	var input struct {
+		Language string `json:"language" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
+		return
+	}
+	language, ok := lyrics.NormalizeLanguage(input.Language)
+	if !ok || language == lyrics.Undetermined {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid language code, expected ISO 639"})
+		return
+	}
+
+	if err := lyrics.SetOriginal(c.Request.Context(), songID, language); err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+	langs, err := lyrics.Languages(c.Request.Context(), songID)
+	if err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+	logger.Info().Int("song_id", songID).Str("language", language).Msg("Original lyrics language set")
+	c.JSON(http.StatusOK, langs)
+}
+
+// GetLyricsSideBySide returns the original lyrics aligned with a translation
+// @Summary Get lyrics side by side
+// @Description Returns the sections of the original lyrics paired by position with a translation: lang if the song has it, else the best match of Accept-Language, else the first translation
+// @Tags Lyrics
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param lang query string false "ISO 639 code of the translation"
+// @Param Accept-Language header string false "Preferred languages"
+// @Success 200 {object} models.SideBySide
+// @Failure 400 {object} map[string]string "Invalid song ID"
+// @Failure 404 {object} map[string]string "Song not found or not translated"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/lyrics/side-by-side [get]
+func GetLyricsSideBySide(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, err := strconv.Atoi(c.Param("song_id"))
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid song ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid song ID"})
+		return
+	}
+
+	result, err := lyrics.Align(c.Request.Context(), songID, c.Query("lang"), c.GetHeader("Accept-Language"))
+	if err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+	c.Writer.Header().Add("Vary", "Accept-Language")
+	c.JSON(http.StatusOK, result)
+}
@@ -0,0 +1,261 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"song_library/config"
+	"song_library/links"
+	"song_library/logging"
+	"song_library/models"
+	"strconv"
+)
+
+// respondLinkError answers the errors shared by the link endpoints
+func respondLinkError(c *gin.Context, err error) {
+	logger := logging.Ctx(c)
+	switch {
+	case errors.Is(err, links.ErrSongNotFound):
+		logger.Warn().Msg("Song not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song is not found"})
+	case errors.Is(err, links.ErrNotFound):
+		logger.Warn().Msg("Link not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Link is not found"})
+	case errors.Is(err, links.ErrInvalid):
+		logger.Warn().Err(err).Msg("Invalid link")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logger.Error().Err(err).Msg("Link query failed")
+		respondDBError(c, err)
+	}
+}
+
+// linkParams parses the song and link IDs of a song link route
+func linkParams(c *gin.Context) (int, int, bool) {
+	songID, ok := intParam(c, "song_id", "Invalid song ID")
+	if !ok {
+		return 0, 0, false
+	}
+	linkID, ok := intParam(c, "link_id", "Invalid link ID")
+	if !ok {
+		return 0, 0, false
+	}
+	return songID, linkID, true
+}
+
+// GetSongLinks returns the links of a song
+// @Summary List song links
+// @Description Returns the links of a song with the result of their last check; dead links failed several checks in a row
+// @Tags Links
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Success 200 {array} models.SongLink
+// @Failure 400 {object} map[string]string "Invalid song ID"
+// @Failure 404 {object} map[string]string "Song not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/links [get]
+func GetSongLinks(c *gin.Context) {
+	songID, ok := intParam(c, "song_id", "Invalid song ID")
+	if !ok {
+		return
+	}
+
+	list, err := links.List(c.Request.Context(), songID)
+	if err != nil {
+		respondLinkError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// AddSongLink adds a link to a song
+// @Summary Add a song link
+// @Description Adds a link to a song. The provider (youtube, spotify, apple_music, soundcloud, deezer, yandex_music, bandcamp, genius or other) is detected from the URL when omitted, and the kind (video, audio, lyrics_source or other) from the provider.
+// @Tags Links
+// @Accept json
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param link body object true "{\"url\": \"https://youtu.be/fJ9rUzIMcZQ\", \"provider\": \"youtube\", \"kind\": \"video\"}"
+// @Success 201 {object} models.SongLink
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 404 {object} map[string]string "Song not found"
+// @Failure 409 {object} map[string]string "The song already has the link"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/links [post]
+func AddSongLink(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, ok := intParam(c, "song_id", "Invalid song ID")
+	if !ok {
+		return
+	}
+	var input struct {
+		URL      string `json:"url" binding:"required"`
+		Provider string `json:"provider"`
+		Kind     string `json:"kind"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format, expected url"})
+		return
+	}
+
+	link, err := links.Create(c.Request.Context(), songID, models.SongLink{URL: input.URL, Provider: input.Provider, Kind: input.Kind})
+	if err != nil {
+		respondLinkError(c, err)
+		return
+	}
+	logger.Info().Int("song_id", songID).Int("link_id", link.ID).Str("provider", link.Provider).Msg("Song link added")
+	c.JSON(http.StatusCreated, link)
+}
+
+// UpdateSongLink changes a link of a song
+// @Summary Update a song link
+// @Description Changes the URL, provider or kind of a link; omitted fields are kept. A new URL detects the provider again unless one is given, and is checked from scratch.
+// @Tags Links
+// @Accept json
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param link_id path int true "Link ID"
+// @Param link body links.Changes true "Fields to change"
+// @Success 200 {object} models.SongLink
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 404 {object} map[string]string "Song link not found"
+// @Failure 409 {object} map[string]string "The song already has the link"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/links/{link_id} [patch]
+func UpdateSongLink(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, linkID, ok := linkParams(c)
+	if !ok {
+		return
+	}
+	var changes links.Changes
+	if err := c.ShouldBindJSON(&changes); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
+		return
+	}
+
+	link, err := links.Update(c.Request.Context(), songID, linkID, changes)
+	if err != nil {
+		respondLinkError(c, err)
+		return
+	}
+	logger.Info().Int("song_id", songID).Int("link_id", linkID).Msg("Song link updated")
+	c.JSON(http.StatusOK, link)
+}
+
+// DeleteSongLink removes a link from a song
+// @Summary Delete a song link
+// @Tags Links
+// @Param song_id path int true "Song ID"
+// @Param link_id path int true "Link ID"
+// @Success 200 {object} map[string]string "Link was deleted"
+// @Failure 400 {object} map[string]string "Invalid ID"
+// @Failure 404 {object} map[string]string "Song link not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/links/{link_id} [delete]
+func DeleteSongLink(c *gin.Context) {
+	songID, linkID, ok := linkParams(c)
+	if !ok {
+		return
+	}
+
+	if err := links.Delete(c.Request.Context(), songID, linkID); err != nil {
+		respondLinkError(c, err)
+		return
+	}
+	logging.Ctx(c).Info().Int("song_id", songID).Int("link_id", linkID).Msg("Song link deleted")
+	c.JSON(http.StatusOK, gin.H{"message": "Link was deleted"})
+}
+
+// CheckSongLink checks a link of a song right away
+// @Summary Check a song link
+// @Description Requests the link now, like the background link checker does, and returns it with the result
+// @Tags Links
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param link_id path int true "Link ID"
+// @Success 200 {object} models.SongLink
+// @Failure 400 {object} map[string]string "Invalid ID"
+// @Failure 404 {object} map[string]string "Song link not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 504 {object} map[string]string "The check timed out"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/links/{link_id}/check [post]
+func CheckSongLink(checker *links.Checker) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		songID, linkID, ok := linkParams(c)
+		if !ok {
+			return
+		}
+
+		link, err := checker.CheckNow(c.Request.Context(), songID, linkID)
+		if err != nil {
+			respondLinkError(c, err)
+			return
+		}
+		logging.Ctx(c).Info().Int("song_id", songID).Int("link_id", linkID).Bool("dead", link.Dead).Msg("Song link checked")
+		c.JSON(http.StatusOK, link)
+	}
+}
+
+// GetDeadLinks returns the dead links of the library
+// @Summary List dead links
+// @Description Returns the links that failed several checks in a row, most recently checked first
+// @Tags Links
+// @Produce json
+// @Param page query int false "Page number (default: 1)"
+// @Param limit query int false "Number of links per page (default: 10)"
+// @Success 200 {array} models.SongLink
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /links/dead [get]
+func GetDeadLinks(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+		if err != nil || limit < 1 {
+			limit = 10
+		}
+		limit = min(limit, cfg.MaxPageSize)
+
+		list, err := links.ListDead(c.Request.Context(), limit, (page-1)*limit)
+		if err != nil {
+			respondLinkError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	}
+}
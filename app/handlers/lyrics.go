@@ -0,0 +1,363 @@
+package handlers
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"song_library/db"
+	"song_library/logging"
+	"song_library/lyrics"
+	"song_library/models"
+	"strconv"
+)
+
+// languageConflictMessage answers a change of the original language to that of a translation
+const languageConflictMessage = "The song has a translation into this language; make it the original with PUT /songs/{song_id}/lyrics/original"
+
+// respondLyricsError answers the errors shared by the lyrics endpoints
+func respondLyricsError(c *gin.Context, err error) {
+	logger := logging.Ctx(c)
+	switch {
+	case errors.Is(err, lyrics.ErrSongNotFound):
+		logger.Warn().Msg("Song not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song is not found"})
+	case errors.Is(err, lyrics.ErrSectionNotFound):
+		logger.Warn().Msg("Section not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Section is not found"})
+	case errors.Is(err, lyrics.ErrLanguageNotFound):
+		logger.Warn().Msg("Lyrics language not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Lyrics language is not found"})
+	case errors.Is(err, lyrics.ErrNoTranslation):
+		logger.Warn().Msg("Song has no translations")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song has no translations"})
+	case errors.Is(err, lyrics.ErrOriginalLanguage):
+		logger.Warn().Msg("Refusing to delete the original lyrics")
+		c.JSON(http.StatusConflict, gin.H{"error": "The original lyrics cannot be deleted"})
+	case errors.Is(err, lyrics.ErrLanguageConflict):
+		logger.Warn().Msg("Song has a translation into the language")
+		c.JSON(http.StatusConflict, gin.H{"error": languageConflictMessage})
+	case errors.Is(err, lyrics.ErrNotSynced):
+		logger.Warn().Msg("Song has no synced lyrics")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song has no synced lyrics"})
+	case errors.Is(err, lyrics.ErrInvalidSection), errors.Is(err, lyrics.ErrInvalidLRC):
+		logger.Warn().Err(err).Msg("Invalid lyrics section")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logger.Error().Err(err).Msg("Lyrics database error")
+		respondDBError(c, err)
+	}
+}
+
+// sectionParams parses the song ID and section position of a section route
+func sectionParams(c *gin.Context) (int, int, bool) {
+	songID, err := strconv.Atoi(c.Param("song_id"))
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Invalid song ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid song ID"})
+		return 0, 0, false
+	}
+	position, err := strconv.Atoi(c.Param("position"))
+	if err != nil || position < 1 {
+		logging.Ctx(c).Error().Err(err).Msg("Invalid section position")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid section position"})
+		return 0, 0, false
+	}
+	return songID, position, true
+}
+
+// languageQuery parses the optional lang query parameter; "" selects the original lyrics
+func languageQuery(c *gin.Context) (string, bool) {
+	if c.Query("lang") == "" {
+		return "", true
+	}
+	code, ok := lyrics.NormalizeLanguage(c.Query("lang"))
+	if !ok {
+		logging.Ctx(c).Warn().Str("lang", c.Query("lang")).Msg("Invalid language code")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid language code, expected ISO 639"})
+		return "", false
+	}
+	return code, true
+}
+
+// GetLyricsSection returns one section of a song's lyrics
+// @Summary Get a lyrics section
+// @Description Returns the section at the given position (starting at 1) of a song's lyrics
+// @Tags Lyrics
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param position path int true "Section position"
+// @Param lang query string false "ISO 639 language code (default: the original)"
+// @Success 200 {object} models.LyricsSection
+// @Failure 400 {object} map[string]string "Invalid song ID or position"
+// @Failure 404 {object} map[string]string "Song or section not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/lyrics/sections/{position} [get]
+func GetLyricsSection(c *gin.Context) {
+	songID, position, ok := sectionParams(c)
+	if !ok {
+		return
+	}
+	language, ok := languageQuery(c)
+	if !ok {
+		return
+	}
+
+	sections, _, err := lyrics.Load(c.Request.Context(), songID, language)
+	if err == nil && position > len(sections) {
+		err = lyrics.ErrSectionNotFound
+	}
+	if err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, sections[position-1])
+}
+
+// ReplaceLyricsSections replaces all sections of a song's lyrics
+// @Summary Replace lyrics sections
+// @Description Replaces the original lyrics of a song with the given sections, renumbered from 1. A text/plain body is parsed instead: [Chorus]-style marker lines start typed sections and blank lines separate sections. Section text must not contain blank lines or marker lines, and labels must not contain brackets.
+// @Tags Lyrics
+// @Accept json
+// @Accept plain
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param sections body object true "Sections as {\"sections\": [{\"type\", \"label\", \"text\"}]} or plain text"
+// @Success 200 {object} models.LyricsPage
+// @Failure 400 {object} map[string]string "Invalid sections"
+// @Failure 404 {object} map[string]string "Song not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/lyrics/sections [put]
+func ReplaceLyricsSections(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, err := strconv.Atoi(c.Param("song_id"))
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid song ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid song ID"})
+		return
+	}
+
+	var sections []models.LyricsSection
+	if c.ContentType() == "text/plain" {
+		body, err := c.GetRawData()
+		if err != nil {
+			logger.Error().Err(err).Msg("Error reading body")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
+			return
+		}
+		sections = lyrics.Parse(string(body))
+	} else {
+		var input struct {
+			Sections []models.LyricsSection `json:"sections" binding:"required"`
+		}
+		if err := c.ShouldBindJSON(&input); err != nil {
+			logger.Error().Err(err).Msg("Error binding JSON")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
+			return
+		}
+		sections = input.Sections
+	}
+	if err := lyrics.Validate(sections); err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+
+	var lang models.LyricsLanguage
+	err = db.InTx(c.Request.Context(), func(tx *sql.Tx) error {
+		lang, err = lyrics.Replace(c.Request.Context(), tx, songID, sections)
+		return err
+	})
+	if err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+
+	for i := range sections {
+		sections[i].Position = i + 1
+	}
+	logger.Info().Int("song_id", songID).Int("sections", len(sections)).Msg("Lyrics sections replaced")
+	c.JSON(http.StatusOK, models.LyricsPage{
+		SongID: songID, Language: lang.Language, Original: true,
+		Page: 1, Limit: len(sections), Total: len(sections), Sections: sections,
+	})
+}
+
+// UpdateLyricsSection edits one section of a song's lyrics
+// @Summary Edit a lyrics section
+// @Description Changes the type, label or text of the section at the given position; omitted fields are kept
+// @Tags Lyrics
+// @Accept json
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param position path int true "Section position"
+// @Param lang query string false "ISO 639 language code (default: the original)"
+// @Param section body lyrics.SectionPatch true "Fields to change"
+// @Success 200 {object} models.LyricsSection
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 404 {object} map[string]string "Song or section not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/lyrics/sections/{position} [patch]
+func UpdateLyricsSection(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, position, ok := sectionParams(c)
+	if !ok {
+		return
+	}
+	language, ok := languageQuery(c)
+	if !ok {
+		return
+	}
+
+	var patch lyrics.SectionPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
+		return
+	}
+
+	section, err := lyrics.UpdateSection(c.Request.Context(), songID, language, position, patch)
+	if err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+	logger.Info().Int("song_id", songID).Int("position", position).Msg("Lyrics section updated")
+	c.JSON(http.StatusOK, section)
+}
+
+// ReplaceSyncedLyrics stores time-synced lyrics from LRC
+// @Summary Upload synced lyrics
+// @Description Replaces the time-synced lyrics of a song with the lines of an LRC file. Enhanced LRC word tags (<mm:ss.xx>) are kept per word, lines with several timestamps are repeated and [offset:] is applied.
+// @Tags Lyrics
+// @Accept plain
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param lrc body string true "LRC file"
+// @Success 200 {object} models.SyncedLyrics
+// @Failure 400 {object} map[string]string "Invalid LRC"
+// @Failure 404 {object} map[string]string "Song not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/lyrics/synced [put]
+func ReplaceSyncedLyrics(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, err := strconv.Atoi(c.Param("song_id"))
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid song ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid song ID"})
+		return
+	}
+
+	body, err := c.GetRawData()
+	if err != nil {
+		logger.Error().Err(err).Msg("Error reading body")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
+		return
+	}
+	lines, _, err := lyrics.ParseLRC(string(body))
+	if err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+	if err := lyrics.ReplaceSynced(c.Request.Context(), songID, lines); err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+
+	logger.Info().Int("song_id", songID).Int("lines", len(lines)).Msg("Synced lyrics replaced")
+	c.JSON(http.StatusOK, models.SyncedLyrics{SongID: songID, Lines: lines})
+}
+
+// GetLyricsLRC returns the synced lyrics of a song as LRC
+// @Summary Download synced lyrics
+// @Description Returns the time-synced lyrics of a song as an LRC file, with word tags where word timings are known
+// @Tags Lyrics
+// @Produce plain
+// @Param song_id path int true "Song ID"
+// @Success 200 {string} string "LRC file"
+// @Failure 400 {object} map[string]string "Invalid song ID"
+// @Failure 404 {object} map[string]string "Song not found or not synced"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/lyrics.lrc [get]
+func GetLyricsLRC(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, err := strconv.Atoi(c.Param("song_id"))
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid song ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid song ID"})
+		return
+	}
+
+	lines, tags, err := lyrics.Synced(c.Request.Context(), songID)
+	if err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+	c.Header("Content-Disposition", fmt.Sprintf(`inline; filename="%d.lrc"`, songID))
+	c.Data(http.StatusOK, "text/plain; charset=utf-8", []byte(lyrics.FormatLRC(tags, lines)))
+}
+
+// GetLyricsAt returns the synced line at a point in time
+// @Summary Get the current synced line
+// @Description Returns the line being sung at time t and the next line; line is null before the first line starts
+// @Tags Lyrics
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param t query string true "Playback position, mm:ss.xx or seconds"
+// @Success 200 {object} models.SyncedPosition
+// @Failure 400 {object} map[string]string "Invalid song ID or time"
+// @Failure 404 {object} map[string]string "Song not found or not synced"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/lyrics/at [get]
+func GetLyricsAt(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, err := strconv.Atoi(c.Param("song_id"))
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid song ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid song ID"})
+		return
+	}
+	ms, err := lyrics.ParseTimestamp(c.Query("t"))
+	if err != nil {
+		logger.Warn().Err(err).Msg("Invalid time")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid time, expected mm:ss.xx"})
+		return
+	}
+
+	lines, _, err := lyrics.Synced(c.Request.Context(), songID)
+	if err != nil {
+		respondLyricsError(c, err)
+		return
+	}
+	line, next := lyrics.LineAt(lines, ms)
+	c.JSON(http.StatusOK, models.SyncedPosition{SongID: songID, TimeMs: ms, Line: line, Next: next})
+}
@@ -0,0 +1,407 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"song_library/auth"
+	"song_library/config"
+	"song_library/logging"
+	"song_library/models"
+	"song_library/playlists"
+	"strconv"
+)
+
+// viewerFrom returns the caller a playlist is read or changed for
+func viewerFrom(c *gin.Context) playlists.Viewer {
+	return playlists.Viewer{Subject: auth.SubjectFrom(c), Admin: auth.PrincipalFrom(c).Can(auth.PermAdmin)}
+}
+
+// respondPlaylistError answers the errors shared by the playlist endpoints
+func respondPlaylistError(c *gin.Context, err error) {
+	logger := logging.Ctx(c)
+	switch {
+	case errors.Is(err, playlists.ErrNotFound):
+		logger.Warn().Msg("Playlist not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Playlist is not found"})
+	case errors.Is(err, playlists.ErrItemNotFound):
+		logger.Warn().Msg("Playlist item not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Playlist item is not found"})
+	case errors.Is(err, playlists.ErrSongNotFound):
+		logger.Warn().Msg("Song not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song is not found"})
+	case errors.Is(err, playlists.ErrInvalid):
+		logger.Warn().Err(err).Msg("Invalid playlist")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	case errors.Is(err, playlists.ErrForbidden):
+		logger.Warn().Msg("Playlist belongs to another subject")
+		c.Header("Content-Type", "application/problem+json")
+		c.JSON(http.StatusForbidden, auth.Problem{
+			Type:   "about:blank",
+			Title:  "Forbidden",
+			Status: http.StatusForbidden,
+			Detail: "Only the owner can change the playlist",
+		})
+	default:
+		logger.Error().Err(err).Msg("Playlist query failed")
+		respondDBError(c, err)
+	}
+}
+
+// playlistParam parses the playlist ID of a playlist route
+func playlistParam(c *gin.Context) (int, bool) {
+	playlistID, err := strconv.Atoi(c.Param("playlist_id"))
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Invalid playlist ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid playlist ID"})
+		return 0, false
+	}
+	return playlistID, true
+}
+
+// itemParams parses the playlist and item IDs of a playlist item route
+func itemParams(c *gin.Context) (int, int, bool) {
+	playlistID, ok := playlistParam(c)
+	if !ok {
+		return 0, 0, false
+	}
+	itemID, err := strconv.Atoi(c.Param("item_id"))
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg("Invalid item ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid item ID"})
+		return 0, 0, false
+	}
+	return playlistID, itemID, true
+}
+
+// GetPlaylists returns the playlists the caller can browse
+// @Summary List playlists
+// @Description Returns the caller's playlists and the public playlists of others, most recently changed first, without items
+// @Tags Playlists
+// @Produce json
+// @Param owner query string false "Only the playlists of this subject"
+// @Param page query int false "Page number (default: 1)"
+// @Param limit query int false "Number of playlists per page (default: 10)"
+// @Success 200 {array} models.Playlist
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /playlists [get]
+func GetPlaylists(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		logger := logging.Ctx(c)
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+		if err != nil || limit < 1 {
+			limit = 10
+		}
+		limit = min(limit, cfg.MaxPageSize)
+
+		list, err := playlists.List(c.Request.Context(), viewerFrom(c), c.Query("owner"), limit, (page-1)*limit)
+		if err != nil {
+			respondPlaylistError(c, err)
+			return
+		}
+		logger.Info().Int("count", len(list)).Msg("Found playlists")
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// GetPlaylist returns a playlist with its songs
+// @Summary Get a playlist
+// @Description Returns a playlist with its items in order; each item embeds a summary of its song. Private playlists are visible only to their owner.
+// @Tags Playlists
+// @Produce json
+// @Param playlist_id path int true "Playlist ID"
+// @Success 200 {object} models.Playlist
+// @Failure 400 {object} map[string]string "Invalid playlist ID"
+// @Failure 404 {object} map[string]string "Playlist not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /playlists/{playlist_id} [get]
+func GetPlaylist(c *gin.Context) {
+	playlistID, ok := playlistParam(c)
+	if !ok {
+		return
+	}
+
+	p, err := playlists.Get(c.Request.Context(), viewerFrom(c), playlistID)
+	if err != nil {
+		respondPlaylistError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, p)
+}
+
+// CreatePlaylist creates an empty playlist owned by the caller
+// @Summary Create a playlist
+// @Description Creates an empty playlist owned by the caller; visibility is private (default), unlisted or public
+// @Tags Playlists
+// @Accept json
+// @Produce json
+// @Param playlist body object true "{\"name\": \"...\", \"description\": \"...\", \"visibility\": \"private\"}"
+// @Success 201 {object} models.Playlist
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /playlists [post]
+func CreatePlaylist(c *gin.Context) {
+	logger := logging.Ctx(c)
+	var input models.Playlist
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
+		return
+	}
+
+	p, err := playlists.Create(c.Request.Context(), viewerFrom(c), input)
+	if err != nil {
+		respondPlaylistError(c, err)
+		return
+	}
+	logger.Info().Int("playlist_id", p.ID).Str("owner", p.Owner).Msg("Playlist created")
+	c.JSON(http.StatusCreated, p)
+}
+
+// UpdatePlaylist changes the details of a playlist
+// @Summary Update a playlist
+// @Description Changes the name, description or visibility of a playlist; omitted fields are kept. Only the owner or an admin can change a playlist.
+// @Tags Playlists
+// @Accept json
+// @Produce json
+// @Param playlist_id path int true "Playlist ID"
+// @Param playlist body playlists.Changes true "Fields to change"
+// @Success 200 {object} models.Playlist
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 404 {object} map[string]string "Playlist not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission or not the owner"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /playlists/{playlist_id} [patch]
+func UpdatePlaylist(c *gin.Context) {
+	logger := logging.Ctx(c)
+	playlistID, ok := playlistParam(c)
+	if !ok {
+		return
+	}
+	var changes playlists.Changes
+	if err := c.ShouldBindJSON(&changes); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
+		return
+	}
+
+	p, err := playlists.Update(c.Request.Context(), viewerFrom(c), playlistID, changes)
+	if err != nil {
+		respondPlaylistError(c, err)
+		return
+	}
+	logger.Info().Int("playlist_id", playlistID).Msg("Playlist updated")
+	c.JSON(http.StatusOK, p)
+}
+
+// DeletePlaylist removes a playlist
+// @Summary Delete a playlist
+// @Description Deletes a playlist and its items; the songs are kept
+// @Tags Playlists
+// @Param playlist_id path int true "Playlist ID"
+// @Success 200 {object} map[string]string "Playlist deleted"
+// @Failure 400 {object} map[string]string "Invalid playlist ID"
+// @Failure 404 {object} map[string]string "Playlist not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission or not the owner"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /playlists/{playlist_id} [delete]
+func DeletePlaylist(c *gin.Context) {
+	playlistID, ok := playlistParam(c)
+	if !ok {
+		return
+	}
+
+	if err := playlists.Delete(c.Request.Context(), viewerFrom(c), playlistID); err != nil {
+		respondPlaylistError(c, err)
+		return
+	}
+	logging.Ctx(c).Info().Int("playlist_id", playlistID).Msg("Playlist deleted")
+	c.JSON(http.StatusOK, gin.H{"message": "Playlist was deleted"})
+}
+
+// DuplicatePlaylist copies a playlist
+// @Summary Duplicate a playlist
+// @Description Copies a playlist the caller can see, with its items in order, into a new private playlist owned by the caller. Without a name the copy is named after the source.
+// @Tags Playlists
+// @Accept json
+// @Produce json
+// @Param playlist_id path int true "Playlist ID"
+// @Param playlist body object false "{\"name\": \"...\"}"
+// @Success 201 {object} models.Playlist
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 404 {object} map[string]string "Playlist not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /playlists/{playlist_id}/duplicate [post]
+func DuplicatePlaylist(c *gin.Context) {
+	logger := logging.Ctx(c)
+	playlistID, ok := playlistParam(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Name string `json:"name"`
+	}
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&input); err != nil {
+			logger.Error().Err(err).Msg("Error binding JSON")
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
+			return
+		}
+	}
+
+	p, err := playlists.Duplicate(c.Request.Context(), viewerFrom(c), playlistID, input.Name)
+	if err != nil {
+		respondPlaylistError(c, err)
+		return
+	}
+	logger.Info().Int("playlist_id", playlistID).Int("copy_id", p.ID).Msg("Playlist duplicated")
+	c.JSON(http.StatusCreated, p)
+}
+
+// AddPlaylistItem adds a song to a playlist
+// @Summary Add a song to a playlist
+// @Description Adds a song at a position counted from 1; without a position, or past the end, the song is appended. A song may appear in a playlist more than once.
+// @Tags Playlists
+// @Accept json
+// @Produce json
+// @Param playlist_id path int true "Playlist ID"
+// @Param item body object true "{\"songId\": 1, \"position\": 1}"
+// @Success 201 {object} models.PlaylistItem
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 404 {object} map[string]string "Playlist or song not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission or not the owner"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /playlists/{playlist_id}/items [post]
+func AddPlaylistItem(c *gin.Context) {
+	logger := logging.Ctx(c)
+	playlistID, ok := playlistParam(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		SongID   int `json:"songId" binding:"required,min=1"`
+		Position int `json:"position" binding:"min=0"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format, expected songId and an optional position"})
+		return
+	}
+
+	it, err := playlists.AddItem(c.Request.Context(), viewerFrom(c), playlistID, input.SongID, input.Position)
+	if err != nil {
+		respondPlaylistError(c, err)
+		return
+	}
+	logger.Info().Int("playlist_id", playlistID).Int("song_id", input.SongID).Int("position", it.Position).Msg("Song added to playlist")
+	c.JSON(http.StatusCreated, it)
+}
+
+// MovePlaylistItem reorders a playlist
+// @Summary Move a playlist item
+// @Description Moves an item to a position counted from 1; past the end moves it last. Only the moved item is rewritten.
+// @Tags Playlists
+// @Accept json
+// @Produce json
+// @Param playlist_id path int true "Playlist ID"
+// @Param item_id path int true "Item ID"
+// @Param item body object true "{\"position\": 1}"
+// @Success 200 {object} models.PlaylistItem
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 404 {object} map[string]string "Playlist or item not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission or not the owner"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /playlists/{playlist_id}/items/{item_id} [patch]
+func MovePlaylistItem(c *gin.Context) {
+	logger := logging.Ctx(c)
+	playlistID, itemID, ok := itemParams(c)
+	if !ok {
+		return
+	}
+	var input struct {
+		Position int `json:"position" binding:"required,min=1"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format, expected a position from 1"})
+		return
+	}
+
+	it, err := playlists.MoveItem(c.Request.Context(), viewerFrom(c), playlistID, itemID, input.Position)
+	if err != nil {
+		respondPlaylistError(c, err)
+		return
+	}
+	logger.Info().Int("playlist_id", playlistID).Int("item_id", itemID).Int("position", it.Position).Msg("Playlist item moved")
+	c.JSON(http.StatusOK, it)
+}
+
+// RemovePlaylistItem removes a song from a playlist
+// @Summary Remove a playlist item
+// @Description Removes an item from a playlist; the song is kept
+// @Tags Playlists
+// @Param playlist_id path int true "Playlist ID"
+// @Param item_id path int true "Item ID"
+// @Success 200 {object} map[string]string "Item removed"
+// @Failure 400 {object} map[string]string "Invalid playlist or item ID"
+// @Failure 404 {object} map[string]string "Playlist or item not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission or not the owner"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /playlists/{playlist_id}/items/{item_id} [delete]
+func RemovePlaylistItem(c *gin.Context) {
+	playlistID, itemID, ok := itemParams(c)
+	if !ok {
+		return
+	}
+
+	if err := playlists.RemoveItem(c.Request.Context(), viewerFrom(c), playlistID, itemID); err != nil {
+		respondPlaylistError(c, err)
+		return
+	}
+	logging.Ctx(c).Info().Int("playlist_id", playlistID).Int("item_id", itemID).Msg("Playlist item removed")
+	c.JSON(http.StatusOK, gin.H{"message": "Item was removed from the playlist"})
+}
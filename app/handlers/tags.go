@@ -0,0 +1,342 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"slices"
+	"song_library/logging"
+	"song_library/models"
+	"song_library/tags"
+	"strconv"
+)
+
+// respondTagError answers the errors shared by the tag endpoints
+func respondTagError(c *gin.Context, err error) {
+	logger := logging.Ctx(c)
+	switch {
+	case errors.Is(err, tags.ErrSongNotFound):
+		logger.Warn().Msg("Song not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song is not found"})
+	case errors.Is(err, tags.ErrNotFound):
+		logger.Warn().Err(err).Msg("Tag not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Tag is not found"})
+	case errors.Is(err, tags.ErrInvalid):
+		logger.Warn().Err(err).Msg("Invalid tag")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logger.Error().Err(err).Msg("Tag query failed")
+		respondDBError(c, err)
+	}
+}
+
+// intParam parses an integer path parameter, answering 400 with message when it is not one
+func intParam(c *gin.Context, name, message string) (int, bool) {
+	value, err := strconv.Atoi(c.Param(name))
+	if err != nil {
+		logging.Ctx(c).Error().Err(err).Msg(message)
+		c.JSON(http.StatusBadRequest, gin.H{"error": message})
+		return 0, false
+	}
+	return value, true
+}
+
+// GetTags returns the tag taxonomy
+// @Summary List tags
+// @Description Returns the tags of a type, or every tag, by type and name. Genres carry the ID of their parent genre.
+// @Tags Tags
+// @Produce json
+// @Param type query string false "Tag type: genre, mood, language or decade"
+// @Success 200 {array} models.Tag
+// @Failure 400 {object} map[string]string "Invalid tag type"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /tags [get]
+func GetTags(c *gin.Context) {
+	tagType := c.Query("type")
+	if tagType != "" && !slices.Contains(models.TagTypes, tagType) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid tag type"})
+		return
+	}
+
+	list, err := tags.List(c.Request.Context(), tagType)
+	if err != nil {
+		respondTagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// CreateTag adds a tag to the taxonomy
+// @Summary Create a tag
+// @Description Creates a genre, mood, language or decade tag. The slug defaults to the name in lowercase with hyphens; a genre may name a parent genre.
+// @Tags Tags
+// @Accept json
+// @Produce json
+// @Param tag body models.Tag true "Tag; the ID is ignored"
+// @Success 201 {object} models.Tag
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 409 {object} map[string]string "A tag of the type with the slug exists"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /tags [post]
+func CreateTag(c *gin.Context) {
+	logger := logging.Ctx(c)
+	var input models.Tag
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
+		return
+	}
+
+	t, err := tags.Create(c.Request.Context(), input)
+	if err != nil {
+		respondTagError(c, err)
+		return
+	}
+	logger.Info().Int("tag_id", t.ID).Str("tag", t.Type+":"+t.Slug).Msg("Tag created")
+	c.JSON(http.StatusCreated, t)
+}
+
+// UpdateTag renames a tag or moves a genre in the hierarchy
+// @Summary Update a tag
+// @Description Changes the name or slug of a tag or the parent of a genre; omitted fields are kept and parentId 0 makes a genre top-level
+// @Tags Tags
+// @Accept json
+// @Produce json
+// @Param tag_id path int true "Tag ID"
+// @Param tag body tags.Changes true "Fields to change"
+// @Success 200 {object} models.Tag
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 404 {object} map[string]string "Tag not found"
+// @Failure 409 {object} map[string]string "A tag of the type with the slug exists"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /tags/{tag_id} [patch]
+func UpdateTag(c *gin.Context) {
+	logger := logging.Ctx(c)
+	tagID, ok := intParam(c, "tag_id", "Invalid tag ID")
+	if !ok {
+		return
+	}
+	var changes tags.Changes
+	if err := c.ShouldBindJSON(&changes); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
+		return
+	}
+
+	t, err := tags.Update(c.Request.Context(), tagID, changes)
+	if err != nil {
+		respondTagError(c, err)
+		return
+	}
+	logger.Info().Int("tag_id", tagID).Msg("Tag updated")
+	c.JSON(http.StatusOK, t)
+}
+
+// DeleteTag removes a tag
+// @Summary Delete a tag
+// @Description Deletes a tag and removes it from every song; the subgenres of a genre move up to its parent
+// @Tags Tags
+// @Param tag_id path int true "Tag ID"
+// @Success 200 {object} map[string]string "Tag deleted"
+// @Failure 400 {object} map[string]string "Invalid tag ID"
+// @Failure 404 {object} map[string]string "Tag not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /tags/{tag_id} [delete]
+func DeleteTag(c *gin.Context) {
+	tagID, ok := intParam(c, "tag_id", "Invalid tag ID")
+	if !ok {
+		return
+	}
+
+	if err := tags.Delete(c.Request.Context(), tagID); err != nil {
+		respondTagError(c, err)
+		return
+	}
+	logging.Ctx(c).Info().Int("tag_id", tagID).Msg("Tag deleted")
+	c.JSON(http.StatusOK, gin.H{"message": "Tag was deleted"})
+}
+
+// GetSongTags returns the tags of a song
+// @Summary List the tags of a song
+// @Tags Tags
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Success 200 {array} models.Tag
+// @Failure 400 {object} map[string]string "Invalid song ID"
+// @Failure 404 {object} map[string]string "Song not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/tags [get]
+func GetSongTags(c *gin.Context) {
+	songID, ok := intParam(c, "song_id", "Invalid song ID")
+	if !ok {
+		return
+	}
+
+	list, err := tags.SongTags(c.Request.Context(), songID)
+	if err != nil {
+		respondTagError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// SetSongTags replaces the tags of a song
+// @Summary Replace the tags of a song
+// @Description Sets the tags of a song to the listed ones, each written type:slug (genre:rock) or as a slug that only one tag has
+// @Tags Tags
+// @Accept json
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param tags body object true "{\"tags\": [\"genre:rock\", \"mood:calm\"]}"
+// @Success 200 {array} models.Tag
+// @Failure 400 {object} map[string]string "Invalid or ambiguous tag"
+// @Failure 404 {object} map[string]string "Song or tag not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/tags [put]
+func SetSongTags(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, ok := intParam(c, "song_id", "Invalid song ID")
+	if !ok {
+		return
+	}
+	var input struct {
+		Tags []string `json:"tags" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format, expected tags"})
+		return
+	}
+	refs := make([]tags.Ref, 0, len(input.Tags))
+	for _, s := range input.Tags {
+		ref, err := tags.ParseRef(s)
+		if err != nil {
+			respondTagError(c, err)
+			return
+		}
+		refs = append(refs, ref)
+	}
+
+	list, err := tags.SetSongTags(c.Request.Context(), songID, refs)
+	if err != nil {
+		respondTagError(c, err)
+		return
+	}
+	logger.Info().Int("song_id", songID).Int("count", len(list)).Msg("Song tags replaced")
+	c.JSON(http.StatusOK, list)
+}
+
+// TagSong adds a tag to a song
+// @Summary Tag a song
+// @Description Adds a tag, written type:slug or as a unique slug, to a song; a tag the song already has is kept
+// @Tags Tags
+// @Accept json
+// @Produce json
+// @Param song_id path int true "Song ID"
+// @Param tag body object true "{\"tag\": \"genre:alternative-rock\"}"
+// @Success 200 {object} models.Tag
+// @Failure 400 {object} map[string]string "Invalid or ambiguous tag"
+// @Failure 404 {object} map[string]string "Song or tag not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/tags [post]
+func TagSong(c *gin.Context) {
+	logger := logging.Ctx(c)
+	songID, ok := intParam(c, "song_id", "Invalid song ID")
+	if !ok {
+		return
+	}
+	var input struct {
+		Tag string `json:"tag" binding:"required"`
+	}
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format, expected tag"})
+		return
+	}
+	ref, err := tags.ParseRef(input.Tag)
+	if err != nil {
+		respondTagError(c, err)
+		return
+	}
+
+	t, err := tags.TagSong(c.Request.Context(), songID, ref)
+	if err != nil {
+		respondTagError(c, err)
+		return
+	}
+	logger.Info().Int("song_id", songID).Int("tag_id", t.ID).Msg("Song tagged")
+	c.JSON(http.StatusOK, t)
+}
+
+// UntagSong removes a tag from a song
+// @Summary Untag a song
+// @Tags Tags
+// @Param song_id path int true "Song ID"
+// @Param tag_id path int true "Tag ID"
+// @Success 200 {object} map[string]string "Tag removed"
+// @Failure 400 {object} map[string]string "Invalid song or tag ID"
+// @Failure 404 {object} map[string]string "Song not found or not tagged"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /songs/{song_id}/tags/{tag_id} [delete]
+func UntagSong(c *gin.Context) {
+	songID, ok := intParam(c, "song_id", "Invalid song ID")
+	if !ok {
+		return
+	}
+	tagID, ok := intParam(c, "tag_id", "Invalid tag ID")
+	if !ok {
+		return
+	}
+
+	removed, err := tags.UntagSong(c.Request.Context(), songID, tagID)
+	if err != nil {
+		respondTagError(c, err)
+		return
+	}
+	if !removed {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Song does not have the tag"})
+		return
+	}
+	logging.Ctx(c).Info().Int("song_id", songID).Int("tag_id", tagID).Msg("Song untagged")
+	c.JSON(http.StatusOK, gin.H{"message": "Tag was removed from the song"})
+}
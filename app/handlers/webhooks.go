@@ -0,0 +1,277 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"net/http"
+	"song_library/auth"
+	"song_library/config"
+	"song_library/logging"
+	"song_library/models"
+	"song_library/webhooks"
+	"strconv"
+)
+
+// respondWebhookError answers the errors shared by the webhook endpoints
+func respondWebhookError(c *gin.Context, err error) {
+	logger := logging.Ctx(c)
+	switch {
+	case errors.Is(err, webhooks.ErrNotFound):
+		logger.Warn().Msg("Webhook subscription not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook subscription is not found"})
+	case errors.Is(err, webhooks.ErrDeliveryNotFound):
+		logger.Warn().Msg("Webhook delivery not found")
+		c.JSON(http.StatusNotFound, gin.H{"error": "Webhook delivery is not found"})
+	case errors.Is(err, webhooks.ErrInactive):
+		logger.Warn().Msg("Webhook subscription inactive")
+		c.JSON(http.StatusConflict, gin.H{"error": "Webhook subscription is inactive; activate it to redeliver"})
+	case errors.Is(err, webhooks.ErrInvalid):
+		logger.Warn().Err(err).Msg("Invalid webhook subscription")
+		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+	default:
+		logger.Error().Err(err).Msg("Webhook query failed")
+		respondDBError(c, err)
+	}
+}
+
+// GetWebhooks returns the webhook subscriptions
+// @Summary List webhook subscriptions
+// @Description Returns the webhook subscriptions in the order they were created, without their secrets
+// @Tags Webhooks
+// @Produce json
+// @Success 200 {array} models.WebhookSubscription
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks [get]
+func GetWebhooks(c *gin.Context) {
+	list, err := webhooks.List(c.Request.Context())
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, list)
+}
+
+// GetWebhook returns a webhook subscription
+// @Summary Get a webhook subscription
+// @Description Returns a webhook subscription without its secret
+// @Tags Webhooks
+// @Produce json
+// @Param webhook_id path int true "Webhook subscription ID"
+// @Success 200 {object} models.WebhookSubscription
+// @Failure 400 {object} map[string]string "Invalid webhook ID"
+// @Failure 404 {object} map[string]string "Webhook subscription not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/{webhook_id} [get]
+func GetWebhook(c *gin.Context) {
+	webhookID, ok := intParam(c, "webhook_id", "Invalid webhook ID")
+	if !ok {
+		return
+	}
+
+	s, err := webhooks.Get(c.Request.Context(), webhookID)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+// CreateWebhook subscribes a URL to song events
+// @Summary Create a webhook subscription
+// @Description Subscribes an http or https URL to song events of the given types (song.created, song.updated, song.deleted; all of them when omitted). Events that happen from now on are posted to the URL as JSON and signed with the secret, which is generated unless given and returned only here and when it is changed.
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Param webhook body object true "{\"url\": \"https://...\", \"eventTypes\": [\"song.created\"], \"secret\": \"...\", \"description\": \"...\"}"
+// @Success 201 {object} models.WebhookSubscription
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks [post]
+func CreateWebhook(c *gin.Context) {
+	logger := logging.Ctx(c)
+	var input models.WebhookSubscription
+	if err := c.ShouldBindJSON(&input); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
+		return
+	}
+	input.CreatedBy = auth.SubjectFrom(c)
+
+	s, err := webhooks.Create(c.Request.Context(), input)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	logger.Info().Int("webhook_id", s.ID).Str("url", s.URL).Strs("event_types", s.EventTypes).Msg("Webhook subscription created")
+	c.JSON(http.StatusCreated, s)
+}
+
+// UpdateWebhook changes a webhook subscription
+// @Summary Update a webhook subscription
+// @Description Changes the URL, event types, secret, description or whether a subscription is active; omitted fields are kept. A paused subscription gets no deliveries for the events that happen meanwhile. A new secret is returned in the response.
+// @Tags Webhooks
+// @Accept json
+// @Produce json
+// @Param webhook_id path int true "Webhook subscription ID"
+// @Param webhook body webhooks.Changes true "Fields to change"
+// @Success 200 {object} models.WebhookSubscription
+// @Failure 400 {object} map[string]string "Invalid data"
+// @Failure 404 {object} map[string]string "Webhook subscription not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/{webhook_id} [patch]
+func UpdateWebhook(c *gin.Context) {
+	logger := logging.Ctx(c)
+	webhookID, ok := intParam(c, "webhook_id", "Invalid webhook ID")
+	if !ok {
+		return
+	}
+	var changes webhooks.Changes
+	if err := c.ShouldBindJSON(&changes); err != nil {
+		logger.Error().Err(err).Msg("Error binding JSON")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid data format"})
+		return
+	}
+
+	s, err := webhooks.Update(c.Request.Context(), webhookID, changes)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	logger.Info().Int("webhook_id", s.ID).Bool("active", s.Active).Bool("secret_changed", changes.Secret != nil).
+		Msg("Webhook subscription updated")
+	c.JSON(http.StatusOK, s)
+}
+
+// DeleteWebhook removes a webhook subscription
+// @Summary Delete a webhook subscription
+// @Description Removes a webhook subscription along with its delivery log; pending deliveries are not sent
+// @Tags Webhooks
+// @Produce json
+// @Param webhook_id path int true "Webhook subscription ID"
+// @Success 200 {object} map[string]string "Webhook subscription was deleted"
+// @Failure 400 {object} map[string]string "Invalid webhook ID"
+// @Failure 404 {object} map[string]string "Webhook subscription not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/{webhook_id} [delete]
+func DeleteWebhook(c *gin.Context) {
+	webhookID, ok := intParam(c, "webhook_id", "Invalid webhook ID")
+	if !ok {
+		return
+	}
+
+	if err := webhooks.Delete(c.Request.Context(), webhookID); err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	logging.Ctx(c).Info().Int("webhook_id", webhookID).Msg("Webhook subscription deleted")
+	c.JSON(http.StatusOK, gin.H{"message": "Webhook subscription was deleted"})
+}
+
+// GetWebhookDeliveries returns the delivery log of a webhook subscription
+// @Summary List webhook deliveries
+// @Description Returns the deliveries of a subscription, newest first, with the event, the status (pending, delivered or dead), the number of attempts, when the next one is due and the response status or error of the last one. A delivery is dead once it has failed webhooks.max_attempts times.
+// @Tags Webhooks
+// @Produce json
+// @Param webhook_id path int true "Webhook subscription ID"
+// @Param status query string false "Only deliveries with this status" Enums(pending, delivered, dead)
+// @Param page query int false "Page number (default: 1)"
+// @Param limit query int false "Number of deliveries per page (default: 10)"
+// @Success 200 {array} models.WebhookDelivery
+// @Failure 400 {object} map[string]string "Invalid webhook ID or status"
+// @Failure 404 {object} map[string]string "Webhook subscription not found"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/{webhook_id}/deliveries [get]
+func GetWebhookDeliveries(cfg *config.Config) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		webhookID, ok := intParam(c, "webhook_id", "Invalid webhook ID")
+		if !ok {
+			return
+		}
+		page, err := strconv.Atoi(c.DefaultQuery("page", "1"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "10"))
+		if err != nil || limit < 1 {
+			limit = 10
+		}
+		limit = min(limit, cfg.MaxPageSize)
+
+		list, err := webhooks.Deliveries(c.Request.Context(), webhookID, c.Query("status"), limit, (page-1)*limit)
+		if err != nil {
+			respondWebhookError(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	}
+}
+
+// RedeliverWebhook sends a delivery again
+// @Summary Redeliver a webhook delivery
+// @Description Schedules a delivery to be sent again at once with a fresh set of attempts, including a dead or delivered one. The subscription must be active.
+// @Tags Webhooks
+// @Produce json
+// @Param webhook_id path int true "Webhook subscription ID"
+// @Param delivery_id path int true "Delivery ID"
+// @Success 200 {object} models.WebhookDelivery
+// @Failure 400 {object} map[string]string "Invalid webhook or delivery ID"
+// @Failure 404 {object} map[string]string "Webhook delivery not found"
+// @Failure 409 {object} map[string]string "Webhook subscription is inactive"
+// @Failure 500 {object} map[string]string "Database error"
+// @Failure 401 {object} map[string]string "Authentication required"
+// @Failure 403 {object} auth.Problem "Missing permission"
+// @Failure 429 {object} map[string]string "Too many requests"
+// @Security ApiKeyAuth
+// @Security BearerAuth
+// @Router /webhooks/{webhook_id}/deliveries/{delivery_id}/redeliver [post]
+func RedeliverWebhook(c *gin.Context) {
+	logger := logging.Ctx(c)
+	webhookID, ok := intParam(c, "webhook_id", "Invalid webhook ID")
+	if !ok {
+		return
+	}
+	deliveryID, err := strconv.ParseInt(c.Param("delivery_id"), 10, 64)
+	if err != nil {
+		logger.Error().Err(err).Msg("Invalid delivery ID")
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid delivery ID"})
+		return
+	}
+
+	d, err := webhooks.Redeliver(c.Request.Context(), webhookID, deliveryID)
+	if err != nil {
+		respondWebhookError(c, err)
+		return
+	}
+	logger.Info().Int("webhook_id", webhookID).Int64("delivery_id", deliveryID).Msg("Webhook delivery rescheduled")
+	c.JSON(http.StatusOK, d)
+}
@@ -0,0 +1,126 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"song_library/config"
+	"song_library/db"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var shuttingDown atomic.Bool
+
+// SetShuttingDown makes readiness fail so that load balancers stop routing new requests
+func SetShuttingDown() {
+	shuttingDown.Store(true)
+}
+
+// ShuttingDown reports whether the server has started draining
+func ShuttingDown() bool {
+	return shuttingDown.Load()
+}
+
+// CheckResult is the outcome of a single dependency check
+type CheckResult struct {
+	Status  string `json:"status"`
+	Latency string `json:"latency"`
+	Error   string `json:"error,omitempty"`
+}
+
+// Report is the readiness response body
+type Report struct {
+	Status string                 `json:"status"`
+	Checks map[string]CheckResult `json:"checks"`
+}
+
+const (
+	StatusOK   = "ok"
+	StatusFail = "fail"
+)
+
+type check func(ctx context.Context) error
+
+func checkDatabase(ctx context.Context) error {
+	return db.Db.PingContext(ctx)
+}
+
+func checkMigrations(ctx context.Context) error {
+	pending, err := db.PendingMigrations(ctx)
+	if err != nil {
+		return err
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("pending migrations: %s", strings.Join(pending, ", "))
+	}
+	return nil
+}
+
+func checkExternalAPI(url string) check {
+	return func(ctx context.Context) error {
+		req, err := http.NewRequestWithContext(ctx, http.MethodHead, url, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode >= http.StatusInternalServerError {
+			return fmt.Errorf("unexpected status %d", resp.StatusCode)
+		}
+		return nil
+	}
+}
+
+// Ready runs all dependency checks concurrently, each bounded by the configured timeout
+func Ready(ctx context.Context, cfg *config.Config) Report {
+	checks := map[string]check{
+		"database":   checkDatabase,
+		"migrations": checkMigrations,
+	}
+	if db.Replica != nil {
+		checks["database_replica"] = func(ctx context.Context) error { return db.Replica.PingContext(ctx) }
+	}
+	if cfg.HealthCheckExternalAPI && cfg.ExternalAPIURL != "" {
+		checks["external_api"] = checkExternalAPI(cfg.ExternalAPIURL)
+	}
+
+	report := Report{Status: StatusOK, Checks: map[string]CheckResult{}}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, run := range checks {
+		wg.Add(1)
+		go func(name string, run check) {
+			defer wg.Done()
+			checkCtx, cancel := context.WithTimeout(ctx, cfg.HealthCheckTimeout)
+			defer cancel()
+
+			start := time.Now()
+			err := run(checkCtx)
+			result := CheckResult{Status: StatusOK, Latency: time.Since(start).String()}
+			if err != nil {
+				result.Status = StatusFail
+				result.Error = err.Error()
+			}
+
+			mu.Lock()
+			report.Checks[name] = result
+			if err != nil {
+				report.Status = StatusFail
+			}
+			mu.Unlock()
+		}(name, run)
+	}
+	wg.Wait()
+
+	if ShuttingDown() {
+		report.Status = StatusFail
+		report.Checks["shutdown"] = CheckResult{Status: StatusFail, Latency: "0s", Error: "server is shutting down"}
+	}
+	return report
+}
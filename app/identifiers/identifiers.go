@@ -0,0 +1,88 @@
+package identifiers
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+var ErrInvalid = errors.New("invalid identifier")
+
+var (
+	isrcPattern = regexp.MustCompile(`^[A-Z]{2}[A-Z0-9]{3}[0-9]{7}$`)
+	iswcPattern = regexp.MustCompile(`^T[0-9]{10}$`)
+	mbidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+)
+
+// compact uppercases s and drops the separators identifiers are often written with
+func compact(s string) string {
+	return strings.ToUpper(strings.NewReplacer("-", "", ".", "", " ", "").Replace(strings.TrimSpace(s)))
+}
+
+// NormalizeISRC checks an ISRC such as US-RC1-76-07839 and returns it without hyphens, USRC17607839
+func NormalizeISRC(s string) (string, error) {
+	isrc := compact(s)
+	if !isrcPattern.MatchString(isrc) {
+		return "", fmt.Errorf("%w: %q is not an ISRC, expected CC-XXX-YY-NNNNN", ErrInvalid, s)
+	}
+	return isrc, nil
+}
+
+// NormalizeISWC checks an ISWC such as T-034.524.680-1, including its check digit, and returns it
+// without separators, T0345246801
+func NormalizeISWC(s string) (string, error) {
+	iswc := compact(s)
+	if !iswcPattern.MatchString(iswc) {
+		return "", fmt.Errorf("%w: %q is not an ISWC, expected T-DDD.DDD.DDD-C", ErrInvalid, s)
+	}
+	sum := 1
+	for i := 1; i <= 9; i++ {
+		sum += i * int(iswc[i]-'0')
+	}
+	if check := (10 - sum%10) % 10; int(iswc[10]-'0') != check {
+		return "", fmt.Errorf("%w: ISWC %q has check digit %c, expected %d", ErrInvalid, s, iswc[10], check)
+	}
+	return iswc, nil
+}
+
+// FormatISWC writes a normalized ISWC the way it is usually printed, T-034.524.680-1
+func FormatISWC(iswc string) string {
+	if len(iswc) != 11 {
+		return iswc
+	}
+	return fmt.Sprintf("T-%s.%s.%s-%s", iswc[1:4], iswc[4:7], iswc[7:10], iswc[10:])
+}
+
+// NormalizeMBID checks a MusicBrainz ID, a UUID, and returns it in lowercase
+func NormalizeMBID(s string) (string, error) {
+	mbid := strings.ToLower(strings.TrimSpace(s))
+	if !mbidPattern.MatchString(mbid) {
+		return "", fmt.Errorf("%w: %q is not a MusicBrainz ID", ErrInvalid, s)
+	}
+	return mbid, nil
+}
+
+// Normalize checks the identifiers of a song that are set and normalizes them in place
+func Normalize(isrc, iswc, recordingID, workID *string) error {
+	fields := []struct {
+		value     *string
+		normalize func(string) (string, error)
+	}{
+		{isrc, NormalizeISRC},
+		{iswc, NormalizeISWC},
+		{recordingID, NormalizeMBID},
+		{workID, NormalizeMBID},
+	}
+	for _, f := range fields {
+		if *f.value == "" {
+			continue
+		}
+		normalized, err := f.normalize(*f.value)
+		if err != nil {
+			return err
+		}
+		*f.value = normalized
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package identifiers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+var ErrRecordingNotFound = errors.New("no MusicBrainz recording has the identifier")
+
+// Recording is what MusicBrainz knows about a recording that identifies a song
+type Recording struct {
+	ID     string
+	Title  string
+	Artist string
+	ISRCs  []string
+	WorkID string
+	ISWC   string
+}
+
+// MusicBrainz resolves ISRCs and recording IDs through the MusicBrainz web service
+type MusicBrainz struct {
+	BaseURL   string
+	UserAgent string
+	HTTP      *http.Client
+}
+
+type mbArtistCredit struct {
+	Name       string `json:"name"`
+	JoinPhrase string `json:"joinphrase"`
+}
+
+type mbRecording struct {
+	ID           string           `json:"id"`
+	Title        string           `json:"title"`
+	ArtistCredit []mbArtistCredit `json:"artist-credit"`
+	ISRCs        []string         `json:"isrcs"`
+	Relations    []struct {
+		Type string `json:"type"`
+		Work *struct {
+			ID    string   `json:"id"`
+			ISWCs []string `json:"iswcs"`
+		} `json:"work"`
+	} `json:"relations"`
+}
+
+// get fetches path from the web service as JSON into v
+func (m *MusicBrainz) get(ctx context.Context, path string, query url.Values, v any) error {
+	query.Set("fmt", "json")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(m.BaseURL, "/")+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	// MusicBrainz rejects requests without a meaningful user agent
+	req.Header.Set("User-Agent", m.UserAgent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := m.HTTP.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return ErrRecordingNotFound
+	case resp.StatusCode != http.StatusOK:
+		return fmt.Errorf("musicbrainz %s: %s", path, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Recording returns a recording by its MusicBrainz ID with its ISRCs and the work it performs
+func (m *MusicBrainz) Recording(ctx context.Context, mbid string) (Recording, error) {
+	var rec mbRecording
+	if err := m.get(ctx, "/recording/"+url.PathEscape(mbid), url.Values{"inc": {"artist-credits isrcs work-rels"}}, &rec); err != nil {
+		return Recording{}, err
+	}
+
+	r := Recording{ID: rec.ID, Title: rec.Title, ISRCs: rec.ISRCs}
+	var artist strings.Builder
+	for _, credit := range rec.ArtistCredit {
+		artist.WriteString(credit.Name + credit.JoinPhrase)
+	}
+	r.Artist = artist.String()
+	for _, rel := range rec.Relations {
+		if rel.Type == "performance" && rel.Work != nil {
+			r.WorkID = rel.Work.ID
+			if len(rel.Work.ISWCs) > 0 {
+				r.ISWC = rel.Work.ISWCs[0]
+			}
+			break
+		}
+	}
+	return r, nil
+}
+
+// RecordingByISRC returns the recording an ISRC is assigned to; when several are, the first is used
+func (m *MusicBrainz) RecordingByISRC(ctx context.Context, isrc string) (Recording, error) {
+	var result struct {
+		Recordings []mbRecording `json:"recordings"`
+	}
+	if err := m.get(ctx, "/isrc/"+url.PathEscape(isrc), url.Values{}, &result); err != nil {
+		return Recording{}, err
+	}
+	if len(result.Recordings) == 0 {
+		return Recording{}, ErrRecordingNotFound
+	}
+	return m.Recording(ctx, result.Recordings[0].ID)
+}
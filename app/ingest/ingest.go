@@ -0,0 +1,196 @@
+// Package ingest bootstraps the library from audio files, creating songs from their tags
+package ingest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"io"
+	"song_library/audiotags"
+	"song_library/db"
+	"song_library/events"
+	"song_library/lyrics"
+	"song_library/models"
+	"strings"
+)
+
+var (
+	ErrSongNotFound = errors.New("song not found")
+	ErrNoTitle      = errors.New("the file has no artist and title tags, nor a name like \"Artist - Title.mp3\"")
+	ErrInvalid      = errors.New("invalid tags")
+)
+
+// Ingest reads an audio file and records it against the song its tags describe. A song with the
+// same ISRC, or the same group and song ignoring case, gets the file and the values it lacks:
+// release date, duration, BPM, key, ISRC and lyrics. Otherwise a song is created from the tags. A
+// file whose content or audio was ingested before is skipped as a duplicate.
+//
+// Files that cannot be ingested are reported in the result with status failed; the error is for
+// failures of the database or of reading r.
+func Ingest(ctx context.Context, filename string, r io.ReaderAt, size int64, ingestedBy string) (models.IngestResult, error) {
+	result := models.IngestResult{File: filename, Status: models.IngestFailed}
+	f, err := audiotags.Read(r, size)
+	if errors.Is(err, audiotags.ErrUnsupported) || errors.Is(err, audiotags.ErrInvalid) {
+		result.Error = err.Error()
+		return result, nil
+	} else if err != nil {
+		return result, err
+	}
+	result.SHA256 = f.SHA256
+	s, language, err := song(f, filename)
+	if err != nil {
+		result.Error = err.Error()
+		return result, nil
+	}
+	result.Group, result.Song, result.Duration = s.Group, s.Song, s.Duration
+
+	err = db.InTx(ctx, func(tx *sql.Tx) error {
+		// Ingests of the same song queue up, so that only the first creates it
+		if _, err := tx.ExecContext(ctx, "SELECT pg_advisory_xact_lock(hashtext(lower($1) || chr(0) || lower($2)))", s.Group, s.Song); err != nil {
+			return err
+		}
+		err := tx.QueryRowContext(ctx, `
+			SELECT song_id FROM song_files WHERE sha256 = $1 OR audio_sha256 = $2 ORDER BY sha256 = $1 DESC LIMIT 1
+		`, f.SHA256, f.AudioSHA256).Scan(&result.SongID)
+		if err == nil {
+			result.Status = models.IngestDuplicate
+			return nil
+		} else if !errors.Is(err, sql.ErrNoRows) {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			SELECT song_id FROM songs
+			WHERE isrc = NULLIF($3, '') OR (lower(group_name) = lower($1) AND lower(song_name) = lower($2))
+			ORDER BY (isrc = NULLIF($3, '')) IS TRUE DESC, song_id
+			LIMIT 1 FOR UPDATE
+		`, s.Group, s.Song, s.ISRC).Scan(&result.SongID)
+		event := models.EventSongUpdated
+		switch {
+		case err == nil:
+			result.Status = models.IngestMatched
+			err = complete(ctx, tx, result.SongID, s, language)
+		case errors.Is(err, sql.ErrNoRows):
+			result.Status, event = models.IngestCreated, models.EventSongCreated
+			result.SongID, err = create(ctx, tx, s, language)
+		}
+		if err != nil {
+			return err
+		}
+		if err := events.Emit(ctx, tx, event, result.SongID); err != nil {
+			return err
+		}
+
+		var durationMs *int
+		if f.Duration > 0 {
+			ms := int(f.Duration.Milliseconds())
+			durationMs = &ms
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO song_files (song_id, filename, format, size, duration_ms, sample_rate, bitrate, album, sha256, audio_sha256, ingested_by)
+			VALUES ($1, $2, $3, $4, $5, NULLIF($6, 0), NULLIF($7, 0), NULLIF($8, ''), $9, $10, NULLIF($11, ''))
+		`, result.SongID, cleanFilename(filename), f.Format, size, durationMs, f.SampleRate, f.Bitrate, f.Tags.Album,
+			f.SHA256, f.AudioSHA256, ingestedBy)
+		return err
+	})
+	if err != nil {
+		result.Status, result.SongID = models.IngestFailed, 0
+		return result, err
+	}
+	return result, nil
+}
+
+// create adds a song made from tags
+func create(ctx context.Context, tx *sql.Tx, s models.Song, language string) (int, error) {
+	var songID int
+	err := tx.QueryRowContext(ctx, `
+		INSERT INTO songs (group_name, song_name, release_date, lyrics, link, isrc, duration_seconds, bpm, musical_key)
+		VALUES ($1, $2, $3, $4, '', NULLIF($5, ''), $6, $7, NULLIF($8, ''))
+		RETURNING song_id
+	`, s.Group, s.Song, s.ReleaseDate, s.Text, s.ISRC, s.Duration, s.BPM, s.Key).Scan(&songID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := lyrics.Replace(ctx, tx, songID, lyrics.Parse(s.Text)); err != nil {
+		return 0, err
+	}
+	if language != "" && s.Text != "" {
+		if err := lyrics.Relabel(ctx, tx, songID, language); err != nil {
+			return 0, err
+		}
+	}
+	return songID, nil
+}
+
+// complete fills in the values an existing song lacks from tags. An ISRC already held by another
+// song is left out.
+func complete(ctx context.Context, tx *sql.Tx, songID int, s models.Song, language string) error {
+	var needsLyrics bool
+	err := tx.QueryRowContext(ctx, `
+		UPDATE songs SET
+			release_date = COALESCE(NULLIF(release_date, ''), $2),
+			duration_seconds = COALESCE(duration_seconds, $3),
+			bpm = COALESCE(bpm, $4),
+			musical_key = COALESCE(musical_key, NULLIF($5, '')),
+			isrc = COALESCE(isrc, (SELECT NULLIF($6::varchar, '') WHERE NOT EXISTS (SELECT 1 FROM songs WHERE isrc = $6::varchar)))
+		WHERE song_id = $1
+		RETURNING COALESCE(lyrics, '') = '' AND NOT EXISTS (SELECT 1 FROM lyrics_sections WHERE song_id = $1)
+	`, songID, s.ReleaseDate, s.Duration, s.BPM, s.Key, s.ISRC).Scan(&needsLyrics)
+	if err != nil || !needsLyrics || s.Text == "" {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "UPDATE songs SET lyrics = $2 WHERE song_id = $1", songID, s.Text); err != nil {
+		return err
+	}
+	if _, err := lyrics.Replace(ctx, tx, songID, lyrics.Parse(s.Text)); err != nil {
+		return err
+	}
+	if language == "" {
+		return nil
+	}
+	// A translation into the language of the tags is kept, leaving the new lyrics unlabelled
+	if err := lyrics.Relabel(ctx, tx, songID, language); !errors.Is(err, lyrics.ErrLanguageConflict) {
+		return err
+	}
+	return nil
+}
+
+// cleanFilename keeps the base name of a file, within the length of song_files.filename
+func cleanFilename(name string) string {
+	name = name[strings.LastIndexAny(name, `/\`)+1:]
+	if runes := []rune(name); len(runes) > 255 {
+		name = string(runes[len(runes)-255:])
+	}
+	return name
+}
+
+const fileColumns = `file_id, song_id, filename, format, size, duration_ms, sample_rate, bitrate, COALESCE(album, ''),
+	sha256, audio_sha256, COALESCE(ingested_by, ''), created_at`
+
+// Files returns the audio files ingested for a song, oldest first
+func Files(ctx context.Context, songID int) ([]models.SongFile, error) {
+	err := db.Reader().QueryRowContext(ctx, "SELECT song_id FROM songs WHERE song_id = $1", songID).Scan(&songID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSongNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Reader().QueryContext(ctx, "SELECT "+fileColumns+" FROM song_files WHERE song_id = $1 ORDER BY file_id", songID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	files := []models.SongFile{}
+	for rows.Next() {
+		var f models.SongFile
+		if err := rows.Scan(&f.ID, &f.SongID, &f.Filename, &f.Format, &f.Size, &f.DurationMs, &f.SampleRate, &f.Bitrate,
+			&f.Album, &f.SHA256, &f.AudioSHA256, &f.IngestedBy, &f.CreatedAt); err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, rows.Err()
+}
@@ -0,0 +1,101 @@
+package ingest
+
+import (
+	"fmt"
+	"math"
+	"path/filepath"
+	"song_library/audiotags"
+	"song_library/identifiers"
+	"song_library/lyrics"
+	"song_library/metadata"
+	"song_library/models"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// maxNameLength matches songs.group_name and songs.song_name
+const maxNameLength = 64
+
+// iso6391 maps the ISO 639-2 codes ID3 tags use to the two-letter codes of the most common languages,
+// which the library prefers. ISO 639-2 has two codes for some of them.
+var iso6391 = map[string]string{
+	"ara": "ar", "ces": "cs", "cze": "cs", "chi": "zh", "dan": "da", "deu": "de", "dut": "nl", "ell": "el",
+	"eng": "en", "fin": "fi", "fra": "fr", "fre": "fr", "ger": "de", "gre": "el", "heb": "he", "hin": "hi",
+	"hun": "hu", "ita": "it", "jpn": "ja", "kor": "ko", "nld": "nl", "nor": "no", "pol": "pl", "por": "pt",
+	"ron": "ro", "rum": "ro", "rus": "ru", "spa": "es", "swe": "sv", "tur": "tr", "ukr": "uk", "zho": "zh",
+}
+
+// language returns the ISO 639 code of a language written in tags, or "" if it is unknown
+func language(tag string) string {
+	code, ok := lyrics.NormalizeLanguage(tag)
+	if !ok || code == lyrics.Undetermined || code == "xxx" || code == "zxx" {
+		return ""
+	}
+	if short, ok := iso6391[code]; ok {
+		return short
+	}
+	return code
+}
+
+// releaseDate formats a tag date as the library does, DD.MM.YYYY, or as the year alone when the
+// day is unknown
+func releaseDate(date string) string {
+	if t, err := time.Parse("2006-01-02", date[:min(len(date), 10)]); err == nil {
+		return t.Format("02.01.2006")
+	}
+	if len(date) >= 4 {
+		if _, err := strconv.Atoi(date[:4]); err == nil {
+			return date[:4]
+		}
+	}
+	return ""
+}
+
+// fromFilename splits a file named "Artist - Title.mp3" into artist and title
+func fromFilename(filename string) (string, string) {
+	name := strings.TrimSuffix(filepath.Base(filename), filepath.Ext(filename))
+	artist, title, ok := strings.Cut(name, " - ")
+	if !ok {
+		return "", ""
+	}
+	return strings.TrimSpace(artist), strings.TrimSpace(title)
+}
+
+// song maps the tags of a file to a song and the language of its lyrics. Title and artist missing
+// from the tags are taken from a file name of the form "Artist - Title"; tag values the library
+// would reject, such as a malformed ISRC or key, are left out.
+func song(f audiotags.File, filename string) (models.Song, string, error) {
+	s := models.Song{Group: f.Tags.Artist, Song: f.Tags.Title, Text: f.Tags.Lyrics, ReleaseDate: releaseDate(f.Tags.Date)}
+	if s.Group == "" || s.Song == "" {
+		artist, title := fromFilename(filename)
+		if s.Group == "" {
+			s.Group = artist
+		}
+		if s.Song == "" {
+			s.Song = title
+		}
+	}
+	if s.Group == "" || s.Song == "" {
+		return models.Song{}, "", ErrNoTitle
+	}
+	if utf8.RuneCountInString(s.Group) > maxNameLength || utf8.RuneCountInString(s.Song) > maxNameLength {
+		return models.Song{}, "", fmt.Errorf("%w: artist and title are limited to %d characters", ErrInvalid, maxNameLength)
+	}
+
+	if isrc, err := identifiers.NormalizeISRC(f.Tags.ISRC); err == nil {
+		s.ISRC = isrc
+	}
+	if f.Duration > 0 {
+		seconds := max(1, int(math.Round(f.Duration.Seconds())))
+		s.Duration = &seconds
+	}
+	if bpm, err := strconv.ParseFloat(strings.ReplaceAll(f.Tags.BPM, ",", "."), 64); err == nil && bpm > 0 && bpm < metadata.MaxBPM {
+		s.BPM = &bpm
+	}
+	if key, err := metadata.NormalizeKey(f.Tags.Key); err == nil {
+		s.Key = key
+	}
+	return s, language(f.Tags.LyricsLanguage), nil
+}
@@ -0,0 +1,216 @@
+package links
+
+import (
+	"context"
+	"net/http"
+	"song_library/config"
+	"song_library/db"
+	"song_library/models"
+	"song_library/publicnet"
+	"song_library/worker"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// checkBatch is the most links a round claims
+	checkBatch = 100
+	// deadAfter is the number of failed checks in a row after which a link is dead
+	deadAfter = 3
+	userAgent = "song_library link checker"
+)
+
+// Checker periodically checks that song links still resolve
+type Checker struct {
+	client       *http.Client
+	interval     time.Duration
+	concurrency  int
+	recheckAfter time.Duration
+}
+
+// NewChecker builds a checker from the configuration
+func NewChecker(cfg *config.Config) *Checker {
+	return &Checker{
+		client:       publicnet.NewClient(cfg.LinkCheckTimeout),
+		interval:     cfg.LinkCheckInterval,
+		concurrency:  cfg.LinkCheckConcurrency,
+		recheckAfter: cfg.LinkRecheckAfter,
+	}
+}
+
+// Start runs the checker in the worker group unless links.check_interval is 0
+func (ch *Checker) Start(workers *worker.Group) {
+	if ch.interval <= 0 {
+		log.Info().Msg("Link checker is disabled")
+		return
+	}
+	workers.Go("link-checker", ch.run)
+}
+
+func (ch *Checker) run(ctx context.Context) {
+	ticker := time.NewTicker(ch.interval)
+	defer ticker.Stop()
+	for {
+		// Keep checking while full batches are due, then wait for the next tick
+		for {
+			checked, err := ch.Round(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Msg("Error checking song links")
+			}
+			if err != nil || checked < checkBatch {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+type due struct {
+	id  int
+	url string
+}
+
+// Round claims the links due a check and checks them, at most concurrency at a time, and returns
+// how many it checked. Claiming stamps last_checked_at, so checkers on other replicas skip them.
+func (ch *Checker) Round(ctx context.Context) (int, error) {
+	rows, err := db.Db.QueryContext(ctx, `
+		UPDATE song_links SET last_checked_at = NOW()
+		WHERE link_id IN (
+			SELECT link_id FROM song_links
+			WHERE last_checked_at IS NULL OR last_checked_at < NOW() - $1 * INTERVAL '1 second'
+			ORDER BY last_checked_at NULLS FIRST
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING link_id, url
+	`, ch.recheckAfter.Seconds(), checkBatch)
+	if err != nil {
+		return 0, err
+	}
+	var batch []due
+	for rows.Next() {
+		var d due
+		if err := rows.Scan(&d.id, &d.url); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, d)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	jobs := make(chan due)
+	var wg sync.WaitGroup
+	for range min(ch.concurrency, len(batch)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for d := range jobs {
+				status, err := ch.check(ctx, d.url)
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := record(ctx, d.id, status, err); err != nil {
+					log.Error().Err(err).Int("link_id", d.id).Msg("Error recording link check")
+				}
+			}
+		}()
+	}
+	for _, d := range batch {
+		select {
+		case jobs <- d:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	log.Debug().Int("count", len(batch)).Msg("Checked song links")
+	return len(batch), ctx.Err()
+}
+
+// check requests a URL with HEAD, or a one-byte GET when the server does not support HEAD, and
+// returns the final status after redirects
+func (ch *Checker) check(ctx context.Context, rawURL string) (int, error) {
+	status, err := ch.request(ctx, http.MethodHead, rawURL)
+	if err == nil && (status == http.StatusMethodNotAllowed || status == http.StatusNotImplemented) {
+		status, err = ch.request(ctx, http.MethodGet, rawURL)
+	}
+	return status, err
+}
+
+func (ch *Checker) request(ctx context.Context, method, rawURL string) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+	if method == http.MethodGet {
+		req.Header.Set("Range", "bytes=0-0")
+	}
+	resp, err := ch.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return resp.StatusCode, nil
+}
+
+// alive reports whether a check succeeded; rate limiting says nothing about the link, so it neither
+// counts as a success nor as a failure
+func alive(status int, err error) (ok, conclusive bool) {
+	switch {
+	case err != nil:
+		return false, true
+	case status == http.StatusTooManyRequests:
+		return false, false
+	default:
+		return status < 400, true
+	}
+}
+
+// record stores the result of a check and marks the link dead after deadAfter failures in a row
+func record(ctx context.Context, linkID, status int, checkErr error) error {
+	ok, conclusive := alive(status, checkErr)
+	var lastStatus *int
+	if status != 0 {
+		lastStatus = &status
+	}
+	var lastError *string
+	if checkErr != nil {
+		message := publicnet.ErrorMessage(checkErr)
+		lastError = &message
+	}
+	_, err := db.Db.ExecContext(ctx, `
+		UPDATE song_links SET
+			last_checked_at = NOW(), last_status = $2, last_error = $3,
+			failures = CASE WHEN NOT $5 THEN failures WHEN $4 THEN 0 ELSE failures + 1 END,
+			dead = CASE WHEN NOT $5 THEN dead WHEN $4 THEN FALSE ELSE failures + 1 >= $6 END
+		WHERE link_id = $1
+	`, linkID, lastStatus, lastError, ok, conclusive, deadAfter)
+	return err
+}
+
+// CheckNow checks one link of a song right away and returns it with the result
+func (ch *Checker) CheckNow(ctx context.Context, songID, linkID int) (models.SongLink, error) {
+	l, err := get(ctx, db.Db, songID, linkID)
+	if err != nil {
+		return models.SongLink{}, err
+	}
+	status, checkErr := ch.check(ctx, l.URL)
+	if err := ctx.Err(); err != nil {
+		return models.SongLink{}, err
+	}
+	if err := record(ctx, linkID, status, checkErr); err != nil {
+		return models.SongLink{}, err
+	}
+	return get(ctx, db.Db, songID, linkID)
+}
@@ -0,0 +1,257 @@
+package links
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net/url"
+	"slices"
+	"song_library/db"
+	"song_library/models"
+	"song_library/publicnet"
+	"strings"
+)
+
+var (
+	ErrSongNotFound = errors.New("song not found")
+	ErrNotFound     = errors.New("link not found")
+	ErrInvalid      = errors.New("invalid link")
+)
+
+// maxURLLength bounds link URLs; longer ones are not served by common browsers and CDNs anyway
+const maxURLLength = 2048
+
+// providerHosts maps the registered domains of providers to their names
+var providerHosts = map[string]string{
+	"youtube.com":      models.ProviderYouTube,
+	"youtu.be":         models.ProviderYouTube,
+	"spotify.com":      models.ProviderSpotify,
+	"music.apple.com":  models.ProviderAppleMusic,
+	"soundcloud.com":   models.ProviderSoundCloud,
+	"deezer.com":       models.ProviderDeezer,
+	"music.yandex.ru":  models.ProviderYandexMusic,
+	"music.yandex.com": models.ProviderYandexMusic,
+	"bandcamp.com":     models.ProviderBandcamp,
+	"genius.com":       models.ProviderGenius,
+}
+
+// DetectProvider names the provider of a URL from its host, or other
+func DetectProvider(u *url.URL) string {
+	host := strings.TrimSuffix(strings.ToLower(u.Hostname()), ".")
+	for host != "" {
+		if provider, ok := providerHosts[host]; ok {
+			return provider
+		}
+		_, host, _ = strings.Cut(host, ".")
+	}
+	return models.ProviderOther
+}
+
+// DefaultKind is the kind of content a provider's links usually point to
+func DefaultKind(provider string) string {
+	switch provider {
+	case models.ProviderYouTube:
+		return models.LinkVideo
+	case models.ProviderGenius:
+		return models.LinkLyricsSource
+	case models.ProviderOther:
+		return models.LinkOther
+	default:
+		return models.LinkAudio
+	}
+}
+
+// normalize checks the URL, provider and kind of a link, detecting the provider and kind when empty
+func normalize(l *models.SongLink) error {
+	l.URL = strings.TrimSpace(l.URL)
+	u, err := url.Parse(l.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalid)
+	}
+	if !publicnet.AllowedHost(u.Hostname()) {
+		return fmt.Errorf("%w: url must point to a public address", ErrInvalid)
+	}
+	if len(l.URL) > maxURLLength {
+		return fmt.Errorf("%w: url is longer than %d characters", ErrInvalid, maxURLLength)
+	}
+
+	l.Provider = strings.ToLower(strings.TrimSpace(l.Provider))
+	if l.Provider == "" {
+		l.Provider = DetectProvider(u)
+	}
+	if !slices.Contains(models.LinkProviders, l.Provider) {
+		return fmt.Errorf("%w: provider must be one of %v", ErrInvalid, models.LinkProviders)
+	}
+	l.Kind = strings.ToLower(strings.TrimSpace(l.Kind))
+	if l.Kind == "" {
+		l.Kind = DefaultKind(l.Provider)
+	}
+	if !slices.Contains(models.LinkKinds, l.Kind) {
+		return fmt.Errorf("%w: kind must be one of %v", ErrInvalid, models.LinkKinds)
+	}
+	return nil
+}
+
+// querier is satisfied by *sql.DB and *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+const linkColumns = `link_id, song_id, url, provider, kind, created_at, last_checked_at, last_status,
+	COALESCE(last_error, ''), failures, dead`
+
+func scanLink(scan func(dest ...any) error) (models.SongLink, error) {
+	var l models.SongLink
+	err := scan(&l.ID, &l.SongID, &l.URL, &l.Provider, &l.Kind, &l.CreatedAt, &l.LastCheckedAt, &l.LastStatus,
+		&l.LastError, &l.Failures, &l.Dead)
+	return l, err
+}
+
+func queryLinks(ctx context.Context, q querier, query string, args ...any) ([]models.SongLink, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []models.SongLink{}
+	for rows.Next() {
+		l, err := scanLink(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, l)
+	}
+	return list, rows.Err()
+}
+
+func get(ctx context.Context, q querier, songID, linkID int) (models.SongLink, error) {
+	l, err := scanLink(q.QueryRowContext(ctx, "SELECT "+linkColumns+" FROM song_links WHERE song_id = $1 AND link_id = $2", songID, linkID).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.SongLink{}, ErrNotFound
+	}
+	return l, err
+}
+
+// checkSong returns ErrSongNotFound unless the song exists; lock is appended to the lookup
+func checkSong(ctx context.Context, q querier, songID int, lock string) error {
+	err := q.QueryRowContext(ctx, "SELECT song_id FROM songs WHERE song_id = $1"+lock, songID).Scan(&songID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSongNotFound
+	}
+	return err
+}
+
+// List returns the links of a song in the order they were added
+func List(ctx context.Context, songID int) ([]models.SongLink, error) {
+	if err := checkSong(ctx, db.Reader(), songID, ""); err != nil {
+		return nil, err
+	}
+	return queryLinks(ctx, db.Reader(), "SELECT "+linkColumns+" FROM song_links WHERE song_id = $1 ORDER BY link_id", songID)
+}
+
+// ListDead returns a page of the dead links of the library, most recently checked first
+func ListDead(ctx context.Context, limit, offset int) ([]models.SongLink, error) {
+	return queryLinks(ctx, db.Reader(), "SELECT "+linkColumns+`
+		FROM song_links WHERE dead ORDER BY last_checked_at DESC, link_id LIMIT $1 OFFSET $2
+	`, limit, offset)
+}
+
+// Get returns a link of a song
+func Get(ctx context.Context, songID, linkID int) (models.SongLink, error) {
+	return get(ctx, db.Reader(), songID, linkID)
+}
+
+// Create adds a link to a song; provider and kind are detected from the URL when empty
+func Create(ctx context.Context, songID int, l models.SongLink) (models.SongLink, error) {
+	if err := normalize(&l); err != nil {
+		return models.SongLink{}, err
+	}
+	var created models.SongLink
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		if err := checkSong(ctx, tx, songID, " FOR SHARE"); err != nil {
+			return err
+		}
+		var err error
+		created, err = scanLink(tx.QueryRowContext(ctx, `
+			INSERT INTO song_links (song_id, url, provider, kind) VALUES ($1, $2, $3, $4)
+			RETURNING `+linkColumns, songID, l.URL, l.Provider, l.Kind).Scan)
+		return err
+	})
+	return created, err
+}
+
+// Save records the legacy single link of a song as one of its links, keeping an existing one
+func Save(ctx context.Context, tx *sql.Tx, songID int, rawURL string) error {
+	l := models.SongLink{URL: rawURL}
+	if rawURL == "" || normalize(&l) != nil {
+		return nil
+	}
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO song_links (song_id, url, provider, kind) VALUES ($1, $2, $3, $4) ON CONFLICT DO NOTHING
+	`, songID, l.URL, l.Provider, l.Kind)
+	return err
+}
+
+// Changes are the link fields to update; nil fields are kept
+type Changes struct {
+	URL      *string `json:"url"`
+	Provider *string `json:"provider"`
+	Kind     *string `json:"kind"`
+}
+
+// Update changes a link; a new URL is checked again from scratch
+func Update(ctx context.Context, songID, linkID int, changes Changes) (models.SongLink, error) {
+	var updated models.SongLink
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		l, err := get(ctx, tx, songID, linkID)
+		if err != nil {
+			return err
+		}
+		urlChanged := changes.URL != nil && *changes.URL != l.URL
+		if changes.URL != nil {
+			l.URL = *changes.URL
+			if urlChanged && changes.Provider == nil {
+				// Let the new URL determine the provider and kind
+				l.Provider, l.Kind = "", ""
+			}
+		}
+		if changes.Provider != nil {
+			l.Provider = *changes.Provider
+		}
+		if changes.Kind != nil {
+			l.Kind = *changes.Kind
+		}
+		if err := normalize(&l); err != nil {
+			return err
+		}
+
+		updated, err = scanLink(tx.QueryRowContext(ctx, `
+			UPDATE song_links SET url = $3, provider = $4, kind = $5,
+				last_checked_at = CASE WHEN $6 THEN NULL ELSE last_checked_at END,
+				last_status = CASE WHEN $6 THEN NULL ELSE last_status END,
+				last_error = CASE WHEN $6 THEN NULL ELSE last_error END,
+				failures = CASE WHEN $6 THEN 0 ELSE failures END,
+				dead = dead AND NOT $6
+			WHERE song_id = $1 AND link_id = $2
+			RETURNING `+linkColumns, songID, linkID, l.URL, l.Provider, l.Kind, urlChanged).Scan)
+		return err
+	})
+	return updated, err
+}
+
+// Delete removes a link from a song
+func Delete(ctx context.Context, songID, linkID int) error {
+	result, err := db.Db.ExecContext(ctx, "DELETE FROM song_links WHERE song_id = $1 AND link_id = $2", songID, linkID)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
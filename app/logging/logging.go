@@ -0,0 +1,48 @@
+package logging
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"os"
+	"strings"
+)
+
+// Setup configures the global logger; level accepts any zerolog level name and format is "json" or "console"
+func Setup(level, format string) error {
+	parsed, err := zerolog.ParseLevel(strings.ToLower(level))
+	if err != nil {
+		return fmt.Errorf("invalid LOG_LEVEL %q: %w", level, err)
+	}
+	zerolog.SetGlobalLevel(parsed)
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+
+	switch format {
+	case "", "json":
+		log.Logger = zerolog.New(os.Stdout).With().Timestamp().Logger()
+	case "console":
+		log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
+	default:
+		return fmt.Errorf("invalid LOG_FORMAT %q, expected json or console", format)
+	}
+
+	// Code paths without a request logger fall back to the global one instead of discarding events
+	zerolog.DefaultContextLogger = &log.Logger
+	return nil
+}
+
+// Ctx returns the logger of the current request
+func Ctx(c *gin.Context) *zerolog.Logger {
+	return zerolog.Ctx(c.Request.Context())
+}
+
+// With adds fields to the logger of the current request for all subsequent events
+func With(c *gin.Context, update func(zerolog.Context) zerolog.Context) {
+	logger := Ctx(c)
+	if logger == zerolog.DefaultContextLogger {
+		// Never mutate the global logger from a request that has no logger of its own
+		return
+	}
+	logger.UpdateContext(update)
+}
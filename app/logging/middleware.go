@@ -0,0 +1,87 @@
+package logging
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.opentelemetry.io/otel/trace"
+	"time"
+)
+
+const RequestIDHeader = "X-Request-ID"
+
+const requestIDKey = "request_id"
+
+// validRequestID accepts short printable IDs so that clients cannot inject arbitrary data into logs
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, r := range id {
+		if r < 0x21 || r > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// RequestID returns the ID of the current request
+func RequestID(c *gin.Context) string {
+	return c.GetString(requestIDKey)
+}
+
+// Middleware assigns a request ID, honoring an incoming X-Request-ID, attaches a request-scoped
+// logger to the request context and writes one access log event per request
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		c.Set(requestIDKey, id)
+		c.Header(RequestIDHeader, id)
+
+		logCtx := log.Logger.With().
+			Str("request_id", id).
+			Str("method", c.Request.Method).
+			Str("route", c.FullPath())
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+			logCtx = logCtx.Str("trace_id", span.TraceID().String())
+		}
+		c.Request = c.Request.WithContext(logCtx.Logger().WithContext(c.Request.Context()))
+
+		c.Next()
+
+		// Fetch the logger again: downstream middleware may have added fields such as the principal
+		logger := Ctx(c)
+		status := c.Writer.Status()
+		var event *zerolog.Event
+		switch {
+		case status >= 500:
+			event = logger.Error()
+		case status >= 400:
+			event = logger.Warn()
+		default:
+			event = logger.Info()
+		}
+		if len(c.Errors) > 0 {
+			event = event.Str("errors", c.Errors.String())
+		}
+		event.
+			Int("status", status).
+			Dur("latency", time.Since(start)).
+			Str("client_ip", c.ClientIP()).
+			Int("bytes", c.Writer.Size()).
+			Msg("Request completed")
+	}
+}
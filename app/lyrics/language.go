@@ -0,0 +1,70 @@
+package lyrics
+
+import (
+	"regexp"
+	"song_library/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Undetermined is the ISO 639-2 code of lyrics whose language is not known
+const Undetermined = "und"
+
+var languagePattern = regexp.MustCompile(`^[a-z]{2,3}$`)
+
+// NormalizeLanguage returns the lowercase ISO 639 code of a language tag such as "EN" or "pt-BR",
+// and whether it is well-formed
+func NormalizeLanguage(tag string) (string, bool) {
+	code, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(tag)), "-")
+	code, _, _ = strings.Cut(code, "_")
+	return code, languagePattern.MatchString(code)
+}
+
+// acceptLanguages returns the language codes of an Accept-Language header, most preferred first
+func acceptLanguages(header string) []string {
+	type weighted struct {
+		code string
+		q    float64
+	}
+	var tags []weighted
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(part, ";")
+		code, ok := NormalizeLanguage(tag)
+		if !ok {
+			continue
+		}
+		q := 1.0
+		if value, found := strings.CutPrefix(strings.TrimSpace(params), "q="); found {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weighted{code, q})
+		}
+	}
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+
+	codes := make([]string, len(tags))
+	for i, t := range tags {
+		codes[i] = t.code
+	}
+	return codes
+}
+
+// Negotiate picks the lyrics language for a request: the requested language if the song has it,
+// then the Accept-Language preferences, then the original. It reports false when the song has no lyrics.
+func Negotiate(langs []models.LyricsLanguage, requested, acceptLanguage string) (models.LyricsLanguage, bool) {
+	var candidates []string
+	if code, ok := NormalizeLanguage(requested); ok {
+		candidates = append(candidates, code)
+	}
+	candidates = append(candidates, acceptLanguages(acceptLanguage)...)
+	for _, code := range candidates {
+		if lang, ok := find(langs, code); ok {
+			return lang, true
+		}
+	}
+	return find(langs, "")
+}
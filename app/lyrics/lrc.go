@@ -0,0 +1,219 @@
+package lyrics
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"regexp"
+	"song_library/models"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalidLRC = errors.New("invalid LRC")
+
+var (
+	// tagPattern matches a leading [..] tag: a timestamp or an ID tag such as [ar:Artist]
+	tagPattern = regexp.MustCompile(`^\[([^\[\]]*)\]`)
+	// wordPattern matches an enhanced LRC word timestamp
+	wordPattern = regexp.MustCompile(`<(\d+:\d{1,2}(?:[.:]\d{1,3})?)>`)
+	// idTagPattern matches the key of an ID tag
+	idTagPattern = regexp.MustCompile(`^([a-zA-Z#]+):(.*)$`)
+)
+
+// ParseTimestamp parses mm:ss, mm:ss.xx or mm:ss.xxx, or plain seconds such as 75.3, into milliseconds
+// that fit an int32
+func ParseTimestamp(s string) (int, error) {
+	s = strings.TrimSpace(s)
+	minutes, rest, found := strings.Cut(s, ":")
+	if !found {
+		minutes, rest = "0", s
+	}
+	// Some LRC files separate hundredths with a colon: [01:02:50]
+	if found {
+		rest = strings.Replace(rest, ":", ".", 1)
+	}
+	// ParseFloat and Atoi would also take signs, exponents, NaN and Inf
+	if !isDecimal(minutes, false) || !isDecimal(rest, true) {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	m, err := strconv.Atoi(minutes)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	seconds, err := strconv.ParseFloat(rest, 64)
+	if err != nil || math.IsInf(seconds, 0) || math.IsNaN(seconds) || (found && seconds >= 60) {
+		return 0, fmt.Errorf("invalid timestamp %q", s)
+	}
+	ms := float64(m)*60_000 + math.Round(seconds*1000)
+	if ms > math.MaxInt32 {
+		return 0, fmt.Errorf("timestamp %q is too large", s)
+	}
+	return int(ms), nil
+}
+
+// isDecimal reports whether s is a non-empty run of digits with, if dot is set, one decimal point
+func isDecimal(s string, dot bool) bool {
+	digits := 0
+	for _, r := range s {
+		switch {
+		case r >= '0' && r <= '9':
+			digits++
+		case r == '.' && dot:
+			dot = false
+		default:
+			return false
+		}
+	}
+	return digits > 0
+}
+
+// FormatTimestamp formats milliseconds as an LRC timestamp, mm:ss.xx
+func FormatTimestamp(ms int) string {
+	return fmt.Sprintf("%02d:%02d.%02d", ms/60_000, ms/1000%60, ms/10%100)
+}
+
+// parseWords splits a line with enhanced <mm:ss.xx> word tags into words and returns the plain text
+func parseWords(text string) ([]models.SyncedWord, string, error) {
+	matches := wordPattern.FindAllStringSubmatchIndex(text, -1)
+	if matches == nil {
+		return nil, strings.TrimSpace(text), nil
+	}
+
+	var words []models.SyncedWord
+	plain := []string{}
+	if lead := strings.TrimSpace(text[:matches[0][0]]); lead != "" {
+		plain = append(plain, lead)
+	}
+	for i, m := range matches {
+		ms, err := ParseTimestamp(text[m[2]:m[3]])
+		if err != nil {
+			return nil, "", err
+		}
+		end := len(text)
+		if i+1 < len(matches) {
+			end = matches[i+1][0]
+		}
+		word := strings.TrimSpace(text[m[1]:end])
+		if word == "" {
+			// A trailing tag only marks when the last word ends
+			continue
+		}
+		words = append(words, models.SyncedWord{TimeMs: ms, Text: word})
+		plain = append(plain, word)
+	}
+	return words, strings.Join(plain, " "), nil
+}
+
+// ParseLRC reads LRC, including enhanced word-level tags, into lines ordered by time. Lines with
+// several timestamps are repeated at each of them and the [offset:] tag is applied. ID tags such
+// as [ar:] and [ti:] are returned by key.
+func ParseLRC(text string) ([]models.SyncedLine, map[string]string, error) {
+	tags := map[string]string{}
+	type timedLine struct {
+		times []int
+		text  string
+	}
+	var timed []timedLine
+
+	for n, line := range strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n") {
+		line = strings.TrimSpace(line)
+		var times []int
+		for {
+			m := tagPattern.FindStringSubmatch(line)
+			if m == nil {
+				break
+			}
+			if ms, err := ParseTimestamp(m[1]); err == nil {
+				times = append(times, ms)
+			} else if tag := idTagPattern.FindStringSubmatch(m[1]); tag != nil && len(times) == 0 {
+				tags[strings.ToLower(tag[1])] = strings.TrimSpace(tag[2])
+			} else {
+				return nil, nil, fmt.Errorf("%w: line %d: unknown tag [%s]", ErrInvalidLRC, n+1, m[1])
+			}
+			line = line[len(m[0]):]
+		}
+		if len(times) > 0 {
+			timed = append(timed, timedLine{times: times, text: line})
+		}
+	}
+
+	offset := 0
+	if raw, ok := tags["offset"]; ok {
+		var err error
+		if offset, err = strconv.Atoi(strings.TrimPrefix(raw, "+")); err != nil {
+			return nil, nil, fmt.Errorf("%w: offset %q is not a number of milliseconds", ErrInvalidLRC, raw)
+		}
+	}
+
+	var lines []models.SyncedLine
+	for _, t := range timed {
+		words, plain, err := parseWords(t.text)
+		if err != nil {
+			return nil, nil, fmt.Errorf("%w: %v", ErrInvalidLRC, err)
+		}
+		for _, at := range t.times {
+			// A positive offset shows lyrics sooner
+			ms := max(at-offset, 0)
+			// Word timings are written for the first timestamp of the line and move with the others
+			var shifted []models.SyncedWord
+			for _, word := range words {
+				shifted = append(shifted, models.SyncedWord{TimeMs: max(word.TimeMs+at-t.times[0]-offset, 0), Text: word.Text})
+			}
+			lines = append(lines, models.SyncedLine{TimeMs: ms, Time: FormatTimestamp(ms), Text: plain, Words: shifted})
+		}
+	}
+	if len(lines) == 0 {
+		return nil, nil, fmt.Errorf("%w: no timed lines", ErrInvalidLRC)
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].TimeMs < lines[j].TimeMs })
+	for i := range lines {
+		lines[i].Line = i + 1
+	}
+	delete(tags, "offset")
+	return lines, tags, nil
+}
+
+// lrcTagOrder is the order FormatLRC writes ID tags in
+var lrcTagOrder = []string{"ar", "ti", "al", "au", "by", "length", "re", "ve"}
+
+// FormatLRC writes lines as LRC, with word tags for lines that have word timings
+func FormatLRC(tags map[string]string, lines []models.SyncedLine) string {
+	var b strings.Builder
+	for _, key := range lrcTagOrder {
+		if value := tags[key]; value != "" {
+			fmt.Fprintf(&b, "[%s:%s]\n", key, value)
+		}
+	}
+	for _, line := range lines {
+		b.WriteString("[" + FormatTimestamp(line.TimeMs) + "]")
+		if len(line.Words) == 0 {
+			b.WriteString(line.Text)
+		} else if lead := leadText(line); lead != "" {
+			b.WriteString(lead + " ")
+		}
+		for i, word := range line.Words {
+			if i > 0 {
+				b.WriteString(" ")
+			}
+			b.WriteString("<" + FormatTimestamp(word.TimeMs) + ">" + word.Text)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
+
+// leadText returns the text of a line before its first timed word, as in [00:20.00]lead <00:20.50>word
+func leadText(line models.SyncedLine) string {
+	timed := make([]string, len(line.Words))
+	for i, word := range line.Words {
+		timed[i] = word.Text
+	}
+	lead, ok := strings.CutSuffix(line.Text, strings.Join(timed, " "))
+	if !ok {
+		return ""
+	}
+	return strings.TrimSpace(lead)
+}
@@ -0,0 +1,104 @@
+package lyrics
+
+import (
+	"errors"
+	"math"
+	"reflect"
+	"song_library/models"
+	"testing"
+)
+
+func TestParseTimestamp(t *testing.T) {
+	tests := []struct {
+		in   string
+		want int
+	}{
+		{"01:02.50", 62_500},
+		{"01:02.5", 62_500},
+		{"01:02.345", 62_345},
+		{"01:02", 62_000},
+		{"01:02:50", 62_500},
+		{"75.3", 75_300},
+		{" 0:00.00 ", 0},
+		{"35791:23.64", 2_147_483_640},
+	}
+	for _, tt := range tests {
+		got, err := ParseTimestamp(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTimestamp(%q) = %d, %v; want %d", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestParseTimestampInvalid(t *testing.T) {
+	for _, in := range []string{
+		"", ":", "01:", ":05", "abc", "NaN", "Inf", "+Inf", "0:NaN", "1e3", "0:1e1", "-1:00", "+1:00", "1:-5",
+		"01:60.00", "1.2.3", "0x10", "99999999:00", "35791:23.65", "2147483.648", "1:00:00:00",
+	} {
+		if ms, err := ParseTimestamp(in); err == nil {
+			t.Errorf("ParseTimestamp(%q) = %d, want an error", in, ms)
+		}
+	}
+	if ms, err := ParseTimestamp("2147483.647"); err != nil || ms != math.MaxInt32 {
+		t.Errorf("ParseTimestamp of the largest int32 = %d, %v", ms, err)
+	}
+}
+
+func TestLRCRoundTrip(t *testing.T) {
+	for _, lrc := range []string{
+		"[00:01.00]First line\n[00:05.50]Second line\n",
+		"[ar:Artist]\n[ti:Title]\n[00:01.00]Line\n",
+		"[00:10.00]<00:10.00>Every <00:10.50>word <00:11.20>timed\n",
+		"[00:20.00]lead <00:20.50>w\n",
+		"[00:20.00]Some lead text <00:21.00>then <00:21.40>words\n",
+		"[00:30.00]\n[00:31.00]After a pause\n",
+	} {
+		lines, tags, err := ParseLRC(lrc)
+		if err != nil {
+			t.Fatalf("ParseLRC(%q): %v", lrc, err)
+		}
+		if got := FormatLRC(tags, lines); got != lrc {
+			t.Errorf("round trip of %q = %q", lrc, got)
+		}
+	}
+}
+
+func TestParseLRCRepeatedLine(t *testing.T) {
+	lines, _, err := ParseLRC("[offset:+500]\n[00:10.00][00:30.00]<00:10.00>la <00:10.80>la\n[00:20.00]middle\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []models.SyncedLine{
+		{Line: 1, TimeMs: 9_500, Time: "00:09.50", Text: "la la",
+			Words: []models.SyncedWord{{TimeMs: 9_500, Text: "la"}, {TimeMs: 10_300, Text: "la"}}},
+		{Line: 2, TimeMs: 19_500, Time: "00:19.50", Text: "middle"},
+		{Line: 3, TimeMs: 29_500, Time: "00:29.50", Text: "la la",
+			Words: []models.SyncedWord{{TimeMs: 29_500, Text: "la"}, {TimeMs: 30_300, Text: "la"}}},
+	}
+	if !reflect.DeepEqual(lines, want) {
+		t.Errorf("lines = %+v, want %+v", lines, want)
+	}
+
+	formatted := FormatLRC(nil, lines)
+	again, _, err := ParseLRC(formatted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, want) {
+		t.Errorf("reparsed lines = %+v, want %+v", again, want)
+	}
+}
+
+func TestParseLRCInvalid(t *testing.T) {
+	for _, lrc := range []string{
+		"",
+		"no timestamps at all",
+		"[99999999:00]too late",
+		"[xx yy]line",
+		"[offset:soon]\n[00:01.00]line",
+	} {
+		if _, _, err := ParseLRC(lrc); !errors.Is(err, ErrInvalidLRC) {
+			t.Errorf("ParseLRC(%q) error = %v, want ErrInvalidLRC", lrc, err)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package lyrics
+
+import (
+	"regexp"
+	"slices"
+	"song_library/models"
+	"strings"
+	"unicode"
+)
+
+// markerPattern matches a section marker line such as [Chorus] or [Verse 2: Artist]
+var markerPattern = regexp.MustCompile(`^\[([^\[\]]+)\]$`)
+
+// markerTypes maps the first word of a marker to a section type; unknown markers become labelled verses
+var markerTypes = map[string]string{
+	"verse":   models.SectionVerse,
+	"chorus":  models.SectionChorus,
+	"hook":    models.SectionChorus,
+	"refrain": models.SectionChorus,
+	"bridge":  models.SectionBridge,
+	"intro":   models.SectionIntro,
+	"outro":   models.SectionOutro,
+}
+
+// markerSection returns the section started by a marker; the marker text is kept as the label
+// unless it only names the type. A marker of a type name, a colon and a label, such as
+// [Chorus: Artist], is split into the two.
+func markerSection(marker string) models.LyricsSection {
+	marker = strings.TrimSpace(marker)
+	if name, label, found := strings.Cut(marker, ":"); found {
+		if sectionType := strings.ToLower(strings.TrimSpace(name)); slices.Contains(models.SectionTypes, sectionType) {
+			return models.LyricsSection{Type: sectionType, Label: strings.TrimSpace(label)}
+		}
+	}
+	words := strings.FieldsFunc(strings.ToLower(marker), func(r rune) bool {
+		return unicode.IsSpace(r) || unicode.IsDigit(r) || r == ':'
+	})
+
+	section := models.LyricsSection{Type: models.SectionVerse, Label: marker}
+	if len(words) == 0 {
+		return section
+	}
+	if sectionType, ok := markerTypes[words[0]]; ok {
+		section.Type = sectionType
+		if strings.EqualFold(marker, sectionType) {
+			section.Label = ""
+		}
+	}
+	return section
+}
+
+// Parse splits plain-text lyrics into sections. A [Chorus]-style marker line starts a section of
+// that type, blank lines separate sections and sections without a marker are verses.
+func Parse(text string) []models.LyricsSection {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+
+	var sections []models.LyricsSection
+	var current *models.LyricsSection
+	var lines []string
+	flush := func() {
+		if current == nil && len(lines) == 0 {
+			return
+		}
+		if current == nil {
+			current = &models.LyricsSection{Type: models.SectionVerse}
+		}
+		current.Position = len(sections) + 1
+		current.Text = strings.Join(lines, "\n")
+		sections = append(sections, *current)
+		current, lines = nil, nil
+	}
+
+	for _, line := range strings.Split(text, "\n") {
+		line = strings.TrimRightFunc(line, unicode.IsSpace)
+		if match := markerPattern.FindStringSubmatch(strings.TrimSpace(line)); match != nil && strings.TrimSpace(match[1]) != "" {
+			flush()
+			section := markerSection(match[1])
+			current = &section
+			continue
+		}
+		if line == "" {
+			// A blank line right after a marker does not end the section it opens
+			if len(lines) > 0 {
+				flush()
+			}
+			continue
+		}
+		lines = append(lines, line)
+	}
+	flush()
+	return sections
+}
+
+// marker returns the marker line Render writes for a section, or "" for an unlabelled verse
+func marker(section models.LyricsSection) string {
+	switch {
+	case section.Label == "" && section.Type == models.SectionVerse:
+		return ""
+	case section.Label == "":
+		return "[" + title(section.Type) + "]"
+	case markerSection(section.Label) == models.LyricsSection{Type: section.Type, Label: section.Label}:
+		return "[" + section.Label + "]"
+	default:
+		return "[" + title(section.Type) + ": " + section.Label + "]"
+	}
+}
+
+func title(s string) string {
+	return strings.ToUpper(s[:1]) + s[1:]
+}
+
+// Render turns sections back into plain text that Parse reads into the same sections, provided
+// they passed Validate
+func Render(sections []models.LyricsSection) string {
+	parts := make([]string, 0, len(sections))
+	for _, section := range sections {
+		part := section.Text
+		if m := marker(section); m != "" {
+			part = strings.TrimRight(m+"\n"+part, "\n")
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, "\n\n")
+}
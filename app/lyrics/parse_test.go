@@ -0,0 +1,142 @@
+package lyrics
+
+import (
+	"errors"
+	"reflect"
+	"song_library/models"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want []models.LyricsSection
+	}{
+		{
+			name: "blank lines",
+			text: "one\ntwo\n\n\nthree\r\n",
+			want: []models.LyricsSection{
+				{Position: 1, Type: models.SectionVerse, Text: "one\ntwo"},
+				{Position: 2, Type: models.SectionVerse, Text: "three"},
+			},
+		},
+		{
+			name: "markers",
+			text: "[Intro]\n\n[Chorus]\nla la\n[Verse 2: Artist]\nwords  \n\n[Hook]\nhey",
+			want: []models.LyricsSection{
+				{Position: 1, Type: models.SectionIntro},
+				{Position: 2, Type: models.SectionChorus, Text: "la la"},
+				{Position: 3, Type: models.SectionVerse, Label: "Verse 2: Artist", Text: "words"},
+				{Position: 4, Type: models.SectionChorus, Label: "Hook", Text: "hey"},
+			},
+		},
+		{
+			name: "type and label",
+			text: "[Chorus: Artist]\nla\n\n[verse: Chorus]\nwords\n\n[Instrumental]",
+			want: []models.LyricsSection{
+				{Position: 1, Type: models.SectionChorus, Label: "Artist", Text: "la"},
+				{Position: 2, Type: models.SectionVerse, Label: "Chorus", Text: "words"},
+				{Position: 3, Type: models.SectionVerse, Label: "Instrumental"},
+			},
+		},
+		{
+			name: "not markers",
+			text: "[]\n[a [b] c]\ntext [with brackets]",
+			want: []models.LyricsSection{
+				{Position: 1, Type: models.SectionVerse, Text: "[]\n[a [b] c]\ntext [with brackets]"},
+			},
+		},
+		{name: "empty", text: "\n\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Parse(tt.text); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Parse = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRenderRoundTrip(t *testing.T) {
+	tests := []struct {
+		name     string
+		sections []models.LyricsSection
+	}{
+		{"unlabelled verses", []models.LyricsSection{
+			{Type: models.SectionVerse, Text: "one\ntwo"},
+			{Type: models.SectionVerse, Text: "three"},
+		}},
+		{"types", []models.LyricsSection{
+			{Type: models.SectionIntro},
+			{Type: models.SectionChorus, Text: "la la"},
+			{Type: models.SectionBridge, Text: "bridge"},
+			{Type: models.SectionOutro, Text: "end"},
+		}},
+		{"labels", []models.LyricsSection{
+			{Type: models.SectionVerse, Label: "Verse 2: Artist", Text: "words"},
+			{Type: models.SectionChorus, Label: "Hook", Text: "hey"},
+			{Type: models.SectionBridge, Label: "Artist", Text: "words"},
+			{Type: models.SectionVerse, Label: "Instrumental"},
+		}},
+		{"labels naming another type", []models.LyricsSection{
+			{Type: models.SectionVerse, Label: "Chorus", Text: "not a chorus"},
+			{Type: models.SectionChorus, Label: "Chorus", Text: "la"},
+			{Type: models.SectionChorus, Label: "Chorus: Artist", Text: "la"},
+			{Type: models.SectionVerse, Label: "Bridge: Artist", Text: "words"},
+			{Type: models.SectionIntro, Label: "CHORUS", Text: "spoken"},
+		}},
+		{"brackets inside lines", []models.LyricsSection{
+			{Type: models.SectionVerse, Text: "text [with brackets]\n[a [b] c]"},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate(tt.sections); err != nil {
+				t.Fatalf("Validate: %v", err)
+			}
+			for i := range tt.sections {
+				tt.sections[i].Position = i + 1
+			}
+			text := Render(tt.sections)
+			if got := Parse(text); !reflect.DeepEqual(got, tt.sections) {
+				t.Errorf("Parse(Render) of %q = %+v, want %+v", text, got, tt.sections)
+			}
+		})
+	}
+}
+
+func TestValidateNormalizes(t *testing.T) {
+	sections := []models.LyricsSection{{Type: " Chorus ", Label: " Hook ", Text: "\nla  \r\nla\n\n"}}
+	if err := Validate(sections); err != nil {
+		t.Fatal(err)
+	}
+	want := models.LyricsSection{Type: models.SectionChorus, Label: "Hook", Text: "la\nla"}
+	if sections[0] != want {
+		t.Errorf("section = %+v, want %+v", sections[0], want)
+	}
+}
+
+func TestValidateInvalid(t *testing.T) {
+	tests := []struct {
+		name    string
+		section models.LyricsSection
+	}{
+		{"unknown type", models.LyricsSection{Type: "solo", Text: "text"}},
+		{"long label", models.LyricsSection{Label: "a very long label that goes on and on past the sixty-four characters", Text: "text"}},
+		{"bracket in label", models.LyricsSection{Label: "Verse [live]", Text: "text"}},
+		{"line break in label", models.LyricsSection{Label: "two\nlines", Text: "text"}},
+		{"blank line", models.LyricsSection{Text: "a\n\nb"}},
+		{"whitespace line", models.LyricsSection{Text: "a\n   \nb"}},
+		{"marker line", models.LyricsSection{Text: "a\n[Chorus]\nb"}},
+		{"indented marker line", models.LyricsSection{Type: models.SectionChorus, Text: "  [Bridge]  "}},
+		{"empty unlabelled verse", models.LyricsSection{Text: " \n "}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := Validate([]models.LyricsSection{tt.section}); !errors.Is(err, ErrInvalidSection) {
+				t.Errorf("Validate error = %v, want ErrInvalidSection", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,482 @@
+package lyrics
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"song_library/db"
+	"song_library/models"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrSongNotFound     = errors.New("song not found")
+	ErrSectionNotFound  = errors.New("section not found")
+	ErrInvalidSection   = errors.New("invalid section")
+	ErrLanguageNotFound = errors.New("lyrics language not found")
+	ErrOriginalLanguage = errors.New("the original lyrics cannot be deleted")
+	ErrNoTranslation    = errors.New("song has no translations")
+	ErrLanguageConflict = errors.New("song has a translation into the language")
+)
+
+// Validate normalizes section types, labels and text and checks them against the schema, and that
+// Render writes them as text Parse reads back into the same sections
+func Validate(sections []models.LyricsSection) error {
+	for i := range sections {
+		s := &sections[i]
+		s.Type = strings.ToLower(strings.TrimSpace(s.Type))
+		if s.Type == "" {
+			s.Type = models.SectionVerse
+		}
+		if !slices.Contains(models.SectionTypes, s.Type) {
+			return fmt.Errorf("%w %d: type must be one of %v", ErrInvalidSection, i+1, models.SectionTypes)
+		}
+		s.Label = strings.TrimSpace(s.Label)
+		if len(s.Label) > 64 {
+			return fmt.Errorf("%w %d: label is longer than 64 characters", ErrInvalidSection, i+1)
+		}
+		if strings.ContainsAny(s.Label, "[]\r\n") {
+			return fmt.Errorf("%w %d: label must not contain brackets or line breaks", ErrInvalidSection, i+1)
+		}
+
+		lines := strings.Split(strings.ReplaceAll(s.Text, "\r\n", "\n"), "\n")
+		for j, line := range lines {
+			lines[j] = strings.TrimRightFunc(line, unicode.IsSpace)
+		}
+		s.Text = strings.Trim(strings.Join(lines, "\n"), "\n")
+		if s.Text == "" {
+			if s.Type == models.SectionVerse && s.Label == "" {
+				return fmt.Errorf("%w %d: an unlabelled verse must have text", ErrInvalidSection, i+1)
+			}
+			continue
+		}
+		for _, line := range strings.Split(s.Text, "\n") {
+			// Blank lines separate sections and marker lines start them
+			if line == "" {
+				return fmt.Errorf("%w %d: text must not contain blank lines", ErrInvalidSection, i+1)
+			}
+			if match := markerPattern.FindStringSubmatch(strings.TrimSpace(line)); match != nil && strings.TrimSpace(match[1]) != "" {
+				return fmt.Errorf("%w %d: text must not contain section marker lines such as %s", ErrInvalidSection, i+1, strings.TrimSpace(line))
+			}
+		}
+	}
+	return nil
+}
+
+// querier is satisfied by *sql.DB and *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// languages returns the lyrics languages of a song, original first, and its plain-text lyrics; lock is
+// appended to the song lookup. Lyrics that were never split into sections have an undetermined original.
+func languages(ctx context.Context, q querier, songID int, lock string) ([]models.LyricsLanguage, string, error) {
+	var text string
+	err := q.QueryRowContext(ctx, "SELECT COALESCE(lyrics, '') FROM songs WHERE song_id = $1"+lock, songID).Scan(&text)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, "", ErrSongNotFound
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	rows, err := q.QueryContext(ctx, `
+		SELECT language, is_original, COALESCE(translator, '')
+		FROM lyrics_languages WHERE song_id = $1 ORDER BY is_original DESC, language
+	`, songID)
+	if err != nil {
+		return nil, "", err
+	}
+	defer rows.Close()
+
+	langs := []models.LyricsLanguage{}
+	for rows.Next() {
+		var l models.LyricsLanguage
+		if err := rows.Scan(&l.Language, &l.Original, &l.Translator); err != nil {
+			return nil, "", err
+		}
+		langs = append(langs, l)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, "", err
+	}
+	if (len(langs) == 0 || !langs[0].Original) && text != "" {
+		langs = append([]models.LyricsLanguage{{Language: Undetermined, Original: true}}, langs...)
+	}
+	return langs, text, nil
+}
+
+// find returns the language with the given code, or the original for ""
+func find(langs []models.LyricsLanguage, code string) (models.LyricsLanguage, bool) {
+	for _, l := range langs {
+		if l.Language == code || (code == "" && l.Original) {
+			return l, true
+		}
+	}
+	return models.LyricsLanguage{}, false
+}
+
+// sections returns the stored sections of a song in one language
+func sections(ctx context.Context, q querier, songID int, language string) ([]models.LyricsSection, error) {
+	rows, err := q.QueryContext(ctx, `
+		SELECT position, section_type, COALESCE(label, ''), text
+		FROM lyrics_sections WHERE song_id = $1 AND language = $2 ORDER BY position
+	`, songID, language)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	result := []models.LyricsSection{}
+	for rows.Next() {
+		var s models.LyricsSection
+		if err := rows.Scan(&s.Position, &s.Type, &s.Label, &s.Text); err != nil {
+			return nil, err
+		}
+		result = append(result, s)
+	}
+	return result, rows.Err()
+}
+
+// load returns the sections of a song in a language, "" for the original
+func load(ctx context.Context, q querier, songID int, language, lock string) ([]models.LyricsSection, models.LyricsLanguage, error) {
+	langs, text, err := languages(ctx, q, songID, lock)
+	if err != nil {
+		return nil, models.LyricsLanguage{}, err
+	}
+	lang, ok := find(langs, language)
+	if !ok && language != "" {
+		return nil, models.LyricsLanguage{}, ErrLanguageNotFound
+	}
+	return loadLanguage(ctx, q, songID, lang, ok, text)
+}
+
+// loadLanguage returns the sections of a song in lang, parsing the plain-text lyrics when the
+// original has no stored sections yet. A song without lyrics (found false) has an empty
+// undetermined original.
+func loadLanguage(ctx context.Context, q querier, songID int, lang models.LyricsLanguage, found bool, text string) ([]models.LyricsSection, models.LyricsLanguage, error) {
+	if !found {
+		return []models.LyricsSection{}, models.LyricsLanguage{Language: Undetermined, Original: true}, nil
+	}
+	result, err := sections(ctx, q, songID, lang.Language)
+	if err != nil {
+		return nil, lang, err
+	}
+	if len(result) == 0 && lang.Original {
+		result = Parse(text)
+	}
+	return result, lang, nil
+}
+
+// Languages returns the lyrics languages of a song, original first
+func Languages(ctx context.Context, songID int) ([]models.LyricsLanguage, error) {
+	langs, _, err := languages(ctx, db.Reader(), songID, "")
+	return langs, err
+}
+
+// Load returns the sections of a song in a language, "" for the original
+func Load(ctx context.Context, songID int, language string) ([]models.LyricsSection, models.LyricsLanguage, error) {
+	return load(ctx, db.Reader(), songID, language, "")
+}
+
+// LoadPreferred returns the sections of a song in the language picked by Negotiate
+func LoadPreferred(ctx context.Context, songID int, requested, acceptLanguage string) ([]models.LyricsSection, models.LyricsLanguage, error) {
+	langs, text, err := languages(ctx, db.Reader(), songID, "")
+	if err != nil {
+		return nil, models.LyricsLanguage{}, err
+	}
+	lang, ok := Negotiate(langs, requested, acceptLanguage)
+	return loadLanguage(ctx, db.Reader(), songID, lang, ok, text)
+}
+
+// save stores sections as the lyrics of a song in one language, renumbering them from 1. The
+// original lyrics are also written to songs.lyrics as plain text.
+func save(ctx context.Context, tx *sql.Tx, songID int, lang models.LyricsLanguage, sections []models.LyricsSection) error {
+	if lang.Original {
+		result, err := tx.ExecContext(ctx, "UPDATE songs SET lyrics = $2 WHERE song_id = $1", songID, Render(sections))
+		if err != nil {
+			return err
+		}
+		if count, _ := result.RowsAffected(); count == 0 {
+			return ErrSongNotFound
+		}
+		if lang.Language == Undetermined && len(sections) == 0 {
+			// No lyrics at all: keep no language record rather than an empty undetermined one
+			_, err := tx.ExecContext(ctx, "DELETE FROM lyrics_languages WHERE song_id = $1 AND language = $2", songID, Undetermined)
+			return err
+		}
+	}
+
+	_, err := tx.ExecContext(ctx, `
+		INSERT INTO lyrics_languages (song_id, language, is_original, translator) VALUES ($1, $2, $3, NULLIF($4, ''))
+		ON CONFLICT (song_id, language) DO UPDATE
+		SET is_original = EXCLUDED.is_original, translator = EXCLUDED.translator, updated_at = NOW()
+	`, songID, lang.Language, lang.Original, lang.Translator)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.ExecContext(ctx, "DELETE FROM lyrics_sections WHERE song_id = $1 AND language = $2", songID, lang.Language); err != nil {
+		return err
+	}
+	if len(sections) == 0 {
+		return nil
+	}
+
+	positions := make([]int, len(sections))
+	types := make([]string, len(sections))
+	labels := make([]string, len(sections))
+	texts := make([]string, len(sections))
+	for i, s := range sections {
+		positions[i], types[i], labels[i], texts[i] = i+1, s.Type, s.Label, s.Text
+	}
+	_, err = tx.ExecContext(ctx, `
+		INSERT INTO lyrics_sections (song_id, language, position, section_type, label, text)
+		SELECT $1, $2, p, t, NULLIF(l, ''), x FROM unnest($3::int[], $4::text[], $5::text[], $6::text[]) AS s (p, t, l, x)
+	`, songID, lang.Language, positions, types, labels, texts)
+	return err
+}
+
+// Replace stores sections as the original lyrics of a song, in its current original language, and returns that language
+func Replace(ctx context.Context, tx *sql.Tx, songID int, sections []models.LyricsSection) (models.LyricsLanguage, error) {
+	langs, _, err := languages(ctx, tx, songID, " FOR UPDATE")
+	if err != nil {
+		return models.LyricsLanguage{}, err
+	}
+	original, ok := find(langs, "")
+	if !ok {
+		original = models.LyricsLanguage{Language: Undetermined, Original: true}
+	}
+	return original, save(ctx, tx, songID, original, sections)
+}
+
+// ReplaceLanguage stores sections as the lyrics of a song in a language. Lyrics in the original
+// language replace the original; any other language is stored as a translation by translator,
+// unless the song has no lyrics yet, in which case it becomes the original.
+func ReplaceLanguage(ctx context.Context, songID int, language, translator string, sections []models.LyricsSection) error {
+	return db.InTx(ctx, func(tx *sql.Tx) error {
+		langs, _, err := languages(ctx, tx, songID, " FOR UPDATE")
+		if err != nil {
+			return err
+		}
+		lang := models.LyricsLanguage{Language: language, Translator: translator}
+		if original, ok := find(langs, ""); !ok || original.Language == language {
+			lang = models.LyricsLanguage{Language: language, Original: true}
+		}
+		return save(ctx, tx, songID, lang, sections)
+	})
+}
+
+// SetOriginal marks the lyrics in language as the original. An existing translation in that
+// language is promoted and the previous original becomes a translation, except undetermined
+// lyrics, which are dropped; without such a translation the original lyrics are relabelled.
+func SetOriginal(ctx context.Context, songID int, language string) error {
+	return db.InTx(ctx, func(tx *sql.Tx) error {
+		current, original, err := load(ctx, tx, songID, "", " FOR UPDATE")
+		if err != nil {
+			return err
+		}
+		if original.Language == language {
+			return nil
+		}
+
+		promoted, _, err := load(ctx, tx, songID, language, "")
+		relabel := errors.Is(err, ErrLanguageNotFound)
+		if relabel {
+			// The original text stays, only its language changes
+			promoted = current
+		} else if err != nil {
+			return err
+		}
+
+		if relabel || original.Language == Undetermined {
+			_, err = tx.ExecContext(ctx, "DELETE FROM lyrics_languages WHERE song_id = $1 AND language = $2", songID, original.Language)
+		} else {
+			_, err = tx.ExecContext(ctx, "UPDATE lyrics_languages SET is_original = FALSE WHERE song_id = $1 AND language = $2", songID, original.Language)
+		}
+		if err != nil {
+			return err
+		}
+		return save(ctx, tx, songID, models.LyricsLanguage{Language: language, Original: true}, promoted)
+	})
+}
+
+// Relabel sets the language of the original lyrics of a song, which need not have any yet. When
+// the song has a translation into that language it returns ErrLanguageConflict and leaves the
+// translation alone: swapping the two is up to SetOriginal.
+func Relabel(ctx context.Context, tx *sql.Tx, songID int, language string) error {
+	var translated bool
+	err := tx.QueryRowContext(ctx,
+		"SELECT EXISTS (SELECT 1 FROM lyrics_languages WHERE song_id = $1 AND language = $2 AND NOT is_original)",
+		songID, language).Scan(&translated)
+	if err != nil {
+		return err
+	}
+	if translated {
+		return ErrLanguageConflict
+	}
+	result, err := tx.ExecContext(ctx, "UPDATE lyrics_languages SET language = $2, updated_at = NOW() WHERE song_id = $1 AND is_original", songID, language)
+	if err != nil {
+		return err
+	}
+	if count, _ := result.RowsAffected(); count > 0 {
+		return nil
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO lyrics_languages (song_id, language, is_original) VALUES ($1, $2, TRUE)", songID, language)
+	return err
+}
+
+// DeleteLanguage removes a translation of a song
+func DeleteLanguage(ctx context.Context, songID int, language string) error {
+	return db.InTx(ctx, func(tx *sql.Tx) error {
+		langs, _, err := languages(ctx, tx, songID, " FOR UPDATE")
+		if err != nil {
+			return err
+		}
+		lang, ok := find(langs, language)
+		if !ok {
+			return ErrLanguageNotFound
+		}
+		if lang.Original {
+			return ErrOriginalLanguage
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM lyrics_languages WHERE song_id = $1 AND language = $2", songID, language)
+		return err
+	})
+}
+
+// SectionPatch holds the fields of a section to change; nil fields are left as they are
+type SectionPatch struct {
+	Type  *string `json:"type"`
+	Label *string `json:"label"`
+	Text  *string `json:"text"`
+}
+
+// UpdateSection edits one section of a song's lyrics in a language, "" for the original, and returns it as stored
+func UpdateSection(ctx context.Context, songID int, language string, position int, patch SectionPatch) (models.LyricsSection, error) {
+	var updated models.LyricsSection
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		sections, lang, err := load(ctx, tx, songID, language, " FOR UPDATE")
+		if err != nil {
+			return err
+		}
+		if position < 1 || position > len(sections) {
+			return ErrSectionNotFound
+		}
+
+		s := &sections[position-1]
+		if patch.Type != nil {
+			s.Type = *patch.Type
+		}
+		if patch.Label != nil {
+			s.Label = *patch.Label
+		}
+		if patch.Text != nil {
+			s.Text = *patch.Text
+		}
+		if err := Validate(sections); err != nil {
+			return err
+		}
+		updated = *s
+		return save(ctx, tx, songID, lang, sections)
+	})
+	return updated, err
+}
+
+// ImportPlainText parses the plain-text lyrics of every song without stored sections and stores
+// the result, returning the number of songs imported
+func ImportPlainText(ctx context.Context) (int, error) {
+	rows, err := db.Db.QueryContext(ctx, `
+		SELECT song_id FROM songs s
+		WHERE COALESCE(lyrics, '') <> ''
+		  AND NOT EXISTS (SELECT 1 FROM lyrics_languages ll WHERE ll.song_id = s.song_id AND ll.is_original)
+		ORDER BY song_id
+	`)
+	if err != nil {
+		return 0, err
+	}
+	var songIDs []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		songIDs = append(songIDs, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	imported := 0
+	for _, songID := range songIDs {
+		err := db.InTx(ctx, func(tx *sql.Tx) error {
+			sections, original, err := load(ctx, tx, songID, "", " FOR UPDATE")
+			if err != nil {
+				return err
+			}
+			return save(ctx, tx, songID, original, sections)
+		})
+		if errors.Is(err, ErrSongNotFound) {
+			continue
+		} else if err != nil {
+			return imported, fmt.Errorf("song %d: %w", songID, err)
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+// Align returns the original lyrics of a song side by side with a translation, picked by Negotiate
+// among the translations or the first one. Sections are paired by position.
+func Align(ctx context.Context, songID int, requested, acceptLanguage string) (models.SideBySide, error) {
+	langs, text, err := languages(ctx, db.Reader(), songID, "")
+	if err != nil {
+		return models.SideBySide{}, err
+	}
+	var translations []models.LyricsLanguage
+	for _, l := range langs {
+		if !l.Original {
+			translations = append(translations, l)
+		}
+	}
+	original, hasOriginal := find(langs, "")
+	if len(translations) == 0 {
+		return models.SideBySide{}, ErrNoTranslation
+	}
+	translation, ok := Negotiate(translations, requested, acceptLanguage)
+	if !ok {
+		translation = translations[0]
+	}
+
+	left, original, err := loadLanguage(ctx, db.Reader(), songID, original, hasOriginal, text)
+	if err != nil {
+		return models.SideBySide{}, err
+	}
+	right, err := sections(ctx, db.Reader(), songID, translation.Language)
+	if err != nil {
+		return models.SideBySide{}, err
+	}
+
+	result := models.SideBySide{SongID: songID, Original: original, Translation: translation, Sections: []models.AlignedSection{}}
+	for i := 0; i < max(len(left), len(right)); i++ {
+		aligned := models.AlignedSection{Position: i + 1}
+		// The structure comes from the original; extra translated sections keep their own
+		source := models.LyricsSection{}
+		if i < len(right) {
+			source = right[i]
+			aligned.Translation = right[i].Text
+		}
+		if i < len(left) {
+			source = left[i]
+			aligned.Original = left[i].Text
+		}
+		aligned.Type, aligned.Label = source.Type, source.Label
+		result.Sections = append(result.Sections, aligned)
+	}
+	return result, nil
+}
@@ -0,0 +1,104 @@
+package lyrics
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"song_library/db"
+	"song_library/models"
+	"sort"
+)
+
+var ErrNotSynced = errors.New("song has no synced lyrics")
+
+// ReplaceSynced stores lines as the time-synced lyrics of a song
+func ReplaceSynced(ctx context.Context, songID int, lines []models.SyncedLine) error {
+	times := make([]int, len(lines))
+	texts := make([]string, len(lines))
+	words := make([]string, len(lines))
+	for i, line := range lines {
+		times[i], texts[i] = line.TimeMs, line.Text
+		if len(line.Words) > 0 {
+			encoded, err := json.Marshal(line.Words)
+			if err != nil {
+				return err
+			}
+			words[i] = string(encoded)
+		}
+	}
+
+	return db.InTx(ctx, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, "SELECT song_id FROM songs WHERE song_id = $1 FOR UPDATE", songID).Scan(&songID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSongNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM lyrics_lines WHERE song_id = $1", songID); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO lyrics_lines (song_id, line_no, time_ms, text, words)
+			SELECT $1, n, t, x, NULLIF(w, '')::jsonb
+			FROM unnest($2::int[], $3::text[], $4::text[]) WITH ORDINALITY AS l (t, x, w, n)
+		`, songID, times, texts, words)
+		return err
+	})
+}
+
+// Synced returns the time-synced lines of a song in order, with the LRC ID tags derived from the song
+func Synced(ctx context.Context, songID int) ([]models.SyncedLine, map[string]string, error) {
+	var group, song string
+	err := db.Reader().QueryRowContext(ctx, "SELECT group_name, song_name FROM songs WHERE song_id = $1", songID).Scan(&group, &song)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil, ErrSongNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	rows, err := db.Reader().QueryContext(ctx, `
+		SELECT line_no, time_ms, text, words FROM lyrics_lines WHERE song_id = $1 ORDER BY line_no
+	`, songID)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	var lines []models.SyncedLine
+	for rows.Next() {
+		var line models.SyncedLine
+		var words []byte
+		if err := rows.Scan(&line.Line, &line.TimeMs, &line.Text, &words); err != nil {
+			return nil, nil, err
+		}
+		if words != nil {
+			if err := json.Unmarshal(words, &line.Words); err != nil {
+				return nil, nil, err
+			}
+		}
+		line.Time = FormatTimestamp(line.TimeMs)
+		lines = append(lines, line)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	if len(lines) == 0 {
+		return nil, nil, ErrNotSynced
+	}
+	return lines, map[string]string{"ar": group, "ti": song}, nil
+}
+
+// LineAt returns the line being sung at ms and the line after it; before the first line Line is nil
+func LineAt(lines []models.SyncedLine, ms int) (*models.SyncedLine, *models.SyncedLine) {
+	i := sort.Search(len(lines), func(i int) bool { return lines[i].TimeMs > ms })
+	var current, next *models.SyncedLine
+	if i > 0 {
+		current = &lines[i-1]
+	}
+	if i < len(lines) {
+		next = &lines[i]
+	}
+	return current, next
+}
@@ -1,17 +1,27 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
 	"github.com/gin-gonic/gin"
-	_ "github.com/lib/pq"
-	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
-	swaggerFiles "github.com/swaggo/files"
-	ginSwagger "github.com/swaggo/gin-swagger"
+	"net/http"
 	"os"
+	"os/signal"
+	"song_library/cli"
 	"song_library/config"
 	"song_library/db"
 	_ "song_library/docs"
-	"song_library/handlers"
+	"song_library/events"
+	"song_library/health"
+	"song_library/logging"
+	"song_library/metrics"
+	"song_library/tracing"
+	"song_library/worker"
+	"syscall"
+	"time"
 )
 
 // @title Song Library API
@@ -19,39 +29,85 @@ import (
 // @description This is a simple API to manage a song library.
 // @host localhost:8080
 // @BasePath /
+// @securityDefinitions.apikey ApiKeyAuth
+// @in header
+// @name X-API-Key
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
 
 func main() {
-	logLevel := os.Getenv("LOG_LEVEL")
-	if logLevel == "debug" {
-		zerolog.SetGlobalLevel(zerolog.DebugLevel)
-	} else {
-		zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	cfg, args, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
 	}
-	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
-	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stdout})
 
-	cfg := config.LoadConfig()
+	if err := logging.Setup(cfg.LogLevel, cfg.LogFormat); err != nil {
+		log.Fatal().Err(err).Msg("Logger setup failed")
+	}
+	log.Debug().Interface("config", cfg.Redacted()).Msg("Loaded configuration")
 
-	db.InitDB(cfg)
-	defer db.Db.Close()
+	if len(args) > 0 {
+		os.Exit(cli.Run(cfg, args))
+	}
 
-	ginMode := os.Getenv("GIN_MODE")
-	if ginMode == "release" {
-		gin.SetMode(gin.ReleaseMode)
-	} else {
-		gin.SetMode(gin.DebugMode)
+	shutdownTracing, err := tracing.Init(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Tracing initialization failed")
 	}
 
-	router := gin.Default()
+	if err := db.InitDB(cfg); err != nil {
+		log.Fatal().Err(err).Msg("Database connection failed")
+	}
+	db.Migrate()
+
+	gin.SetMode(cfg.GinMode)
+
+	metrics.Register()
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	router.GET("/songs", handlers.GetSongs)
-	router.GET("/songs/lyrics/:song_id", handlers.GetSongLyrics)
-	router.DELETE("/songs/:song_id", handlers.DeleteSong)
-	router.PUT("/songs/:song_id", handlers.UpdateSong)
-	router.POST("/songs", handlers.AddSong(cfg))
+	workers := worker.NewGroup()
+	metrics.StartLibrary(workers, cfg.MetricsLibraryInterval)
+	hub := events.NewHub(cfg)
+	server := newServer(cfg, newRouter(cfg, workers, hub))
+	// Shutdown waits for open requests, so event streams are told to end first
+	server.RegisterOnShutdown(hub.Close)
 
-	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	go func() {
+		log.Info().Msgf("Backend API running on port %s", cfg.AppPort)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Fatal().Err(err).Msg("HTTP server failed")
+		}
+	}()
 
-	log.Info().Msgf("Backend API running on port %s", cfg.AppPort)
-	router.Run(":" + cfg.AppPort)
+	<-ctx.Done()
+	stop()
+	log.Info().Msg("Shutdown signal received, draining")
+
+	// Fail readiness first so that load balancers stop sending new requests before the listener closes
+	health.SetShuttingDown()
+	time.Sleep(cfg.ShutdownDrainDelay)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.ShutdownTimeout)
+	defer cancel()
+
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("HTTP server did not drain in time")
+	}
+	if err := workers.Stop(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("Background workers did not stop in time")
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		log.Error().Err(err).Msg("Flushing traces failed")
+	}
+	if err := db.Close(); err != nil {
+		log.Error().Err(err).Msg("Closing database pool failed")
+	}
+	log.Info().Msg("Server stopped")
 }
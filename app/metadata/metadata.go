@@ -0,0 +1,115 @@
+package metadata
+
+import (
+	"errors"
+	"fmt"
+	"song_library/lyrics"
+	"song_library/models"
+	"strconv"
+	"strings"
+)
+
+var ErrInvalid = errors.New("invalid song metadata")
+
+// MaxBPM bounds the tempo of a song, exclusive
+const MaxBPM = 1000
+
+var (
+	// majorKeys and minorKeys spell the keys of each pitch class, C = 0, the way DJ software does
+	majorKeys = [12]string{"C", "Db", "D", "Eb", "E", "F", "F#", "G", "Ab", "A", "Bb", "B"}
+	minorKeys = [12]string{"Cm", "C#m", "Dm", "Ebm", "Em", "Fm", "F#m", "Gm", "G#m", "Am", "Bbm", "Bm"}
+	naturals  = map[byte]int{'C': 0, 'D': 2, 'E': 4, 'F': 5, 'G': 7, 'A': 9, 'B': 11}
+)
+
+// NormalizeKey parses a musical key written as C, F# minor, Gbm, Ab major or in Camelot notation
+// such as 8A, and returns it spelled as in majorKeys or minorKeys, so that enharmonic keys match
+func NormalizeKey(s string) (string, error) {
+	key := strings.NewReplacer(" ", "", "♯", "#", "♭", "b").Replace(strings.TrimSpace(s))
+	if key == "" {
+		return "", fmt.Errorf("%w: key is empty", ErrInvalid)
+	}
+	if pitch, minor, ok := parseCamelot(key); ok {
+		return spell(pitch, minor), nil
+	}
+
+	pitch, ok := naturals[strings.ToUpper(key[:1])[0]]
+	if !ok {
+		return "", fmt.Errorf("%w: %q is not a musical key, expected e.g. C, F#m or 8A", ErrInvalid, s)
+	}
+	rest := key[1:]
+	if len(rest) > 0 && (rest[0] == '#' || rest[0] == 'b') {
+		if rest[0] == '#' {
+			pitch++
+		} else {
+			pitch--
+		}
+		rest = rest[1:]
+	}
+	// Mode names are case-insensitive, except that a lone M means major as in CM
+	var minor bool
+	switch strings.ToLower(rest) {
+	case "", "maj", "major":
+	case "m", "min", "minor":
+		minor = rest != "M"
+	default:
+		return "", fmt.Errorf("%w: %q is not a musical key, expected e.g. C, F#m or 8A", ErrInvalid, s)
+	}
+	return spell((pitch+12)%12, minor), nil
+}
+
+func spell(pitch int, minor bool) string {
+	if minor {
+		return minorKeys[pitch]
+	}
+	return majorKeys[pitch]
+}
+
+// parseCamelot reads a Camelot wheel position, 1A to 12A for minor keys and 1B to 12B for major ones
+func parseCamelot(key string) (int, bool, bool) {
+	if len(key) < 2 {
+		return 0, false, false
+	}
+	n, err := strconv.Atoi(key[:len(key)-1])
+	if err != nil || n < 1 || n > 12 {
+		return 0, false, false
+	}
+	var minor bool
+	switch key[len(key)-1] {
+	case 'A', 'a':
+		minor = true
+	case 'B', 'b':
+	default:
+		return 0, false, false
+	}
+	// 8B is C major and each step clockwise is a fifth up; a minor key sits next to its relative major
+	pitch := ((n-8)*7%12 + 12) % 12
+	if minor {
+		pitch = (pitch + 9) % 12
+	}
+	return pitch, minor, true
+}
+
+// Normalize checks the metadata of a song that is set and normalizes the key and language in place
+func Normalize(m *models.SongMetadata) error {
+	if m.Duration != nil && *m.Duration <= 0 {
+		return fmt.Errorf("%w: duration must be a positive number of seconds", ErrInvalid)
+	}
+	if m.BPM != nil && (*m.BPM <= 0 || *m.BPM >= MaxBPM) {
+		return fmt.Errorf("%w: bpm must be between 0 and %d", ErrInvalid, MaxBPM)
+	}
+	if m.Key != "" {
+		key, err := NormalizeKey(m.Key)
+		if err != nil {
+			return err
+		}
+		m.Key = key
+	}
+	if m.Language != "" {
+		language, ok := lyrics.NormalizeLanguage(m.Language)
+		if !ok {
+			return fmt.Errorf("%w: language must be an ISO 639 code, got %q", ErrInvalid, m.Language)
+		}
+		m.Language = language
+	}
+	return nil
+}
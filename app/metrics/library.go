@@ -0,0 +1,106 @@
+package metrics
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/rs/zerolog/log"
+	"song_library/db"
+	"song_library/worker"
+	"sync"
+	"time"
+)
+
+// libraryCollector reports business gauges computed from the songs and song_links tables. The
+// counts scan whole tables and /metrics is public, so a worker refreshes them on an interval and
+// scrapes serve the last values.
+type libraryCollector struct {
+	songs         *prometheus.Desc
+	missingLyrics *prometheus.Desc
+	deadLinks     *prometheus.Desc
+
+	mu    sync.Mutex
+	stats *libraryStats
+}
+
+type libraryStats struct {
+	songs, missingLyrics, deadLinks int
+}
+
+// library is the collector registered by Register and refreshed by StartLibrary
+var library = newLibraryCollector()
+
+func newLibraryCollector() *libraryCollector {
+	return &libraryCollector{
+		songs: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "library", "songs"),
+			"Total number of songs in the library.", nil, nil,
+		),
+		missingLyrics: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "library", "songs_missing_lyrics"),
+			"Number of songs without lyrics.", nil, nil,
+		),
+		deadLinks: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "library", "dead_links"),
+			"Number of song links that failed several checks in a row.", nil, nil,
+		),
+	}
+}
+
+// StartLibrary recomputes the library gauges in the worker group every interval
+func StartLibrary(workers *worker.Group, interval time.Duration) {
+	workers.Go("library-metrics", func(ctx context.Context) {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			library.refresh(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
+
+// refresh recomputes the gauges, keeping the last values when the query fails
+func (lc *libraryCollector) refresh(ctx context.Context) {
+	queryCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var stats libraryStats
+	err := db.Reader().QueryRowContext(queryCtx, `
+		SELECT COUNT(*), COUNT(*) FILTER (WHERE lyrics IS NULL OR lyrics = ''),
+			(SELECT COUNT(*) FROM song_links WHERE dead)
+		FROM songs
+	`).Scan(&stats.songs, &stats.missingLyrics, &stats.deadLinks)
+	if err != nil {
+		if ctx.Err() == nil {
+			log.Error().Err(err).Msg("Error collecting library metrics")
+		}
+		return
+	}
+
+	lc.mu.Lock()
+	lc.stats = &stats
+	lc.mu.Unlock()
+}
+
+func (lc *libraryCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- lc.songs
+	ch <- lc.missingLyrics
+	ch <- lc.deadLinks
+}
+
+func (lc *libraryCollector) Collect(ch chan<- prometheus.Metric) {
+	lc.mu.Lock()
+	stats := lc.stats
+	lc.mu.Unlock()
+	// Nothing is reported until the first refresh succeeds
+	if stats == nil {
+		return
+	}
+
+	ch <- prometheus.MustNewConstMetric(lc.songs, prometheus.GaugeValue, float64(stats.songs))
+	ch <- prometheus.MustNewConstMetric(lc.missingLyrics, prometheus.GaugeValue, float64(stats.missingLyrics))
+	ch <- prometheus.MustNewConstMetric(lc.deadLinks, prometheus.GaugeValue, float64(stats.deadLinks))
+}
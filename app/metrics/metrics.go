@@ -0,0 +1,120 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net"
+	"net/http"
+	"song_library/db"
+	"strconv"
+	"time"
+)
+
+const namespace = "song_library"
+
+var (
+	httpRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	httpDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "HTTP request latency by method, route and status code.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route", "status"})
+
+	externalRequests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "external_api_requests_total",
+		Help:      "Calls to the external enrichment API by outcome.",
+	}, []string{"outcome"})
+
+	externalDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "external_api_request_duration_seconds",
+		Help:      "Latency of calls to the external enrichment API by outcome.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"outcome"})
+)
+
+// Outcomes of external API calls
+const (
+	OutcomeOK          = "ok"
+	OutcomeTimeout     = "timeout"
+	OutcomeNetwork     = "network_error"
+	OutcomeClientError = "http_4xx"
+	OutcomeServerError = "http_5xx"
+	OutcomeBadStatus   = "http_other"
+	OutcomeDecodeError = "decode_error"
+)
+
+// Register registers all collectors, including the pool collector of the primary and replica pools
+// and the business collector that StartLibrary keeps up to date
+func Register() {
+	pools := map[string]*pgxpool.Pool{"primary": db.Pool}
+	if db.ReplicaPool != nil {
+		pools["replica"] = db.ReplicaPool
+	}
+	prometheus.MustRegister(
+		httpRequests,
+		httpDuration,
+		externalRequests,
+		externalDuration,
+		newPoolCollector(pools),
+		library,
+	)
+}
+
+// Handler serves the /metrics endpoint
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(promhttp.Handler())
+}
+
+// Middleware records request counts and latencies labelled by the matched route
+func Middleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		status := strconv.Itoa(c.Writer.Status())
+		httpRequests.WithLabelValues(c.Request.Method, route, status).Inc()
+		httpDuration.WithLabelValues(c.Request.Method, route, status).Observe(time.Since(start).Seconds())
+	}
+}
+
+// ExternalCallOutcome classifies the result of an external API request
+func ExternalCallOutcome(err error, resp *http.Response) string {
+	if err != nil {
+		var netErr net.Error
+		if errors.As(err, &netErr) && netErr.Timeout() {
+			return OutcomeTimeout
+		}
+		return OutcomeNetwork
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		return OutcomeOK
+	case resp.StatusCode >= 500:
+		return OutcomeServerError
+	case resp.StatusCode >= 400:
+		return OutcomeClientError
+	default:
+		return OutcomeBadStatus
+	}
+}
+
+// ObserveExternalCall records one call to the external enrichment API
+func ObserveExternalCall(outcome string, duration time.Duration) {
+	externalRequests.WithLabelValues(outcome).Inc()
+	externalDuration.WithLabelValues(outcome).Observe(duration.Seconds())
+}
@@ -0,0 +1,68 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// poolCollector reports the statistics of the pgx pools, which hold the connections; the sql.DB
+// handles on top of them keep no idle connections, so their own statistics say little
+type poolCollector struct {
+	pools map[string]*pgxpool.Pool
+
+	maxConns       *prometheus.Desc
+	totalConns     *prometheus.Desc
+	acquiredConns  *prometheus.Desc
+	idleConns      *prometheus.Desc
+	acquires       *prometheus.Desc
+	emptyAcquires  *prometheus.Desc
+	canceled       *prometheus.Desc
+	acquireSeconds *prometheus.Desc
+	newConns       *prometheus.Desc
+}
+
+// newPoolCollector reports the pools by name, labelling their metrics with pool="name"
+func newPoolCollector(pools map[string]*pgxpool.Pool) *poolCollector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName(namespace, "db_pool", name), help, []string{"pool"}, nil)
+	}
+	return &poolCollector{
+		pools:          pools,
+		maxConns:       desc("max_connections", "Maximum number of connections of the pool."),
+		totalConns:     desc("connections", "Number of open connections, including those being established."),
+		acquiredConns:  desc("acquired_connections", "Number of connections in use."),
+		idleConns:      desc("idle_connections", "Number of idle connections."),
+		acquires:       desc("acquires_total", "Connections acquired from the pool."),
+		emptyAcquires:  desc("empty_acquires_total", "Acquires that had to wait for a connection to be established or released."),
+		canceled:       desc("canceled_acquires_total", "Acquires canceled before a connection was available."),
+		acquireSeconds: desc("acquire_duration_seconds_total", "Time spent acquiring connections."),
+		newConns:       desc("new_connections_total", "Connections established by the pool."),
+	}
+}
+
+func (pc *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- pc.maxConns
+	ch <- pc.totalConns
+	ch <- pc.acquiredConns
+	ch <- pc.idleConns
+	ch <- pc.acquires
+	ch <- pc.emptyAcquires
+	ch <- pc.canceled
+	ch <- pc.acquireSeconds
+	ch <- pc.newConns
+}
+
+func (pc *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	for name, pool := range pc.pools {
+		stat := pool.Stat()
+		ch <- prometheus.MustNewConstMetric(pc.maxConns, prometheus.GaugeValue, float64(stat.MaxConns()), name)
+		ch <- prometheus.MustNewConstMetric(pc.totalConns, prometheus.GaugeValue, float64(stat.TotalConns()), name)
+		ch <- prometheus.MustNewConstMetric(pc.acquiredConns, prometheus.GaugeValue, float64(stat.AcquiredConns()), name)
+		ch <- prometheus.MustNewConstMetric(pc.idleConns, prometheus.GaugeValue, float64(stat.IdleConns()), name)
+		ch <- prometheus.MustNewConstMetric(pc.acquires, prometheus.CounterValue, float64(stat.AcquireCount()), name)
+		ch <- prometheus.MustNewConstMetric(pc.emptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()), name)
+		ch <- prometheus.MustNewConstMetric(pc.canceled, prometheus.CounterValue, float64(stat.CanceledAcquireCount()), name)
+		ch <- prometheus.MustNewConstMetric(pc.acquireSeconds, prometheus.CounterValue, stat.AcquireDuration().Seconds(), name)
+		ch <- prometheus.MustNewConstMetric(pc.newConns, prometheus.CounterValue, float64(stat.NewConnsCount()), name)
+	}
+}
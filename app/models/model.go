@@ -1,5 +1,7 @@
 package models
 
+import "time"
+
 type Song struct {
 	ID          int    `json:"id"`
 	Group       string `json:"group"`
@@ -7,6 +9,25 @@ type Song struct {
 	ReleaseDate string `json:"releaseDate,omitempty"`
 	Text        string `json:"text,omitempty"`
 	Link        string `json:"link,omitempty"`
+
+	ISRC                   string `json:"isrc,omitempty"`
+	ISWC                   string `json:"iswc,omitempty"`
+	MusicBrainzRecordingID string `json:"musicbrainzRecordingId,omitempty"`
+	MusicBrainzWorkID      string `json:"musicbrainzWorkId,omitempty"`
+
+	SongMetadata
+}
+
+// SongMetadata describes the recording of a song; unknown values are left out
+type SongMetadata struct {
+	// Duration is the length of the recording in seconds
+	Duration *int     `json:"duration,omitempty"`
+	BPM      *float64 `json:"bpm,omitempty"`
+	// Key is the musical key, such as C, F#m or Bb; Camelot notation such as 8A is accepted on input
+	Key      string `json:"key,omitempty"`
+	Explicit *bool  `json:"explicit,omitempty"`
+	// Language is the ISO 639 code of the original lyrics
+	Language string `json:"language,omitempty"`
 }
 
 type SongDetail struct {
@@ -14,3 +35,356 @@ type SongDetail struct {
 	Text        string `json:"text"`
 	Link        string `json:"link"`
 }
+
+// Section types of structured lyrics
+const (
+	SectionVerse  = "verse"
+	SectionChorus = "chorus"
+	SectionBridge = "bridge"
+	SectionIntro  = "intro"
+	SectionOutro  = "outro"
+)
+
+// SectionTypes lists the valid section types
+var SectionTypes = []string{SectionVerse, SectionChorus, SectionBridge, SectionIntro, SectionOutro}
+
+// LyricsSection is one verse, chorus or other part of a song's lyrics; Position starts at 1
+type LyricsSection struct {
+	Position int    `json:"position"`
+	Type     string `json:"type"`
+	Label    string `json:"label,omitempty"`
+	Text     string `json:"text"`
+}
+
+// LyricsPage is a page of lyrics sections in one language together with the total number of sections
+type LyricsPage struct {
+	SongID     int             `json:"songId"`
+	Language   string          `json:"language"`
+	Original   bool            `json:"original"`
+	Translator string          `json:"translator,omitempty"`
+	Page       int             `json:"page"`
+	Limit      int             `json:"limit"`
+	Total      int             `json:"total"`
+	Sections   []LyricsSection `json:"sections"`
+}
+
+// LyricsLanguage describes the lyrics of a song in one ISO 639 language; exactly one is the original
+type LyricsLanguage struct {
+	Language   string `json:"language"`
+	Original   bool   `json:"original"`
+	Translator string `json:"translator,omitempty"`
+}
+
+// AlignedSection pairs a section of the original lyrics with the section at the same position of a translation
+type AlignedSection struct {
+	Position    int    `json:"position"`
+	Type        string `json:"type"`
+	Label       string `json:"label,omitempty"`
+	Original    string `json:"original"`
+	Translation string `json:"translation"`
+}
+
+// SideBySide is the original lyrics of a song aligned with a translation
+type SideBySide struct {
+	SongID      int              `json:"songId"`
+	Original    LyricsLanguage   `json:"original"`
+	Translation LyricsLanguage   `json:"translation"`
+	Sections    []AlignedSection `json:"sections"`
+}
+
+// SyncedWord is a word of a synced line with its own start time, from enhanced LRC
+type SyncedWord struct {
+	TimeMs int    `json:"timeMs"`
+	Text   string `json:"text"`
+}
+
+// SyncedLine is a lyrics line with the time it starts at
+type SyncedLine struct {
+	Line   int          `json:"line"`
+	TimeMs int          `json:"timeMs"`
+	Time   string       `json:"time"`
+	Text   string       `json:"text"`
+	Words  []SyncedWord `json:"words,omitempty"`
+}
+
+// SyncedPosition is the line being sung at a point in time, with the line after it
+type SyncedPosition struct {
+	SongID int         `json:"songId"`
+	TimeMs int         `json:"timeMs"`
+	Line   *SyncedLine `json:"line"`
+	Next   *SyncedLine `json:"next,omitempty"`
+}
+
+// SyncedLyrics are the time-synced lines of a song
+type SyncedLyrics struct {
+	SongID int          `json:"songId"`
+	Lines  []SyncedLine `json:"lines"`
+}
+
+// Playlist visibilities: private playlists are seen only by their owner, unlisted ones by anyone
+// with the link and public ones are also listed to everyone
+const (
+	VisibilityPrivate  = "private"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPublic   = "public"
+)
+
+// Visibilities lists the valid playlist visibilities
+var Visibilities = []string{VisibilityPrivate, VisibilityUnlisted, VisibilityPublic}
+
+// Playlist is an ordered list of songs owned by a subject; Items is only filled for a single playlist
+type Playlist struct {
+	ID          int            `json:"id"`
+	Name        string         `json:"name"`
+	Description string         `json:"description"`
+	Owner       string         `json:"owner"`
+	Visibility  string         `json:"visibility"`
+	ItemCount   int            `json:"itemCount"`
+	CreatedAt   time.Time      `json:"createdAt"`
+	UpdatedAt   time.Time      `json:"updatedAt"`
+	Items       []PlaylistItem `json:"items,omitempty"`
+}
+
+// PlaylistItem is a song in a playlist; Position starts at 1 and the song is a summary without lyrics
+type PlaylistItem struct {
+	ID       int       `json:"id"`
+	Position int       `json:"position"`
+	AddedBy  string    `json:"addedBy"`
+	AddedAt  time.Time `json:"addedAt"`
+	Song     Song      `json:"song"`
+}
+
+// Tag types
+const (
+	TagGenre    = "genre"
+	TagMood     = "mood"
+	TagLanguage = "language"
+	TagDecade   = "decade"
+)
+
+// TagTypes lists the valid tag types
+var TagTypes = []string{TagGenre, TagMood, TagLanguage, TagDecade}
+
+// Tag categorizes songs; genres may have a parent genre, e.g. rock is the parent of alternative rock
+type Tag struct {
+	ID       int    `json:"id"`
+	Type     string `json:"type"`
+	Name     string `json:"name"`
+	Slug     string `json:"slug"`
+	ParentID *int   `json:"parentId,omitempty"`
+}
+
+// TagFacet is the number of matching songs with a tag; a genre also counts the songs of its subgenres
+type TagFacet struct {
+	Tag
+	Count int `json:"count"`
+}
+
+// SongsWithFacets is a page of songs with the tag counts of every song matching the filter, by tag type
+type SongsWithFacets struct {
+	Songs  []Song                `json:"songs"`
+	Facets map[string][]TagFacet `json:"facets"`
+}
+
+// Credit roles
+const (
+	CreditWriter   = "writer"
+	CreditComposer = "composer"
+	CreditLyricist = "lyricist"
+	CreditProducer = "producer"
+	CreditFeatured = "featured"
+)
+
+// CreditRoles lists the valid credit roles in the order credits are listed
+var CreditRoles = []string{CreditWriter, CreditComposer, CreditLyricist, CreditProducer, CreditFeatured}
+
+// Person is a songwriter, composer, producer or artist who can be credited on songs
+type Person struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// Credit is a person credited on a song in a role; a person may hold several roles on one song
+type Credit struct {
+	PersonID int    `json:"personId"`
+	Name     string `json:"name"`
+	Role     string `json:"role"`
+}
+
+// CreditedSong is a song a person is credited on, with the person's roles on it
+type CreditedSong struct {
+	Song  Song     `json:"song"`
+	Roles []string `json:"roles"`
+}
+
+// Link providers
+const (
+	ProviderYouTube     = "youtube"
+	ProviderSpotify     = "spotify"
+	ProviderAppleMusic  = "apple_music"
+	ProviderSoundCloud  = "soundcloud"
+	ProviderDeezer      = "deezer"
+	ProviderYandexMusic = "yandex_music"
+	ProviderBandcamp    = "bandcamp"
+	ProviderGenius      = "genius"
+	ProviderOther       = "other"
+)
+
+// LinkProviders lists the valid link providers
+var LinkProviders = []string{
+	ProviderYouTube, ProviderSpotify, ProviderAppleMusic, ProviderSoundCloud, ProviderDeezer,
+	ProviderYandexMusic, ProviderBandcamp, ProviderGenius, ProviderOther,
+}
+
+// Link kinds
+const (
+	LinkVideo        = "video"
+	LinkAudio        = "audio"
+	LinkLyricsSource = "lyrics_source"
+	LinkOther        = "other"
+)
+
+// LinkKinds lists the valid link kinds
+var LinkKinds = []string{LinkVideo, LinkAudio, LinkLyricsSource, LinkOther}
+
+// SongLink is a media link of a song with the result of its last health check
+type SongLink struct {
+	ID            int        `json:"id"`
+	SongID        int        `json:"songId"`
+	URL           string     `json:"url"`
+	Provider      string     `json:"provider"`
+	Kind          string     `json:"kind"`
+	CreatedAt     time.Time  `json:"createdAt"`
+	LastCheckedAt *time.Time `json:"lastCheckedAt,omitempty"`
+	LastStatus    *int       `json:"lastStatus,omitempty"`
+	LastError     string     `json:"lastError,omitempty"`
+	Failures      int        `json:"failures"`
+	Dead          bool       `json:"dead"`
+}
+
+// Attachment kinds
+const (
+	AttachmentCover = "cover"
+	AttachmentOther = "other"
+)
+
+// AttachmentKinds lists the valid attachment kinds
+var AttachmentKinds = []string{AttachmentCover, AttachmentOther}
+
+// Attachment is a file uploaded to a song, such as its cover. URL and Thumbnails are signed download
+// URLs valid until ExpiresAt; images that can be scaled have a thumbnail URL per size.
+type Attachment struct {
+	ID          int               `json:"id"`
+	SongID      int               `json:"songId"`
+	Kind        string            `json:"kind"`
+	Filename    string            `json:"filename"`
+	ContentType string            `json:"contentType"`
+	Size        int64             `json:"size"`
+	SHA256      string            `json:"sha256"`
+	Width       *int              `json:"width,omitempty"`
+	Height      *int              `json:"height,omitempty"`
+	UploadedBy  string            `json:"uploadedBy,omitempty"`
+	CreatedAt   time.Time         `json:"createdAt"`
+	URL         string            `json:"url"`
+	Thumbnails  map[string]string `json:"thumbnails,omitempty"`
+	ExpiresAt   time.Time         `json:"expiresAt"`
+}
+
+// SongFile is an audio file ingested into the library
+type SongFile struct {
+	ID       int    `json:"id"`
+	SongID   int    `json:"songId"`
+	Filename string `json:"filename"`
+	Format   string `json:"format"`
+	Size     int64  `json:"size"`
+	// DurationMs is the length of the audio in milliseconds
+	DurationMs  *int      `json:"durationMs,omitempty"`
+	SampleRate  *int      `json:"sampleRate,omitempty"`
+	Bitrate     *int      `json:"bitrate,omitempty"`
+	Album       string    `json:"album,omitempty"`
+	SHA256      string    `json:"sha256"`
+	AudioSHA256 string    `json:"audioSha256"`
+	IngestedBy  string    `json:"ingestedBy,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Outcomes of ingesting a file
+const (
+	IngestCreated   = "created"
+	IngestMatched   = "matched"
+	IngestDuplicate = "duplicate"
+	IngestFailed    = "failed"
+)
+
+// IngestResult is the outcome of ingesting an audio file: a new song was created, the file was
+// matched to an existing song with the same group and song, the file or its audio had been
+// ingested before, or the file could not be read
+type IngestResult struct {
+	File   string `json:"file"`
+	Status string `json:"status"`
+	SongID int    `json:"songId,omitempty"`
+	Group  string `json:"group,omitempty"`
+	Song   string `json:"song,omitempty"`
+	// Duration is the length of the audio in seconds
+	Duration *int   `json:"duration,omitempty"`
+	SHA256   string `json:"sha256,omitempty"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Types of song change events
+const (
+	EventSongCreated = "song.created"
+	EventSongUpdated = "song.updated"
+	EventSongDeleted = "song.deleted"
+)
+
+// EventTypes lists the valid event types
+var EventTypes = []string{EventSongCreated, EventSongUpdated, EventSongDeleted}
+
+// SongEvent is a change to a song, with the group and song as they were after it
+type SongEvent struct {
+	ID         int64     `json:"id"`
+	Type       string    `json:"type"`
+	SongID     int       `json:"songId"`
+	Group      string    `json:"group"`
+	Song       string    `json:"song"`
+	OccurredAt time.Time `json:"occurredAt"`
+}
+
+// WebhookSubscription asks for the song events of some types to be posted to a URL
+type WebhookSubscription struct {
+	ID         int      `json:"id"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"eventTypes"`
+	// Secret signs the deliveries; it is only returned when the subscription is created
+	Secret      string    `json:"secret,omitempty"`
+	Active      bool      `json:"active"`
+	Description string    `json:"description,omitempty"`
+	CreatedBy   string    `json:"createdBy,omitempty"`
+	CreatedAt   time.Time `json:"createdAt"`
+}
+
+// Statuses of webhook deliveries
+const (
+	DeliveryPending   = "pending"
+	DeliveryDelivered = "delivered"
+	DeliveryDead      = "dead"
+)
+
+// DeliveryStatuses lists the valid delivery statuses
+var DeliveryStatuses = []string{DeliveryPending, DeliveryDelivered, DeliveryDead}
+
+// WebhookDelivery is the delivery of an event to a subscription, with the outcome of its last attempt
+type WebhookDelivery struct {
+	ID             int64      `json:"id"`
+	SubscriptionID int        `json:"subscriptionId"`
+	Event          SongEvent  `json:"event"`
+	Status         string     `json:"status"`
+	Attempts       int        `json:"attempts"`
+	NextAttemptAt  *time.Time `json:"nextAttemptAt,omitempty"`
+	LastAttemptAt  *time.Time `json:"lastAttemptAt,omitempty"`
+	LastStatus     *int       `json:"lastStatus,omitempty"`
+	LastError      string     `json:"lastError,omitempty"`
+	DeliveredAt    *time.Time `json:"deliveredAt,omitempty"`
+	CreatedAt      time.Time  `json:"createdAt"`
+}
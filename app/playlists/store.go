@@ -0,0 +1,470 @@
+package playlists
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"song_library/db"
+	"song_library/models"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrNotFound     = errors.New("playlist not found")
+	ErrItemNotFound = errors.New("playlist item not found")
+	ErrSongNotFound = errors.New("song not found")
+	ErrForbidden    = errors.New("playlist belongs to another subject")
+	ErrInvalid      = errors.New("invalid playlist")
+)
+
+const (
+	// rankGap is the distance between the ranks of appended items and of items after a rebalance
+	rankGap = 1024.0
+	// minRankGap is the closest two neighbours may get before the playlist is rebalanced
+	minRankGap = 1e-6
+	// maxNameLength matches playlists.name
+	maxNameLength = 128
+)
+
+// Viewer is the subject a playlist is read or changed for; admins may read and change every playlist
+type Viewer struct {
+	Subject string
+	Admin   bool
+}
+
+func (v Viewer) canRead(owner, visibility string) bool {
+	return v.Admin || owner == v.Subject || visibility != models.VisibilityPrivate
+}
+
+func (v Viewer) canWrite(owner string) bool {
+	return v.Admin || owner == v.Subject
+}
+
+// Changes are the playlist fields to update; nil fields are kept
+type Changes struct {
+	Name        *string `json:"name"`
+	Description *string `json:"description"`
+	Visibility  *string `json:"visibility"`
+}
+
+// normalize trims the name and defaults the visibility, and checks them against the schema
+func normalize(name, visibility *string) error {
+	if name != nil {
+		*name = strings.TrimSpace(*name)
+		if *name == "" {
+			return fmt.Errorf("%w: name is required", ErrInvalid)
+		}
+		if utf8.RuneCountInString(*name) > maxNameLength {
+			return fmt.Errorf("%w: name is longer than %d characters", ErrInvalid, maxNameLength)
+		}
+	}
+	if visibility != nil {
+		*visibility = strings.ToLower(strings.TrimSpace(*visibility))
+		if *visibility == "" {
+			*visibility = models.VisibilityPrivate
+		}
+		if !slices.Contains(models.Visibilities, *visibility) {
+			return fmt.Errorf("%w: visibility must be one of %v", ErrInvalid, models.Visibilities)
+		}
+	}
+	return nil
+}
+
+// querier is satisfied by *sql.DB and *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+const playlistColumns = `
+	p.playlist_id, p.name, p.description, p.owner, p.visibility, p.created_at, p.updated_at,
+	(SELECT COUNT(*) FROM playlist_items i WHERE i.playlist_id = p.playlist_id)
+`
+
+func scanPlaylist(scan func(dest ...any) error) (models.Playlist, error) {
+	var p models.Playlist
+	err := scan(&p.ID, &p.Name, &p.Description, &p.Owner, &p.Visibility, &p.CreatedAt, &p.UpdatedAt, &p.ItemCount)
+	return p, err
+}
+
+// itemsQuery selects the items of playlist $1 in order, numbering their positions from 1
+const itemsQuery = `
+	SELECT i.item_id, ROW_NUMBER() OVER (ORDER BY i.rank, i.item_id), i.added_by, i.added_at,
+		s.song_id, s.group_name, s.song_name, COALESCE(s.release_date, ''), COALESCE(s.link, '')
+	FROM playlist_items i
+	JOIN songs s ON s.song_id = i.song_id
+	WHERE i.playlist_id = $1
+`
+
+func scanItems(rows *sql.Rows) ([]models.PlaylistItem, error) {
+	defer rows.Close()
+	items := []models.PlaylistItem{}
+	for rows.Next() {
+		var it models.PlaylistItem
+		s := &it.Song
+		if err := rows.Scan(&it.ID, &it.Position, &it.AddedBy, &it.AddedAt, &s.ID, &s.Group, &s.Song, &s.ReleaseDate, &s.Link); err != nil {
+			return nil, err
+		}
+		items = append(items, it)
+	}
+	return items, rows.Err()
+}
+
+// get returns a playlist the viewer may read with its items
+func get(ctx context.Context, q querier, viewer Viewer, playlistID int) (models.Playlist, error) {
+	p, err := scanPlaylist(q.QueryRowContext(ctx, "SELECT "+playlistColumns+" FROM playlists p WHERE p.playlist_id = $1", playlistID).Scan)
+	if errors.Is(err, sql.ErrNoRows) || (err == nil && !viewer.canRead(p.Owner, p.Visibility)) {
+		return models.Playlist{}, ErrNotFound
+	} else if err != nil {
+		return models.Playlist{}, err
+	}
+
+	rows, err := q.QueryContext(ctx, itemsQuery+" ORDER BY i.rank, i.item_id", playlistID)
+	if err != nil {
+		return models.Playlist{}, err
+	}
+	if p.Items, err = scanItems(rows); err != nil {
+		return models.Playlist{}, err
+	}
+	return p, nil
+}
+
+// item returns one item of a playlist with its position
+func item(ctx context.Context, q querier, playlistID, itemID int) (models.PlaylistItem, error) {
+	rows, err := q.QueryContext(ctx, "SELECT * FROM ("+itemsQuery+") items WHERE item_id = $2", playlistID, itemID)
+	if err != nil {
+		return models.PlaylistItem{}, err
+	}
+	items, err := scanItems(rows)
+	if err != nil {
+		return models.PlaylistItem{}, err
+	}
+	if len(items) == 0 {
+		return models.PlaylistItem{}, ErrItemNotFound
+	}
+	return items[0], nil
+}
+
+// lock locks a playlist for a change by the viewer; playlists the viewer cannot see are not found
+func lock(ctx context.Context, tx *sql.Tx, viewer Viewer, playlistID int) error {
+	var owner, visibility string
+	err := tx.QueryRowContext(ctx, "SELECT owner, visibility FROM playlists WHERE playlist_id = $1 FOR UPDATE", playlistID).Scan(&owner, &visibility)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return ErrNotFound
+	case err != nil:
+		return err
+	case !viewer.canRead(owner, visibility):
+		return ErrNotFound
+	case !viewer.canWrite(owner):
+		return ErrForbidden
+	}
+	return nil
+}
+
+// touch marks a playlist as changed
+func touch(ctx context.Context, tx *sql.Tx, playlistID int) error {
+	_, err := tx.ExecContext(ctx, "UPDATE playlists SET updated_at = NOW() WHERE playlist_id = $1", playlistID)
+	return err
+}
+
+// rankAt returns the rank that places an item at position among the other items of a playlist,
+// leaving exclude out. Position 0 or past the end appends. Neighbours too close to split are
+// spread out by rebalancing the playlist first.
+func rankAt(ctx context.Context, tx *sql.Tx, playlistID, exclude, position int) (float64, error) {
+	if position > 0 {
+		for attempt := 0; ; attempt++ {
+			rows, err := tx.QueryContext(ctx, `
+				SELECT rank FROM playlist_items WHERE playlist_id = $1 AND item_id <> $2
+				ORDER BY rank, item_id OFFSET $3 LIMIT 2
+			`, playlistID, exclude, max(position-2, 0))
+			if err != nil {
+				return 0, err
+			}
+			var ranks []float64
+			for rows.Next() {
+				var rank float64
+				if err := rows.Scan(&rank); err != nil {
+					rows.Close()
+					return 0, err
+				}
+				ranks = append(ranks, rank)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return 0, err
+			}
+
+			if position == 1 {
+				if len(ranks) == 0 {
+					return rankGap, nil
+				}
+				return ranks[0] - rankGap, nil
+			}
+			if len(ranks) < 2 {
+				// The item goes last
+				break
+			}
+			prev, next := ranks[0], ranks[1]
+			if mid := (prev + next) / 2; next-prev >= minRankGap && mid > prev && mid < next {
+				return mid, nil
+			}
+			if attempt > 0 {
+				return 0, fmt.Errorf("playlist %d: no room between ranks %v and %v after rebalancing", playlistID, prev, next)
+			}
+			if err := rebalance(ctx, tx, playlistID); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	var last sql.NullFloat64
+	err := tx.QueryRowContext(ctx, "SELECT MAX(rank) FROM playlist_items WHERE playlist_id = $1 AND item_id <> $2", playlistID, exclude).Scan(&last)
+	if err != nil {
+		return 0, err
+	}
+	return last.Float64 + rankGap, nil
+}
+
+// rebalance spreads the ranks of a playlist's items evenly, keeping their order
+func rebalance(ctx context.Context, tx *sql.Tx, playlistID int) error {
+	_, err := tx.ExecContext(ctx, `
+		UPDATE playlist_items i SET rank = o.n * $2::float8
+		FROM (
+			SELECT item_id, ROW_NUMBER() OVER (ORDER BY rank, item_id) AS n
+			FROM playlist_items WHERE playlist_id = $1
+		) o
+		WHERE i.item_id = o.item_id
+	`, playlistID, rankGap)
+	return err
+}
+
+// List returns a page of the playlists the viewer owns and the public playlists of others, most recently
+// changed first; admins see every playlist. A non-empty owner narrows the list to that subject.
+func List(ctx context.Context, viewer Viewer, owner string, limit, offset int) ([]models.Playlist, error) {
+	rows, err := db.Reader().QueryContext(ctx, "SELECT "+playlistColumns+`
+		FROM playlists p
+		WHERE ($1 OR p.owner = $2 OR p.visibility = 'public') AND ($3 = '' OR p.owner = $3)
+		ORDER BY p.updated_at DESC, p.playlist_id DESC
+		LIMIT $4 OFFSET $5
+	`, viewer.Admin, viewer.Subject, owner, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []models.Playlist{}
+	for rows.Next() {
+		p, err := scanPlaylist(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, p)
+	}
+	return list, rows.Err()
+}
+
+// Get returns a playlist with its items if the viewer may read it
+func Get(ctx context.Context, viewer Viewer, playlistID int) (models.Playlist, error) {
+	return get(ctx, db.Reader(), viewer, playlistID)
+}
+
+// Create stores a new empty playlist owned by the viewer
+func Create(ctx context.Context, viewer Viewer, p models.Playlist) (models.Playlist, error) {
+	if err := normalize(&p.Name, &p.Visibility); err != nil {
+		return models.Playlist{}, err
+	}
+	err := db.Db.QueryRowContext(ctx, `
+		INSERT INTO playlists (name, description, owner, visibility) VALUES ($1, $2, $3, $4)
+		RETURNING playlist_id
+	`, p.Name, p.Description, viewer.Subject, p.Visibility).Scan(&p.ID)
+	if err != nil {
+		return models.Playlist{}, err
+	}
+	return get(ctx, db.Db, viewer, p.ID)
+}
+
+// Update changes the name, description or visibility of a playlist
+func Update(ctx context.Context, viewer Viewer, playlistID int, changes Changes) (models.Playlist, error) {
+	if err := normalize(changes.Name, changes.Visibility); err != nil {
+		return models.Playlist{}, err
+	}
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		if err := lock(ctx, tx, viewer, playlistID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			UPDATE playlists SET
+				name = COALESCE($2, name),
+				description = COALESCE($3, description),
+				visibility = COALESCE($4, visibility),
+				updated_at = NOW()
+			WHERE playlist_id = $1
+		`, playlistID, changes.Name, changes.Description, changes.Visibility)
+		return err
+	})
+	if err != nil {
+		return models.Playlist{}, err
+	}
+	return get(ctx, db.Db, viewer, playlistID)
+}
+
+// Delete removes a playlist and its items
+func Delete(ctx context.Context, viewer Viewer, playlistID int) error {
+	return db.InTx(ctx, func(tx *sql.Tx) error {
+		if err := lock(ctx, tx, viewer, playlistID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, "DELETE FROM playlists WHERE playlist_id = $1", playlistID)
+		return err
+	})
+}
+
+// Duplicate copies a playlist the viewer may read into a new private playlist owned by the viewer.
+// An empty name names the copy after the source.
+func Duplicate(ctx context.Context, viewer Viewer, playlistID int, name string) (models.Playlist, error) {
+	var copyID int
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		var source models.Playlist
+		err := tx.QueryRowContext(ctx, `
+			SELECT name, description, owner, visibility FROM playlists WHERE playlist_id = $1 FOR SHARE
+		`, playlistID).Scan(&source.Name, &source.Description, &source.Owner, &source.Visibility)
+		if errors.Is(err, sql.ErrNoRows) || (err == nil && !viewer.canRead(source.Owner, source.Visibility)) {
+			return ErrNotFound
+		} else if err != nil {
+			return err
+		}
+
+		if name == "" {
+			name = source.Name + " (copy)"
+			if runes := []rune(name); len(runes) > maxNameLength {
+				name = string(runes[:maxNameLength])
+			}
+		}
+		visibility := models.VisibilityPrivate
+		if err := normalize(&name, &visibility); err != nil {
+			return err
+		}
+
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO playlists (name, description, owner, visibility) VALUES ($1, $2, $3, $4)
+			RETURNING playlist_id
+		`, name, source.Description, viewer.Subject, visibility).Scan(&copyID)
+		if err != nil {
+			return err
+		}
+		// Copied ranks are spread out again so the copy starts without crowded neighbours
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO playlist_items (playlist_id, song_id, rank, added_by)
+			SELECT $2, song_id, ROW_NUMBER() OVER (ORDER BY rank, item_id) * $3::float8, $4
+			FROM playlist_items WHERE playlist_id = $1
+		`, playlistID, copyID, rankGap, viewer.Subject)
+		return err
+	})
+	if err != nil {
+		return models.Playlist{}, err
+	}
+	return get(ctx, db.Db, viewer, copyID)
+}
+
+// AddItem adds a song to a playlist at position, counted from 1; position 0 appends
+func AddItem(ctx context.Context, viewer Viewer, playlistID, songID, position int) (models.PlaylistItem, error) {
+	var itemID int
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		if err := lock(ctx, tx, viewer, playlistID); err != nil {
+			return err
+		}
+		err := tx.QueryRowContext(ctx, "SELECT song_id FROM songs WHERE song_id = $1 FOR SHARE", songID).Scan(&songID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrSongNotFound
+		} else if err != nil {
+			return err
+		}
+
+		rank, err := rankAt(ctx, tx, playlistID, 0, position)
+		if err != nil {
+			return err
+		}
+		err = tx.QueryRowContext(ctx, `
+			INSERT INTO playlist_items (playlist_id, song_id, rank, added_by) VALUES ($1, $2, $3, $4)
+			RETURNING item_id
+		`, playlistID, songID, rank, viewer.Subject).Scan(&itemID)
+		if err != nil {
+			return err
+		}
+		return touch(ctx, tx, playlistID)
+	})
+	if err != nil {
+		return models.PlaylistItem{}, err
+	}
+	return item(ctx, db.Db, playlistID, itemID)
+}
+
+// MoveItem moves an item of a playlist to position, counted from 1; only the moved item is rewritten
+// unless its new neighbours are too close to split
+func MoveItem(ctx context.Context, viewer Viewer, playlistID, itemID, position int) (models.PlaylistItem, error) {
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		if err := lock(ctx, tx, viewer, playlistID); err != nil {
+			return err
+		}
+		err := tx.QueryRowContext(ctx, `
+			SELECT item_id FROM playlist_items WHERE playlist_id = $1 AND item_id = $2
+		`, playlistID, itemID).Scan(&itemID)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrItemNotFound
+		} else if err != nil {
+			return err
+		}
+
+		rank, err := rankAt(ctx, tx, playlistID, itemID, position)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE playlist_items SET rank = $2 WHERE item_id = $1", itemID, rank); err != nil {
+			return err
+		}
+		return touch(ctx, tx, playlistID)
+	})
+	if err != nil {
+		return models.PlaylistItem{}, err
+	}
+	return item(ctx, db.Db, playlistID, itemID)
+}
+
+// RemoveItem removes an item from a playlist
+func RemoveItem(ctx context.Context, viewer Viewer, playlistID, itemID int) error {
+	return db.InTx(ctx, func(tx *sql.Tx) error {
+		if err := lock(ctx, tx, viewer, playlistID); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, "DELETE FROM playlist_items WHERE playlist_id = $1 AND item_id = $2", playlistID, itemID)
+		if err != nil {
+			return err
+		}
+		if count, err := result.RowsAffected(); err != nil {
+			return err
+		} else if count == 0 {
+			return ErrItemNotFound
+		}
+		return touch(ctx, tx, playlistID)
+	})
+}
+
+// RemoveSong drops a song from every playlist ahead of deleting it and returns how many playlists had it
+func RemoveSong(ctx context.Context, tx *sql.Tx, songID int) (int, error) {
+	result, err := tx.ExecContext(ctx, `
+		UPDATE playlists SET updated_at = NOW()
+		WHERE playlist_id IN (SELECT playlist_id FROM playlist_items WHERE song_id = $1)
+	`, songID)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM playlist_items WHERE song_id = $1", songID); err != nil {
+		return 0, err
+	}
+	count, err := result.RowsAffected()
+	return int(count), err
+}
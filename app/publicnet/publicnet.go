@@ -0,0 +1,80 @@
+// Package publicnet makes outbound HTTP requests to user-supplied URLs, such as song links and
+// webhooks, without letting users reach the network the service runs in
+package publicnet
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrPrivateAddress refuses connections that would let users probe the network the service runs in
+var ErrPrivateAddress = errors.New("address is not public")
+
+// publicAddr reports whether an address may be requested: not loopback, private, link-local,
+// unspecified or multicast
+func publicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() && !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsLinkLocalUnicast() &&
+		!addr.IsUnspecified() && !addr.IsMulticast()
+}
+
+// AllowedHost reports whether a URL host may be requested as written; names are only checked once they
+// are resolved, when the client connects
+func AllowedHost(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return false
+	}
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return publicAddr(addr)
+	}
+	return true
+}
+
+// NewClient returns an HTTP client that connects to public addresses only. The check runs on the
+// resolved address of every connection, redirects included, so DNS names pointing inside the
+// network are refused as well.
+func NewClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+		Control: func(_, address string, _ syscall.RawConn) error {
+			addrPort, err := netip.ParseAddrPort(address)
+			if err != nil || !publicAddr(addrPort.Addr()) {
+				return ErrPrivateAddress
+			}
+			return nil
+		},
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	// A proxy would connect on the client's behalf, past the dialer
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+	return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// ErrorMessage describes a failed request without the addresses and resolver details of the error
+// itself, which would tell users about the network the service runs in
+func ErrorMessage(err error) string {
+	var netErr net.Error
+	var dnsErr *net.DNSError
+	var certErr *tls.CertificateVerificationError
+	switch {
+	case errors.Is(err, ErrPrivateAddress):
+		return "address is not public"
+	case errors.As(err, &dnsErr):
+		return "host not found"
+	case errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()):
+		return "timed out"
+	case errors.As(err, &certErr):
+		return "invalid TLS certificate"
+	default:
+		return "connection failed"
+	}
+}
@@ -0,0 +1,65 @@
+package ratelimit
+
+import (
+	"context"
+	"song_library/worker"
+	"sync"
+	"time"
+)
+
+type bucket struct {
+	tokens    float64
+	updatedAt time.Time
+}
+
+// MemoryBackend keeps buckets in process memory; limits are per replica
+type MemoryBackend struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+// NewMemoryBackend creates an in-memory backend and starts evicting idle buckets
+func NewMemoryBackend(workers *worker.Group) *MemoryBackend {
+	b := &MemoryBackend{buckets: map[string]*bucket{}}
+	workers.Go("ratelimit-evict", func(ctx context.Context) { b.evict(ctx, 10*time.Minute, time.Hour) })
+	return b
+}
+
+func (b *MemoryBackend) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	bk, ok := b.buckets[key]
+	if !ok {
+		bk = &bucket{tokens: float64(limit.Burst), updatedAt: now}
+		b.buckets[key] = bk
+	}
+
+	var result Result
+	bk.tokens, result = refill(bk.tokens, now.Sub(bk.updatedAt), limit)
+	bk.updatedAt = now
+	return result, nil
+}
+
+func (b *MemoryBackend) evict(ctx context.Context, interval, idle time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		cutoff := time.Now().Add(-idle)
+		b.mu.Lock()
+		for key, bk := range b.buckets {
+			if bk.updatedAt.Before(cutoff) {
+				delete(b.buckets, key)
+			}
+		}
+		b.mu.Unlock()
+	}
+}
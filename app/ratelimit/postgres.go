@@ -0,0 +1,78 @@
+package ratelimit
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+	"song_library/db"
+	"song_library/worker"
+	"time"
+)
+
+// PostgresBackend keeps buckets in the rate_limit_buckets table so that limits are shared by all replicas
+type PostgresBackend struct{}
+
+// NewPostgresBackend creates a Postgres backend and starts evicting idle buckets
+func NewPostgresBackend(workers *worker.Group) *PostgresBackend {
+	b := &PostgresBackend{}
+	workers.Go("ratelimit-evict", func(ctx context.Context) { b.evict(ctx, 10*time.Minute, time.Hour) })
+	return b
+}
+
+func (b *PostgresBackend) Take(ctx context.Context, key string, limit Limit) (Result, error) {
+	tx, err := db.Db.BeginTx(ctx, nil)
+	if err != nil {
+		return Result{}, err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx,
+		"INSERT INTO rate_limit_buckets (bucket_key, tokens) VALUES ($1, $2) ON CONFLICT DO NOTHING",
+		key, float64(limit.Burst),
+	)
+	if err != nil {
+		return Result{}, err
+	}
+
+	var tokens, elapsed float64
+	err = tx.QueryRowContext(ctx, `
+		SELECT tokens, EXTRACT(EPOCH FROM clock_timestamp() - updated_at)
+		FROM rate_limit_buckets WHERE bucket_key = $1 FOR UPDATE
+	`, key).Scan(&tokens, &elapsed)
+	if err != nil {
+		return Result{}, err
+	}
+
+	tokens, result := refill(tokens, time.Duration(elapsed*float64(time.Second)), limit)
+
+	_, err = tx.ExecContext(ctx,
+		"UPDATE rate_limit_buckets SET tokens = $2, updated_at = clock_timestamp() WHERE bucket_key = $1",
+		key, tokens,
+	)
+	if err != nil {
+		return Result{}, err
+	}
+	return result, tx.Commit()
+}
+
+func (b *PostgresBackend) evict(ctx context.Context, interval, idle time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		result, err := db.Db.ExecContext(ctx,
+			"DELETE FROM rate_limit_buckets WHERE updated_at < clock_timestamp() - $1 * INTERVAL '1 second'",
+			idle.Seconds(),
+		)
+		if err != nil {
+			log.Error().Err(err).Msg("Error evicting rate limit buckets")
+			continue
+		}
+		count, _ := result.RowsAffected()
+		log.Debug().Msgf("Evicted %d idle rate limit buckets", count)
+	}
+}
@@ -0,0 +1,51 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"time"
+)
+
+// Limit describes a token bucket: Burst tokens at most, refilled at PerMinute tokens per minute
+type Limit struct {
+	PerMinute int
+	Burst     int
+}
+
+func (l Limit) ratePerSecond() float64 {
+	return float64(l.PerMinute) / 60
+}
+
+// Result is the outcome of taking a token from a bucket
+type Result struct {
+	Allowed    bool
+	Remaining  int
+	RetryAfter time.Duration
+	ResetAfter time.Duration
+}
+
+// Backend stores token buckets
+type Backend interface {
+	Take(ctx context.Context, key string, limit Limit) (Result, error)
+}
+
+// refill returns the bucket level after elapsed time and the outcome of taking one token from it
+func refill(tokens float64, elapsed time.Duration, limit Limit) (float64, Result) {
+	rate := limit.ratePerSecond()
+	tokens = math.Min(float64(limit.Burst), tokens+elapsed.Seconds()*rate)
+
+	var result Result
+	if tokens >= 1 {
+		tokens--
+		result.Allowed = true
+	} else {
+		result.RetryAfter = secondsToDuration((1 - tokens) / rate)
+	}
+	result.Remaining = int(math.Floor(tokens))
+	result.ResetAfter = secondsToDuration((float64(limit.Burst) - tokens) / rate)
+	return tokens, result
+}
+
+func secondsToDuration(seconds float64) time.Duration {
+	return time.Duration(math.Ceil(seconds)) * time.Second
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"context"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	swaggerFiles "github.com/swaggo/files"
+	ginSwagger "github.com/swaggo/gin-swagger"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
+	"net/http"
+	"slices"
+	"song_library/attachments"
+	"song_library/auth"
+	"song_library/blobstore"
+	"song_library/config"
+	"song_library/events"
+	"song_library/handlers"
+	"song_library/links"
+	"song_library/logging"
+	"song_library/metrics"
+	"song_library/ratelimit"
+	"song_library/webhooks"
+	"song_library/worker"
+	"time"
+)
+
+// bodyLimit rejects request bodies larger than maxBytes, or than the limit of the route in routeLimits
+func bodyLimit(maxBytes int64, routeLimits map[string]int64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		maxBytes := maxBytes
+		if limit, ok := routeLimits[c.FullPath()]; ok {
+			maxBytes = limit
+		}
+		if c.Request.ContentLength > maxBytes {
+			c.AbortWithStatusJSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Request body is too large"})
+			return
+		}
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxBytes)
+		c.Next()
+	}
+}
+
+// requestTimeout bounds the request context, and with it every database query and outbound call of the request
+func requestTimeout(timeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// uploadTimeout replaces the server's read timeout and the request timeout, which are sized for
+// ordinary requests, with a deadline long enough to receive and process large uploads. The
+// response then has writeTimeout to be written.
+func uploadTimeout(timeout, writeTimeout time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		deadline := time.Now().Add(timeout)
+		rc := http.NewResponseController(c.Writer)
+		if err := rc.SetReadDeadline(deadline); err != nil {
+			logging.Ctx(c).Warn().Err(err).Msg("Couldn't extend the read deadline of an upload")
+		}
+		if err := rc.SetWriteDeadline(deadline.Add(writeTimeout)); err != nil {
+			logging.Ctx(c).Warn().Err(err).Msg("Couldn't extend the write deadline of an upload")
+		}
+		ctx, cancel := context.WithDeadline(c.Request.Context(), deadline)
+		defer cancel()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+	}
+}
+
+// cors answers preflight requests and sets CORS headers for the configured origins
+func cors(origins []string) gin.HandlerFunc {
+	if len(origins) == 0 {
+		return func(c *gin.Context) { c.Next() }
+	}
+	allowAny := slices.Contains(origins, "*")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		if origin == "" || !(allowAny || slices.Contains(origins, origin)) {
+			c.Next()
+			return
+		}
+
+		c.Header("Access-Control-Allow-Origin", origin)
+		c.Header("Vary", "Origin")
+		c.Header("Access-Control-Expose-Headers", "X-Request-ID, X-RateLimit-Limit, X-RateLimit-Remaining, X-RateLimit-Reset, Retry-After")
+		if c.Request.Method == http.MethodOptions {
+			c.Header("Access-Control-Allow-Methods", "GET, POST, PUT, PATCH, DELETE, OPTIONS")
+			c.Header("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key, X-Request-ID")
+			c.Header("Access-Control-Max-Age", "600")
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+		c.Next()
+	}
+}
+
+func newRouter(cfg *config.Config, workers *worker.Group, hub *events.Hub) *gin.Engine {
+	blobs, err := blobstore.New(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Str("backend", cfg.AttachmentsBackend).Msg("Blob store setup failed")
+	}
+	files, err := attachments.New(cfg, blobs)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Attachment store setup failed")
+	}
+	files.Start(workers)
+
+	router := gin.New()
+	// Gin trusts forwarding headers from any peer by default, which would let clients pick the IP
+	// that rate limits count them under
+	if err := router.SetTrustedProxies(cfg.HTTPTrustedProxies); err != nil {
+		log.Fatal().Err(err).Msg("Invalid trusted proxies")
+	}
+	router.Use(gin.Recovery())
+	router.Use(otelgin.Middleware(cfg.ServiceName))
+	router.Use(logging.Middleware())
+	router.Use(metrics.Middleware())
+	router.Use(cors(cfg.CORSAllowedOrigins))
+	// Uploads get room for the largest attachment and the multipart framing around it
+	router.Use(bodyLimit(cfg.HTTPMaxBodyBytes, map[string]int64{
+		"/songs/:song_id/attachments": files.MaxBytes() + 1<<20,
+		"/songs/ingest":               cfg.IngestMaxBytes,
+	}))
+	router.GET("/metrics", metrics.Handler())
+	router.GET("/healthz", handlers.Liveness)
+	router.GET("/readyz", handlers.Readiness(cfg))
+
+	limiter := ratelimit.NewBackend(workers, cfg)
+	reads := ratelimit.Middleware(limiter, "read", ratelimit.Limit{PerMinute: cfg.RateLimitReadPerMinute, Burst: cfg.RateLimitReadBurst})
+	writes := ratelimit.Middleware(limiter, "write", ratelimit.Limit{PerMinute: cfg.RateLimitWritePerMinute, Burst: cfg.RateLimitWriteBurst})
+	enrichment := ratelimit.Middleware(limiter, "enrich", ratelimit.Limit{PerMinute: cfg.RateLimitEnrichPerMinute, Burst: cfg.RateLimitEnrichBurst})
+	// Counted before authentication, so that requests with wrong credentials are limited as well
+	byIP := ratelimit.ByIP(limiter, "ip", ratelimit.Limit{PerMinute: cfg.RateLimitIPPerMinute, Burst: cfg.RateLimitIPBurst})
+
+	checker := links.NewChecker(cfg)
+	checker.Start(workers)
+	dispatcher := webhooks.NewDispatcher(cfg)
+	dispatcher.Start(workers)
+	hub.Start(workers)
+
+	router.GET("/files/:attachment_id", requestTimeout(cfg.HTTPRequestTimeout), reads, handlers.DownloadAttachment(files))
+
+	authenticate := auth.Middleware(cfg)
+	// Event streams outlive the request timeout, so they bound their own queries instead
+	router.GET("/events", byIP, auth.QueryToken("access_token"), authenticate, auth.Require(auth.PermSongsRead), reads,
+		handlers.StreamEvents(hub, cfg))
+
+	uploads := router.Group("/", uploadTimeout(cfg.HTTPUploadTimeout, cfg.HTTPWriteTimeout), byIP, authenticate)
+	uploads.POST("/songs/ingest", auth.Require(auth.PermSongsWrite), writes, handlers.IngestSongs)
+	uploads.POST("/songs/:song_id/attachments", auth.Require(auth.PermSongsWrite), writes, handlers.UploadSongAttachment(files))
+
+	api := router.Group("/", requestTimeout(cfg.HTTPRequestTimeout), byIP, authenticate)
+	api.GET("/songs", auth.Require(auth.PermSongsRead), reads, handlers.GetSongs(cfg))
+	api.GET("/songs/by-isrc/:isrc", auth.Require(auth.PermSongsRead), reads, handlers.GetSongByISRC)
+	api.GET("/songs/by-iswc/:iswc", auth.Require(auth.PermSongsRead), reads, handlers.GetSongsByISWC)
+	api.GET("/songs/by-mbid/:mbid", auth.Require(auth.PermSongsRead), reads, handlers.GetSongsByMBID)
+	api.GET("/songs/lyrics/:song_id", auth.Require(auth.PermSongsRead), reads, handlers.GetSongLyrics)
+	api.GET("/songs/:song_id/lyrics/sections/:position", auth.Require(auth.PermSongsRead), reads, handlers.GetLyricsSection)
+	api.PUT("/songs/:song_id/lyrics/sections", auth.Require(auth.PermSongsWrite), writes, handlers.ReplaceLyricsSections)
+	api.PATCH("/songs/:song_id/lyrics/sections/:position", auth.Require(auth.PermSongsWrite), writes, handlers.UpdateLyricsSection)
+	api.PUT("/songs/:song_id/lyrics/synced", auth.Require(auth.PermSongsWrite), writes, handlers.ReplaceSyncedLyrics)
+	api.GET("/songs/:song_id/lyrics/languages", auth.Require(auth.PermSongsRead), reads, handlers.ListLyricsLanguages)
+	api.PUT("/songs/:song_id/lyrics/languages/:lang", auth.Require(auth.PermSongsWrite), writes, handlers.PutLyricsLanguage)
+	api.DELETE("/songs/:song_id/lyrics/languages/:lang", auth.Require(auth.PermSongsWrite), writes, handlers.DeleteLyricsLanguage)
+	api.PUT("/songs/:song_id/lyrics/original", auth.Require(auth.PermSongsWrite), writes, handlers.SetOriginalLanguage)
+	api.GET("/songs/:song_id/lyrics/side-by-side", auth.Require(auth.PermSongsRead), reads, handlers.GetLyricsSideBySide)
+	api.GET("/songs/:song_id/lyrics.lrc", auth.Require(auth.PermSongsRead), reads, handlers.GetLyricsLRC)
+	api.GET("/songs/:song_id/lyrics/at", auth.Require(auth.PermSongsRead), reads, handlers.GetLyricsAt)
+	api.DELETE("/songs/:song_id", auth.Require(auth.PermSongsDelete), writes, handlers.DeleteSong)
+	api.PUT("/songs/:song_id", auth.Require(auth.PermSongsWrite), writes, handlers.UpdateSong)
+	api.POST("/songs", auth.Require(auth.PermSongsWrite), writes, enrichment, handlers.AddSong(cfg))
+	api.GET("/songs/:song_id/files", auth.Require(auth.PermSongsRead), reads, handlers.GetSongFiles)
+
+	api.GET("/songs/:song_id/tags", auth.Require(auth.PermSongsRead), reads, handlers.GetSongTags)
+	api.PUT("/songs/:song_id/tags", auth.Require(auth.PermSongsWrite), writes, handlers.SetSongTags)
+	api.POST("/songs/:song_id/tags", auth.Require(auth.PermSongsWrite), writes, handlers.TagSong)
+	api.DELETE("/songs/:song_id/tags/:tag_id", auth.Require(auth.PermSongsWrite), writes, handlers.UntagSong)
+	api.GET("/songs/:song_id/links", auth.Require(auth.PermSongsRead), reads, handlers.GetSongLinks)
+	api.POST("/songs/:song_id/links", auth.Require(auth.PermSongsWrite), writes, handlers.AddSongLink)
+	api.PATCH("/songs/:song_id/links/:link_id", auth.Require(auth.PermSongsWrite), writes, handlers.UpdateSongLink)
+	api.DELETE("/songs/:song_id/links/:link_id", auth.Require(auth.PermSongsWrite), writes, handlers.DeleteSongLink)
+	api.POST("/songs/:song_id/links/:link_id/check", auth.Require(auth.PermSongsWrite), writes, enrichment, handlers.CheckSongLink(checker))
+	api.GET("/links/dead", auth.Require(auth.PermSongsRead), reads, handlers.GetDeadLinks(cfg))
+	api.GET("/songs/:song_id/attachments", auth.Require(auth.PermSongsRead), reads, handlers.GetSongAttachments(files))
+	api.GET("/songs/:song_id/attachments/:attachment_id", auth.Require(auth.PermSongsRead), reads, handlers.GetSongAttachment(files))
+	api.DELETE("/songs/:song_id/attachments/:attachment_id", auth.Require(auth.PermSongsWrite), writes, handlers.DeleteSongAttachment(files))
+	api.GET("/songs/:song_id/cover", auth.Require(auth.PermSongsRead), reads, handlers.GetSongCover(files))
+	api.GET("/songs/:song_id/credits", auth.Require(auth.PermSongsRead), reads, handlers.GetSongCredits)
+	api.PUT("/songs/:song_id/credits", auth.Require(auth.PermSongsWrite), writes, handlers.SetSongCredits)
+	api.POST("/songs/:song_id/credits", auth.Require(auth.PermSongsWrite), writes, handlers.AddSongCredit)
+	api.DELETE("/songs/:song_id/credits/:person_id", auth.Require(auth.PermSongsWrite), writes, handlers.RemoveSongCredit)
+	api.GET("/people", auth.Require(auth.PermSongsRead), reads, handlers.GetPeople(cfg))
+	api.POST("/people", auth.Require(auth.PermSongsWrite), writes, handlers.CreatePerson)
+	api.GET("/people/:person_id", auth.Require(auth.PermSongsRead), reads, handlers.GetPerson)
+	api.PATCH("/people/:person_id", auth.Require(auth.PermSongsWrite), writes, handlers.UpdatePerson)
+	api.DELETE("/people/:person_id", auth.Require(auth.PermSongsDelete), writes, handlers.DeletePerson)
+	api.GET("/people/:person_id/songs", auth.Require(auth.PermSongsRead), reads, handlers.GetPersonSongs(cfg))
+	api.GET("/tags", auth.Require(auth.PermSongsRead), reads, handlers.GetTags)
+	api.POST("/tags", auth.Require(auth.PermSongsWrite), writes, handlers.CreateTag)
+	api.PATCH("/tags/:tag_id", auth.Require(auth.PermSongsWrite), writes, handlers.UpdateTag)
+	api.DELETE("/tags/:tag_id", auth.Require(auth.PermSongsDelete), writes, handlers.DeleteTag)
+
+	api.GET("/playlists", auth.Require(auth.PermSongsRead), reads, handlers.GetPlaylists(cfg))
+	api.POST("/playlists", auth.Require(auth.PermPlaylistsWrite), writes, handlers.CreatePlaylist)
+	api.GET("/playlists/:playlist_id", auth.Require(auth.PermSongsRead), reads, handlers.GetPlaylist)
+	api.PATCH("/playlists/:playlist_id", auth.Require(auth.PermPlaylistsWrite), writes, handlers.UpdatePlaylist)
+	api.DELETE("/playlists/:playlist_id", auth.Require(auth.PermPlaylistsWrite), writes, handlers.DeletePlaylist)
+	api.POST("/playlists/:playlist_id/duplicate", auth.Require(auth.PermPlaylistsWrite), writes, handlers.DuplicatePlaylist)
+	api.POST("/playlists/:playlist_id/items", auth.Require(auth.PermPlaylistsWrite), writes, handlers.AddPlaylistItem)
+	api.PATCH("/playlists/:playlist_id/items/:item_id", auth.Require(auth.PermPlaylistsWrite), writes, handlers.MovePlaylistItem)
+	api.DELETE("/playlists/:playlist_id/items/:item_id", auth.Require(auth.PermPlaylistsWrite), writes, handlers.RemovePlaylistItem)
+
+	hooks := api.Group("/webhooks", auth.Require(auth.PermWebhooksManage))
+	hooks.GET("", reads, handlers.GetWebhooks)
+	hooks.POST("", writes, handlers.CreateWebhook)
+	hooks.GET("/:webhook_id", reads, handlers.GetWebhook)
+	hooks.PATCH("/:webhook_id", writes, handlers.UpdateWebhook)
+	hooks.DELETE("/:webhook_id", writes, handlers.DeleteWebhook)
+	hooks.GET("/:webhook_id/deliveries", reads, handlers.GetWebhookDeliveries(cfg))
+	hooks.POST("/:webhook_id/deliveries/:delivery_id/redeliver", writes, handlers.RedeliverWebhook)
+
+	admin := api.Group("/admin", auth.Require(auth.PermAdmin), writes)
+	admin.GET("/roles", handlers.ListRoles)
+	admin.GET("/subjects/:subject/roles", handlers.GetSubjectRoles)
+	admin.POST("/subjects/:subject/roles", handlers.GrantRole)
+	admin.DELETE("/subjects/:subject/roles/:role", handlers.RevokeRole)
+
+	router.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	return router
+}
+
+func newServer(cfg *config.Config, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              ":" + cfg.AppPort,
+		Handler:           handler,
+		ReadTimeout:       cfg.HTTPReadTimeout,
+		ReadHeaderTimeout: cfg.HTTPReadHeaderTimeout,
+		WriteTimeout:      cfg.HTTPWriteTimeout,
+		IdleTimeout:       cfg.HTTPIdleTimeout,
+		MaxHeaderBytes:    cfg.HTTPMaxHeaderBytes,
+	}
+}
@@ -0,0 +1,433 @@
+package tags
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"slices"
+	"song_library/db"
+	"song_library/models"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+var (
+	ErrNotFound     = errors.New("tag not found")
+	ErrSongNotFound = errors.New("song not found")
+	ErrInvalid      = errors.New("invalid tag")
+)
+
+// maxNameLength matches tags.name and tags.slug
+const maxNameLength = 64
+
+// Slugify lowercases a name and joins its words with hyphens: "Alternative Rock" becomes alternative-rock
+func Slugify(name string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(name) {
+		if r == '\'' || r == '’' {
+			// 90's becomes 90s rather than 90-s
+			continue
+		}
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+	return b.String()
+}
+
+// Ref refers to a tag by type and slug, written genre:rock; a bare slug matches a tag of any type
+type Ref struct {
+	Type string
+	Slug string
+}
+
+// ParseRef parses genre:rock or rock into a Ref
+func ParseRef(s string) (Ref, error) {
+	var ref Ref
+	if tagType, slug, found := strings.Cut(s, ":"); found && slices.Contains(models.TagTypes, strings.ToLower(tagType)) {
+		ref = Ref{Type: strings.ToLower(tagType), Slug: Slugify(slug)}
+	} else {
+		ref = Ref{Slug: Slugify(s)}
+	}
+	if ref.Slug == "" {
+		return Ref{}, fmt.Errorf("%w: %q is not a tag, expected type:slug or slug", ErrInvalid, s)
+	}
+	return ref, nil
+}
+
+func (r Ref) String() string {
+	if r.Type == "" {
+		return r.Slug
+	}
+	return r.Type + ":" + r.Slug
+}
+
+// Condition returns an SQL condition on songs.song_id that matches the songs tagged with ref or, for a
+// genre, with any of its subgenres. Its placeholders start at $arg.
+func Condition(ref Ref, arg int) (string, []any) {
+	return fmt.Sprintf(`song_id IN (
+		SELECT st.song_id FROM song_tags st WHERE st.tag_id IN (
+			WITH RECURSIVE sub AS (
+				SELECT tag_id FROM tags WHERE slug = $%d AND ($%d = '' OR tag_type = $%d)
+				UNION
+				SELECT t.tag_id FROM tags t JOIN sub ON t.parent_id = sub.tag_id
+			)
+			SELECT tag_id FROM sub
+		)
+	)`, arg, arg+1, arg+1), []any{ref.Slug, ref.Type}
+}
+
+// Facets counts the songs matching where, an SQL WHERE clause on songs (or ""), per tag and groups the
+// counts by tag type. A genre counts the songs of its subgenres too.
+func Facets(ctx context.Context, where string, args []any) (map[string][]models.TagFacet, error) {
+	rows, err := db.Reader().QueryContext(ctx, `
+		WITH RECURSIVE matched AS (
+			SELECT song_id FROM songs`+where+`
+		), closure (ancestor, tag_id) AS (
+			SELECT tag_id, tag_id FROM tags
+			UNION
+			SELECT closure.ancestor, t.tag_id FROM closure JOIN tags t ON t.parent_id = closure.tag_id
+		)
+		SELECT a.tag_id, a.tag_type, a.name, a.slug, a.parent_id, COUNT(DISTINCT st.song_id) AS songs
+		FROM closure
+		JOIN song_tags st ON st.tag_id = closure.tag_id
+		JOIN matched m ON m.song_id = st.song_id
+		JOIN tags a ON a.tag_id = closure.ancestor
+		GROUP BY a.tag_id
+		ORDER BY a.tag_type, songs DESC, a.name
+	`, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	facets := map[string][]models.TagFacet{}
+	for _, tagType := range models.TagTypes {
+		facets[tagType] = []models.TagFacet{}
+	}
+	for rows.Next() {
+		var f models.TagFacet
+		if err := rows.Scan(&f.ID, &f.Type, &f.Name, &f.Slug, &f.ParentID, &f.Count); err != nil {
+			return nil, err
+		}
+		facets[f.Type] = append(facets[f.Type], f)
+	}
+	return facets, rows.Err()
+}
+
+// querier is satisfied by *sql.DB and *sql.Tx
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+const tagColumns = "tag_id, tag_type, name, slug, parent_id"
+
+func scanTags(rows *sql.Rows) ([]models.Tag, error) {
+	defer rows.Close()
+	list := []models.Tag{}
+	for rows.Next() {
+		var t models.Tag
+		if err := rows.Scan(&t.ID, &t.Type, &t.Name, &t.Slug, &t.ParentID); err != nil {
+			return nil, err
+		}
+		list = append(list, t)
+	}
+	return list, rows.Err()
+}
+
+func get(ctx context.Context, q querier, tagID int) (models.Tag, error) {
+	var t models.Tag
+	err := q.QueryRowContext(ctx, "SELECT "+tagColumns+" FROM tags WHERE tag_id = $1", tagID).
+		Scan(&t.ID, &t.Type, &t.Name, &t.Slug, &t.ParentID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.Tag{}, ErrNotFound
+	}
+	return t, err
+}
+
+// resolve returns the ID of the tag ref refers to
+func resolve(ctx context.Context, q querier, ref Ref) (int, error) {
+	rows, err := q.QueryContext(ctx, "SELECT tag_id FROM tags WHERE slug = $1 AND ($2 = '' OR tag_type = $2) LIMIT 2", ref.Slug, ref.Type)
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+	var ids []int
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+	switch len(ids) {
+	case 0:
+		return 0, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	case 1:
+		return ids[0], nil
+	default:
+		return 0, fmt.Errorf("%w: %s is ambiguous, prefix it with the tag type", ErrInvalid, ref)
+	}
+}
+
+// checkParent checks that parentID may become the parent genre of tagID (0 for a new tag)
+func checkParent(ctx context.Context, tx *sql.Tx, tagID int, tagType string, parentID int) error {
+	if tagType != models.TagGenre {
+		return fmt.Errorf("%w: only genres have a parent", ErrInvalid)
+	}
+	parent, err := get(ctx, tx, parentID)
+	if errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("%w: parent %d is not found", ErrInvalid, parentID)
+	} else if err != nil {
+		return err
+	}
+	if parent.Type != models.TagGenre {
+		return fmt.Errorf("%w: the parent must be a genre", ErrInvalid)
+	}
+	if tagID == 0 {
+		return nil
+	}
+
+	var cycle bool
+	err = tx.QueryRowContext(ctx, `
+		WITH RECURSIVE up AS (
+			SELECT tag_id, parent_id FROM tags WHERE tag_id = $1
+			UNION
+			SELECT t.tag_id, t.parent_id FROM tags t JOIN up ON t.tag_id = up.parent_id
+		)
+		SELECT EXISTS (SELECT 1 FROM up WHERE tag_id = $2)
+	`, parentID, tagID).Scan(&cycle)
+	if err != nil {
+		return err
+	}
+	if cycle {
+		return fmt.Errorf("%w: a genre cannot be nested under itself or its subgenres", ErrInvalid)
+	}
+	return nil
+}
+
+// normalize trims the name and derives the slug from it when it is empty
+func normalize(t *models.Tag) error {
+	t.Type = strings.ToLower(strings.TrimSpace(t.Type))
+	if !slices.Contains(models.TagTypes, t.Type) {
+		return fmt.Errorf("%w: type must be one of %v", ErrInvalid, models.TagTypes)
+	}
+	t.Name = strings.TrimSpace(t.Name)
+	if t.Name == "" || utf8.RuneCountInString(t.Name) > maxNameLength {
+		return fmt.Errorf("%w: name must have 1 to %d characters", ErrInvalid, maxNameLength)
+	}
+	if t.Slug == "" {
+		t.Slug = t.Name
+	}
+	t.Slug = Slugify(t.Slug)
+	if t.Slug == "" || utf8.RuneCountInString(t.Slug) > maxNameLength {
+		return fmt.Errorf("%w: slug must have 1 to %d letters or digits", ErrInvalid, maxNameLength)
+	}
+	return nil
+}
+
+// List returns the tags of a type, or all tags when tagType is empty, by type and name
+func List(ctx context.Context, tagType string) ([]models.Tag, error) {
+	rows, err := db.Reader().QueryContext(ctx, `
+		SELECT `+tagColumns+` FROM tags WHERE $1 = '' OR tag_type = $1 ORDER BY tag_type, name
+	`, tagType)
+	if err != nil {
+		return nil, err
+	}
+	return scanTags(rows)
+}
+
+// Create stores a new tag; the slug defaults to the slugified name
+func Create(ctx context.Context, t models.Tag) (models.Tag, error) {
+	if err := normalize(&t); err != nil {
+		return models.Tag{}, err
+	}
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		if t.ParentID != nil {
+			if err := checkParent(ctx, tx, 0, t.Type, *t.ParentID); err != nil {
+				return err
+			}
+		}
+		return tx.QueryRowContext(ctx, `
+			INSERT INTO tags (tag_type, name, slug, parent_id) VALUES ($1, $2, $3, $4) RETURNING tag_id
+		`, t.Type, t.Name, t.Slug, t.ParentID).Scan(&t.ID)
+	})
+	if err != nil {
+		return models.Tag{}, err
+	}
+	return t, nil
+}
+
+// Changes are the tag fields to update; nil fields are kept and a ParentID of 0 makes a genre top-level
+type Changes struct {
+	Name     *string `json:"name"`
+	Slug     *string `json:"slug"`
+	ParentID *int    `json:"parentId"`
+}
+
+// Update renames a tag or moves a genre under another parent
+func Update(ctx context.Context, tagID int, changes Changes) (models.Tag, error) {
+	var t models.Tag
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		var err error
+		if t, err = get(ctx, tx, tagID); err != nil {
+			return err
+		}
+		if changes.Name != nil {
+			t.Name = *changes.Name
+		}
+		if changes.Slug != nil {
+			t.Slug = *changes.Slug
+		}
+		if err := normalize(&t); err != nil {
+			return err
+		}
+		if changes.ParentID != nil {
+			t.ParentID = changes.ParentID
+			if *changes.ParentID == 0 {
+				t.ParentID = nil
+			} else if err := checkParent(ctx, tx, tagID, t.Type, *changes.ParentID); err != nil {
+				return err
+			}
+		}
+		_, err = tx.ExecContext(ctx, `
+			UPDATE tags SET name = $2, slug = $3, parent_id = $4 WHERE tag_id = $1
+		`, tagID, t.Name, t.Slug, t.ParentID)
+		return err
+	})
+	if err != nil {
+		return models.Tag{}, err
+	}
+	return t, nil
+}
+
+// Delete removes a tag from the taxonomy and from every song; subgenres move up to its parent
+func Delete(ctx context.Context, tagID int) error {
+	return db.InTx(ctx, func(tx *sql.Tx) error {
+		t, err := get(ctx, tx, tagID)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE tags SET parent_id = $2 WHERE parent_id = $1", tagID, t.ParentID); err != nil {
+			return err
+		}
+		_, err = tx.ExecContext(ctx, "DELETE FROM tags WHERE tag_id = $1", tagID)
+		return err
+	})
+}
+
+// songTags returns the tags of a song by type and name
+func songTags(ctx context.Context, q querier, songID int) ([]models.Tag, error) {
+	err := q.QueryRowContext(ctx, "SELECT song_id FROM songs WHERE song_id = $1", songID).Scan(&songID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrSongNotFound
+	} else if err != nil {
+		return nil, err
+	}
+	rows, err := q.QueryContext(ctx, `
+		SELECT t.tag_id, t.tag_type, t.name, t.slug, t.parent_id
+		FROM song_tags st JOIN tags t ON t.tag_id = st.tag_id
+		WHERE st.song_id = $1
+		ORDER BY t.tag_type, t.name
+	`, songID)
+	if err != nil {
+		return nil, err
+	}
+	return scanTags(rows)
+}
+
+// SongTags returns the tags of a song
+func SongTags(ctx context.Context, songID int) ([]models.Tag, error) {
+	return songTags(ctx, db.Reader(), songID)
+}
+
+// lockSong locks a song while its tags change
+func lockSong(ctx context.Context, tx *sql.Tx, songID int) error {
+	err := tx.QueryRowContext(ctx, "SELECT song_id FROM songs WHERE song_id = $1 FOR UPDATE", songID).Scan(&songID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrSongNotFound
+	}
+	return err
+}
+
+// SetSongTags replaces the tags of a song and returns them
+func SetSongTags(ctx context.Context, songID int, refs []Ref) ([]models.Tag, error) {
+	var list []models.Tag
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		if err := lockSong(ctx, tx, songID); err != nil {
+			return err
+		}
+		ids := make([]int, 0, len(refs))
+		for _, ref := range refs {
+			id, err := resolve(ctx, tx, ref)
+			if err != nil {
+				return err
+			}
+			ids = append(ids, id)
+		}
+
+		if _, err := tx.ExecContext(ctx, "DELETE FROM song_tags WHERE song_id = $1", songID); err != nil {
+			return err
+		}
+		_, err := tx.ExecContext(ctx, `
+			INSERT INTO song_tags (song_id, tag_id) SELECT $1, unnest($2::int[]) ON CONFLICT DO NOTHING
+		`, songID, ids)
+		if err != nil {
+			return err
+		}
+		list, err = songTags(ctx, tx, songID)
+		return err
+	})
+	return list, err
+}
+
+// TagSong adds a tag to a song; adding a tag the song already has does nothing
+func TagSong(ctx context.Context, songID int, ref Ref) (models.Tag, error) {
+	var t models.Tag
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		if err := lockSong(ctx, tx, songID); err != nil {
+			return err
+		}
+		id, err := resolve(ctx, tx, ref)
+		if err != nil {
+			return err
+		}
+		if _, err := tx.ExecContext(ctx, "INSERT INTO song_tags (song_id, tag_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", songID, id); err != nil {
+			return err
+		}
+		t, err = get(ctx, tx, id)
+		return err
+	})
+	return t, err
+}
+
+// UntagSong removes a tag from a song and reports whether the song had it
+func UntagSong(ctx context.Context, songID, tagID int) (bool, error) {
+	var removed bool
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		if err := lockSong(ctx, tx, songID); err != nil {
+			return err
+		}
+		result, err := tx.ExecContext(ctx, "DELETE FROM song_tags WHERE song_id = $1 AND tag_id = $2", songID, tagID)
+		if err != nil {
+			return err
+		}
+		count, err := result.RowsAffected()
+		removed = count > 0
+		return err
+	})
+	return removed, err
+}
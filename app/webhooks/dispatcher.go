@@ -0,0 +1,306 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"song_library/config"
+	"song_library/db"
+	"song_library/models"
+	"song_library/publicnet"
+	"song_library/worker"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// relayBatch is the most events a round fans out
+	relayBatch = 100
+	// deliveryBatch is the most deliveries a round claims
+	deliveryBatch = 100
+	userAgent     = "song_library webhooks"
+)
+
+// Headers of webhook deliveries
+const (
+	HeaderEvent     = "X-Webhook-Event"
+	HeaderEventID   = "X-Webhook-Event-Id"
+	HeaderDelivery  = "X-Webhook-Delivery"
+	HeaderSignature = "X-Webhook-Signature"
+)
+
+// Signature signs the body of a delivery sent at a Unix time, as "t=<timestamp>,v1=<hex HMAC-SHA256
+// of "<timestamp>.<body>">". Covering the timestamp lets receivers reject replayed deliveries.
+func Signature(secret string, timestamp int64, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	fmt.Fprintf(mac, "%d.", timestamp)
+	mac.Write(body)
+	return "t=" + strconv.FormatInt(timestamp, 10) + ",v1=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Dispatcher fans song events out to the matching webhook subscriptions and delivers them,
+// retrying failed deliveries with exponential backoff until they are dead-lettered
+type Dispatcher struct {
+	client      *http.Client
+	interval    time.Duration
+	timeout     time.Duration
+	concurrency int
+	maxAttempts int
+	retryBase   time.Duration
+	retryMax    time.Duration
+}
+
+// newClient returns a client that posts to public addresses only and does not follow redirects
+func newClient(timeout time.Duration) *http.Client {
+	client := publicnet.NewClient(timeout)
+	// A redirect is reported as a failure rather than followed with the signed body
+	client.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+	return client
+}
+
+// NewDispatcher builds a dispatcher from the configuration
+func NewDispatcher(cfg *config.Config) *Dispatcher {
+	return &Dispatcher{
+		client:      newClient(cfg.WebhookTimeout),
+		interval:    cfg.WebhookInterval,
+		timeout:     cfg.WebhookTimeout,
+		concurrency: cfg.WebhookConcurrency,
+		maxAttempts: cfg.WebhookMaxAttempts,
+		retryBase:   cfg.WebhookRetryBase,
+		retryMax:    cfg.WebhookRetryMax,
+	}
+}
+
+// Start runs the dispatcher in the worker group unless webhooks.interval is 0
+func (d *Dispatcher) Start(workers *worker.Group) {
+	if d.interval <= 0 {
+		log.Info().Msg("Webhook dispatcher is disabled")
+		return
+	}
+	workers.Go("webhook-dispatcher", d.run)
+}
+
+func (d *Dispatcher) run(ctx context.Context) {
+	ticker := time.NewTicker(d.interval)
+	defer ticker.Stop()
+	for {
+		// Keep going while full batches are waiting, then wait for the next tick
+		for {
+			relayed, err := d.Relay(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Msg("Error relaying song events to webhooks")
+			}
+			delivered, err := d.Round(ctx)
+			if err != nil && ctx.Err() == nil {
+				log.Error().Err(err).Msg("Error delivering webhooks")
+			}
+			if ctx.Err() != nil || (relayed < relayBatch && delivered < deliveryBatch) {
+				break
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Relay reads undispatched events from the outbox, queues a delivery for every active
+// subscription to their type that existed when they happened, and returns how many it relayed
+func (d *Dispatcher) Relay(ctx context.Context) (int, error) {
+	var relayed int
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		rows, err := tx.QueryContext(ctx, `
+			UPDATE song_events SET dispatched = TRUE
+			WHERE event_id IN (
+				SELECT event_id FROM song_events WHERE NOT dispatched
+				ORDER BY event_id LIMIT $1 FOR UPDATE SKIP LOCKED
+			)
+			RETURNING event_id
+		`, relayBatch)
+		if err != nil {
+			return err
+		}
+		var ids []int64
+		for rows.Next() {
+			var id int64
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			ids = append(ids, id)
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil || len(ids) == 0 {
+			return err
+		}
+		relayed = len(ids)
+
+		_, err = tx.ExecContext(ctx, `
+			INSERT INTO webhook_deliveries (subscription_id, event_id)
+			SELECT s.subscription_id, e.event_id
+			FROM song_events e JOIN webhook_subscriptions s
+				ON s.active AND e.event_type = ANY (s.event_types) AND s.created_at <= e.created_at
+			WHERE e.event_id = ANY ($1)
+			ORDER BY e.event_id
+			ON CONFLICT DO NOTHING
+		`, ids)
+		return err
+	})
+	return relayed, err
+}
+
+type delivery struct {
+	id       int64
+	attempts int
+	url      string
+	secret   string
+	event    models.SongEvent
+}
+
+// Round claims the deliveries that are due, sends them, at most concurrency at a time, and
+// returns how many it sent. Claiming pushes next_attempt_at past the timeout, so dispatchers on
+// other replicas skip them, and a delivery claimed by a replica that stops is retried afterwards.
+func (d *Dispatcher) Round(ctx context.Context) (int, error) {
+	rows, err := db.Db.QueryContext(ctx, `
+		UPDATE webhook_deliveries d SET next_attempt_at = NOW() + $1 * INTERVAL '1 second'
+		FROM webhook_subscriptions s, song_events e
+		WHERE d.delivery_id IN (
+			SELECT delivery_id FROM webhook_deliveries
+			WHERE status = 'pending' AND next_attempt_at <= NOW()
+			ORDER BY next_attempt_at
+			LIMIT $2
+			FOR UPDATE SKIP LOCKED
+		) AND s.subscription_id = d.subscription_id AND s.active AND e.event_id = d.event_id
+		RETURNING d.delivery_id, d.attempts, s.url, s.secret, e.event_id, e.event_type, e.song_id, e.group_name, e.song_name, e.created_at
+	`, 2*d.timeout.Seconds(), deliveryBatch)
+	if err != nil {
+		return 0, err
+	}
+	var batch []delivery
+	for rows.Next() {
+		var dl delivery
+		err := rows.Scan(&dl.id, &dl.attempts, &dl.url, &dl.secret,
+			&dl.event.ID, &dl.event.Type, &dl.event.SongID, &dl.event.Group, &dl.event.Song, &dl.event.OccurredAt)
+		if err != nil {
+			rows.Close()
+			return 0, err
+		}
+		batch = append(batch, dl)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	jobs := make(chan delivery)
+	var wg sync.WaitGroup
+	for range min(d.concurrency, len(batch)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for dl := range jobs {
+				status, err := d.send(ctx, dl)
+				if ctx.Err() != nil {
+					continue
+				}
+				if err := d.record(ctx, dl, status, err); err != nil {
+					log.Error().Err(err).Int64("delivery_id", dl.id).Msg("Error recording webhook delivery")
+				}
+			}
+		}()
+	}
+	for _, dl := range batch {
+		select {
+		case jobs <- dl:
+		case <-ctx.Done():
+		}
+	}
+	close(jobs)
+	wg.Wait()
+
+	log.Debug().Int("count", len(batch)).Msg("Sent webhook deliveries")
+	return len(batch), ctx.Err()
+}
+
+// send posts an event to a subscription and returns the response status
+func (d *Dispatcher) send(ctx context.Context, dl delivery) (int, error) {
+	body, err := json.Marshal(dl.event)
+	if err != nil {
+		return 0, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, dl.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", userAgent)
+	req.Header.Set(HeaderEvent, dl.event.Type)
+	req.Header.Set(HeaderEventID, strconv.FormatInt(dl.event.ID, 10))
+	req.Header.Set(HeaderDelivery, strconv.FormatInt(dl.id, 10))
+	req.Header.Set(HeaderSignature, Signature(dl.secret, time.Now().Unix(), body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 4096))
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
+
+// backoff is the delay before the retry that follows a number of failed attempts
+func (d *Dispatcher) backoff(attempts int) time.Duration {
+	delay := d.retryBase
+	for i := 1; i < attempts && delay < d.retryMax; i++ {
+		delay *= 2
+	}
+	return min(delay, d.retryMax)
+}
+
+// record stores the outcome of an attempt: a delivered delivery is done, a failed one is retried
+// after a backoff or, once it has used up its attempts, dead-lettered
+func (d *Dispatcher) record(ctx context.Context, dl delivery, status int, sendErr error) error {
+	attempts := dl.attempts + 1
+	var lastStatus *int
+	if status != 0 {
+		lastStatus = &status
+	}
+	var lastError *string
+	newStatus := models.DeliveryDelivered
+	if sendErr != nil {
+		message := sendErr.Error()
+		if status == 0 {
+			// The request did not get a response; its error may name addresses inside the network
+			message = publicnet.ErrorMessage(sendErr)
+		}
+		lastError = &message
+		newStatus = models.DeliveryPending
+		if attempts >= d.maxAttempts {
+			newStatus = models.DeliveryDead
+			log.Warn().Int64("delivery_id", dl.id).Int64("event_id", dl.event.ID).Str("url", dl.url).
+				Int("attempts", attempts).Msg("Webhook delivery dead-lettered")
+		}
+	}
+	_, err := db.Db.ExecContext(ctx, `
+		UPDATE webhook_deliveries SET
+			status = $2, attempts = $3, last_attempt_at = NOW(), last_status = $4, last_error = $5,
+			next_attempt_at = NOW() + $6 * INTERVAL '1 second',
+			delivered_at = CASE WHEN $2 = 'delivered' THEN NOW() END
+		WHERE delivery_id = $1
+	`, dl.id, newStatus, attempts, lastStatus, lastError, d.backoff(attempts).Seconds())
+	return err
+}
@@ -0,0 +1,289 @@
+// Package webhooks posts song change events to subscribed URLs
+package webhooks
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgtype"
+	"net/url"
+	"slices"
+	"song_library/db"
+	"song_library/models"
+	"song_library/publicnet"
+	"strings"
+	"unicode/utf8"
+)
+
+var (
+	ErrNotFound         = errors.New("webhook subscription not found")
+	ErrDeliveryNotFound = errors.New("webhook delivery not found")
+	ErrInvalid          = errors.New("invalid webhook subscription")
+	ErrInactive         = errors.New("webhook subscription is inactive")
+)
+
+// secretPrefix marks generated secrets
+const secretPrefix = "whsec_"
+
+// maxDescriptionLength matches webhook_subscriptions.description
+const maxDescriptionLength = 255
+
+type querier interface {
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// normalize checks a subscription, subscribing it to every event type when it names none
+func normalize(s *models.WebhookSubscription) error {
+	s.URL = strings.TrimSpace(s.URL)
+	if u, err := url.Parse(s.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return fmt.Errorf("%w: url must be an absolute http or https URL", ErrInvalid)
+	} else if !publicnet.AllowedHost(u.Hostname()) {
+		return fmt.Errorf("%w: url must point to a public address", ErrInvalid)
+	}
+	if len(s.EventTypes) == 0 {
+		s.EventTypes = slices.Clone(models.EventTypes)
+	}
+	for _, t := range s.EventTypes {
+		if !slices.Contains(models.EventTypes, t) {
+			return fmt.Errorf("%w: event types must be among %v, got %q", ErrInvalid, models.EventTypes, t)
+		}
+	}
+	slices.Sort(s.EventTypes)
+	s.EventTypes = slices.Compact(s.EventTypes)
+	if utf8.RuneCountInString(s.Description) > maxDescriptionLength {
+		return fmt.Errorf("%w: description is limited to %d characters", ErrInvalid, maxDescriptionLength)
+	}
+	return nil
+}
+
+// newSecret generates a signing secret
+func newSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return secretPrefix + base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+const subscriptionColumns = `subscription_id, url, event_types, active, COALESCE(description, ''), COALESCE(created_by, ''), created_at`
+
+func scanSubscription(scan func(dest ...any) error) (models.WebhookSubscription, error) {
+	var s models.WebhookSubscription
+	types := pgtype.NewMap()
+	err := scan(&s.ID, &s.URL, types.SQLScanner(&s.EventTypes), &s.Active, &s.Description, &s.CreatedBy, &s.CreatedAt)
+	return s, err
+}
+
+func get(ctx context.Context, q querier, subscriptionID int) (models.WebhookSubscription, error) {
+	s, err := scanSubscription(q.QueryRowContext(ctx,
+		"SELECT "+subscriptionColumns+" FROM webhook_subscriptions WHERE subscription_id = $1", subscriptionID).Scan)
+	if errors.Is(err, sql.ErrNoRows) {
+		return models.WebhookSubscription{}, ErrNotFound
+	}
+	return s, err
+}
+
+// List returns the webhook subscriptions in the order they were created
+func List(ctx context.Context) ([]models.WebhookSubscription, error) {
+	rows, err := db.Reader().QueryContext(ctx, "SELECT "+subscriptionColumns+" FROM webhook_subscriptions ORDER BY subscription_id")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	subscriptions := []models.WebhookSubscription{}
+	for rows.Next() {
+		s, err := scanSubscription(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		subscriptions = append(subscriptions, s)
+	}
+	return subscriptions, rows.Err()
+}
+
+// Get returns a webhook subscription
+func Get(ctx context.Context, subscriptionID int) (models.WebhookSubscription, error) {
+	return get(ctx, db.Reader(), subscriptionID)
+}
+
+// Create adds a webhook subscription, generating its secret when it has none. The subscription
+// receives the events that happen from now on.
+func Create(ctx context.Context, s models.WebhookSubscription) (models.WebhookSubscription, error) {
+	if err := normalize(&s); err != nil {
+		return models.WebhookSubscription{}, err
+	}
+	if s.Secret == "" {
+		var err error
+		if s.Secret, err = newSecret(); err != nil {
+			return models.WebhookSubscription{}, err
+		}
+	}
+	created, err := scanSubscription(db.Db.QueryRowContext(ctx, `
+		INSERT INTO webhook_subscriptions (url, event_types, secret, description, created_by)
+		VALUES ($1, $2, $3, NULLIF($4, ''), NULLIF($5, ''))
+		RETURNING `+subscriptionColumns, s.URL, s.EventTypes, s.Secret, s.Description, s.CreatedBy).Scan)
+	if err != nil {
+		return models.WebhookSubscription{}, err
+	}
+	created.Secret = s.Secret
+	return created, nil
+}
+
+// Changes are the subscription fields to update; nil fields are kept. A new secret is returned
+// with the updated subscription.
+type Changes struct {
+	URL         *string   `json:"url"`
+	EventTypes  *[]string `json:"eventTypes"`
+	Secret      *string   `json:"secret"`
+	Active      *bool     `json:"active"`
+	Description *string   `json:"description"`
+}
+
+// Update changes a webhook subscription
+func Update(ctx context.Context, subscriptionID int, changes Changes) (models.WebhookSubscription, error) {
+	var updated models.WebhookSubscription
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		s, err := get(ctx, tx, subscriptionID)
+		if err != nil {
+			return err
+		}
+		if changes.URL != nil {
+			s.URL = *changes.URL
+		}
+		if changes.EventTypes != nil {
+			s.EventTypes = *changes.EventTypes
+		}
+		if changes.Active != nil {
+			s.Active = *changes.Active
+		}
+		if changes.Description != nil {
+			s.Description = *changes.Description
+		}
+		if err := normalize(&s); err != nil {
+			return err
+		}
+		if changes.Secret != nil && strings.TrimSpace(*changes.Secret) == "" {
+			return fmt.Errorf("%w: secret must not be empty", ErrInvalid)
+		}
+
+		updated, err = scanSubscription(tx.QueryRowContext(ctx, `
+			UPDATE webhook_subscriptions SET url = $2, event_types = $3, active = $4, description = NULLIF($5, ''),
+				secret = COALESCE($6, secret)
+			WHERE subscription_id = $1
+			RETURNING `+subscriptionColumns, subscriptionID, s.URL, s.EventTypes, s.Active, s.Description, changes.Secret).Scan)
+		if err != nil {
+			return err
+		}
+		if changes.Secret != nil {
+			updated.Secret = *changes.Secret
+		}
+		if updated.Active {
+			return nil
+		}
+		// The dispatcher skips an inactive subscription, so its pending deliveries would never leave
+		// that state and would keep their events from being pruned
+		_, err = tx.ExecContext(ctx, `
+			UPDATE webhook_deliveries SET status = 'dead', last_error = 'subscription deactivated'
+			WHERE subscription_id = $1 AND status = 'pending'
+		`, subscriptionID)
+		return err
+	})
+	return updated, err
+}
+
+// Delete removes a webhook subscription along with its deliveries
+func Delete(ctx context.Context, subscriptionID int) error {
+	result, err := db.Db.ExecContext(ctx, "DELETE FROM webhook_subscriptions WHERE subscription_id = $1", subscriptionID)
+	if err != nil {
+		return err
+	}
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+const deliveryColumns = `d.delivery_id, d.subscription_id, e.event_id, e.event_type, e.song_id, e.group_name, e.song_name,
+	e.created_at, d.status, d.attempts, CASE WHEN d.status = 'pending' THEN d.next_attempt_at END, d.last_attempt_at,
+	d.last_status, COALESCE(d.last_error, ''), d.delivered_at, d.created_at`
+
+func scanDelivery(scan func(dest ...any) error) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	err := scan(&d.ID, &d.SubscriptionID, &d.Event.ID, &d.Event.Type, &d.Event.SongID, &d.Event.Group, &d.Event.Song,
+		&d.Event.OccurredAt, &d.Status, &d.Attempts, &d.NextAttemptAt, &d.LastAttemptAt,
+		&d.LastStatus, &d.LastError, &d.DeliveredAt, &d.CreatedAt)
+	return d, err
+}
+
+// Deliveries returns a page of the deliveries of a subscription, newest first, optionally only
+// those with a status
+func Deliveries(ctx context.Context, subscriptionID int, status string, limit, offset int) ([]models.WebhookDelivery, error) {
+	if status != "" && !slices.Contains(models.DeliveryStatuses, status) {
+		return nil, fmt.Errorf("%w: status must be one of %v", ErrInvalid, models.DeliveryStatuses)
+	}
+	if _, err := get(ctx, db.Reader(), subscriptionID); err != nil {
+		return nil, err
+	}
+	rows, err := db.Reader().QueryContext(ctx, "SELECT "+deliveryColumns+`
+		FROM webhook_deliveries d JOIN song_events e USING (event_id)
+		WHERE d.subscription_id = $1 AND ($2 = '' OR d.status = $2)
+		ORDER BY d.delivery_id DESC LIMIT $3 OFFSET $4
+	`, subscriptionID, status, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		d, err := scanDelivery(rows.Scan)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+	return deliveries, rows.Err()
+}
+
+// Redeliver schedules a delivery to be sent again at once, bringing a dead one back to life. The
+// subscription must be active.
+func Redeliver(ctx context.Context, subscriptionID int, deliveryID int64) (models.WebhookDelivery, error) {
+	var d models.WebhookDelivery
+	err := db.InTx(ctx, func(tx *sql.Tx) error {
+		var active bool
+		err := tx.QueryRowContext(ctx, `
+			SELECT active FROM webhook_subscriptions WHERE subscription_id = $1 FOR SHARE
+		`, subscriptionID).Scan(&active)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrDeliveryNotFound
+		} else if err != nil {
+			return err
+		}
+		if !active {
+			return ErrInactive
+		}
+
+		result, err := tx.ExecContext(ctx, `
+			UPDATE webhook_deliveries SET status = 'pending', attempts = 0, next_attempt_at = NOW()
+			WHERE subscription_id = $1 AND delivery_id = $2
+		`, subscriptionID, deliveryID)
+		if err != nil {
+			return err
+		}
+		if count, _ := result.RowsAffected(); count == 0 {
+			return ErrDeliveryNotFound
+		}
+		d, err = scanDelivery(tx.QueryRowContext(ctx, "SELECT "+deliveryColumns+`
+			FROM webhook_deliveries d JOIN song_events e USING (event_id) WHERE d.delivery_id = $1
+		`, deliveryID).Scan)
+		return err
+	})
+	return d, err
+}
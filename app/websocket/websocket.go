@@ -0,0 +1,252 @@
+// Package websocket implements the server side of the WebSocket protocol (RFC 6455) as far as
+// streaming text messages to clients needs: the handshake, unfragmented text frames, pings and
+// the closing handshake. Messages from clients are read and discarded.
+package websocket
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// acceptGUID is appended to the client key to compute Sec-WebSocket-Accept
+const acceptGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxControlPayload is the largest payload the protocol allows in a control frame
+const maxControlPayload = 125
+
+// maxMessageSize bounds the frames clients may send, which are only read to be discarded
+const maxMessageSize = 1 << 16
+
+// Frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close codes
+const (
+	CloseNormal          = 1000
+	CloseGoingAway       = 1001
+	CloseProtocolError   = 1002
+	CloseMessageTooBig   = 1009
+	CloseInternalError   = 1011
+	closeNoStatusPresent = 1005
+)
+
+// ErrBadHandshake is returned for requests that are not valid WebSocket upgrades
+var ErrBadHandshake = errors.New("websocket: bad handshake")
+
+// IsUpgrade reports whether a request asks to switch to the WebSocket protocol
+func IsUpgrade(r *http.Request) bool {
+	return headerContains(r.Header, "Connection", "upgrade") && headerContains(r.Header, "Upgrade", "websocket")
+}
+
+func headerContains(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, t := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(t), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Accept computes the Sec-WebSocket-Accept header answering a Sec-WebSocket-Key
+func Accept(key string) string {
+	sum := sha1.Sum([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// Conn is a server-side WebSocket connection. Writes may be made from several goroutines, reads
+// from one.
+type Conn struct {
+	conn         net.Conn
+	br           *bufio.Reader
+	writeTimeout time.Duration
+
+	mu     sync.Mutex
+	closed bool
+}
+
+// Upgrade completes the handshake of an upgrade request and takes over its connection. On a bad
+// handshake it answers 400 itself and returns ErrBadHandshake. Every write must complete within
+// writeTimeout.
+func Upgrade(w http.ResponseWriter, r *http.Request, writeTimeout time.Duration) (*Conn, error) {
+	key := strings.TrimSpace(r.Header.Get("Sec-WebSocket-Key"))
+	if r.Method != http.MethodGet || !IsUpgrade(r) || key == "" {
+		http.Error(w, "Expected a WebSocket upgrade request", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		http.Error(w, "Unsupported WebSocket version", http.StatusBadRequest)
+		return nil, ErrBadHandshake
+	}
+
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, err
+	}
+	// The server's read and write timeouts were meant for the request, not for the stream
+	if err := netConn.SetDeadline(time.Time{}); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	c := &Conn{conn: netConn, br: brw.Reader, writeTimeout: writeTimeout}
+	response := "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n" +
+		"Sec-WebSocket-Accept: " + Accept(key) + "\r\n\r\n"
+	if err := c.write([]byte(response)); err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *Conn) write(b []byte) error {
+	if err := c.conn.SetWriteDeadline(time.Now().Add(c.writeTimeout)); err != nil {
+		return err
+	}
+	_, err := c.conn.Write(b)
+	return err
+}
+
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	frame := make([]byte, 0, 10+len(payload))
+	frame = append(frame, 0x80|opcode)
+	switch {
+	case len(payload) < 126:
+		frame = append(frame, byte(len(payload)))
+	case len(payload) <= 0xFFFF:
+		frame = append(frame, 126)
+		frame = binary.BigEndian.AppendUint16(frame, uint16(len(payload)))
+	default:
+		frame = append(frame, 127)
+		frame = binary.BigEndian.AppendUint64(frame, uint64(len(payload)))
+	}
+	frame = append(frame, payload...)
+	return c.write(frame)
+}
+
+// WriteText sends a text message
+func (c *Conn) WriteText(message []byte) error {
+	return c.writeFrame(opText, message)
+}
+
+// Ping sends a ping; clients answer with a pong, which keeps intermediaries from timing out
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// Close sends a close frame with a code and a reason and closes the connection. It is safe to
+// call more than once.
+func (c *Conn) Close(code int, reason string) error {
+	payload := binary.BigEndian.AppendUint16(nil, uint16(code))
+	payload = append(payload, reason[:min(len(reason), maxControlPayload-2)]...)
+	err := c.writeFrame(opClose, payload)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	if closeErr := c.conn.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// ReadLoop reads the frames of the client, answering pings and discarding messages, until the
+// client closes the connection, breaks the protocol or the connection fails. It answers a close
+// frame and returns nil on a clean close.
+func (c *Conn) ReadLoop() error {
+	header := make([]byte, 2)
+	for {
+		if _, err := io.ReadFull(c.br, header); err != nil {
+			return err
+		}
+		fin, opcode := header[0]&0x80 != 0, header[0]&0x0F
+		masked, length := header[1]&0x80 != 0, int64(header[1]&0x7F)
+		if header[0]&0x70 != 0 || !masked {
+			c.Close(CloseProtocolError, "Frames must be masked and use no extensions")
+			return fmt.Errorf("websocket: invalid frame header %#x", header)
+		}
+		switch length {
+		case 126:
+			var ext [2]byte
+			if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+				return err
+			}
+			length = int64(binary.BigEndian.Uint16(ext[:]))
+		case 127:
+			var ext [8]byte
+			if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+				return err
+			}
+			length = int64(binary.BigEndian.Uint64(ext[:]) & (1<<63 - 1))
+		}
+		control := opcode >= opClose
+		if control && (!fin || length > maxControlPayload) {
+			c.Close(CloseProtocolError, "Invalid control frame")
+			return fmt.Errorf("websocket: invalid control frame %#x", opcode)
+		}
+		if length > maxMessageSize {
+			c.Close(CloseMessageTooBig, "Message is too large")
+			return fmt.Errorf("websocket: %d byte frame is too large", length)
+		}
+
+		var mask [4]byte
+		if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+			return err
+		}
+		payload := make([]byte, length)
+		if _, err := io.ReadFull(c.br, payload); err != nil {
+			return err
+		}
+		for i := range payload {
+			payload[i] ^= mask[i%4]
+		}
+
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return err
+			}
+		case opClose:
+			code := closeNoStatusPresent
+			if len(payload) >= 2 {
+				code = int(binary.BigEndian.Uint16(payload))
+			}
+			if code == closeNoStatusPresent {
+				code = CloseNormal
+			}
+			c.Close(code, "")
+			return nil
+		case opPong, opText, opBinary, opContinuation:
+		default:
+			c.Close(CloseProtocolError, "Unknown opcode")
+			return fmt.Errorf("websocket: unknown opcode %#x", opcode)
+		}
+	}
+}
@@ -0,0 +1,48 @@
+package worker
+
+import (
+	"context"
+	"github.com/rs/zerolog/log"
+	"sync"
+)
+
+// Group runs background workers that share a context cancelled on shutdown
+type Group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewGroup() *Group {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Group{ctx: ctx, cancel: cancel}
+}
+
+// Go starts a worker; fn must return once its context is cancelled
+func (g *Group) Go(name string, fn func(ctx context.Context)) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		log.Debug().Str("worker", name).Msg("Background worker started")
+		fn(g.ctx)
+		log.Debug().Str("worker", name).Msg("Background worker stopped")
+	}()
+}
+
+// Stop cancels all workers and waits for them to return or for ctx to expire
+func (g *Group) Stop(ctx context.Context) error {
+	g.cancel()
+
+	done := make(chan struct{})
+	go func() {
+		g.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}